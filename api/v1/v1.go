@@ -0,0 +1,82 @@
+// Package v1 holds the request and response bodies of the /v1 API. They only change in ways that keep
+// v1 clients working, a breaking change gets its own bodies in a new version package and handlers registered for it.
+package v1
+
+import (
+	"github.com/nktsitas/checkout-techlab/audit"
+	"github.com/nktsitas/checkout-techlab/disputes"
+	"github.com/nktsitas/checkout-techlab/fx"
+	"github.com/nktsitas/checkout-techlab/gateway"
+	"github.com/nktsitas/checkout-techlab/ledger"
+	"github.com/nktsitas/checkout-techlab/risk"
+)
+
+// Version is the path prefix of these bodies' routes
+const Version = "v1"
+
+type RequestParams struct {
+	Id string `json:"id" example:"unique_authorization_id"`
+	Amount float64 `json:"amount" example:"100.00"`
+
+	gateway.Details
+}
+
+type VoidRequestParams struct {
+	Id string `json:"id" example:"unique_authorization_id"`
+}
+
+type AuthResponse struct {
+	Id string `json:"id" example:"unique_authorization_id"`
+	Amount float64 `json:"amount" example:"100.00"`
+	Currency string `json:"currency" example:"EUR"`
+	Status string `json:"status" example:"authorized"`
+	ApprovalCode string `json:"approval_code,omitempty" example:"123456"`
+	AcquirerReference string `json:"acquirer_reference,omitempty" example:"sim_5f0c3a1e9b2d4c68"`
+	DeclineCode string `json:"decline_code,omitempty" example:"05"`
+	DeclineReason string `json:"decline_reason,omitempty" example:"Do not honour"`
+	// FX is the exchange rate locked for captures and refunds when the merchant settles in another currency
+	FX *fx.LockedRate `json:"fx,omitempty"`
+	// Risk is the fraud screening assessment, blocked authorizations are declined with decline_code risk_blocked
+	Risk *risk.Assessment `json:"risk,omitempty"`
+
+	gateway.Details
+}
+
+type ActionsResponse struct {
+	Amount float64 `json:"amount" example:"100.00"`
+	Currency string `json:"currency" example:"EUR"`
+	SettlementAmount float64 `json:"settlement_amount" example:"100.00"`
+	SettlementCurrency string `json:"settlement_currency" example:"EUR"`
+	// Fee charged to the merchant and Net, what the operation adds to (or, for refunds, takes from) the merchant's balance, in the settlement currency
+	Fee float64 `json:"fee" example:"1.65"`
+	Net float64 `json:"net" example:"98.35"`
+
+	gateway.Details
+}
+
+type ListResponse struct {
+	Data []*gateway.AuthorizationSummary `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty" example:"eyJ2IjoxLCJpZCI6ImFiYyJ9"`
+}
+
+type BalancesResponse struct {
+	Merchant string `json:"merchant" example:"Checkout"`
+	Balances []ledger.MerchantBalance `json:"balances"`
+}
+
+type EvidenceRequest struct {
+	Evidence []disputes.Evidence `json:"evidence"`
+}
+
+type ListEntryRequest struct {
+	// List is allow or block
+	List string `json:"list" example:"block"`
+
+	risk.Entry
+}
+
+type AuditResponse struct {
+	// Verification covers the whole chain, whatever the filters
+	Verification *audit.Verification `json:"verification"`
+	Records []*audit.Record `json:"records"`
+}
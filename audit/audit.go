@@ -0,0 +1,264 @@
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/db"
+)
+
+const recordKind = "audit_record"
+
+// Audited operations
+const (
+	OperationLogin = "login"
+	OperationAuthorize = "authorize"
+	OperationCapture = "capture"
+	OperationRefund = "refund"
+	OperationVoid = "void"
+	OperationDisputeNotification = "dispute.notification"
+	OperationDisputeEvidence = "dispute.evidence"
+	OperationRiskListView = "risk_list.view"
+	OperationRiskListAdd = "risk_list.add"
+	OperationRiskListRemove = "risk_list.remove"
+	OperationAuditExport = "audit.export"
+	OperationReconciliationView = "reconciliation.view"
+)
+
+// Results of an audited operation
+const (
+	ResultSuccess = "success"
+	// ResultDeclined is a payment refused by risk screening or the acquirer
+	ResultDeclined = "declined"
+	// ResultDenied is a request refused for its credentials or role, or a locked out login
+	ResultDenied = "denied"
+	ResultFailure = "failure"
+)
+
+// Record is one audited operation. Records are chained: each one holds the hash of the previous one,
+// so changing or removing a record breaks the chain from there on.
+type Record struct {
+	Id string								`json:"id"`
+	Sequence uint64					`json:"sequence"`
+	// Actor is the authenticated client, or the username a login was attempted with
+	Actor string						`json:"actor"`
+	Role string							`json:"role,omitempty"`
+	// Merchant whose payments the operation is about, empty for admin operations
+	Merchant string					`json:"merchant,omitempty"`
+	Operation string				`json:"operation" example:"refund"`
+	// Target is the id of the object operated on, e.g. the authorization
+	Target string						`json:"target,omitempty"`
+	// Payload is the request body with card numbers, CVVs, expiry dates and passwords masked
+	Payload json.RawMessage	`json:"payload,omitempty" swaggertype:"object"`
+	Result string						`json:"result" example:"success"`
+	Status int							`json:"status" example:"200"`
+	RequestID string				`json:"request_id,omitempty"`
+	CreatedAt time.Time			`json:"created_at"`
+	PrevHash string					`json:"prev_hash"`
+	Hash string							`json:"hash"`
+}
+
+// AuditTrail appends records to storage, one at a time so the chain has no forks
+type AuditTrail struct {
+	mu sync.Mutex
+	loaded bool
+	sequence uint64
+	lastHash string
+}
+
+// Trail is the audit log of the service
+var Trail = &AuditTrail{}
+
+// Append completes record with its position in the chain and stores it. Records can't be updated afterwards.
+func (at *AuditTrail) Append(record *Record) *Record {
+	at.mu.Lock()
+	defer at.mu.Unlock()
+
+	if !at.loaded {
+		at.load()
+	}
+
+	record.Sequence = at.sequence + 1
+	record.CreatedAt = time.Now().UTC()
+	record.PrevHash = at.lastHash
+	record.Hash = record.computeHash()
+	record.Id = "aud_" + record.Hash[:16]
+
+	db.DB.StoreItem(record.Id, record)
+
+	at.sequence = record.Sequence
+	at.lastHash = record.Hash
+
+	log.WithFields(log.Fields{
+		"id": record.Id,
+		"sequence": record.Sequence,
+		"actor": record.Actor,
+		"operation": record.Operation,
+		"target": record.Target,
+		"result": record.Result,
+	}).Info("AuditTrail.Append - Operation audited")
+
+	return record
+}
+
+// load continues the chain already in storage, if any
+func (at *AuditTrail) load() {
+	items, _, err := db.DB.QueryItems(db.Query{
+		Equals: map[string]string{"kind": recordKind},
+		SortBy: "sequence",
+		Descending: true,
+		Limit: 1,
+	})
+	if err != nil {
+		log.WithField("err", err).Error("AuditTrail.load - Error reading the last record")
+		return
+	}
+
+	if len(items) > 0 {
+		if last, ok := items[0].(*Record); ok {
+			at.sequence = last.Sequence
+			at.lastHash = last.Hash
+		}
+	}
+
+	at.loaded = true
+}
+
+// computeHash covers every field but the id and the hash itself
+func (record *Record) computeHash() string {
+	chained := *record
+	chained.Id = ""
+	chained.Hash = ""
+
+	content, _ := json.Marshal(chained)
+	sum := sha256.Sum256(content)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Filter selects records, unset fields match everything
+type Filter struct {
+	Actor string
+	Merchant string
+	Operation string
+	Target string
+	From *time.Time
+	To *time.Time
+}
+
+// Records returns the records matching filter, oldest first
+func Records(filter Filter) ([]*Record, error) {
+	equals := map[string]string{"kind": recordKind}
+	for key, value := range map[string]string{
+		"actor": filter.Actor,
+		"merchant": filter.Merchant,
+		"operation": filter.Operation,
+		"target": filter.Target,
+	} {
+		if value != "" {
+			equals[key] = value
+		}
+	}
+
+	query := db.Query{
+		Equals: equals,
+		SortBy: "sequence",
+	}
+
+	if filter.From != nil || filter.To != nil {
+		createdAt := db.Range{}
+		if filter.From != nil {
+			from := microseconds(*filter.From)
+			createdAt.Min = &from
+		}
+		if filter.To != nil {
+			to := microseconds(*filter.To)
+			createdAt.Max = &to
+		}
+		query.Ranges = map[string]db.Range{"created_at": createdAt}
+	}
+
+	items, _, err := db.DB.QueryItems(query)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Record{}
+	for _, iterItem := range items {
+		if record, ok := iterItem.(*Record); ok {
+			result = append(result, record)
+		}
+	}
+
+	return result, nil
+}
+
+// Verification is the outcome of checking the whole chain
+type Verification struct {
+	Valid bool					`json:"valid"`
+	Records int					`json:"records"`
+	// BrokenAt is the sequence of the first record that doesn't match the chain
+	BrokenAt uint64			`json:"broken_at,omitempty"`
+	Reason string				`json:"reason,omitempty"`
+}
+
+// Verify recomputes the chain from the first record and reports the first break
+func Verify() (*Verification, error) {
+	records, err := Records(Filter{})
+	if err != nil {
+		return nil, err
+	}
+
+	verification := &Verification{Valid: true, Records: len(records)}
+	prevHash := ""
+
+	for i, iterRecord := range records {
+		var reason string
+		switch {
+		case iterRecord.Sequence != uint64(i + 1):
+			reason = fmt.Sprintf("expected sequence %d, a record is missing", i + 1)
+		case iterRecord.PrevHash != prevHash:
+			reason = "previous hash doesn't match the previous record"
+		case iterRecord.Hash != iterRecord.computeHash():
+			reason = "hash doesn't match the record's content"
+		}
+
+		if reason != "" {
+			verification.Valid = false
+			verification.BrokenAt = iterRecord.Sequence
+			verification.Reason = reason
+			break
+		}
+
+		prevHash = iterRecord.Hash
+	}
+
+	return verification, nil
+}
+
+func (record *Record) IndexValues() map[string][]string {
+	return map[string][]string{
+		"kind": {recordKind},
+		"actor": {record.Actor},
+		"merchant": {record.Merchant},
+		"operation": {record.Operation},
+		"target": {record.Target},
+	}
+}
+
+func (record *Record) SortValues() map[string]float64 {
+	return map[string]float64{
+		"sequence": float64(record.Sequence),
+		"created_at": microseconds(record.CreatedAt),
+	}
+}
+
+func microseconds(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Microsecond))
+}
@@ -0,0 +1,173 @@
+package audit
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+}
+
+func resetTrail() {
+	db.DB = db.InitMemoryDB()
+	Trail = &AuditTrail{}
+}
+
+func TestVerify(t *testing.T) {
+	assert := assert.New(t)
+
+	resetTrail()
+
+	first := Trail.Append(&Record{Actor: "Checkout", Merchant: "Checkout", Operation: OperationAuthorize, Target: "auth_1", Result: ResultSuccess, Status: 200})
+	second := Trail.Append(&Record{Actor: "Checkout", Merchant: "Checkout", Operation: OperationRefund, Target: "auth_1", Result: ResultSuccess, Status: 200})
+	third := Trail.Append(&Record{Actor: "Admin", Role: auth.RoleAdmin, Operation: OperationRiskListAdd, Result: ResultSuccess, Status: 201})
+
+	assert.Equal(uint64(3), third.Sequence)
+	assert.Equal("", first.PrevHash, "First record - Starts the chain")
+	assert.Equal(first.Hash, second.PrevHash, "Chained to the previous record")
+
+	verification, err := Verify()
+	assert.NoError(err)
+	assert.Equal(&Verification{Valid: true, Records: 3}, verification, "Untouched chain")
+
+	records, err := Records(Filter{Target: "auth_1"})
+	assert.NoError(err)
+	assert.Equal([]*Record{first, second}, records, "Filtered, oldest first")
+
+	// a new trail continues the chain in storage
+	Trail = &AuditTrail{}
+	fourth := Trail.Append(&Record{Actor: "Checkout", Operation: OperationLogin, Result: ResultSuccess, Status: 200})
+	assert.Equal(uint64(4), fourth.Sequence, "Reloaded - Sequence continues")
+	assert.Equal(third.Hash, fourth.PrevHash, "Reloaded - Chain continues")
+
+	second.Operation = OperationCapture
+	verification, err = Verify()
+	assert.NoError(err)
+	assert.Equal(&Verification{Valid: false, Records: 4, BrokenAt: 2, Reason: "hash doesn't match the record's content"}, verification, "Tampered record")
+	second.Operation = OperationRefund
+
+	db.DB.DeleteItem(third.Id)
+	verification, err = Verify()
+	assert.NoError(err)
+	assert.Equal(&Verification{Valid: false, Records: 3, BrokenAt: 4, Reason: "expected sequence 3, a record is missing"}, verification, "Removed record")
+}
+
+func TestMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	resetTrail()
+
+	tests := []struct{
+		operation string
+		merchant string
+		role string
+		body string
+		status int
+		expected Record
+		description string
+	}{
+		{
+			OperationLogin,
+			"",
+			"",
+			`{"username":"Checkout","password":"Checkout"}`,
+			401,
+			Record{Actor: "Checkout", Merchant: "Checkout", Operation: OperationLogin, Payload: []byte(`{"password":"***","username":"Checkout"}`), Result: ResultDenied, Status: 401},
+			"Login - Attributed to the username, password masked",
+		},
+		{
+			OperationAuthorize,
+			"Checkout",
+			"",
+			`{"amount":100,"card":{"number":"4242 4242 4242 4242","expiry":"12/30","cvv":"123"}}`,
+			402,
+			Record{Actor: "Checkout", Merchant: "Checkout", Operation: OperationAuthorize, Target: "auth_1", Payload: []byte(`{"amount":100,"card":{"cvv":"***","expiry":"***","number":"************4242"}}`), Result: ResultDeclined, Status: 402},
+			"Authorize - Card masked",
+		},
+		{
+			OperationRiskListRemove,
+			"Admin",
+			auth.RoleAdmin,
+			"",
+			204,
+			Record{Actor: "Admin", Role: auth.RoleAdmin, Operation: OperationRiskListRemove, Target: "auth_1", Result: ResultSuccess, Status: 204},
+			"Admin - No merchant, no payload",
+		},
+		{
+			OperationCapture,
+			"Checkout",
+			"",
+			"not json",
+			400,
+			Record{Actor: "Checkout", Merchant: "Checkout", Operation: OperationCapture, Target: "auth_1", Result: ResultFailure, Status: 400},
+			"Capture - Body that isn't JSON left out",
+		},
+	}
+
+	for _, iterTest := range tests {
+		status := iterTest.status
+		var received string
+		handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			received = string(body)
+			if iterTest.operation != OperationLogin {
+				Target(r.Context(), "auth_1")
+			}
+			w.WriteHeader(status)
+		}), iterTest.operation)
+
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(iterTest.body))
+		req = req.WithContext(auth.WithRole(auth.WithMerchant(req.Context(), iterTest.merchant), iterTest.role))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(iterTest.body, received, iterTest.description + " - Body still readable")
+
+		records, err := Records(Filter{Operation: iterTest.operation})
+		assert.NoError(err)
+		if assert.Len(records, 1, iterTest.description) {
+			record := *records[0]
+			record.Id, record.Sequence, record.CreatedAt, record.PrevHash, record.Hash = "", 0, iterTest.expected.CreatedAt, "", ""
+			assert.Equal(iterTest.expected, record, iterTest.description)
+		}
+	}
+}
+
+func TestMiddlewareOversized(t *testing.T) {
+	assert := assert.New(t)
+
+	resetTrail()
+
+	served := false
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}), OperationRefund)
+
+	body := `{"amount":1,"reference":"` + strings.Repeat("a", MaxPayloadBytes) + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+	req = req.WithContext(auth.WithMerchant(req.Context(), "Checkout"))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(http.StatusRequestEntityTooLarge, w.Code)
+	assert.False(served, "Not served")
+
+	records, err := Records(Filter{Operation: OperationRefund})
+	assert.NoError(err)
+	if assert.Len(records, 1) {
+		assert.Nil(records[0].Payload, "Audited without payload")
+		assert.Equal(http.StatusRequestEntityTooLarge, records[0].Status)
+		assert.Equal(ResultFailure, records[0].Result)
+	}
+}
@@ -0,0 +1,161 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/logger"
+)
+
+// MaxPayloadBytes caps the request body of audited routes, larger bodies are refused and audited without payload
+const MaxPayloadBytes = 64 * 1024
+
+type contextKey string
+
+const targetKey contextKey = "audit_target"
+
+// maskedFields hold secrets, they are replaced wherever they appear in a payload
+var maskedFields = map[string]bool{
+	"cvv": true,
+	"expiry": true,
+	"password": true,
+}
+
+// Middleware audits every request of a route as operation, once it is served.
+// It goes inside Authenticate so the actor is known, and outside RequireRole so refused admin calls are audited too.
+func Middleware(inner http.Handler, operation string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := new(string)
+		ctx := context.WithValue(r.Context(), targetKey, target)
+
+		recorder := logger.NewResponseRecorder(w)
+
+		// read at most MaxPayloadBytes, even before authentication on login
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MaxPayloadBytes))
+		switch {
+		case err != nil && len(body) >= MaxPayloadBytes:
+			body = nil
+			http.Error(recorder, "Request body too large", http.StatusRequestEntityTooLarge)
+		case err != nil:
+			http.Error(w, "Can't read body", http.StatusBadRequest)
+			return
+		default:
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			inner.ServeHTTP(recorder, r.WithContext(ctx))
+		}
+
+		payload := maskPayload(body)
+
+		record := &Record{
+			Actor: auth.MerchantFromContext(ctx),
+			Role: auth.RoleFromContext(ctx),
+			Operation: operation,
+			Target: *target,
+			Payload: payload,
+			Result: result(recorder.Status),
+			Status: recorder.Status,
+			RequestID: logger.RequestIDFromContext(ctx),
+		}
+
+		// logins aren't authenticated yet, they are attributed to the username they try
+		if record.Actor == "" {
+			var credentials struct {
+				Username string `json:"username"`
+			}
+			json.Unmarshal(body, &credentials)
+			record.Actor = credentials.Username
+		}
+
+		if record.Role != auth.RoleAdmin {
+			record.Merchant = record.Actor
+		}
+
+		Trail.Append(record)
+	})
+}
+
+// Target sets the id of the object the audited request operates on, e.g. the authorization
+func Target(ctx context.Context, id string) {
+	if target, ok := ctx.Value(targetKey).(*string); ok {
+		*target = id
+	}
+}
+
+func result(status int) string {
+	switch {
+	case status < 400:
+		return ResultSuccess
+	case status == http.StatusPaymentRequired:
+		return ResultDeclined
+	case status == http.StatusUnauthorized || status == http.StatusForbidden || status == http.StatusTooManyRequests:
+		return ResultDenied
+	}
+
+	return ResultFailure
+}
+
+// maskPayload keeps the last 4 digits of card numbers and hides other secrets. Bodies that aren't JSON are left out.
+func maskPayload(body []byte) json.RawMessage {
+	if len(body) == 0 {
+		return nil
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil
+	}
+
+	masked, err := json.Marshal(mask(payload))
+	if err != nil {
+		return nil
+	}
+
+	return masked
+}
+
+func mask(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, iterValue := range typed {
+			lower := strings.ToLower(key)
+			switch {
+			case maskedFields[lower]:
+				typed[key] = "***"
+			case lower == "number":
+				typed[key] = maskNumber(iterValue)
+			default:
+				typed[key] = mask(iterValue)
+			}
+		}
+	case []interface{}:
+		for i, iterValue := range typed {
+			typed[i] = mask(iterValue)
+		}
+	}
+
+	return value
+}
+
+func maskNumber(value interface{}) interface{} {
+	number, ok := value.(string)
+	if !ok {
+		return "***"
+	}
+
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, number)
+	if len(digits) <= 4 {
+		return "***"
+	}
+
+	return strings.Repeat("*", len(digits) - 4) + digits[len(digits) - 4:]
+}
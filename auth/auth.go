@@ -2,15 +2,23 @@ package auth
 
 import (
 	// "fmt"
+	"context"
+	"crypto/tls"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
-	"os"
 	"errors"
 	"encoding/json"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/dgrijalva/jwt-go"
 
+	"github.com/nktsitas/checkout-techlab/logger"
+
 )
 
 type loginRequest struct {
@@ -23,11 +31,178 @@ type tokenResponse struct {
 }
 
 type User struct {
-	Username string
-	Password string
+	Username string	`json:"username" yaml:"username"`
+	Password string	`json:"password" yaml:"password"`
+	Role string			`json:"role" yaml:"role"`
+}
+
+// RoleAdmin is the "role" claim of operators allowed on admin routes
+const RoleAdmin = "admin"
+
+// AdminUsername logs in with AdminPassword
+const AdminUsername = "Admin"
+
+// Users can log in, set from the configuration at startup
+var Users = []User{{"Checkout", "Checkout", ""}}
+
+// AdminPassword enables the admin user, admin login is disabled when it is empty
+var AdminPassword string
+
+// AccessSecret signs and verifies the tokens
+var AccessSecret string
+
+// TokenExpiry is how long a token is valid after login
+var TokenExpiry = 30 * time.Minute
+
+// ClientCertMerchants maps a verified client certificate subject (e.g. "CN=checkout,O=Checkout Ltd"),
+// or just its common name, to the merchant it authenticates without a token
+var ClientCertMerchants = map[string]string{}
+
+// Lockout refuses logins to a username from a client IP after repeated failures, set from the configuration at startup
+var Lockout = NewLoginLockout(5, 15 * time.Minute, 15 * time.Minute)
+
+// TrustForwardedFor takes the client IP from X-Forwarded-For, only behind a load balancer setting it
+var TrustForwardedFor bool
+
+// LoginLockout locks a username from a client IP for duration after maxFailures failed logins within window,
+// 0 maxFailures disables it. Failures from other IPs don't count, or anyone could lock a merchant out; guessing
+// from many IPs is left to the per-IP rate limit of the login route.
+type LoginLockout struct {
+	maxFailures int
+	window time.Duration
+	duration time.Duration
+
+	mu sync.Mutex
+	attempts map[string]*loginAttempts
+	swept time.Time
+	now func() time.Time
+}
+
+type loginAttempts struct {
+	failures int
+	first time.Time
+	lockedUntil time.Time
+}
+
+func NewLoginLockout(maxFailures int, window time.Duration, duration time.Duration) *LoginLockout {
+	return &LoginLockout{
+		maxFailures: maxFailures,
+		window: window,
+		duration: duration,
+		attempts: make(map[string]*loginAttempts),
+		now: time.Now,
+	}
 }
 
-var dummyUser User = User{"Checkout", "Checkout"}
+// Locked returns how long username stays locked from ip, 0 when it isn't
+func (ll *LoginLockout) Locked(username string, ip string) time.Duration {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	if attempts, ok := ll.attempts[lockoutKey(username, ip)]; ok && attempts.lockedUntil.After(ll.now()) {
+		return attempts.lockedUntil.Sub(ll.now())
+	}
+
+	return 0
+}
+
+// Fail counts a failed login, locking username from ip once there are too many
+func (ll *LoginLockout) Fail(username string, ip string) {
+	if ll.maxFailures <= 0 {
+		return
+	}
+
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	now := ll.now()
+	ll.sweep(now)
+
+	key := lockoutKey(username, ip)
+	attempts, ok := ll.attempts[key]
+	if !ok || now.Sub(attempts.first) > ll.window {
+		attempts = &loginAttempts{first: now}
+		ll.attempts[key] = attempts
+	}
+
+	attempts.failures++
+	if attempts.failures >= ll.maxFailures {
+		attempts.lockedUntil = now.Add(ll.duration)
+		log.WithFields(log.Fields{"username": username, "ip": ip, "failures": attempts.failures}).Warn("Fail - Too many failed logins, locked out")
+	}
+}
+
+// Succeed forgets the failures of username from ip
+func (ll *LoginLockout) Succeed(username string, ip string) {
+	ll.mu.Lock()
+	defer ll.mu.Unlock()
+
+	delete(ll.attempts, lockoutKey(username, ip))
+}
+
+// sweep forgets failures out of the window and expired locks, at most once a window, so guessed usernames and IPs don't pile up
+func (ll *LoginLockout) sweep(now time.Time) {
+	if now.Sub(ll.swept) < ll.window {
+		return
+	}
+	ll.swept = now
+
+	for key, iterAttempts := range ll.attempts {
+		if now.Sub(iterAttempts.first) > ll.window && !iterAttempts.lockedUntil.After(now) {
+			delete(ll.attempts, key)
+		}
+	}
+}
+
+func lockoutKey(username string, ip string) string {
+	return username + "\x00" + ip
+}
+
+// ClientIP is the address of the client sending r, the last X-Forwarded-For address with trustForwardedFor
+func ClientIP(r *http.Request, trustForwardedFor bool) string {
+	if trustForwardedFor {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			addresses := strings.Split(forwarded, ",")
+			return strings.TrimSpace(addresses[len(addresses) - 1])
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+type contextKey string
+
+const (
+	merchantKey contextKey = "merchant"
+	roleKey contextKey = "role"
+)
+
+// WithMerchant stores the authenticated client in the context
+func WithMerchant(ctx context.Context, merchant string) context.Context {
+	return context.WithValue(ctx, merchantKey, merchant)
+}
+
+// MerchantFromContext returns the authenticated client (the token's "client" claim), if any
+func MerchantFromContext(ctx context.Context) string {
+	merchant, _ := ctx.Value(merchantKey).(string)
+	return merchant
+}
+
+// WithRole stores the authenticated client's role in the context
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleKey, role)
+}
+
+// RoleFromContext returns the token's "role" claim, empty for merchants
+func RoleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value(roleKey).(string)
+	return role
+}
 
 // Refund godoc
 // @Summary Logins a user and provides an authentication token
@@ -37,7 +212,9 @@ var dummyUser User = User{"Checkout", "Checkout"}
 // @Produce  json
 // @Param Credentials body loginRequest true "User Credentials"
 // @Success 200 {object} tokenResponse
-// @Router /login [post]
+// @Failure 401 {string} string "Wrong Username or Password"
+// @Failure 429 {string} string "Too many failed logins or requests, see Retry-After"
+// @Router /v1/login [post]
 func Login(w http.ResponseWriter, r *http.Request) {
 	var req loginRequest
 
@@ -48,13 +225,25 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if dummyUser.Username != req.Username || dummyUser.Password != req.Password {
+	// even the right password is refused while locked, or guessing would go on
+	ip := ClientIP(r, TrustForwardedFor)
+	if remaining := Lockout.Locked(req.Username, ip); remaining > 0 {
+		log.WithFields(log.Fields{"username": req.Username, "ip": ip}).Error("Login - Locked out")
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(remaining.Seconds()))))
+		http.Error(w, "Too many failed logins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	user, ok := findUser(req.Username, req.Password)
+	if !ok {
 		log.Error("Login - Wrong Username or Password")
+		Lockout.Fail(req.Username, ip)
 		http.Error(w, "Wrong Username or Password", http.StatusUnauthorized)
 		return
 	}
+	Lockout.Succeed(req.Username, ip)
 
-	token, err := GenerateToken(req.Username)
+	token, err := GenerateToken(user.Username, user.Role)
 	if err != nil {
 		log.WithField("err", err).Error("Login - Error Generating Token")
 		http.Error(w, "Error Generating Token", http.StatusInternalServerError)
@@ -77,16 +266,31 @@ func Login(w http.ResponseWriter, r *http.Request) {
   w.Write([]byte(respJSON))
 }
 
-func GenerateToken(username string) (string, error) {
+func findUser(username string, password string) (User, bool) {
+	for _, iterUser := range Users {
+		if iterUser.Username == username && iterUser.Password == password {
+			return iterUser, true
+		}
+	}
+
+	if AdminPassword != "" && username == AdminUsername && password == AdminPassword {
+		return User{AdminUsername, AdminPassword, RoleAdmin}, true
+	}
+
+	return User{}, false
+}
+
+func GenerateToken(username string, role string) (string, error) {
 	token := jwt.New(jwt.SigningMethodHS256)
 	claims := token.Claims.(jwt.MapClaims)
 	claims["authorized"] = true
 	claims["client"] = username
-	claims["exp"] = time.Now().Add(time.Minute * 30).Unix()
+	if role != "" {
+		claims["role"] = role
+	}
+	claims["exp"] = time.Now().Add(TokenExpiry).Unix()
 
-	accessSecret := os.Getenv("ACCESS_SECRET")
-	
-	tokenString, err := token.SignedString([]byte(accessSecret))
+	tokenString, err := token.SignedString([]byte(AccessSecret))
 	if err != nil {
 		return "", err
 	}
@@ -94,14 +298,40 @@ func GenerateToken(username string) (string, error) {
 	return tokenString, nil
 }
 
+// MerchantFromCertificate returns the merchant mapped to the client certificate of a TLS connection,
+// only certificates that passed verification count
+func MerchantFromCertificate(state *tls.ConnectionState) (string, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+
+	subject := state.VerifiedChains[0][0].Subject
+	if merchant, ok := ClientCertMerchants[subject.String()]; ok {
+		return merchant, true
+	}
+	merchant, ok := ClientCertMerchants[subject.CommonName]
+
+	return merchant, ok
+}
+
+// Authenticate accepts a token, or a client certificate mapped to a merchant. A token sent along with
+// such a certificate must be the same merchant's.
 func Authenticate(inner http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		certMerchant, hasCert := MerchantFromCertificate(r.TLS)
+		if hasCert && r.Header["Token"] == nil {
+			logger.Annotate(r.Context(), "merchant", certMerchant)
+
+			inner.ServeHTTP(w, r.WithContext(WithMerchant(r.Context(), certMerchant)))
+			return
+		}
+
 		if r.Header["Token"] != nil {
 			token, err := jwt.Parse(r.Header["Token"][0], func(token *jwt.Token) (interface{}, error) {
 				if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 						return nil, errors.New("Authentication Error")
 				}
-				return []byte(os.Getenv("ACCESS_SECRET")), nil
+				return []byte(AccessSecret), nil
 			})
 
 			if err != nil {
@@ -112,7 +342,17 @@ func Authenticate(inner http.Handler, name string) http.Handler {
 			}
 
 			if token.Valid {
-				inner.ServeHTTP(w, r)
+				claims, _ := token.Claims.(jwt.MapClaims)
+				merchant, _ := claims["client"].(string)
+				role, _ := claims["role"].(string)
+				if hasCert && merchant != certMerchant {
+					log.WithFields(log.Fields{"client": merchant, "certificate": certMerchant}).Error("Authenticate - Token doesn't match the client certificate")
+					http.Error(w, "Forbidden", http.StatusForbidden)
+					return
+				}
+				logger.Annotate(r.Context(), "merchant", merchant)
+
+				inner.ServeHTTP(w, r.WithContext(WithRole(WithMerchant(r.Context(), merchant), role)))
 			} else {
 				log.Error("Authenticate - Authentication Error")
 				http.Error(w, err.Error(), http.StatusBadRequest)
@@ -125,3 +365,16 @@ func Authenticate(inner http.Handler, name string) http.Handler {
 		}
 	})
 }
+
+// RequireRole only lets authenticated clients with role through, it goes inside Authenticate
+func RequireRole(inner http.Handler, role string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if RoleFromContext(r.Context()) != role {
+			log.WithField("client", MerchantFromContext(r.Context())).Error("RequireRole - Missing role " + role)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		inner.ServeHTTP(w, r)
+	})
+}
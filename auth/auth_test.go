@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+}
+
+func verified(subject pkix.Name) *tls.ConnectionState {
+	return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+}
+
+func TestAuthenticate(t *testing.T) {
+	assert := assert.New(t)
+
+	AccessSecret = "supersecret"
+	ClientCertMerchants = map[string]string{
+		"CN=checkout,O=Checkout Ltd": "Checkout",
+		"shop": "Shop",
+	}
+	defer func() { ClientCertMerchants = map[string]string{} }()
+
+	checkoutToken, err := GenerateToken("Checkout", "")
+	assert.NoError(err)
+	adminToken, err := GenerateToken(AdminUsername, RoleAdmin)
+	assert.NoError(err)
+
+	tests := []struct{
+		state *tls.ConnectionState
+		token string
+		expectedCode int
+		expectedMerchant string
+		description string
+	}{
+		{nil, checkoutToken, 200, "Checkout", "Token"},
+		{verified(pkix.Name{CommonName: "checkout", Organization: []string{"Checkout Ltd"}}), "", 200, "Checkout", "Certificate - Full subject"},
+		{verified(pkix.Name{CommonName: "shop", Organization: []string{"Anything"}}), "", 200, "Shop", "Certificate - Common name"},
+		{verified(pkix.Name{CommonName: "checkout", Organization: []string{"Checkout Ltd"}}), checkoutToken, 200, "Checkout", "Certificate - Same merchant's token"},
+		{verified(pkix.Name{CommonName: "shop"}), adminToken, 403, "", "Error - Token of someone else"},
+		{verified(pkix.Name{CommonName: "unknown"}), "", 400, "", "Error - Certificate not mapped"},
+		{&tls.ConnectionState{}, "", 400, "", "Error - Certificate not verified"},
+	}
+
+	for _, iterTest := range tests {
+		var merchant string
+		handler := Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			merchant = MerchantFromContext(r.Context())
+		}), "Test")
+
+		req := httptest.NewRequest("GET", "/authorizations", nil)
+		req.TLS = iterTest.state
+		if iterTest.token != "" {
+			req.Header.Set("Token", iterTest.token)
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Equal(iterTest.expectedMerchant, merchant, iterTest.description)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	assert := assert.New(t)
+
+	AccessSecret = "supersecret"
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	Lockout = NewLoginLockout(3, 15 * time.Minute, 10 * time.Minute)
+	Lockout.now = func() time.Time { return now }
+	defer func() { Lockout = NewLoginLockout(5, 15 * time.Minute, 15 * time.Minute) }()
+
+	tests := []struct{
+		advance time.Duration
+		remoteAddr string
+		password string
+		expectedCode int
+		expectedRetryAfter string
+		description string
+	}{
+		{0, "192.0.2.1:1234", "wrong", 401, "", "Failure 1"},
+		{0, "192.0.2.1:1234", "Checkout", 200, "", "Success - Failures forgotten"},
+		{0, "192.0.2.1:1234", "wrong", 401, "", "Failure 1"},
+		{0, "192.0.2.1:1234", "wrong", 401, "", "Failure 2"},
+		{16 * time.Minute, "192.0.2.1:1234", "wrong", 401, "", "Failure 1 - Window passed"},
+		{0, "192.0.2.1:1234", "wrong", 401, "", "Failure 2"},
+		{0, "192.0.2.1:1234", "wrong", 401, "", "Failure 3 - Locked"},
+		{time.Minute, "192.0.2.1:1234", "Checkout", 429, "540", "Locked - Right password refused"},
+		{0, "198.51.100.7:4321", "Checkout", 200, "", "Other IP - Not locked"},
+		{0, "198.51.100.7:4321", "wrong", 401, "", "Other IP - Failure 1"},
+		{0, "192.0.2.1:1234", "Checkout", 429, "540", "Locked - Other IP didn't unlock"},
+		{9 * time.Minute, "192.0.2.1:1234", "Checkout", 200, "", "Lock expired"},
+	}
+
+	for _, iterTest := range tests {
+		now = now.Add(iterTest.advance)
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/login", bytes.NewBufferString(`{"username":"Checkout","password":"`+iterTest.password+`"}`))
+		r.RemoteAddr = iterTest.remoteAddr
+		Login(w, r)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Equal(iterTest.expectedRetryAfter, w.Header().Get("Retry-After"), iterTest.description)
+	}
+}
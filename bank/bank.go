@@ -1,68 +1,200 @@
 package bank
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"strconv"
+	"strings"
 	"errors"
+	"fmt"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	"github.com/nktsitas/checkout-techlab/logger"
+
 )
 
-type Transaction struct {
-	message string
-	err error
-}
+// Card brands
+const (
+	BrandVisa = "visa"
+	BrandMastercard = "mastercard"
+	BrandAmex = "amex"
+	BrandDiscover = "discover"
+	BrandUnknown = "unknown"
+)
 
 type CreditCard struct {
 	Number string		 `json:"number"`
 	Expiry string		 `json:"expiry"`
 	Cvv string			 `json:"cvv"`
+}
+
+// ErrUnknownOutcome is matched (errors.Is) by errors returned when a request reached
+// the acquirer but no answer arrived before the deadline.
+var ErrUnknownOutcome = errors.New("Unknown Outcome")
+
+type UnknownOutcomeError struct {
+	Operation string
+	Err error
+}
+
+func (ue *UnknownOutcomeError) Error() string {
+	return fmt.Sprintf("%s failure - No answer from acquirer, outcome unknown (%s)", operationLabel(ue.Operation), ue.Err.Error())
+}
+
+func (ue *UnknownOutcomeError) Is(target error) bool {
+	return target == ErrUnknownOutcome
+}
+
+func (ue *UnknownOutcomeError) Unwrap() error {
+	return ue.Err
+}
 
-	transaction chan Transaction
+// Timeouts holds the deadline applied to each acquirer operation.
+// Operations missing from the map only honour the caller's context.
+var Timeouts = map[string]time.Duration{
+	"authorize": 5 * time.Second,
+	"charge": 10 * time.Second,
+	"refund": 10 * time.Second,
+	"void": 5 * time.Second,
 }
 
-// This can be either Charge or Refund, etc
-func (cc *CreditCard) Transaction(action string) error {
-	if cc.transaction == nil {
-		cc.transaction = make(chan Transaction)
+// ParseTimeouts parses a comma separated list of operation=duration pairs, e.g. "charge=3s,refund=2500ms"
+func ParseTimeouts(spec string) (map[string]time.Duration, error) {
+	timeouts := make(map[string]time.Duration)
+
+	for _, iterPair := range strings.Split(spec, ",") {
+		if strings.TrimSpace(iterPair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(iterPair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid timeout %q - expected operation=duration", iterPair)
+		}
+
+		operation := strings.TrimSpace(parts[0])
+		if _, ok := operationLabels[operation]; !ok {
+			return nil, fmt.Errorf("Invalid timeout %q - unknown operation", iterPair)
+		}
+
+		timeout, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("Invalid timeout %q - expected a positive duration", iterPair)
+		}
+
+		timeouts[operation] = timeout
 	}
 
-	// communicate with CreditCard service and wait to receive response.
-	// This can be an API call or a message broker receiver, etc
-	go cc.simulateCreditCardTransaction(action)
-	resp := <- cc.transaction
+	return timeouts, nil
+}
+
+// Response is what the acquirer answers when it approves a transaction
+type Response struct {
+	ApprovalCode string `json:"approval_code"`
+	Reference string `json:"reference"`
+}
+
+// This can be either Charge or Refund, etc. It goes through the connector of the given acquirer.
+func (cc *CreditCard) Transaction(ctx context.Context, acquirer string, action string, amount float64) (*Response, error) {
+	connector, err := GetConnector(acquirer)
+	if err != nil {
+		return nil, err
+	}
 
-	if resp.err != nil {
-		return resp.err
+	var resp *Response
+	err = connector.Execute(ctx, action, func(ctx context.Context) error {
+		var sendErr error
+		resp, sendErr = connector.Backend.Send(ctx, cc, action, amount)
+		return sendErr
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	return resp, nil
 }
 
-func (cc *CreditCard) simulateCreditCardTransaction(action string) {
-	time.Sleep(200*time.Millisecond)
+// Authorize tries the candidate acquirers in order, failing over to the next one only when
+// the request never reached the acquirer. It returns the acquirer that answered.
+func (cc *CreditCard) Authorize(ctx context.Context, candidates []string, amount float64) (string, *Response, error) {
+	var err error
 
-	if cc.Number == "4000 0000 0000 0259" && action == "charge" {
-		log.Error("CreditCard.Charge - Manually triggered capture failure.")
-		
-		cc.transaction <- Transaction{
-			"Capture Failure",
-			errors.New("Capture failure - Unknown Error"),
+	for _, iterAcquirer := range candidates {
+		var resp *Response
+		resp, err = cc.Transaction(ctx, iterAcquirer, "authorize", amount)
+		if err == nil || !(errors.Is(err, ErrNetwork) || errors.Is(err, ErrCircuitOpen)) {
+			return iterAcquirer, resp, err
 		}
-	} else if cc.Number == "4000 0000 0000 3238" && action == "refund" {
-		log.Error("CreditCard.Charge - Manually triggered refund failure.")
-		
-		cc.transaction <- Transaction{
-			"Capture Failure",
-			errors.New("Refund failure - Unknown Error"),
+
+		logger.FromContext(ctx).WithFields(log.Fields{
+			"acquirer": iterAcquirer,
+			"err": err,
+		}).Warn("CreditCard.Authorize - Acquirer unreachable, failing over")
+	}
+
+	if err == nil {
+		err = errors.New("Authorization failure - No acquirer available")
+	}
+
+	return "", nil, err
+}
+
+// Brand detects the card scheme from the number's prefix
+func (cc *CreditCard) Brand() string {
+	number := normalizeNumber(cc.Number)
+
+	prefix := func(length int) int {
+		if len(number) < length {
+			return -1
 		}
-	} else {
-		cc.transaction <- Transaction{
-			"Capture Successful!",
-			nil,
+		value, err := strconv.Atoi(number[:length])
+		if err != nil {
+			return -1
 		}
+		return value
+	}
+
+	switch {
+	case prefix(1) == 4:
+		return BrandVisa
+	case prefix(2) >= 51 && prefix(2) <= 55, prefix(4) >= 2221 && prefix(4) <= 2720:
+		return BrandMastercard
+	case prefix(2) == 34, prefix(2) == 37:
+		return BrandAmex
+	case prefix(4) == 6011, prefix(2) == 65, prefix(3) >= 644 && prefix(3) <= 649:
+		return BrandDiscover
 	}
+
+	return BrandUnknown
+}
+
+func (cc *CreditCard) Last4() string {
+	number := normalizeNumber(cc.Number)
+	if len(number) < 4 {
+		return number
+	}
+
+	return number[len(number)-4:]
+}
+
+// Fingerprint identifies the card without exposing its number, the same card always has the same fingerprint
+func (cc *CreditCard) Fingerprint() string {
+	sum := sha256.Sum256([]byte(normalizeNumber(cc.Number)))
+
+	return hex.EncodeToString(sum[:16])
+}
+
+// BIN is the issuer identification number, the first six digits of the card number
+func (cc *CreditCard) BIN() string {
+	number := normalizeNumber(cc.Number)
+	if len(number) < 6 {
+		return number
+	}
+
+	return number[:6]
 }
 
 func (cc *CreditCard) Validate() error {
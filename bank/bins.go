@@ -0,0 +1,69 @@
+package bank
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// BinCountry maps a BIN range to the ISO 3166-1 alpha-2 country of its issuer
+type BinCountry struct {
+	From string		`json:"from" yaml:"from"`
+	To string		`json:"to" yaml:"to"`
+	Country string	`json:"country" yaml:"country"`
+}
+
+// BinCountries resolves the issuing country of cards, first matching range wins
+var BinCountries []BinCountry
+
+// LoadBinCountries reads a list of BIN ranges and countries from a YAML (.yaml/.yml) or JSON file, unknown keys are errors
+func LoadBinCountries(path string) ([]BinCountry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading BIN countries file - %s", err.Error())
+	}
+
+	var bins []BinCountry
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &bins)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&bins)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing BIN countries file - %s", err.Error())
+	}
+
+	for i := range bins {
+		if err := (BinRange{bins[i].From, bins[i].To}).validate(); err != nil {
+			return nil, fmt.Errorf("Invalid BIN countries - %s", err.Error())
+		}
+
+		if len(bins[i].Country) != 2 {
+			return nil, fmt.Errorf("Invalid BIN countries - %s-%s: country must be a 2 letter code", bins[i].From, bins[i].To)
+		}
+		bins[i].Country = strings.ToUpper(bins[i].Country)
+	}
+
+	return bins, nil
+}
+
+// Country is the issuing country of the card, empty when unknown
+func (cc *CreditCard) Country() string {
+	bin := cc.BIN()
+
+	for _, iterBin := range BinCountries {
+		if (BinRange{iterBin.From, iterBin.To}).contains(bin) {
+			return iterBin.Country
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,173 @@
+package bank
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states
+const (
+	StateClosed = "closed"
+	StateOpen = "open"
+	StateHalfOpen = "half_open"
+)
+
+// ErrCircuitOpen is returned without contacting the acquirer while its breaker is open
+var ErrCircuitOpen = errors.New("Acquirer unavailable, failing fast")
+
+type BreakerSettings struct {
+	// FailureThreshold consecutive failures open the breaker
+	FailureThreshold int	`json:"failure_threshold"`
+	// OpenTimeout is how long the breaker stays open before letting trial calls through
+	OpenTimeout time.Duration	`json:"open_timeout"`
+	// HalfOpenMaxCalls trial calls are allowed concurrently while half-open; all must succeed to close
+	HalfOpenMaxCalls int	`json:"half_open_max_calls"`
+}
+
+func DefaultBreakerSettings() BreakerSettings {
+	return BreakerSettings{
+		FailureThreshold: 5,
+		OpenTimeout: 30 * time.Second,
+		HalfOpenMaxCalls: 1,
+	}
+}
+
+type CircuitBreaker struct {
+	Name string
+	settings BreakerSettings
+
+	state string
+	consecutiveFailures int
+	openedAt time.Time
+	halfOpenCalls int
+	halfOpenSuccesses int
+
+	trips int64
+	rejected int64
+	successes int64
+	failures int64
+
+	mu sync.Mutex
+}
+
+// BreakerStatus is a point in time view of a breaker, used by the status endpoint and metrics
+type BreakerStatus struct {
+	Name string				`json:"name"`
+	State string			`json:"state"`
+	ConsecutiveFailures int	`json:"consecutive_failures"`
+	OpenedAt *time.Time		`json:"opened_at,omitempty"`
+	Trips int64				`json:"trips"`
+	Rejected int64			`json:"rejected"`
+	Successes int64			`json:"successes"`
+	Failures int64			`json:"failures"`
+}
+
+func NewCircuitBreaker(name string, settings BreakerSettings) *CircuitBreaker {
+	if settings.FailureThreshold <= 0 {
+		settings.FailureThreshold = 1
+	}
+	if settings.HalfOpenMaxCalls <= 0 {
+		settings.HalfOpenMaxCalls = 1
+	}
+
+	return &CircuitBreaker{
+		Name: name,
+		settings: settings,
+		state: StateClosed,
+	}
+}
+
+// Allow reports whether a call may go through. Every allowed call must be followed by Record.
+func (cb *CircuitBreaker) Allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == StateOpen && time.Since(cb.openedAt) >= cb.settings.OpenTimeout {
+		cb.state = StateHalfOpen
+		cb.halfOpenCalls = 0
+		cb.halfOpenSuccesses = 0
+	}
+
+	switch cb.state {
+	case StateOpen:
+		cb.rejected++
+		return ErrCircuitOpen
+	case StateHalfOpen:
+		if cb.halfOpenCalls >= cb.settings.HalfOpenMaxCalls {
+			cb.rejected++
+			return ErrCircuitOpen
+		}
+		cb.halfOpenCalls++
+	}
+
+	return nil
+}
+
+// Record reports the outcome of an allowed call. Only acquirer health counts as failure,
+// a declined transaction is a success as far as the breaker is concerned.
+func (cb *CircuitBreaker) Record(success bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if success {
+		cb.successes++
+		cb.consecutiveFailures = 0
+
+		if cb.state == StateHalfOpen {
+			cb.halfOpenSuccesses++
+			if cb.halfOpenSuccesses >= cb.settings.HalfOpenMaxCalls {
+				cb.state = StateClosed
+			}
+		}
+		return
+	}
+
+	cb.failures++
+	cb.consecutiveFailures++
+
+	if cb.state == StateHalfOpen || cb.consecutiveFailures >= cb.settings.FailureThreshold {
+		cb.trip()
+	}
+}
+
+// Release gives back an allowed call without recording an outcome
+func (cb *CircuitBreaker) Release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == StateHalfOpen && cb.halfOpenCalls > 0 {
+		cb.halfOpenCalls--
+	}
+}
+
+func (cb *CircuitBreaker) trip() {
+	if cb.state != StateOpen {
+		cb.trips++
+	}
+
+	cb.state = StateOpen
+	cb.openedAt = time.Now()
+}
+
+func (cb *CircuitBreaker) Status() BreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status := BreakerStatus{
+		Name: cb.Name,
+		State: cb.state,
+		ConsecutiveFailures: cb.consecutiveFailures,
+		Trips: cb.trips,
+		Rejected: cb.rejected,
+		Successes: cb.successes,
+		Failures: cb.failures,
+	}
+
+	if cb.state != StateClosed {
+		openedAt := cb.openedAt
+		status.OpenedAt = &openedAt
+	}
+
+	return status
+}
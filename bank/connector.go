@@ -0,0 +1,235 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/nktsitas/checkout-techlab/logger"
+	"github.com/nktsitas/checkout-techlab/metrics"
+	"github.com/nktsitas/checkout-techlab/tracing"
+)
+
+// ErrNetwork is matched (errors.Is) by errors raised before a request reached the acquirer,
+// so retrying them can never duplicate a transaction.
+var ErrNetwork = errors.New("Acquirer unreachable")
+
+type NetworkError struct {
+	Operation string
+}
+
+func (ne *NetworkError) Error() string {
+	return fmt.Sprintf("%s failure - %s", operationLabel(ne.Operation), ErrNetwork.Error())
+}
+
+func (ne *NetworkError) Is(target error) bool {
+	return target == ErrNetwork
+}
+
+// Operations that can safely be repeated at the acquirer even when a previous attempt may have landed
+var idempotentOperations = map[string]bool{
+	"void": true,
+}
+
+type RetryPolicy struct {
+	// MaxAttempts includes the first call, 1 disables retries
+	MaxAttempts int `json:"max_attempts"`
+	BaseDelay time.Duration `json:"base_delay"`
+	MaxDelay time.Duration `json:"max_delay"`
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		BaseDelay: 100 * time.Millisecond,
+		MaxDelay: 2 * time.Second,
+	}
+}
+
+// backoff uses full jitter: a random delay up to the exponentially growing cap
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := rp.BaseDelay << uint(attempt)
+	if ceiling <= 0 || ceiling > rp.MaxDelay {
+		ceiling = rp.MaxDelay
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// AcquirerI is the transport to one acquirer, a single attempt per call
+type AcquirerI interface {
+	Send(ctx context.Context, cc *CreditCard, operation string, amount float64) (*Response, error)
+}
+
+// Connector guards the calls to one acquirer with a circuit breaker and a retry policy
+type Connector struct {
+	Name string
+	Backend AcquirerI
+	Breaker *CircuitBreaker
+	Retry RetryPolicy
+}
+
+// DefaultAcquirer is used when no routing is configured, and for authorizations
+// that were not assigned an acquirer
+const DefaultAcquirer = "simulator"
+
+// connectors are keyed by acquirer name, replaced as a whole by ConfigureAcquirers
+var connectors = map[string]*Connector{
+	DefaultAcquirer: NewConnector(DefaultAcquirer, &Simulator{}, DefaultBreakerSettings(), DefaultRetryPolicy()),
+}
+
+func NewConnector(name string, backend AcquirerI, breakerSettings BreakerSettings, retry RetryPolicy) *Connector {
+	return &Connector{
+		Name: name,
+		Backend: backend,
+		Breaker: NewCircuitBreaker(name, breakerSettings),
+		Retry: retry,
+	}
+}
+
+func GetConnector(name string) (*Connector, error) {
+	if name == "" {
+		name = DefaultAcquirer
+	}
+
+	connector, ok := connectors[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown acquirer %q", name)
+	}
+
+	return connector, nil
+}
+
+// Statuses reports the breaker state of every acquirer connector
+func Statuses() []BreakerStatus {
+	statuses := make([]BreakerStatus, 0, len(connectors))
+	for _, iterConnector := range connectors {
+		statuses = append(statuses, iterConnector.Breaker.Status())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses
+}
+
+// Connectors returns every acquirer connector, by name
+func Connectors() []*Connector {
+	result := make([]*Connector, 0, len(connectors))
+	for _, iterConnector := range connectors {
+		result = append(result, iterConnector)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+
+	return result
+}
+
+// PingerI is implemented by acquirer backends that can be unreachable, local stubs always answer
+type PingerI interface {
+	Ping(context.Context) error
+}
+
+// Ping checks the acquirer can be reached, without sending a transaction
+func (c *Connector) Ping(ctx context.Context) error {
+	if pinger, ok := c.Backend.(PingerI); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+// Execute runs call through the breaker, retrying failures that are safe to retry
+func (c *Connector) Execute(ctx context.Context, operation string, call func(context.Context) error) error {
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		if allowErr := c.Breaker.Allow(); allowErr != nil {
+			logger.FromContext(ctx).WithFields(log.Fields{
+				"acquirer": c.Name,
+				"operation": operation,
+			}).Error("Connector.Execute - Circuit open, failing fast")
+
+			return fmt.Errorf("%s failure - %w", operationLabel(operation), allowErr)
+		}
+
+		start := time.Now()
+		attemptCtx, span := tracing.Start(ctx, "acquirer." + operation, attribute.String("acquirer", c.Name), attribute.Int("attempt", attempt + 1))
+		err = call(attemptCtx)
+		tracing.End(span, err)
+		metrics.AcquirerDuration.WithLabelValues(c.Name, operation, callOutcome(ctx, err)).Observe(time.Since(start).Seconds())
+
+		if ctx.Err() != nil {
+			// the caller gave up, that says nothing about the acquirer's health
+			c.Breaker.Release()
+			return err
+		}
+		c.Breaker.Record(!isAcquirerFailure(err))
+
+		if err == nil || !c.retryable(operation, err) || attempt+1 >= c.Retry.MaxAttempts {
+			return err
+		}
+
+		delay := c.Retry.backoff(attempt)
+
+		logger.FromContext(ctx).WithFields(log.Fields{
+			"acquirer": c.Name,
+			"operation": operation,
+			"attempt": attempt + 1,
+			"delay": delay,
+			"err": err,
+		}).Warn("Connector.Execute - Retrying acquirer call")
+
+		select {
+		case <- time.After(delay):
+		case <- ctx.Done():
+			return err
+		}
+	}
+}
+
+func (c *Connector) retryable(operation string, err error) bool {
+	if errors.Is(err, ErrNetwork) {
+		return true
+	}
+
+	return idempotentOperations[operation] && errors.Is(err, ErrUnknownOutcome)
+}
+
+// callOutcome labels a single acquirer call for metrics
+func callOutcome(ctx context.Context, err error) string {
+	var declineErr *DeclineError
+
+	switch {
+	case err == nil:
+		return "success"
+	case ctx.Err() != nil:
+		return "cancelled"
+	case errors.As(err, &declineErr):
+		return "declined"
+	case errors.Is(err, ErrNetwork):
+		return "network_error"
+	case errors.Is(err, ErrUnknownOutcome):
+		return "unknown_outcome"
+	}
+
+	return "error"
+}
+
+// isAcquirerFailure tells apart an unhealthy acquirer from an answer we did not like (e.g. a decline)
+func isAcquirerFailure(err error) bool {
+	return errors.Is(err, ErrNetwork) || errors.Is(err, ErrUnknownOutcome)
+}
@@ -0,0 +1,193 @@
+package bank
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	assert := assert.New(t)
+
+	breaker := NewCircuitBreaker("test", BreakerSettings{
+		FailureThreshold: 2,
+		OpenTimeout: 20 * time.Millisecond,
+		HalfOpenMaxCalls: 1,
+	})
+
+	steps := []struct{
+		wait time.Duration
+		allowErr error
+		success bool
+		state string
+		description string
+	}{
+		{0, nil, false, StateClosed, "Closed - One failure below threshold"},
+		{0, nil, true, StateClosed, "Closed - Success resets consecutive failures"},
+		{0, nil, false, StateClosed, "Closed - One failure below threshold again"},
+		{0, nil, false, StateOpen, "Open - Threshold reached"},
+		{0, ErrCircuitOpen, false, StateOpen, "Open - Calls rejected"},
+		{30 * time.Millisecond, nil, false, StateOpen, "Half open - Trial call fails and reopens"},
+		{30 * time.Millisecond, nil, true, StateClosed, "Half open - Trial call succeeds and closes"},
+	}
+
+	for _, iterStep := range steps {
+		time.Sleep(iterStep.wait)
+
+		err := breaker.Allow()
+		if err == nil {
+			breaker.Record(iterStep.success)
+		}
+
+		assert.Equal(iterStep.allowErr, err, iterStep.description)
+		assert.Equal(iterStep.state, breaker.Status().State, iterStep.description)
+	}
+
+	assert.Equal(int64(2), breaker.Status().Trips, "Trips counted")
+	assert.Equal(int64(1), breaker.Status().Rejected, "Rejections counted")
+}
+
+func TestConnectorExecute(t *testing.T) {
+	assert := assert.New(t)
+
+	retry := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+	tests := []struct{
+		operation string
+		errs []error
+		calls int
+		err error
+		description string
+	}{
+		{
+			"charge",
+			[]error{nil},
+			1,
+			nil,
+			"OK - First attempt succeeds",
+		},
+		{
+			"charge",
+			[]error{&NetworkError{"charge"}, nil},
+			2,
+			nil,
+			"OK - Network error retried",
+		},
+		{
+			"charge",
+			[]error{&NetworkError{"charge"}, &NetworkError{"charge"}, &NetworkError{"charge"}},
+			3,
+			&NetworkError{"charge"},
+			"Error - Gives up after max attempts",
+		},
+		{
+			"charge",
+			[]error{&UnknownOutcomeError{"charge", context.DeadlineExceeded}, nil},
+			1,
+			&UnknownOutcomeError{"charge", context.DeadlineExceeded},
+			"Error - Unknown outcome of a charge is never retried",
+		},
+		{
+			"void",
+			[]error{&UnknownOutcomeError{"void", context.DeadlineExceeded}, nil},
+			2,
+			nil,
+			"OK - Unknown outcome of an idempotent void is retried",
+		},
+		{
+			"charge",
+			[]error{&DeclineError{"charge", "05", "Unknown Error"}, nil},
+			1,
+			&DeclineError{"charge", "05", "Unknown Error"},
+			"Error - Declines are not retried",
+		},
+	}
+
+	for _, iterTest := range tests {
+		connector := NewConnector("test", &Simulator{}, DefaultBreakerSettings(), retry)
+
+		calls := 0
+		err := connector.Execute(context.Background(), iterTest.operation, func(ctx context.Context) error {
+			calls++
+			return iterTest.errs[calls-1]
+		})
+
+		assert.Equal(iterTest.calls, calls, iterTest.description)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+}
+
+func TestConnectorFailsFast(t *testing.T) {
+	assert := assert.New(t)
+
+	connector := NewConnector("test", &Simulator{}, BreakerSettings{FailureThreshold: 1, OpenTimeout: time.Minute}, RetryPolicy{MaxAttempts: 1})
+
+	calls := 0
+	call := func(ctx context.Context) error {
+		calls++
+		return &NetworkError{"charge"}
+	}
+
+	err := connector.Execute(context.Background(), "charge", call)
+	assert.Equal(&NetworkError{"charge"}, err, "Network error opens the breaker")
+
+	err = connector.Execute(context.Background(), "charge", call)
+	assert.True(errors.Is(err, ErrCircuitOpen), "Open breaker fails fast")
+	assert.Equal("Capture failure - Acquirer unavailable, failing fast", err.Error(), "Open breaker fails fast")
+	assert.Equal(1, calls, "Acquirer not contacted while open")
+}
+
+func TestHTTPAcquirer(t *testing.T) {
+	assert := assert.New(t)
+
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+	var received http.Header
+	var body httpAcquirerRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		json.NewDecoder(r.Body).Decode(&body)
+
+		switch body.Amount {
+		case 100:
+			json.NewEncoder(w).Encode(Response{ApprovalCode: "123456", Reference: "ref_1"})
+		case 200:
+			w.WriteHeader(http.StatusPaymentRequired)
+			json.NewEncoder(w).Encode(httpAcquirerDecline{ReasonCode: "51", Message: "Insufficient Funds"})
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	// a request carrying the caller's trace context, as the tracing middleware leaves it
+	header := http.Header{}
+	header.Set("traceparent", traceparent)
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier(header))
+
+	acquirer := NewHTTPAcquirer(server.URL)
+	cc := &CreditCard{Number: "4242424242424242", Expiry: "12/30", Cvv: "123"}
+
+	resp, err := acquirer.Send(ctx, cc, "authorize", 100)
+	assert.NoError(err, "Approved")
+	assert.Equal(&Response{ApprovalCode: "123456", Reference: "ref_1"}, resp, "Approved")
+	assert.Equal("authorize", body.Operation, "Approved - Operation sent")
+	assert.Equal(traceparent, received.Get("traceparent"), "Approved - Trace context propagated")
+
+	_, err = acquirer.Send(ctx, cc, "capture", 200)
+	assert.Equal(&DeclineError{Operation: "capture", Code: "51", Message: "Insufficient Funds"}, err, "Declined")
+
+	_, err = acquirer.Send(ctx, cc, "refund", 300)
+	assert.True(errors.Is(err, ErrUnknownOutcome), "Acquirer failure - Outcome unknown")
+
+	_, err = NewHTTPAcquirer("http://127.0.0.1:1").Send(ctx, cc, "authorize", 100)
+	assert.Equal(&NetworkError{Operation: "authorize"}, err, "Unreachable")
+}
@@ -0,0 +1,125 @@
+package bank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/nktsitas/checkout-techlab/logger"
+	"github.com/nktsitas/checkout-techlab/tracing"
+)
+
+// HTTPAcquirer sends transactions to an acquirer's HTTP endpoint, one POST per attempt.
+// Approvals answer 200 with a Response, declines 402 with a reason_code and a message.
+type HTTPAcquirer struct {
+	URL string
+	Client *http.Client
+}
+
+type httpAcquirerRequest struct {
+	Operation string			`json:"operation"`
+	Amount float64				`json:"amount"`
+	Card *CreditCard			`json:"card"`
+}
+
+type httpAcquirerDecline struct {
+	ReasonCode string	`json:"reason_code"`
+	Message string		`json:"message"`
+}
+
+// NewHTTPAcquirer relies on the operation deadlines (Timeouts) rather than a client timeout
+func NewHTTPAcquirer(endpoint string) *HTTPAcquirer {
+	return &HTTPAcquirer{
+		URL: endpoint,
+		Client: &http.Client{},
+	}
+}
+
+// Send performs a single attempt, bounded by the operation's deadline
+func (ha *HTTPAcquirer) Send(ctx context.Context, cc *CreditCard, action string, amount float64) (*Response, error) {
+	if timeout, ok := Timeouts[action]; ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	body, err := json.Marshal(httpAcquirerRequest{Operation: action, Amount: amount, Card: cc})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, ha.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if requestID := logger.RequestIDFromContext(ctx); requestID != "" {
+		req.Header.Set(logger.RequestIDHeader, requestID)
+	}
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := ha.Client.Do(req)
+	if err != nil {
+		// nothing was sent when the connection could not be made, anything later may have reached the acquirer
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			logger.FromContext(ctx).WithField("err", err).Error("HTTPAcquirer.Send - Acquirer unreachable")
+			return nil, &NetworkError{Operation: action}
+		}
+
+		logger.FromContext(ctx).WithField("err", err).Error("HTTPAcquirer.Send - No answer from acquirer, outcome unknown")
+		return nil, &UnknownOutcomeError{Operation: action, Err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		var approval Response
+		if err := json.NewDecoder(resp.Body).Decode(&approval); err != nil {
+			return nil, &UnknownOutcomeError{Operation: action, Err: fmt.Errorf("unreadable approval - %w", err)}
+		}
+
+		return &approval, nil
+	case resp.StatusCode == http.StatusPaymentRequired:
+		decline := httpAcquirerDecline{ReasonCode: "05", Message: "Unknown Error"}
+		json.NewDecoder(resp.Body).Decode(&decline)
+
+		return nil, &DeclineError{Operation: action, Code: decline.ReasonCode, Message: decline.Message}
+	}
+
+	// the acquirer failed while handling the request, we can't tell whether it went through
+	return nil, &UnknownOutcomeError{
+		Operation: action,
+		Err: fmt.Errorf("acquirer answered %d", resp.StatusCode),
+	}
+}
+
+// Ping opens a TCP connection to the acquirer's host, without sending a request
+func (ha *HTTPAcquirer) Ping(ctx context.Context) error {
+	endpoint, err := url.Parse(ha.URL)
+	if err != nil {
+		return err
+	}
+
+	address := endpoint.Host
+	if endpoint.Port() == "" {
+		port := "80"
+		if endpoint.Scheme == "https" {
+			port = "443"
+		}
+		address = net.JoinHostPort(endpoint.Hostname(), port)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
@@ -0,0 +1,332 @@
+package bank
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RoutingConfig declares the acquirers and the rules that pick them.
+// Each route is an ordered list: the first acquirer is the primary, the rest are failovers.
+type RoutingConfig struct {
+	Acquirers []AcquirerConfig	`json:"acquirers" yaml:"acquirers"`
+	Default []string			`json:"default" yaml:"default"`
+	Rules []Rule				`json:"rules" yaml:"rules"`
+}
+
+// AcquirerConfig declares one acquirer. Acquirers with a URL are reached over HTTP,
+// the others are local stubs, each optionally answering from its own scenarios file.
+type AcquirerConfig struct {
+	Name string				`json:"name" yaml:"name"`
+	URL string				`json:"url" yaml:"url"`
+	ScenariosFile string	`json:"scenarios_file" yaml:"scenarios_file"`
+}
+
+// Rule matches when every matcher that is set matches, first matching rule wins
+type Rule struct {
+	Name string				`json:"name" yaml:"name"`
+	Brands []string			`json:"brands" yaml:"brands"`
+	Currencies []string		`json:"currencies" yaml:"currencies"`
+	BinRanges []BinRange	`json:"bin_ranges" yaml:"bin_ranges"`
+	MinAmount *float64		`json:"min_amount" yaml:"min_amount"`
+	MaxAmount *float64		`json:"max_amount" yaml:"max_amount"`
+	Merchants []string		`json:"merchants" yaml:"merchants"`
+
+	Acquirers []string	`json:"acquirers" yaml:"acquirers"`
+}
+
+// BinRange is an inclusive range of card number prefixes of equal length, e.g. 400000-499999
+type BinRange struct {
+	From string	`json:"from" yaml:"from"`
+	To string	`json:"to" yaml:"to"`
+}
+
+// RouteRequest holds what routing rules can match on
+type RouteRequest struct {
+	Brand string
+	BIN string
+	Currency string
+	Amount float64
+	Merchant string
+}
+
+type Router struct {
+	Default []string
+	Rules []Rule
+}
+
+// Routing picks acquirers for new authorizations
+var Routing = &Router{
+	Default: []string{DefaultAcquirer},
+}
+
+var validBrands = map[string]bool{
+	BrandVisa: true,
+	BrandMastercard: true,
+	BrandAmex: true,
+	BrandDiscover: true,
+	BrandUnknown: true,
+}
+
+func DefaultRoutingConfig() *RoutingConfig {
+	return &RoutingConfig{
+		Acquirers: []AcquirerConfig{{Name: DefaultAcquirer}},
+		Default: []string{DefaultAcquirer},
+	}
+}
+
+// LoadRouting reads a routing configuration from a YAML (.yaml/.yml) or JSON file, unknown keys are errors
+func LoadRouting(path string) (*RoutingConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading routing file - %s", err.Error())
+	}
+
+	config := &RoutingConfig{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, config)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing routing file - %s", err.Error())
+	}
+
+	// scenario files are relative to the routing file
+	for i := range config.Acquirers {
+		if config.Acquirers[i].ScenariosFile != "" && !filepath.IsAbs(config.Acquirers[i].ScenariosFile) {
+			config.Acquirers[i].ScenariosFile = filepath.Join(filepath.Dir(path), config.Acquirers[i].ScenariosFile)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (rc *RoutingConfig) Validate() error {
+	if len(rc.Acquirers) == 0 {
+		return fmt.Errorf("Invalid routing - no acquirers declared")
+	}
+
+	known := make(map[string]bool)
+	for _, iterAcquirer := range rc.Acquirers {
+		if iterAcquirer.Name == "" {
+			return fmt.Errorf("Invalid routing - acquirer without a name")
+		}
+		if known[iterAcquirer.Name] {
+			return fmt.Errorf("Invalid routing - acquirer %q declared twice", iterAcquirer.Name)
+		}
+		known[iterAcquirer.Name] = true
+
+		if iterAcquirer.URL != "" {
+			if parsed, err := url.Parse(iterAcquirer.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("Invalid routing - acquirer %q: url must be an http(s) URL", iterAcquirer.Name)
+			}
+			if iterAcquirer.ScenariosFile != "" {
+				return fmt.Errorf("Invalid routing - acquirer %q: scenarios_file only applies to stub acquirers", iterAcquirer.Name)
+			}
+		}
+	}
+
+	if err := validateRoute("default", rc.Default, known); err != nil {
+		return err
+	}
+
+	for i, iterRule := range rc.Rules {
+		name := iterRule.Name
+		if name == "" {
+			name = fmt.Sprintf("rule #%d", i)
+		}
+
+		if err := validateRoute(name, iterRule.Acquirers, known); err != nil {
+			return err
+		}
+
+		for _, iterBrand := range iterRule.Brands {
+			if !validBrands[iterBrand] {
+				return fmt.Errorf("Invalid routing - %s: unknown brand %q", name, iterBrand)
+			}
+		}
+
+		for _, iterRange := range iterRule.BinRanges {
+			if err := iterRange.validate(); err != nil {
+				return fmt.Errorf("Invalid routing - %s: %s", name, err.Error())
+			}
+		}
+
+		if iterRule.MinAmount != nil && iterRule.MaxAmount != nil && *iterRule.MinAmount > *iterRule.MaxAmount {
+			return fmt.Errorf("Invalid routing - %s: min_amount is greater than max_amount", name)
+		}
+	}
+
+	return nil
+}
+
+func validateRoute(name string, acquirers []string, known map[string]bool) error {
+	if len(acquirers) == 0 {
+		return fmt.Errorf("Invalid routing - %s: no acquirers", name)
+	}
+
+	for _, iterAcquirer := range acquirers {
+		if !known[iterAcquirer] {
+			return fmt.Errorf("Invalid routing - %s: unknown acquirer %q", name, iterAcquirer)
+		}
+	}
+
+	return nil
+}
+
+// SetEndpoints makes the named acquirers HTTP acquirers reached at the given URLs
+func (rc *RoutingConfig) SetEndpoints(endpoints map[string]string) error {
+	for name, endpoint := range endpoints {
+		found := false
+		for i := range rc.Acquirers {
+			if rc.Acquirers[i].Name == name {
+				rc.Acquirers[i].URL = endpoint
+				rc.Acquirers[i].ScenariosFile = ""
+				found = true
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("Invalid routing - acquirer %q has an endpoint but isn't declared", name)
+		}
+	}
+
+	return nil
+}
+
+// ConfigureAcquirers replaces the acquirer connectors and routing rules. It is meant to run at startup.
+func ConfigureAcquirers(config *RoutingConfig, breakerSettings BreakerSettings, retry RetryPolicy) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	configured := make(map[string]*Connector)
+
+	for _, iterAcquirer := range config.Acquirers {
+		if iterAcquirer.URL != "" {
+			configured[iterAcquirer.Name] = NewConnector(iterAcquirer.Name, NewHTTPAcquirer(iterAcquirer.URL), breakerSettings, retry)
+			continue
+		}
+
+		simulator := &Simulator{}
+
+		if iterAcquirer.ScenariosFile != "" {
+			scenarios, err := LoadScenarios(iterAcquirer.ScenariosFile)
+			if err != nil {
+				return fmt.Errorf("Acquirer %s - %s", iterAcquirer.Name, err.Error())
+			}
+			simulator.Scenarios = scenarios
+		}
+
+		configured[iterAcquirer.Name] = NewConnector(iterAcquirer.Name, simulator, breakerSettings, retry)
+	}
+
+	connectors = configured
+	Routing = &Router{
+		Default: config.Default,
+		Rules: config.Rules,
+	}
+
+	return nil
+}
+
+// Route returns the acquirers to try for a new authorization, in order
+func (r *Router) Route(req RouteRequest) []string {
+	for _, iterRule := range r.Rules {
+		if iterRule.matches(req) {
+			return iterRule.Acquirers
+		}
+	}
+
+	return r.Default
+}
+
+func (rule *Rule) matches(req RouteRequest) bool {
+	if len(rule.Brands) > 0 && !containsFold(rule.Brands, req.Brand) {
+		return false
+	}
+
+	if len(rule.Currencies) > 0 && !containsFold(rule.Currencies, req.Currency) {
+		return false
+	}
+
+	if len(rule.Merchants) > 0 && !containsFold(rule.Merchants, req.Merchant) {
+		return false
+	}
+
+	if rule.MinAmount != nil && req.Amount < *rule.MinAmount {
+		return false
+	}
+
+	if rule.MaxAmount != nil && req.Amount > *rule.MaxAmount {
+		return false
+	}
+
+	if len(rule.BinRanges) == 0 {
+		return true
+	}
+
+	for _, iterRange := range rule.BinRanges {
+		if iterRange.contains(req.BIN) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (br BinRange) validate() error {
+	if len(br.From) == 0 || len(br.From) != len(br.To) {
+		return fmt.Errorf("bin range %s-%s must have bounds of equal length", br.From, br.To)
+	}
+
+	if len(br.From) > 6 {
+		return fmt.Errorf("bin range %s-%s cannot be longer than 6 digits", br.From, br.To)
+	}
+
+	if _, err := strconv.ParseUint(br.From+br.To, 10, 64); err != nil {
+		return fmt.Errorf("bin range %s-%s must be numeric", br.From, br.To)
+	}
+
+	if br.From > br.To {
+		return fmt.Errorf("bin range %s-%s is reversed", br.From, br.To)
+	}
+
+	return nil
+}
+
+// contains compares the bin's prefix of the bounds' length; equal length digit strings order like numbers
+func (br BinRange) contains(bin string) bool {
+	if len(bin) < len(br.From) {
+		return false
+	}
+
+	prefix := bin[:len(br.From)]
+
+	return prefix >= br.From && prefix <= br.To
+}
+
+func containsFold(values []string, value string) bool {
+	for _, iterValue := range values {
+		if strings.EqualFold(iterValue, value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,231 @@
+package bank
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	assert := assert.New(t)
+
+	minAmount := 1000.00
+
+	router := &Router{
+		Default: []string{"a", "b"},
+		Rules: []Rule{
+			{
+				Brands: []string{BrandAmex},
+				Acquirers: []string{"amex"},
+			},
+			{
+				Currencies: []string{"USD"},
+				BinRanges: []BinRange{{"400000", "449999"}},
+				Acquirers: []string{"b", "a"},
+			},
+			{
+				MinAmount: &minAmount,
+				Merchants: []string{"checkout"},
+				Acquirers: []string{"c"},
+			},
+		},
+	}
+
+	tests := []struct{
+		req RouteRequest
+		expected []string
+		description string
+	}{
+		{
+			RouteRequest{Brand: BrandVisa, BIN: "400000", Currency: "EUR", Amount: 10},
+			[]string{"a", "b"},
+			"Default - No rule matches",
+		},
+		{
+			RouteRequest{Brand: BrandAmex, BIN: "378282", Currency: "EUR", Amount: 10},
+			[]string{"amex"},
+			"Rule - Brand",
+		},
+		{
+			RouteRequest{Brand: BrandVisa, BIN: "424242", Currency: "usd", Amount: 10},
+			[]string{"b", "a"},
+			"Rule - Currency and BIN range",
+		},
+		{
+			RouteRequest{Brand: BrandVisa, BIN: "455555", Currency: "USD", Amount: 10},
+			[]string{"a", "b"},
+			"Default - BIN out of range",
+		},
+		{
+			RouteRequest{Brand: BrandVisa, BIN: "455555", Currency: "EUR", Amount: 5000, Merchant: "Checkout"},
+			[]string{"c"},
+			"Rule - Amount and merchant",
+		},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.expected, router.Route(iterTest.req), iterTest.description)
+	}
+}
+
+func TestRoutingValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct{
+		config RoutingConfig
+		err error
+		description string
+	}{
+		{
+			RoutingConfig{
+				Acquirers: []AcquirerConfig{{Name: "a"}, {Name: "b"}},
+				Default: []string{"a", "b"},
+				Rules: []Rule{{Brands: []string{BrandVisa}, BinRanges: []BinRange{{"4000", "4999"}}, Acquirers: []string{"b"}}},
+			},
+			nil,
+			"OK - Valid routing",
+		},
+		{
+			RoutingConfig{
+				Acquirers: []AcquirerConfig{{Name: "a"}},
+				Default: []string{"a", "b"},
+			},
+			errors.New(`Invalid routing - default: unknown acquirer "b"`),
+			"Error - Unknown acquirer in default route",
+		},
+		{
+			RoutingConfig{
+				Acquirers: []AcquirerConfig{{Name: "a"}},
+				Default: []string{"a"},
+				Rules: []Rule{{Name: "bins", BinRanges: []BinRange{{"4999", "4000"}}, Acquirers: []string{"a"}}},
+			},
+			errors.New("Invalid routing - bins: bin range 4999-4000 is reversed"),
+			"Error - Reversed BIN range",
+		},
+		{
+			RoutingConfig{
+				Acquirers: []AcquirerConfig{{Name: "a"}},
+				Default: []string{"a"},
+				Rules: []Rule{{Brands: []string{"diners"}, Acquirers: []string{"a"}}},
+			},
+			errors.New(`Invalid routing - rule #0: unknown brand "diners"`),
+			"Error - Unknown brand",
+		},
+		{
+			RoutingConfig{
+				Acquirers: []AcquirerConfig{{Name: "a", URL: "acquirer.local/payments"}},
+				Default: []string{"a"},
+			},
+			errors.New(`Invalid routing - acquirer "a": url must be an http(s) URL`),
+			"Error - Acquirer URL without a scheme",
+		},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.err, iterTest.config.Validate(), iterTest.description)
+	}
+}
+
+func TestLoadRouting(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := LoadRouting("../routing.example.yaml")
+	assert.NoError(err, "Example file")
+
+	dir, err := ioutil.TempDir("", "routing")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct{
+		content string
+		err error
+		description string
+	}{
+		{`{"acquirers": [{"name": "a"}], "default": ["a"]}`, nil, "OK - JSON file"},
+		{`{"acquirers": [{"name": "a"}], "defualt": ["a"]}`, errors.New(`Error parsing routing file - json: unknown field "defualt"`), "Error - Unknown JSON key"},
+	}
+
+	for _, iterTest := range tests {
+		path := filepath.Join(dir, "routing.json")
+		assert.NoError(ioutil.WriteFile(path, []byte(iterTest.content), 0644))
+
+		_, err := LoadRouting(path)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+}
+
+func TestAuthorizeFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	defer ConfigureAcquirers(DefaultRoutingConfig(), DefaultBreakerSettings(), DefaultRetryPolicy())
+
+	unreachable := &Simulator{&ScenarioRegistry{Scenarios: []Scenario{{Outcome: OutcomeNetworkError}}}}
+	declining := &Simulator{&ScenarioRegistry{Scenarios: []Scenario{{Outcome: OutcomeDecline}}}}
+	approving := &Simulator{&ScenarioRegistry{}}
+
+	tests := []struct{
+		backends []AcquirerI
+		acquirer string
+		err error
+		description string
+	}{
+		{
+			[]AcquirerI{approving, unreachable},
+			"a",
+			nil,
+			"OK - Primary authorizes",
+		},
+		{
+			[]AcquirerI{unreachable, approving},
+			"b",
+			nil,
+			"OK - Failover on network error",
+		},
+		{
+			[]AcquirerI{declining, approving},
+			"a",
+			&DeclineError{"authorize", "05", "Unknown Error"},
+			"Decline - No failover on a decline",
+		},
+		{
+			[]AcquirerI{unreachable, unreachable},
+			"",
+			&NetworkError{"authorize"},
+			"Error - All acquirers unreachable",
+		},
+	}
+
+	cc := &CreditCard{Number: "4000 0000 0000 0123", Expiry: "12/22", Cvv: "123"}
+
+	for _, iterTest := range tests {
+		connectors = map[string]*Connector{
+			"a": NewConnector("a", iterTest.backends[0], DefaultBreakerSettings(), RetryPolicy{MaxAttempts: 1}),
+			"b": NewConnector("b", iterTest.backends[1], DefaultBreakerSettings(), RetryPolicy{MaxAttempts: 1}),
+		}
+
+		acquirer, resp, err := cc.Authorize(context.Background(), []string{"a", "b"}, 10.00)
+
+		assert.Equal(iterTest.acquirer, acquirer, iterTest.description)
+		assert.Equal(iterTest.err, err, iterTest.description)
+		assert.Equal(iterTest.err == nil, resp != nil, iterTest.description)
+	}
+}
+
+func TestSetEndpoints(t *testing.T) {
+	assert := assert.New(t)
+
+	config := &RoutingConfig{
+		Acquirers: []AcquirerConfig{{Name: "a", ScenariosFile: "scenarios.yaml"}, {Name: "b"}},
+		Default: []string{"a", "b"},
+	}
+
+	assert.NoError(config.SetEndpoints(map[string]string{"a": "https://a.example.com/payments"}))
+	assert.Equal([]AcquirerConfig{{Name: "a", URL: "https://a.example.com/payments"}, {Name: "b"}}, config.Acquirers, "Stub replaced by an HTTP acquirer")
+
+	assert.Equal(errors.New(`Invalid routing - acquirer "c" has an endpoint but isn't declared`), config.SetEndpoints(map[string]string{"c": "https://c.example.com"}))
+}
@@ -0,0 +1,307 @@
+package bank
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Outcomes the simulated acquirer can answer with
+const (
+	OutcomeApprove = "approve"
+	OutcomeDecline = "decline"
+	OutcomeInsufficientFunds = "insufficient_funds"
+	OutcomeTimeout = "timeout"
+	OutcomeNetworkError = "network_error"
+)
+
+var validOutcomes = map[string]bool{
+	OutcomeApprove: true,
+	OutcomeDecline: true,
+	OutcomeInsufficientFunds: true,
+	OutcomeTimeout: true,
+	OutcomeNetworkError: true,
+}
+
+// Operations known to the simulator, mapped to the label used in error messages
+var operationLabels = map[string]string{
+	"authorize": "Authorization",
+	"charge":    "Capture",
+	"refund":    "Refund",
+	"void":      "Void",
+}
+
+// Latency is a uniform latency distribution in milliseconds
+type Latency struct {
+	MinMs int	`json:"min_ms" yaml:"min_ms"`
+	MaxMs int	`json:"max_ms" yaml:"max_ms"`
+}
+
+// Scenario maps a card number and/or an amount range to an outcome for a set of operations.
+// Empty matchers match everything, so a scenario with only an amount range applies to all cards.
+type Scenario struct {
+	Name string			`json:"name" yaml:"name"`
+	Card string			`json:"card" yaml:"card"`
+	MinAmount *float64	`json:"min_amount" yaml:"min_amount"`
+	MaxAmount *float64	`json:"max_amount" yaml:"max_amount"`
+	Operations []string	`json:"operations" yaml:"operations"`
+
+	Outcome string		`json:"outcome" yaml:"outcome"`
+	ReasonCode string	`json:"reason_code" yaml:"reason_code"`
+	Message string		`json:"message" yaml:"message"`
+
+	// Latency overrides the registry default for matching transactions
+	Latency *Latency	`json:"latency" yaml:"latency"`
+	// FailureRate is the probability (0-1) the outcome applies, otherwise the transaction is approved.
+	// Omitting it means the outcome always applies.
+	FailureRate *float64	`json:"failure_rate" yaml:"failure_rate"`
+}
+
+// ScenarioRegistry holds the scenarios the simulated acquirer consults, first match wins
+type ScenarioRegistry struct {
+	DefaultLatency Latency	`json:"default_latency" yaml:"default_latency"`
+	Scenarios []Scenario	`json:"scenarios" yaml:"scenarios"`
+}
+
+// DeclineError is returned when the acquirer does not approve a transaction
+type DeclineError struct {
+	Operation string
+	Code string
+	Message string
+}
+
+func (de *DeclineError) Error() string {
+	return fmt.Sprintf("%s failure - %s", operationLabel(de.Operation), de.Message)
+}
+
+// errNoAnswer tells the simulator to never answer, so the caller's deadline fires
+var errNoAnswer = errors.New("Acquirer does not answer")
+
+// Scenarios is the registry used by the simulated acquirer.
+// It defaults to the well-known test cards and can be replaced by LoadScenarios.
+var Scenarios = DefaultScenarios()
+
+func DefaultScenarios() *ScenarioRegistry {
+	return &ScenarioRegistry{
+		DefaultLatency: Latency{200, 200},
+		Scenarios: []Scenario{
+			{
+				Name: "Authorization failure",
+				Card: "4000 0000 0000 0119",
+				Operations: []string{"authorize"},
+				Outcome: OutcomeDecline,
+				ReasonCode: "05",
+				Message: "Unknown Error",
+			},
+			{
+				Name: "Capture failure",
+				Card: "4000 0000 0000 0259",
+				Operations: []string{"charge"},
+				Outcome: OutcomeDecline,
+				ReasonCode: "05",
+				Message: "Unknown Error",
+			},
+			{
+				Name: "Refund failure",
+				Card: "4000 0000 0000 3238",
+				Operations: []string{"refund"},
+				Outcome: OutcomeDecline,
+				ReasonCode: "05",
+				Message: "Unknown Error",
+			},
+		},
+	}
+}
+
+// LoadScenarios reads a scenario registry from a YAML (.yaml/.yml) or JSON file, unknown keys are errors
+func LoadScenarios(path string) (*ScenarioRegistry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading scenarios file - %s", err.Error())
+	}
+
+	registry := &ScenarioRegistry{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, registry)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(registry)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing scenarios file - %s", err.Error())
+	}
+
+	if err := registry.Validate(); err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+func (sr *ScenarioRegistry) Validate() error {
+	if err := sr.DefaultLatency.validate(); err != nil {
+		return fmt.Errorf("Invalid scenarios - default latency: %s", err.Error())
+	}
+
+	for i, iterScenario := range sr.Scenarios {
+		name := iterScenario.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i)
+		}
+
+		if !validOutcomes[iterScenario.Outcome] {
+			return fmt.Errorf("Invalid scenarios - %s: unknown outcome %q", name, iterScenario.Outcome)
+		}
+
+		for _, iterOperation := range iterScenario.Operations {
+			if _, ok := operationLabels[iterOperation]; !ok {
+				return fmt.Errorf("Invalid scenarios - %s: unknown operation %q", name, iterOperation)
+			}
+		}
+
+		if iterScenario.MinAmount != nil && iterScenario.MaxAmount != nil && *iterScenario.MinAmount > *iterScenario.MaxAmount {
+			return fmt.Errorf("Invalid scenarios - %s: min_amount is greater than max_amount", name)
+		}
+
+		if iterScenario.FailureRate != nil && (*iterScenario.FailureRate < 0 || *iterScenario.FailureRate > 1) {
+			return fmt.Errorf("Invalid scenarios - %s: failure_rate must be between 0 and 1", name)
+		}
+
+		if iterScenario.Latency != nil {
+			if err := iterScenario.Latency.validate(); err != nil {
+				return fmt.Errorf("Invalid scenarios - %s: %s", name, err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Match returns the first scenario that applies to the transaction, or nil
+func (sr *ScenarioRegistry) Match(operation, number string, amount float64) *Scenario {
+	for i := range sr.Scenarios {
+		if sr.Scenarios[i].matches(operation, number, amount) {
+			return &sr.Scenarios[i]
+		}
+	}
+
+	return nil
+}
+
+// Resolve decides how long the simulated acquirer takes to answer and what it answers with
+func (sr *ScenarioRegistry) Resolve(operation, number string, amount float64) (time.Duration, error) {
+	scenario := sr.Match(operation, number, amount)
+	if scenario == nil {
+		return sr.DefaultLatency.pick(), nil
+	}
+
+	latency := sr.DefaultLatency
+	if scenario.Latency != nil {
+		latency = *scenario.Latency
+	}
+
+	if scenario.FailureRate != nil && rand.Float64() >= *scenario.FailureRate {
+		return latency.pick(), nil
+	}
+
+	return latency.pick(), scenario.err(operation)
+}
+
+func (s *Scenario) matches(operation, number string, amount float64) bool {
+	if s.Card != "" && normalizeNumber(s.Card) != normalizeNumber(number) {
+		return false
+	}
+
+	if s.MinAmount != nil && amount < *s.MinAmount {
+		return false
+	}
+
+	if s.MaxAmount != nil && amount > *s.MaxAmount {
+		return false
+	}
+
+	if len(s.Operations) == 0 {
+		return true
+	}
+
+	for _, iterOperation := range s.Operations {
+		if iterOperation == operation {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Scenario) err(operation string) error {
+	var code, message string
+
+	switch s.Outcome {
+	case OutcomeApprove:
+		return nil
+	case OutcomeInsufficientFunds:
+		code, message = "51", "Insufficient Funds"
+	case OutcomeTimeout:
+		return errNoAnswer
+	case OutcomeNetworkError:
+		return &NetworkError{Operation: operation}
+	default:
+		code, message = "05", "Unknown Error"
+	}
+
+	if s.ReasonCode != "" {
+		code = s.ReasonCode
+	}
+	if s.Message != "" {
+		message = s.Message
+	}
+
+	return &DeclineError{
+		Operation: operation,
+		Code: code,
+		Message: message,
+	}
+}
+
+func (l Latency) validate() error {
+	if l.MinMs < 0 || l.MaxMs < 0 {
+		return errors.New("latency cannot be negative")
+	}
+
+	if l.MaxMs != 0 && l.MinMs > l.MaxMs {
+		return errors.New("latency min_ms is greater than max_ms")
+	}
+
+	return nil
+}
+
+func (l Latency) pick() time.Duration {
+	ms := l.MinMs
+	if l.MaxMs > l.MinMs {
+		ms += rand.Intn(l.MaxMs - l.MinMs + 1)
+	}
+
+	return time.Duration(ms) * time.Millisecond
+}
+
+func operationLabel(operation string) string {
+	if label, ok := operationLabels[operation]; ok {
+		return label
+	}
+
+	return operation
+}
+
+func normalizeNumber(number string) string {
+	return strings.Replace(number, " ", "", -1)
+}
@@ -0,0 +1,180 @@
+package bank
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolve(t *testing.T) {
+	assert := assert.New(t)
+
+	minAmount := 1000.00
+	never := 0.0
+
+	registry := &ScenarioRegistry{
+		DefaultLatency: Latency{10, 10},
+		Scenarios: []Scenario{
+			{
+				Card: "4000000000000119",
+				Operations: []string{"authorize"},
+				Outcome: OutcomeDecline,
+			},
+			{
+				MinAmount: &minAmount,
+				Outcome: OutcomeInsufficientFunds,
+				Latency: &Latency{20, 20},
+			},
+			{
+				Card: "4000 0000 0000 0341",
+				Outcome: OutcomeDecline,
+				FailureRate: &never,
+			},
+			{
+				Card: "4000 0000 0000 0408",
+				Outcome: OutcomeTimeout,
+			},
+		},
+	}
+
+	tests := []struct{
+		operation string
+		number string
+		amount float64
+		latency time.Duration
+		err error
+		description string
+	}{
+		{
+			"charge",
+			"4000 0000 0000 0123",
+			100.00,
+			10 * time.Millisecond,
+			nil,
+			"OK - No scenario matches",
+		},
+		{
+			"authorize",
+			"4000 0000 0000 0119",
+			100.00,
+			10 * time.Millisecond,
+			&DeclineError{"authorize", "05", "Unknown Error"},
+			"Decline - Card matches regardless of spacing",
+		},
+		{
+			"charge",
+			"4000 0000 0000 0119",
+			100.00,
+			10 * time.Millisecond,
+			nil,
+			"OK - Card matches but operation does not",
+		},
+		{
+			"refund",
+			"4000 0000 0000 0123",
+			1500.00,
+			20 * time.Millisecond,
+			&DeclineError{"refund", "51", "Insufficient Funds"},
+			"Insufficient Funds - Amount range with scenario latency",
+		},
+		{
+			"refund",
+			"4000 0000 0000 0341",
+			10.00,
+			10 * time.Millisecond,
+			nil,
+			"OK - Failure rate never triggers",
+		},
+		{
+			"charge",
+			"4000 0000 0000 0408",
+			10.00,
+			10 * time.Millisecond,
+			errNoAnswer,
+			"Timeout - Acquirer never answers",
+		},
+	}
+
+	for _, iterTest := range tests {
+		latency, err := registry.Resolve(iterTest.operation, iterTest.number, iterTest.amount)
+
+		assert.Equal(iterTest.latency, latency, iterTest.description)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+}
+
+func TestLoadScenarios(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "scenarios")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct{
+		filename string
+		content string
+		scenarios int
+		err error
+		description string
+	}{
+		{
+			"ok.yaml",
+			"default_latency: {min_ms: 5, max_ms: 50}\nscenarios:\n  - card: \"4000 0000 0000 0259\"\n    operations: [charge]\n    outcome: decline\n",
+			1,
+			nil,
+			"OK - YAML file",
+		},
+		{
+			"ok.json",
+			`{"scenarios": [{"min_amount": 10, "outcome": "timeout"}, {"card": "4000 0000 0000 3238", "outcome": "approve"}]}`,
+			2,
+			nil,
+			"OK - JSON file",
+		},
+		{
+			"outcome.yaml",
+			"scenarios:\n  - card: \"4000 0000 0000 0259\"\n    outcome: explode\n",
+			0,
+			errors.New(`Invalid scenarios - #0: unknown outcome "explode"`),
+			"Error - Unknown outcome",
+		},
+		{
+			"operation.json",
+			`{"scenarios": [{"name": "bad", "operations": ["transfer"], "outcome": "decline"}]}`,
+			0,
+			errors.New(`Invalid scenarios - bad: unknown operation "transfer"`),
+			"Error - Unknown operation",
+		},
+		{
+			"rate.json",
+			`{"scenarios": [{"name": "bad", "outcome": "decline", "failure_rate": 1.5}]}`,
+			0,
+			errors.New("Invalid scenarios - bad: failure_rate must be between 0 and 1"),
+			"Error - Failure rate out of range",
+		},
+		{
+			"typo.json",
+			`{"scenarios": [{"card": "4000 0000 0000 0259", "outcom": "decline"}]}`,
+			0,
+			errors.New(`Error parsing scenarios file - json: unknown field "outcom"`),
+			"Error - Unknown JSON key",
+		},
+	}
+
+	for _, iterTest := range tests {
+		path := filepath.Join(dir, iterTest.filename)
+		assert.NoError(ioutil.WriteFile(path, []byte(iterTest.content), 0644))
+
+		registry, err := LoadScenarios(path)
+
+		assert.Equal(iterTest.err, err, iterTest.description)
+		if iterTest.err == nil {
+			assert.Len(registry.Scenarios, iterTest.scenarios, iterTest.description)
+		}
+	}
+}
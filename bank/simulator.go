@@ -0,0 +1,105 @@
+package bank
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/logger"
+)
+
+type Transaction struct {
+	message string
+	response *Response
+	err error
+}
+
+// Simulator is a local stub acquirer answering according to a scenario registry
+type Simulator struct {
+	// Scenarios overrides the package registry for this acquirer
+	Scenarios *ScenarioRegistry
+}
+
+func (s *Simulator) scenarios() *ScenarioRegistry {
+	if s.Scenarios != nil {
+		return s.Scenarios
+	}
+
+	return Scenarios
+}
+
+// Send performs a single attempt, bounded by the operation's deadline
+func (s *Simulator) Send(ctx context.Context, cc *CreditCard, action string, amount float64) (*Response, error) {
+	if timeout, ok := Timeouts[action]; ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	if err := ctx.Err(); err != nil {
+		logger.FromContext(ctx).WithField("err", err).Error("Simulator.Send - Cancelled before contacting acquirer")
+
+		return nil, fmt.Errorf("%s failure - Request cancelled before reaching the acquirer: %w", operationLabel(action), err)
+	}
+
+	// Buffered so the sender never blocks (and leaks) once we stop waiting
+	transaction := make(chan Transaction, 1)
+
+	// communicate with CreditCard service and wait to receive response.
+	// This can be an API call or a message broker receiver, etc
+	go s.simulateCreditCardTransaction(ctx, cc, action, amount, transaction)
+
+	select {
+	case resp := <- transaction:
+		return resp.response, resp.err
+	case <- ctx.Done():
+		logger.FromContext(ctx).WithFields(log.Fields{
+			"action": action,
+			"err": ctx.Err(),
+		}).Error("Simulator.Send - No answer from acquirer, outcome unknown")
+
+		return nil, &UnknownOutcomeError{
+			Operation: action,
+			Err: ctx.Err(),
+		}
+	}
+}
+
+func (s *Simulator) simulateCreditCardTransaction(ctx context.Context, cc *CreditCard, action string, amount float64, transaction chan<- Transaction) {
+	latency, err := s.scenarios().Resolve(action, cc.Number, amount)
+	if err == errNoAnswer {
+		logger.FromContext(ctx).WithField("action", action).Error("Simulator.Send - Scenario triggered acquirer timeout.")
+		return
+	}
+
+	select {
+	case <- time.After(latency):
+	case <- ctx.Done():
+		return
+	}
+
+	if err != nil {
+		logger.FromContext(ctx).WithFields(log.Fields{
+			"action": action,
+			"err": err,
+		}).Error("Simulator.Send - Scenario triggered failure.")
+
+		transaction <- Transaction{
+			"Transaction Failure",
+			nil,
+			err,
+		}
+	} else {
+		transaction <- Transaction{
+			"Transaction Successful!",
+			&Response{
+				ApprovalCode: fmt.Sprintf("%06d", rand.Intn(1000000)),
+				Reference: fmt.Sprintf("sim_%016x", rand.Uint64()),
+			},
+			nil,
+		}
+	}
+}
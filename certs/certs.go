@@ -0,0 +1,184 @@
+package certs
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Client certificate modes
+const (
+	ClientAuthNone = "none"
+	// ClientAuthOptional verifies a certificate when the client sends one, clients without one use a token
+	ClientAuthOptional = "optional"
+	ClientAuthRequire = "require"
+)
+
+// cipherSuites are the TLS 1.2 suites with forward secrecy and AEAD, TLS 1.3 suites aren't configurable
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// Reloader serves the certificate in its files, picking up a rotated certificate without a restart
+type Reloader struct {
+	certFile string
+	keyFile string
+
+	mu sync.RWMutex
+	certificate *tls.Certificate
+	modTime time.Time
+}
+
+// NewReloader loads the certificate and key, which must be PEM encoded
+func NewReloader(certFile string, keyFile string) (*Reloader, error) {
+	reloader := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Reload reads the files again. The certificate in use is kept when they can't be read.
+func (cr *Reloader) Reload() error {
+	modTime, err := cr.lastModified()
+	if err != nil {
+		return fmt.Errorf("Error reading certificate - %s", err.Error())
+	}
+
+	certificate, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return fmt.Errorf("Error loading certificate - %s", err.Error())
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	cr.certificate = &certificate
+	cr.modTime = modTime
+
+	return nil
+}
+
+// lastModified is the latest modification of the certificate and the key, they are usually rotated together
+func (cr *Reloader) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, iterFile := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(iterFile)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// GetCertificate is the tls.Config hook, so every handshake gets the current certificate
+func (cr *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+
+	return cr.certificate, nil
+}
+
+// Watch reloads the certificate whenever its files change, checking every interval until ctx is done
+func (cr *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := cr.lastModified()
+		if err != nil {
+			log.WithField("err", err).Error("Watch - Error reading certificate, keeping the current one")
+			continue
+		}
+
+		cr.mu.RLock()
+		changed := !modTime.Equal(cr.modTime)
+		cr.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := cr.Reload(); err != nil {
+			log.WithField("err", err).Error("Watch - Error reloading certificate, keeping the current one")
+			continue
+		}
+		log.WithField("cert_file", cr.certFile).Info("Watch - Certificate reloaded")
+	}
+}
+
+// ServerConfig is TLS 1.2 or later with forward secret AEAD suites, serving the reloader's certificate.
+// Client certificates are verified against the CAs in clientCAFile unless clientAuth is none.
+func ServerConfig(reloader *Reloader, clientAuth string, clientCAFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		CipherSuites: cipherSuites,
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+		PreferServerCipherSuites: true,
+		GetCertificate: reloader.GetCertificate,
+	}
+
+	switch clientAuth {
+	case "", ClientAuthNone:
+		return config, nil
+	case ClientAuthOptional:
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequire:
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("Invalid client auth %q - expected %s, %s or %s", clientAuth, ClientAuthNone, ClientAuthOptional, ClientAuthRequire)
+	}
+
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading client CA file - %s", err.Error())
+	}
+
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("Error parsing client CA file - no PEM certificate in %s", clientCAFile)
+	}
+
+	return config, nil
+}
+
+// RedirectHandler sends plain HTTP requests to the same URL over HTTPS on httpsPort
+func RedirectHandler(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if hostname, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = hostname
+		}
+		if httpsPort != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(httpsPort))
+		}
+
+		// 308 keeps the method and body, a client following it doesn't turn a POST into a GET
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,217 @@
+package certs
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+}
+
+// issue writes a certificate for commonName signed by parent, self-signed when parent is nil
+func issue(t *testing.T, dir string, commonName string, parent *tls.Certificate) (*tls.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{CommonName: commonName, Organization: []string{"Checkout Techlab"}},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA: parent == nil,
+	}
+
+	signer, signerKey := template, interface{}(key)
+	if parent != nil {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, commonName+".crt"), filepath.Join(dir, commonName+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate.Leaf, _ = x509.ParseCertificate(der)
+
+	return &certificate, certFile, keyFile
+}
+
+func commonName(t *testing.T, reloader *Reloader) string {
+	certificate, _ := reloader.GetCertificate(nil)
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "certs")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	_, certFile, keyFile := issue(t, dir, "server", nil)
+
+	_, err = NewReloader(filepath.Join(dir, "missing.crt"), keyFile)
+	assert.Error(err, "Error - Missing certificate")
+
+	reloader, err := NewReloader(certFile, keyFile)
+	assert.NoError(err)
+	assert.Equal("server", commonName(t, reloader))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10 * time.Millisecond)
+
+	// rotated in place, as a certificate manager would
+	_, rotatedCert, rotatedKey := issue(t, dir, "rotated", nil)
+	assert.NoError(os.Rename(rotatedCert, certFile))
+	assert.NoError(os.Rename(rotatedKey, keyFile))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(os.Chtimes(certFile, later, later))
+
+	assert.Eventually(func() bool { return commonName(t, reloader) == "rotated" }, time.Second, 10 * time.Millisecond, "Rotated - Reloaded")
+
+	assert.NoError(ioutil.WriteFile(certFile, []byte("not a certificate"), 0600))
+	evenLater := later.Add(time.Minute)
+	assert.NoError(os.Chtimes(certFile, evenLater, evenLater))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal("rotated", commonName(t, reloader), "Broken file - Current certificate kept")
+}
+
+func TestServerConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "certs")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	ca, caFile, _ := issue(t, dir, "ca", nil)
+	_, serverCert, serverKey := issue(t, dir, "server", ca)
+	client, _, _ := issue(t, dir, "checkout", ca)
+
+	reloader, err := NewReloader(serverCert, serverKey)
+	assert.NoError(err)
+
+	_, err = ServerConfig(reloader, "sometimes", caFile)
+	assert.Error(err, "Error - Unknown client auth")
+	_, err = ServerConfig(reloader, ClientAuthRequire, serverKey)
+	assert.Error(err, "Error - CA file without a certificate")
+
+	config, err := ServerConfig(reloader, ClientAuthRequire, caFile)
+	assert.NoError(err)
+	assert.Equal(uint16(tls.VersionTLS12), config.MinVersion)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}))
+	// httptest's StartTLS would serve its own certificate
+	server.Listener = tls.NewListener(server.Listener, config)
+	server.Config.ErrorLog = stdlog.New(ioutil.Discard, "", 0)
+	server.Start()
+	defer server.Close()
+	serverURL := "https://" + server.Listener.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+
+	tests := []struct{
+		clientConfig *tls.Config
+		expected string
+		description string
+	}{
+		{&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{*client}}, "checkout", "OK - Client certificate verified"},
+		{&tls.Config{RootCAs: roots}, "", "Error - No client certificate"},
+		{&tls.Config{RootCAs: roots, Certificates: []tls.Certificate{*client}, MaxVersion: tls.VersionTLS11}, "", "Error - TLS 1.1"},
+	}
+
+	for _, iterTest := range tests {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: iterTest.clientConfig}}
+		resp, err := httpClient.Get(serverURL)
+		if iterTest.expected == "" {
+			if err == nil {
+				// TLS 1.3 reports a missing client certificate after the handshake, on the first read
+				_, err = ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+			}
+			assert.Error(err, iterTest.description)
+			continue
+		}
+
+		if assert.NoError(err, iterTest.description) {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(iterTest.expected, string(body), iterTest.description)
+		}
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct{
+		httpsPort int
+		target string
+		expected string
+		description string
+	}{
+		{443, "http://api.example.com/authorize?id=1", "https://api.example.com/authorize?id=1", "Default port"},
+		{2012, "http://api.example.com:8080/status/ping", "https://api.example.com:2012/status/ping", "Other port"},
+	}
+
+	for _, iterTest := range tests {
+		w := httptest.NewRecorder()
+		RedirectHandler(iterTest.httpsPort).ServeHTTP(w, httptest.NewRequest("POST", iterTest.target, nil))
+
+		assert.Equal(http.StatusPermanentRedirect, w.Code, iterTest.description)
+		assert.Equal(iterTest.expected, w.Header().Get("Location"), iterTest.description)
+	}
+}
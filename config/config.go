@@ -0,0 +1,517 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/certs"
+	"github.com/nktsitas/checkout-techlab/ratelimit"
+	"github.com/nktsitas/checkout-techlab/settlement"
+	"github.com/nktsitas/checkout-techlab/tracing"
+)
+
+// Storage backends
+const (
+	StorageMemory = "memory"
+)
+
+// Log formats
+const (
+	LogFormatJSON = "json"
+	LogFormatText = "text"
+)
+
+// Config is everything the service is started with. Values come from the defaults, then the config file,
+// then environment variables, then command line flags, each overriding the previous ones.
+type Config struct {
+	Port int											`json:"port" yaml:"port"`
+	// ShutdownTimeout bounds the wait for in-flight requests and background work on SIGTERM/SIGINT
+	ShutdownTimeout Duration			`json:"shutdown_timeout" yaml:"shutdown_timeout"`
+	// ShutdownDelay keeps serving while /health/ready reports not ready, so load balancers stop routing first
+	ShutdownDelay Duration				`json:"shutdown_delay" yaml:"shutdown_delay"`
+	TLS TLSConfig									`json:"tls" yaml:"tls"`
+	API APIConfig									`json:"api" yaml:"api"`
+	Log LogConfig									`json:"log" yaml:"log"`
+	Auth AuthConfig								`json:"auth" yaml:"auth"`
+	RateLimit RateLimitConfig			`json:"rate_limit" yaml:"rate_limit"`
+	Storage StorageConfig					`json:"storage" yaml:"storage"`
+	Tracing TracingConfig					`json:"tracing" yaml:"tracing"`
+	Bank BankConfig								`json:"bank" yaml:"bank"`
+	MerchantsFile string					`json:"merchants_file" yaml:"merchants_file"`
+	PricingFile string						`json:"pricing_file" yaml:"pricing_file"`
+	FXRatesFile string						`json:"fx_rates_file" yaml:"fx_rates_file"`
+	RiskFile string								`json:"risk_file" yaml:"risk_file"`
+	Settlement SettlementConfig		`json:"settlement" yaml:"settlement"`
+	Reconciliation ReconciliationConfig	`json:"reconciliation" yaml:"reconciliation"`
+	Disputes DisputesConfig				`json:"disputes" yaml:"disputes"`
+}
+
+// TLSConfig serves HTTPS when CertFile is set
+type TLSConfig struct {
+	CertFile string									`json:"cert_file" yaml:"cert_file"`
+	KeyFile string									`json:"key_file" yaml:"key_file"`
+	// ReloadInterval is how often the certificate files are checked for a rotated certificate
+	ReloadInterval Duration					`json:"reload_interval" yaml:"reload_interval"`
+	// ClientAuth is none, optional or require, client certificates are verified against ClientCAFile
+	ClientAuth string								`json:"client_auth" yaml:"client_auth"`
+	ClientCAFile string							`json:"client_ca_file" yaml:"client_ca_file"`
+	// ClientCertMerchants maps a client certificate subject, or its common name, to the merchant it authenticates
+	ClientCertMerchants map[string]string	`json:"client_cert_merchants" yaml:"client_cert_merchants"`
+	// RedirectPort listens for plain HTTP and redirects to HTTPS, off when 0
+	RedirectPort int								`json:"redirect_port" yaml:"redirect_port"`
+}
+
+// APIConfig is about the unversioned paths, deprecated aliases of /v1
+type APIConfig struct {
+	LegacyRoutes bool					`json:"legacy_routes" yaml:"legacy_routes"`
+	// LegacySunset is the date, YYYY-MM-DD, announced in the Sunset header of the unversioned paths
+	LegacySunset string				`json:"legacy_sunset" yaml:"legacy_sunset"`
+}
+
+// LegacySunsetDate parses LegacySunset, validated with the configuration
+func (ac *APIConfig) LegacySunsetDate() time.Time {
+	date, _ := time.Parse("2006-01-02", ac.LegacySunset)
+
+	return date
+}
+
+type LogConfig struct {
+	// Level is a logrus level: debug, info, warning, error...
+	Level string		`json:"level" yaml:"level"`
+	// Format is json or text
+	Format string		`json:"format" yaml:"format"`
+}
+
+type AuthConfig struct {
+	// AccessSecret signs the tokens
+	AccessSecret string				`json:"access_secret" yaml:"access_secret"`
+	TokenExpiry Duration			`json:"token_expiry" yaml:"token_expiry"`
+	// Users can log in, merchants have no role
+	Users []auth.User					`json:"users" yaml:"users"`
+	// AdminPassword enables the Admin user, admin login is disabled when it is empty
+	AdminPassword string			`json:"admin_password" yaml:"admin_password"`
+	Lockout LockoutConfig			`json:"lockout" yaml:"lockout"`
+}
+
+// LockoutConfig locks a username out from a client IP for Duration after MaxFailures failed logins within Window, 0 MaxFailures disables it
+type LockoutConfig struct {
+	MaxFailures int			`json:"max_failures" yaml:"max_failures"`
+	Window Duration			`json:"window" yaml:"window"`
+	Duration Duration		`json:"duration" yaml:"duration"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool												`json:"enabled" yaml:"enabled"`
+	// Default is per merchant, or per client IP before authentication, across the routes without a limit of their own
+	Default ratelimit.Limit							`json:"default" yaml:"default"`
+	// Routes override the default by route name, on top of the built-in Login and Refund limits
+	Routes map[string]ratelimit.Limit		`json:"routes" yaml:"routes"`
+	// TrustForwardedFor takes the client IP from X-Forwarded-For, only behind a load balancer setting it
+	TrustForwardedFor bool							`json:"trust_forwarded_for" yaml:"trust_forwarded_for"`
+}
+
+type StorageConfig struct {
+	// Backend is where authorizations and everything else are kept, only memory for now
+	Backend string	`json:"backend" yaml:"backend"`
+}
+
+type TracingConfig struct {
+	// Exporter is none, stdout or otlp
+	Exporter string	`json:"exporter" yaml:"exporter"`
+}
+
+type BankConfig struct {
+	// SimulatorLatency is how long the built-in simulator takes to answer, a scenarios file sets its own default_latency
+	SimulatorLatency Duration					`json:"simulator_latency" yaml:"simulator_latency"`
+	ScenariosFile string							`json:"scenarios_file" yaml:"scenarios_file"`
+	// Timeouts override the deadline of acquirer calls by operation
+	Timeouts map[string]Duration			`json:"timeouts" yaml:"timeouts"`
+	RoutingFile string								`json:"routing_file" yaml:"routing_file"`
+	// AcquirerURLs are the endpoints of HTTP acquirers by name, overriding the url of the routing file
+	AcquirerURLs map[string]string		`json:"acquirer_urls" yaml:"acquirer_urls"`
+	BinCountriesFile string						`json:"bin_countries_file" yaml:"bin_countries_file"`
+	Breaker BreakerConfig							`json:"breaker" yaml:"breaker"`
+	Retry RetryConfig									`json:"retry" yaml:"retry"`
+}
+
+type BreakerConfig struct {
+	FailureThreshold int			`json:"failure_threshold" yaml:"failure_threshold"`
+	OpenTimeout Duration			`json:"open_timeout" yaml:"open_timeout"`
+	HalfOpenMaxCalls int			`json:"half_open_max_calls" yaml:"half_open_max_calls"`
+}
+
+type RetryConfig struct {
+	MaxAttempts int			`json:"max_attempts" yaml:"max_attempts"`
+	BaseDelay Duration	`json:"base_delay" yaml:"base_delay"`
+	MaxDelay Duration		`json:"max_delay" yaml:"max_delay"`
+}
+
+type SettlementConfig struct {
+	// Cutoff is the end of the settlement day, HH:MM UTC
+	Cutoff string	`json:"cutoff" yaml:"cutoff"`
+}
+
+type ReconciliationConfig struct {
+	// Dir is polled for acquirer settlement files, reconciliation is off when it is empty
+	Dir string					`json:"dir" yaml:"dir"`
+	Interval Duration		`json:"interval" yaml:"interval"`
+}
+
+type DisputesConfig struct {
+	ResponseWindow Duration	`json:"response_window" yaml:"response_window"`
+}
+
+// Duration reads as a Go duration string, e.g. 30s or 1h30m
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) set(value string) error {
+	parsed, err := time.ParseDuration(strings.TrimSpace(value))
+	if err != nil {
+		return fmt.Errorf("%q is not a duration, e.g. 30s", value)
+	}
+
+	d.Duration = parsed
+	return nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%s is not a duration, e.g. \"30s\"", string(data))
+	}
+
+	return d.set(value)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value string
+	if err := unmarshal(&value); err != nil {
+		return err
+	}
+
+	return d.set(value)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// Default is the configuration used when nothing overrides it
+func Default() *Config {
+	breaker := bank.DefaultBreakerSettings()
+	retry := bank.DefaultRetryPolicy()
+	rateLimits := ratelimit.DefaultSettings()
+
+	return &Config{
+		Port: 2012,
+		ShutdownTimeout: Duration{30 * time.Second},
+		API: APIConfig{
+			LegacyRoutes: true,
+			LegacySunset: "2027-04-30",
+		},
+		TLS: TLSConfig{
+			ReloadInterval: Duration{time.Minute},
+			ClientAuth: certs.ClientAuthNone,
+		},
+		Log: LogConfig{
+			Level: log.InfoLevel.String(),
+			Format: LogFormatJSON,
+		},
+		Auth: AuthConfig{
+			TokenExpiry: Duration{30 * time.Minute},
+			Users: []auth.User{{Username: "Checkout", Password: "Checkout"}},
+			Lockout: LockoutConfig{
+				MaxFailures: 5,
+				Window: Duration{15 * time.Minute},
+				Duration: Duration{15 * time.Minute},
+			},
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: rateLimits.Enabled,
+			Default: rateLimits.Default,
+		},
+		Storage: StorageConfig{Backend: StorageMemory},
+		Tracing: TracingConfig{Exporter: tracing.ExporterNone},
+		Bank: BankConfig{
+			SimulatorLatency: Duration{200 * time.Millisecond},
+			Breaker: BreakerConfig{
+				FailureThreshold: breaker.FailureThreshold,
+				OpenTimeout: Duration{breaker.OpenTimeout},
+				HalfOpenMaxCalls: breaker.HalfOpenMaxCalls,
+			},
+			Retry: RetryConfig{
+				MaxAttempts: retry.MaxAttempts,
+				BaseDelay: Duration{retry.BaseDelay},
+				MaxDelay: Duration{retry.MaxDelay},
+			},
+		},
+		Settlement: SettlementConfig{Cutoff: "22:00"},
+		Reconciliation: ReconciliationConfig{Interval: Duration{time.Minute}},
+		Disputes: DisputesConfig{ResponseWindow: Duration{7 * 24 * time.Hour}},
+	}
+}
+
+// readFile overrides config with the YAML or JSON file at path, unknown keys are errors
+func (config *Config) readFile(path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading config file - %s", err.Error())
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(content, config)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(content)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	}
+	if err != nil {
+		return fmt.Errorf("Error parsing config file - %s", err.Error())
+	}
+
+	return nil
+}
+
+// Files are the configured files by setting, those the service needs to read again on restart or rotation
+func (config *Config) Files() map[string]string {
+	files := map[string]string{
+		"tls.cert_file": config.TLS.CertFile,
+		"tls.key_file": config.TLS.KeyFile,
+		"tls.client_ca_file": config.TLS.ClientCAFile,
+		"bank.scenarios_file": config.Bank.ScenariosFile,
+		"bank.routing_file": config.Bank.RoutingFile,
+		"bank.bin_countries_file": config.Bank.BinCountriesFile,
+		"merchants_file": config.MerchantsFile,
+		"pricing_file": config.PricingFile,
+		"fx_rates_file": config.FXRatesFile,
+		"risk_file": config.RiskFile,
+	}
+
+	for setting, path := range files {
+		if path == "" {
+			delete(files, setting)
+		}
+	}
+
+	return files
+}
+
+// Validate checks every value, so a bad configuration stops the service at startup rather than at first use
+func (config *Config) Validate() error {
+	if config.Port < 1 || config.Port > 65535 {
+		return fmt.Errorf("Invalid config - port must be between 1 and 65535")
+	}
+
+	if config.ShutdownTimeout.Duration <= 0 {
+		return fmt.Errorf("Invalid config - shutdown_timeout must be positive")
+	}
+
+	if config.ShutdownDelay.Duration < 0 {
+		return fmt.Errorf("Invalid config - shutdown_delay can't be negative")
+	}
+
+	if err := config.TLS.validate(config.Port); err != nil {
+		return err
+	}
+
+	if _, err := time.Parse("2006-01-02", config.API.LegacySunset); err != nil {
+		return fmt.Errorf("Invalid config - api.legacy_sunset %q must be a YYYY-MM-DD date", config.API.LegacySunset)
+	}
+
+	if _, err := log.ParseLevel(config.Log.Level); err != nil {
+		return fmt.Errorf("Invalid config - log.level %q is not a log level", config.Log.Level)
+	}
+
+	if config.Log.Format != LogFormatJSON && config.Log.Format != LogFormatText {
+		return fmt.Errorf("Invalid config - log.format must be %s or %s", LogFormatJSON, LogFormatText)
+	}
+
+	if err := config.Auth.validate(); err != nil {
+		return err
+	}
+
+	if err := config.RateLimit.validate(); err != nil {
+		return err
+	}
+
+	if config.Storage.Backend != StorageMemory {
+		return fmt.Errorf("Invalid config - storage.backend %q is not supported, only %s", config.Storage.Backend, StorageMemory)
+	}
+
+	switch config.Tracing.Exporter {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		return fmt.Errorf("Invalid config - tracing.exporter must be %s, %s or %s", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP)
+	}
+
+	if err := config.Bank.validate(); err != nil {
+		return err
+	}
+
+	if _, err := settlement.ParseCutoff(config.Settlement.Cutoff); err != nil {
+		return fmt.Errorf("Invalid config - settlement.cutoff: %s", err.Error())
+	}
+
+	if config.Reconciliation.Interval.Duration <= 0 {
+		return fmt.Errorf("Invalid config - reconciliation.interval must be positive")
+	}
+
+	if config.Disputes.ResponseWindow.Duration <= 0 {
+		return fmt.Errorf("Invalid config - disputes.response_window must be positive")
+	}
+
+	return nil
+}
+
+func (tc *TLSConfig) validate(port int) error {
+	if (tc.CertFile == "") != (tc.KeyFile == "") {
+		return fmt.Errorf("Invalid config - tls.cert_file and tls.key_file go together")
+	}
+
+	if tc.ReloadInterval.Duration <= 0 {
+		return fmt.Errorf("Invalid config - tls.reload_interval must be positive")
+	}
+
+	switch tc.ClientAuth {
+	case certs.ClientAuthNone:
+		if tc.ClientCAFile != "" || len(tc.ClientCertMerchants) > 0 {
+			return fmt.Errorf("Invalid config - tls.client_ca_file and tls.client_cert_merchants need tls.client_auth %s or %s", certs.ClientAuthOptional, certs.ClientAuthRequire)
+		}
+	case certs.ClientAuthOptional, certs.ClientAuthRequire:
+		if tc.ClientCAFile == "" {
+			return fmt.Errorf("Invalid config - tls.client_auth %s needs tls.client_ca_file", tc.ClientAuth)
+		}
+	default:
+		return fmt.Errorf("Invalid config - tls.client_auth must be %s, %s or %s", certs.ClientAuthNone, certs.ClientAuthOptional, certs.ClientAuthRequire)
+	}
+
+	if tc.CertFile == "" && (tc.ClientAuth != certs.ClientAuthNone || tc.RedirectPort != 0) {
+		return fmt.Errorf("Invalid config - tls.client_auth and tls.redirect_port need tls.cert_file")
+	}
+
+	if tc.RedirectPort < 0 || tc.RedirectPort > 65535 || (tc.RedirectPort != 0 && tc.RedirectPort == port) {
+		return fmt.Errorf("Invalid config - tls.redirect_port must be between 1 and 65535 and differ from port")
+	}
+
+	return nil
+}
+
+func (ac *AuthConfig) validate() error {
+	if ac.AccessSecret == "" {
+		return fmt.Errorf("Invalid config - auth.access_secret is required, e.g. ACCESS_SECRET=supersecret")
+	}
+
+	if ac.TokenExpiry.Duration <= 0 {
+		return fmt.Errorf("Invalid config - auth.token_expiry must be positive")
+	}
+
+	known := make(map[string]bool)
+	for _, iterUser := range ac.Users {
+		if iterUser.Username == "" || iterUser.Password == "" {
+			return fmt.Errorf("Invalid config - auth.users: every user needs a username and a password")
+		}
+		if known[iterUser.Username] || (ac.AdminPassword != "" && iterUser.Username == auth.AdminUsername) {
+			return fmt.Errorf("Invalid config - auth.users: user %q declared twice", iterUser.Username)
+		}
+		if iterUser.Role != "" && iterUser.Role != auth.RoleAdmin {
+			return fmt.Errorf("Invalid config - auth.users: user %q role must be empty or %s", iterUser.Username, auth.RoleAdmin)
+		}
+		known[iterUser.Username] = true
+	}
+
+	if ac.Lockout.MaxFailures < 0 {
+		return fmt.Errorf("Invalid config - auth.lockout.max_failures can't be negative")
+	}
+
+	if ac.Lockout.MaxFailures > 0 && (ac.Lockout.Window.Duration <= 0 || ac.Lockout.Duration.Duration <= 0) {
+		return fmt.Errorf("Invalid config - auth.lockout.window and auth.lockout.duration must be positive")
+	}
+
+	return nil
+}
+
+func (rc *RateLimitConfig) validate() error {
+	if rc.Default.PerMinute <= 0 || rc.Default.Burst <= 0 {
+		return fmt.Errorf("Invalid config - rate_limit.default per_minute and burst must be positive")
+	}
+
+	for route, iterLimit := range rc.Routes {
+		if iterLimit.PerMinute <= 0 || iterLimit.Burst <= 0 {
+			return fmt.Errorf("Invalid config - rate_limit.routes: %s per_minute and burst must be positive", route)
+		}
+	}
+
+	return nil
+}
+
+// Settings are the rate limits of the routes, the configured route limits replacing the built-in ones
+func (rc *RateLimitConfig) Settings() ratelimit.Settings {
+	settings := ratelimit.DefaultSettings()
+	settings.Enabled = rc.Enabled
+	settings.Default = rc.Default
+	settings.TrustForwardedFor = rc.TrustForwardedFor
+	for route, iterLimit := range rc.Routes {
+		settings.Routes[route] = iterLimit
+	}
+
+	return settings
+}
+
+func (bc *BankConfig) validate() error {
+	if bc.SimulatorLatency.Duration < 0 {
+		return fmt.Errorf("Invalid config - bank.simulator_latency cannot be negative")
+	}
+
+	for operation, iterTimeout := range bc.Timeouts {
+		if _, err := bank.ParseTimeouts(fmt.Sprintf("%s=%s", operation, iterTimeout.Duration)); err != nil {
+			return fmt.Errorf("Invalid config - bank.timeouts: %s", err.Error())
+		}
+	}
+
+	for name, iterURL := range bc.AcquirerURLs {
+		if parsed, err := url.Parse(iterURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("Invalid config - bank.acquirer_urls: %s url must be an http(s) URL", name)
+		}
+	}
+
+	if bc.Breaker.FailureThreshold <= 0 || bc.Breaker.HalfOpenMaxCalls <= 0 || bc.Breaker.OpenTimeout.Duration <= 0 {
+		return fmt.Errorf("Invalid config - bank.breaker values must be positive")
+	}
+
+	if bc.Retry.MaxAttempts <= 0 || bc.Retry.BaseDelay.Duration <= 0 || bc.Retry.MaxDelay.Duration <= 0 {
+		return fmt.Errorf("Invalid config - bank.retry values must be positive")
+	}
+
+	return nil
+}
+
+// BreakerSettings are the circuit breaker settings of every acquirer
+func (bc *BankConfig) BreakerSettings() bank.BreakerSettings {
+	return bank.BreakerSettings{
+		FailureThreshold: bc.Breaker.FailureThreshold,
+		OpenTimeout: bc.Breaker.OpenTimeout.Duration,
+		HalfOpenMaxCalls: bc.Breaker.HalfOpenMaxCalls,
+	}
+}
+
+// RetryPolicy is the retry policy of every acquirer
+func (bc *BankConfig) RetryPolicy() bank.RetryPolicy {
+	return bank.RetryPolicy{
+		MaxAttempts: bc.Retry.MaxAttempts,
+		BaseDelay: bc.Retry.BaseDelay.Duration,
+		MaxDelay: bc.Retry.MaxDelay.Duration,
+	}
+}
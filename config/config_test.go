@@ -0,0 +1,207 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// setEnv sets environment variables for a test, the returned function restores them
+func setEnv(values map[string]string) func() {
+	previous := make(map[string]*string)
+	for key, value := range values {
+		if old, ok := os.LookupEnv(key); ok {
+			previous[key] = &old
+		} else {
+			previous[key] = nil
+		}
+		os.Setenv(key, value)
+	}
+
+	return func() {
+		for key, old := range previous {
+			if old == nil {
+				os.Unsetenv(key)
+			} else {
+				os.Setenv(key, *old)
+			}
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	config, err := Load([]string{"-config", "../config.example.yaml"})
+	assert.Equal(errors.New("Invalid config - auth.access_secret is required, e.g. ACCESS_SECRET=supersecret"), err, "Example file - No secret")
+	assert.Nil(config)
+
+	restore := setEnv(map[string]string{"ACCESS_SECRET": "supersecret"})
+	defer restore()
+
+	config, err = Load([]string{"-config", "../config.example.yaml"})
+	assert.NoError(err, "Example file")
+	assert.Equal(3 * time.Second, config.Bank.Timeouts["charge"].Duration, "Example file")
+
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	assert.NoError(ioutil.WriteFile(path, []byte("port: 3000\nlog:\n  level: debug\nauth:\n  token_expiry: 5m\n  users:\n    - {username: Shop, password: secret}\n"), 0644))
+
+	config, err = Load([]string{"-config", path})
+	assert.NoError(err)
+	assert.Equal(3000, config.Port, "File - Overrides the default")
+	assert.Equal("debug", config.Log.Level, "File - Overrides the default")
+	assert.Equal("json", config.Log.Format, "File - Defaults kept")
+	assert.Equal(5 * time.Minute, config.Auth.TokenExpiry.Duration, "File - Duration")
+	assert.Equal([]auth.User{{Username: "Shop", Password: "secret"}}, config.Auth.Users, "File - Users")
+
+	limitsPath := filepath.Join(dir, "limits.yaml")
+	assert.NoError(ioutil.WriteFile(limitsPath, []byte("rate_limit:\n  routes:\n    Refund: {per_minute: 30, burst: 3}\n"), 0644))
+	config, err = Load([]string{"-config", limitsPath})
+	assert.NoError(err)
+	assert.Equal(map[string]ratelimit.Limit{"Login": {PerMinute: 10, Burst: 5}, "Refund": {PerMinute: 30, Burst: 3}}, config.RateLimit.Settings().Routes, "File - Route limits on top of the built-in ones")
+
+	restoreFile := setEnv(map[string]string{"CONFIG_FILE": path, "PORT": "4000", "BANK_TIMEOUTS": "refund=2500ms"})
+	defer restoreFile()
+
+	config, err = Load([]string{"-port", "5000", "-log-level", "warning"})
+	assert.NoError(err)
+	assert.Equal(5000, config.Port, "Flag - Overrides env and file")
+	assert.Equal("warning", config.Log.Level, "Flag - Overrides file")
+	assert.Equal(5 * time.Minute, config.Auth.TokenExpiry.Duration, "CONFIG_FILE - Read")
+	assert.Equal(2500 * time.Millisecond, config.Bank.Timeouts["refund"].Duration, "Env - Timeouts")
+
+	config, err = Load(nil)
+	assert.NoError(err)
+	assert.Equal(4000, config.Port, "Env - Overrides file")
+}
+
+func TestLoadErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	restore := setEnv(map[string]string{"ACCESS_SECRET": "supersecret"})
+	defer restore()
+
+	dir, err := ioutil.TempDir("", "config")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct{
+		file string
+		content string
+		args []string
+		err error
+		description string
+	}{
+		{
+			"config.yaml",
+			"prot: 3000\n",
+			nil,
+			errors.New("Error parsing config file - yaml: unmarshal errors:\n  line 1: field prot not found in type config.Config"),
+			"Error - Unknown key",
+		},
+		{
+			"config.json",
+			`{"auth": {"token_expiry": 30}}`,
+			nil,
+			errors.New(`Error parsing config file - 30 is not a duration, e.g. "30s"`),
+			"Error - Duration as a number",
+		},
+		{
+			"config.yaml",
+			"storage:\n  backend: postgres\n",
+			nil,
+			errors.New(`Invalid config - storage.backend "postgres" is not supported, only memory`),
+			"Error - Storage backend",
+		},
+		{
+			"config.yaml",
+			"auth:\n  users:\n    - {username: Shop, password: secret, role: root}\n",
+			nil,
+			errors.New(`Invalid config - auth.users: user "Shop" role must be empty or admin`),
+			"Error - Role",
+		},
+		{
+			"config.yaml",
+			"bank:\n  acquirer_urls:\n    acquirer_a: acquirer-a.local\n",
+			nil,
+			errors.New("Invalid config - bank.acquirer_urls: acquirer_a url must be an http(s) URL"),
+			"Error - Acquirer URL",
+		},
+		{
+			"config.yaml",
+			"tls:\n  cert_file: server.crt\n",
+			nil,
+			errors.New("Invalid config - tls.cert_file and tls.key_file go together"),
+			"Error - Certificate without a key",
+		},
+		{
+			"config.yaml",
+			"tls:\n  cert_file: server.crt\n  key_file: server.key\n  client_auth: require\n",
+			nil,
+			errors.New("Invalid config - tls.client_auth require needs tls.client_ca_file"),
+			"Error - Client certificates without a CA",
+		},
+		{
+			"config.yaml",
+			"",
+			[]string{"-tls-redirect-port", "80"},
+			errors.New("Invalid config - tls.client_auth and tls.redirect_port need tls.cert_file"),
+			"Error - Redirect without TLS",
+		},
+		{
+			"config.yaml",
+			"rate_limit:\n  routes:\n    Refund: {per_minute: 0, burst: 5}\n",
+			nil,
+			errors.New("Invalid config - rate_limit.routes: Refund per_minute and burst must be positive"),
+			"Error - Route rate limit",
+		},
+		{
+			"config.yaml",
+			"",
+			[]string{"-rate-limit-enabled", "maybe"},
+			errors.New(`Invalid config - -rate-limit-enabled: "maybe" is not true or false`),
+			"Error - Boolean flag",
+		},
+		{
+			"config.yaml",
+			"",
+			[]string{"-port", "http"},
+			errors.New(`Invalid config - -port: "http" is not an integer`),
+			"Error - Flag value",
+		},
+		{
+			"config.yaml",
+			"",
+			[]string{"-settlement-cutoff", "25:00"},
+			errors.New(`Invalid config - settlement.cutoff: Invalid settlement cutoff "25:00" - must be HH:MM`),
+			"Error - Cutoff",
+		},
+		{
+			"config.yaml",
+			"",
+			[]string{"-access-secret", "visible"},
+			errors.New("Invalid flags - flag provided but not defined: -access-secret"),
+			"Error - Secrets aren't flags",
+		},
+	}
+
+	for _, iterTest := range tests {
+		path := filepath.Join(dir, iterTest.file)
+		assert.NoError(ioutil.WriteFile(path, []byte(iterTest.content), 0644))
+
+		_, err := Load(append([]string{"-config", path}, iterTest.args...))
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+}
@@ -0,0 +1,208 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nktsitas/checkout-techlab/bank"
+)
+
+// ConfigFileEnv names the config file when the -config flag isn't given
+const ConfigFileEnv = "CONFIG_FILE"
+
+// setting is a value that can be overridden by an environment variable and, unless it is a secret, a flag
+type setting struct {
+	key string
+	env string
+	// flag is empty for secrets, which shouldn't show in the process list
+	flag string
+	set func(config *Config, value string) error
+}
+
+var settings = []setting{
+	{"port", "PORT", "port", intValue(func(c *Config) *int { return &c.Port })},
+	{"shutdown_timeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", durationValue(func(c *Config) *Duration { return &c.ShutdownTimeout })},
+	{"shutdown_delay", "SHUTDOWN_DELAY", "shutdown-delay", durationValue(func(c *Config) *Duration { return &c.ShutdownDelay })},
+	{"tls.cert_file", "TLS_CERT_FILE", "tls-cert-file", stringValue(func(c *Config) *string { return &c.TLS.CertFile })},
+	{"tls.key_file", "TLS_KEY_FILE", "tls-key-file", stringValue(func(c *Config) *string { return &c.TLS.KeyFile })},
+	{"tls.reload_interval", "TLS_RELOAD_INTERVAL", "tls-reload-interval", durationValue(func(c *Config) *Duration { return &c.TLS.ReloadInterval })},
+	{"tls.client_auth", "TLS_CLIENT_AUTH", "tls-client-auth", stringValue(func(c *Config) *string { return &c.TLS.ClientAuth })},
+	{"tls.client_ca_file", "TLS_CLIENT_CA_FILE", "tls-client-ca-file", stringValue(func(c *Config) *string { return &c.TLS.ClientCAFile })},
+	{"tls.redirect_port", "TLS_REDIRECT_PORT", "tls-redirect-port", intValue(func(c *Config) *int { return &c.TLS.RedirectPort })},
+	{"api.legacy_routes", "API_LEGACY_ROUTES", "api-legacy-routes", boolValue(func(c *Config) *bool { return &c.API.LegacyRoutes })},
+	{"api.legacy_sunset", "API_LEGACY_SUNSET", "api-legacy-sunset", stringValue(func(c *Config) *string { return &c.API.LegacySunset })},
+	{"log.level", "LOG_LEVEL", "log-level", stringValue(func(c *Config) *string { return &c.Log.Level })},
+	{"log.format", "LOG_FORMAT", "log-format", stringValue(func(c *Config) *string { return &c.Log.Format })},
+	{"auth.access_secret", "ACCESS_SECRET", "", stringValue(func(c *Config) *string { return &c.Auth.AccessSecret })},
+	{"auth.token_expiry", "TOKEN_EXPIRY", "token-expiry", durationValue(func(c *Config) *Duration { return &c.Auth.TokenExpiry })},
+	{"auth.admin_password", "ADMIN_PASSWORD", "", stringValue(func(c *Config) *string { return &c.Auth.AdminPassword })},
+	{"auth.lockout.max_failures", "AUTH_LOCKOUT_MAX_FAILURES", "auth-lockout-max-failures", intValue(func(c *Config) *int { return &c.Auth.Lockout.MaxFailures })},
+	{"auth.lockout.window", "AUTH_LOCKOUT_WINDOW", "auth-lockout-window", durationValue(func(c *Config) *Duration { return &c.Auth.Lockout.Window })},
+	{"auth.lockout.duration", "AUTH_LOCKOUT_DURATION", "auth-lockout-duration", durationValue(func(c *Config) *Duration { return &c.Auth.Lockout.Duration })},
+	{"rate_limit.enabled", "RATE_LIMIT_ENABLED", "rate-limit-enabled", boolValue(func(c *Config) *bool { return &c.RateLimit.Enabled })},
+	{"rate_limit.default.per_minute", "RATE_LIMIT_PER_MINUTE", "rate-limit-per-minute", intValue(func(c *Config) *int { return &c.RateLimit.Default.PerMinute })},
+	{"rate_limit.default.burst", "RATE_LIMIT_BURST", "rate-limit-burst", intValue(func(c *Config) *int { return &c.RateLimit.Default.Burst })},
+	{"rate_limit.trust_forwarded_for", "RATE_LIMIT_TRUST_FORWARDED_FOR", "rate-limit-trust-forwarded-for", boolValue(func(c *Config) *bool { return &c.RateLimit.TrustForwardedFor })},
+	{"storage.backend", "STORAGE_BACKEND", "storage-backend", stringValue(func(c *Config) *string { return &c.Storage.Backend })},
+	{"tracing.exporter", "TRACING_EXPORTER", "tracing-exporter", stringValue(func(c *Config) *string { return &c.Tracing.Exporter })},
+	{"bank.simulator_latency", "BANK_SIMULATOR_LATENCY", "bank-simulator-latency", durationValue(func(c *Config) *Duration { return &c.Bank.SimulatorLatency })},
+	{"bank.scenarios_file", "BANK_SCENARIOS_FILE", "bank-scenarios-file", stringValue(func(c *Config) *string { return &c.Bank.ScenariosFile })},
+	{"bank.timeouts", "BANK_TIMEOUTS", "bank-timeouts", setTimeouts},
+	{"bank.routing_file", "BANK_ROUTING_FILE", "bank-routing-file", stringValue(func(c *Config) *string { return &c.Bank.RoutingFile })},
+	{"bank.acquirer_urls", "BANK_ACQUIRER_URLS", "bank-acquirer-urls", setAcquirerURLs},
+	{"bank.bin_countries_file", "BANK_BIN_COUNTRIES_FILE", "bank-bin-countries-file", stringValue(func(c *Config) *string { return &c.Bank.BinCountriesFile })},
+	{"bank.breaker.failure_threshold", "BANK_BREAKER_FAILURE_THRESHOLD", "bank-breaker-failure-threshold", intValue(func(c *Config) *int { return &c.Bank.Breaker.FailureThreshold })},
+	{"bank.breaker.open_timeout", "BANK_BREAKER_OPEN_TIMEOUT", "bank-breaker-open-timeout", durationValue(func(c *Config) *Duration { return &c.Bank.Breaker.OpenTimeout })},
+	{"bank.breaker.half_open_max_calls", "BANK_BREAKER_HALF_OPEN_MAX_CALLS", "bank-breaker-half-open-max-calls", intValue(func(c *Config) *int { return &c.Bank.Breaker.HalfOpenMaxCalls })},
+	{"bank.retry.max_attempts", "BANK_RETRY_MAX_ATTEMPTS", "bank-retry-max-attempts", intValue(func(c *Config) *int { return &c.Bank.Retry.MaxAttempts })},
+	{"bank.retry.base_delay", "BANK_RETRY_BASE_DELAY", "bank-retry-base-delay", durationValue(func(c *Config) *Duration { return &c.Bank.Retry.BaseDelay })},
+	{"bank.retry.max_delay", "BANK_RETRY_MAX_DELAY", "bank-retry-max-delay", durationValue(func(c *Config) *Duration { return &c.Bank.Retry.MaxDelay })},
+	{"merchants_file", "MERCHANTS_FILE", "merchants-file", stringValue(func(c *Config) *string { return &c.MerchantsFile })},
+	{"pricing_file", "PRICING_FILE", "pricing-file", stringValue(func(c *Config) *string { return &c.PricingFile })},
+	{"fx_rates_file", "FX_RATES_FILE", "fx-rates-file", stringValue(func(c *Config) *string { return &c.FXRatesFile })},
+	{"risk_file", "RISK_FILE", "risk-file", stringValue(func(c *Config) *string { return &c.RiskFile })},
+	{"settlement.cutoff", "SETTLEMENT_CUTOFF", "settlement-cutoff", stringValue(func(c *Config) *string { return &c.Settlement.Cutoff })},
+	{"reconciliation.dir", "RECONCILIATION_DIR", "reconciliation-dir", stringValue(func(c *Config) *string { return &c.Reconciliation.Dir })},
+	{"reconciliation.interval", "RECONCILIATION_INTERVAL", "reconciliation-interval", durationValue(func(c *Config) *Duration { return &c.Reconciliation.Interval })},
+	{"disputes.response_window", "DISPUTE_RESPONSE_WINDOW", "dispute-response-window", durationValue(func(c *Config) *Duration { return &c.Disputes.ResponseWindow })},
+}
+
+// Load builds the configuration from the defaults, the config file (-config flag or CONFIG_FILE),
+// the environment and the command line args, in increasing precedence, and validates it
+func Load(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("checkout-techlab", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+
+	configFile := flags.String("config", os.Getenv(ConfigFileEnv), "YAML or JSON config file, env "+ConfigFileEnv)
+	values := make(map[string]*string)
+	for _, iterSetting := range settings {
+		if iterSetting.flag != "" {
+			values[iterSetting.flag] = flags.String(iterSetting.flag, "", iterSetting.key+", env "+iterSetting.env)
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			flags.SetOutput(os.Stderr)
+			flags.PrintDefaults()
+			return nil, err
+		}
+		return nil, fmt.Errorf("Invalid flags - %s", err.Error())
+	}
+
+	config := Default()
+
+	if *configFile != "" {
+		if err := config.readFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, iterSetting := range settings {
+		if value, ok := os.LookupEnv(iterSetting.env); ok && value != "" {
+			if err := iterSetting.set(config, value); err != nil {
+				return nil, fmt.Errorf("Invalid config - %s: %s", iterSetting.env, err.Error())
+			}
+		}
+	}
+
+	var flagErr error
+	flags.Visit(func(f *flag.Flag) {
+		for _, iterSetting := range settings {
+			if flagErr == nil && iterSetting.flag == f.Name {
+				if err := iterSetting.set(config, f.Value.String()); err != nil {
+					flagErr = fmt.Errorf("Invalid config - -%s: %s", f.Name, err.Error())
+				}
+			}
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func stringValue(field func(*Config) *string) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		*field(config) = value
+		return nil
+	}
+}
+
+func intValue(field func(*Config) *int) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+
+		*field(config) = parsed
+		return nil
+	}
+}
+
+func boolValue(field func(*Config) *bool) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		parsed, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", value)
+		}
+
+		*field(config) = parsed
+		return nil
+	}
+}
+
+func durationValue(field func(*Config) *Duration) func(*Config, string) error {
+	return func(config *Config, value string) error {
+		return field(config).set(value)
+	}
+}
+
+// setTimeouts reads operation=duration pairs, e.g. charge=3s,refund=2500ms
+func setTimeouts(config *Config, value string) error {
+	timeouts, err := bank.ParseTimeouts(value)
+	if err != nil {
+		return err
+	}
+
+	if config.Bank.Timeouts == nil {
+		config.Bank.Timeouts = make(map[string]Duration)
+	}
+	for operation, timeout := range timeouts {
+		config.Bank.Timeouts[operation] = Duration{timeout}
+	}
+
+	return nil
+}
+
+// setAcquirerURLs reads name=url pairs, e.g. acquirer_a=https://a.example.com/payments
+func setAcquirerURLs(config *Config, value string) error {
+	if config.Bank.AcquirerURLs == nil {
+		config.Bank.AcquirerURLs = make(map[string]string)
+	}
+
+	for _, iterPair := range strings.Split(value, ",") {
+		if strings.TrimSpace(iterPair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(iterPair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("%q - expected acquirer=url", iterPair)
+		}
+
+		config.Bank.AcquirerURLs[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package db
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/nktsitas/checkout-techlab/tracing"
+)
+
+// StoreItemContext stores an item in DB, traced as part of the request of ctx
+func StoreItemContext(ctx context.Context, id string, item interface{}) {
+	_, span := tracing.Start(ctx, "db.StoreItem", attribute.String("db.id", id))
+	defer span.End()
+
+	DB.StoreItem(id, item)
+}
+
+// FetchItemContext fetches an item from DB, traced as part of the request of ctx
+func FetchItemContext(ctx context.Context, id string) interface{} {
+	_, span := tracing.Start(ctx, "db.FetchItem", attribute.String("db.id", id))
+	defer span.End()
+
+	return DB.FetchItem(id)
+}
@@ -1,9 +1,84 @@
 package db
 
+import (
+	"context"
+	"errors"
+	"io"
+)
+
 type DatabaseI interface {
 	StoreItem(string, interface{})
 	FetchItem(string) interface{}
 	DeleteItem(string)
+	QueryItems(Query) ([]interface{}, string, error)
+}
+
+var DB DatabaseI
+
+// PingerI is implemented by storage backends that can lose their connection, the in-memory one can't
+type PingerI interface {
+	Ping(context.Context) error
+}
+
+// Ping checks the storage backend is reachable, for readiness checks
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("Storage failure - Not initialized")
+	}
+
+	if pinger, ok := DB.(PingerI); ok {
+		return pinger.Ping(ctx)
+	}
+
+	return nil
+}
+
+// Close releases the storage backend at shutdown, when it holds anything (connections, files) to release
+func Close() error {
+	if closer, ok := DB.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
 }
 
-var DB DatabaseI
\ No newline at end of file
+// Indexable items are (re)indexed every time they are stored, so they can be queried.
+// Items that are not Indexable can only be fetched by id.
+type Indexable interface {
+	// IndexValues are exact-match indexes, a field can hold several values (e.g. tags)
+	IndexValues() map[string][]string
+	// SortValues are ordered indexes used for ranges, sorting and pagination
+	SortValues() map[string]float64
+}
+
+// Query matches indexed items. Every Equals field must match and every Range must contain the item's value.
+// Items without a value for SortBy are never returned.
+type Query struct {
+	Equals map[string]string
+	Ranges map[string]Range
+	SortBy string
+	Descending bool
+	// Limit caps the page size, the returned cursor fetches the next page
+	Limit int
+	Cursor string
+}
+
+// Range bounds are inclusive, a nil bound is open
+type Range struct {
+	Min *float64
+	Max *float64
+}
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+func (r Range) contains(value float64) bool {
+	if r.Min != nil && value < *r.Min {
+		return false
+	}
+
+	if r.Max != nil && value > *r.Max {
+		return false
+	}
+
+	return true
+}
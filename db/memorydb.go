@@ -1,29 +1,63 @@
 package db
 
-import "sync"
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"sync"
+)
 
 type memoryDB struct {
 	store_map map[string]interface{}
+
+	// secondary indexes, maintained for Indexable items. Sort indexes are kept by partition, then by field.
+	equal_index map[string]map[string]map[string]struct{}
+	sort_index map[string]map[string][]sortEntry
+	item_keys map[string]indexKeys
+
 	mu sync.Mutex
 }
 
+type sortEntry struct {
+	Value float64	`json:"v"`
+	Id string		`json:"id"`
+}
+
+type indexKeys struct {
+	equals map[string][]string
+	sorts map[string]float64
+	partitions []string
+}
+
+// partitionFields scope the sort indexes: besides the index of every item, items are in the sort index of
+// their kind and in the one of their kind and merchant, so listing a merchant's items only walks those
+var partitionFields = []string{"kind", "merchant"}
+
 func InitMemoryDB() *memoryDB {
 	return &memoryDB{
 		store_map: make(map[string]interface{}),
+		equal_index: make(map[string]map[string]map[string]struct{}),
+		sort_index: make(map[string]map[string][]sortEntry),
+		item_keys: make(map[string]indexKeys),
 	}
 }
 
 func (mdb *memoryDB) StoreItem(id string, item interface{}) {
 	mdb.mu.Lock()
 	defer mdb.mu.Unlock()
-	
+
 	mdb.store_map[id] = item
+
+	mdb.unindex(id)
+	if indexable, ok := item.(Indexable); ok {
+		mdb.index(id, indexable)
+	}
 }
 
 func (mdb *memoryDB) FetchItem(id string) interface{} {
 	mdb.mu.Lock()
 	defer mdb.mu.Unlock()
-	
+
 	item := mdb.store_map[id]
 	return item
 }
@@ -31,6 +65,307 @@ func (mdb *memoryDB) FetchItem(id string) interface{} {
 func (mdb *memoryDB) DeleteItem(id string) {
 	mdb.mu.Lock()
 	defer mdb.mu.Unlock()
-	
+
 	delete(mdb.store_map, id)
-}
\ No newline at end of file
+	mdb.unindex(id)
+}
+
+// QueryItems walks the sort index of the query's partition, from the cursor and within the range on the sort key,
+// checking the other fields on the way. When an exact-match index holds few enough items, those are sorted instead.
+func (mdb *memoryDB) QueryItems(query Query) ([]interface{}, string, error) {
+	mdb.mu.Lock()
+	defer mdb.mu.Unlock()
+
+	var after *sortEntry
+	if query.Cursor != "" {
+		decoded, err := decodeCursor(query.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		after = decoded
+	}
+
+	partition, rest := queryPartition(query.Equals)
+	entries := mdb.sort_index[partition][query.SortBy]
+
+	sets := mdb.equalSets(query.Equals)
+	if len(sets) > 0 && collectCandidates(len(sets[0]), len(entries), query.Limit) {
+		entries = mdb.candidates(sets, query.SortBy)
+		rest = nil
+	}
+
+	// entries are ordered, cut them down to the range on the sort key
+	if bounds, ok := query.Ranges[query.SortBy]; ok {
+		entries = entries[lowerBound(entries, bounds.Min):upperBound(entries, bounds.Max)]
+	}
+
+	// resume right after the cursor
+	if after != nil {
+		if query.Descending {
+			entries = entries[:sort.Search(len(entries), func(i int) bool {
+				return !entries[i].less(*after)
+			})]
+		} else {
+			entries = entries[sort.Search(len(entries), func(i int) bool {
+				return after.less(entries[i])
+			}):]
+		}
+	}
+
+	restSets := mdb.equalSets(rest)
+
+	var items []interface{}
+	var last sortEntry
+	more := false
+
+	for i := range entries {
+		entry := entries[i]
+		if query.Descending {
+			entry = entries[len(entries)-1-i]
+		}
+
+		if !inSets(entry.Id, restSets) || !mdb.inRanges(entry.Id, query.Ranges) {
+			continue
+		}
+
+		if query.Limit > 0 && len(items) == query.Limit {
+			more = true
+			break
+		}
+
+		items = append(items, mdb.store_map[entry.Id])
+		last = entry
+	}
+
+	if !more {
+		return items, "", nil
+	}
+
+	return items, encodeCursor(last), nil
+}
+
+// queryPartition is the most specific partition covering equals, and the fields left to check
+func queryPartition(equals map[string]string) (string, map[string]string) {
+	rest := make(map[string]string, len(equals))
+	for field, value := range equals {
+		rest[field] = value
+	}
+
+	partition := ""
+	for _, iterField := range partitionFields {
+		value, ok := equals[iterField]
+		if !ok {
+			break
+		}
+		partition += iterField + "=" + value + "\x00"
+		delete(rest, iterField)
+	}
+
+	return partition, rest
+}
+
+// partitionsOf are the partitions of an item with the exact-match values equals, the "" one holds every item
+func partitionsOf(equals map[string][]string) []string {
+	partitions := []string{""}
+
+	partition := ""
+	for _, iterField := range partitionFields {
+		if len(equals[iterField]) != 1 {
+			break
+		}
+		partition += iterField + "=" + equals[iterField][0] + "\x00"
+		partitions = append(partitions, partition)
+	}
+
+	return partitions
+}
+
+// collectCandidates tells whether sorting the k items of the smallest exact-match index beats walking the sorted
+// entries: a walk visits about limit*entries/k of them to fill a page when matches are spread evenly,
+// sorting costs about k*log(k)
+func collectCandidates(k int, entries int, limit int) bool {
+	if limit == 0 {
+		limit = entries
+	}
+
+	return k*k < limit*entries
+}
+
+// equalSets are the exact-match index sets of equals, smallest first
+func (mdb *memoryDB) equalSets(equals map[string]string) []map[string]struct{} {
+	var sets []map[string]struct{}
+	for field, value := range equals {
+		sets = append(sets, mdb.equal_index[field][value])
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		return len(sets[i]) < len(sets[j])
+	})
+
+	return sets
+}
+
+// candidates are the items in every set with a sortBy value, ordered
+func (mdb *memoryDB) candidates(sets []map[string]struct{}, sortBy string) []sortEntry {
+	entries := make([]sortEntry, 0, len(sets[0]))
+	for id := range sets[0] {
+		if !inSets(id, sets[1:]) {
+			continue
+		}
+		if value, ok := mdb.item_keys[id].sorts[sortBy]; ok {
+			entries = append(entries, sortEntry{value, id})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].less(entries[j])
+	})
+
+	return entries
+}
+
+func inSets(id string, sets []map[string]struct{}) bool {
+	for _, iterSet := range sets {
+		if _, ok := iterSet[id]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (mdb *memoryDB) inRanges(id string, ranges map[string]Range) bool {
+	sorts := mdb.item_keys[id].sorts
+
+	for field, bounds := range ranges {
+		value, ok := sorts[field]
+		if !ok || !bounds.contains(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (mdb *memoryDB) index(id string, item Indexable) {
+	keys := indexKeys{
+		equals: item.IndexValues(),
+		sorts: item.SortValues(),
+	}
+	keys.partitions = partitionsOf(keys.equals)
+
+	for field, values := range keys.equals {
+		if mdb.equal_index[field] == nil {
+			mdb.equal_index[field] = make(map[string]map[string]struct{})
+		}
+		for _, iterValue := range values {
+			if mdb.equal_index[field][iterValue] == nil {
+				mdb.equal_index[field][iterValue] = make(map[string]struct{})
+			}
+			mdb.equal_index[field][iterValue][id] = struct{}{}
+		}
+	}
+
+	for _, iterPartition := range keys.partitions {
+		if mdb.sort_index[iterPartition] == nil {
+			mdb.sort_index[iterPartition] = make(map[string][]sortEntry)
+		}
+		sortIndex := mdb.sort_index[iterPartition]
+
+		for field, value := range keys.sorts {
+			entry := sortEntry{value, id}
+			entries := sortIndex[field]
+
+			position := sort.Search(len(entries), func(i int) bool {
+				return !entries[i].less(entry)
+			})
+			entries = append(entries, sortEntry{})
+			copy(entries[position+1:], entries[position:])
+			entries[position] = entry
+
+			sortIndex[field] = entries
+		}
+	}
+
+	mdb.item_keys[id] = keys
+}
+
+func (mdb *memoryDB) unindex(id string) {
+	keys, ok := mdb.item_keys[id]
+	if !ok {
+		return
+	}
+
+	for field, values := range keys.equals {
+		for _, iterValue := range values {
+			delete(mdb.equal_index[field][iterValue], id)
+			if len(mdb.equal_index[field][iterValue]) == 0 {
+				delete(mdb.equal_index[field], iterValue)
+			}
+		}
+	}
+
+	for _, iterPartition := range keys.partitions {
+		sortIndex := mdb.sort_index[iterPartition]
+
+		for field, value := range keys.sorts {
+			entries := sortIndex[field]
+			entry := sortEntry{value, id}
+
+			position := sort.Search(len(entries), func(i int) bool {
+				return !entries[i].less(entry)
+			})
+			if position < len(entries) && entries[position] == entry {
+				sortIndex[field] = append(entries[:position], entries[position+1:]...)
+			}
+		}
+	}
+
+	delete(mdb.item_keys, id)
+}
+
+// entries are ordered by value, ties broken by id so the order (and the cursor) is stable
+func (se sortEntry) less(other sortEntry) bool {
+	if se.Value != other.Value {
+		return se.Value < other.Value
+	}
+
+	return se.Id < other.Id
+}
+
+func lowerBound(entries []sortEntry, min *float64) int {
+	if min == nil {
+		return 0
+	}
+
+	return sort.Search(len(entries), func(i int) bool {
+		return entries[i].Value >= *min
+	})
+}
+
+func upperBound(entries []sortEntry, max *float64) int {
+	if max == nil {
+		return len(entries)
+	}
+
+	return sort.Search(len(entries), func(i int) bool {
+		return entries[i].Value > *max
+	})
+}
+
+func encodeCursor(entry sortEntry) string {
+	data, _ := json.Marshal(entry)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (*sortEntry, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var entry sortEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Id == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	return &entry, nil
+}
@@ -0,0 +1,248 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testItem struct {
+	id string
+	color string
+	amount float64
+}
+
+func (ti *testItem) IndexValues() map[string][]string {
+	return map[string][]string{"color": {ti.color}}
+}
+
+func (ti *testItem) SortValues() map[string]float64 {
+	return map[string]float64{"amount": ti.amount}
+}
+
+func ids(items []interface{}) []string {
+	result := []string{}
+	for _, iterItem := range items {
+		result = append(result, iterItem.(*testItem).id)
+	}
+
+	return result
+}
+
+func float(value float64) *float64 {
+	return &value
+}
+
+func TestQueryItems(t *testing.T) {
+	assert := assert.New(t)
+
+	mdb := InitMemoryDB()
+	for i := 0; i < 6; i++ {
+		color := "red"
+		if i%2 == 1 {
+			color = "blue"
+		}
+		mdb.StoreItem(fmt.Sprintf("item%d", i), &testItem{fmt.Sprintf("item%d", i), color, float64(i * 10)})
+	}
+	// not indexable, never returned by queries
+	mdb.StoreItem("plain", "plain value")
+
+	// re-storing moves the item in the indexes
+	mdb.StoreItem("item0", &testItem{"item0", "blue", 100})
+
+	tests := []struct{
+		query Query
+		expected []string
+		description string
+	}{
+		{
+			Query{SortBy: "amount"},
+			[]string{"item1", "item2", "item3", "item4", "item5", "item0"},
+			"All items ascending",
+		},
+		{
+			Query{SortBy: "amount", Descending: true, Equals: map[string]string{"color": "blue"}},
+			[]string{"item0", "item5", "item3", "item1"},
+			"Filtered descending",
+		},
+		{
+			Query{SortBy: "amount", Ranges: map[string]Range{"amount": {Min: float(20), Max: float(40)}}},
+			[]string{"item2", "item3", "item4"},
+			"Range on the sort key",
+		},
+		{
+			Query{SortBy: "amount", Equals: map[string]string{"color": "red"}, Ranges: map[string]Range{"amount": {Min: float(30)}}},
+			[]string{"item4"},
+			"Filter and open range",
+		},
+		{
+			Query{SortBy: "amount", Equals: map[string]string{"color": "green"}},
+			[]string{},
+			"No match",
+		},
+	}
+
+	for _, iterTest := range tests {
+		items, cursor, err := mdb.QueryItems(iterTest.query)
+
+		assert.NoError(err, iterTest.description)
+		assert.Empty(cursor, iterTest.description)
+		assert.Equal(iterTest.expected, ids(items), iterTest.description)
+	}
+}
+
+func TestQueryItemsPagination(t *testing.T) {
+	assert := assert.New(t)
+
+	mdb := InitMemoryDB()
+	for i := 0; i < 5; i++ {
+		// equal amounts are ordered by id
+		mdb.StoreItem(fmt.Sprintf("item%d", i), &testItem{fmt.Sprintf("item%d", i), "red", float64(i / 2)})
+	}
+
+	for _, iterDescending := range []bool{false, true} {
+		var pages [][]string
+		query := Query{SortBy: "amount", Descending: iterDescending, Limit: 2}
+
+		for {
+			items, cursor, err := mdb.QueryItems(query)
+			assert.NoError(err)
+
+			pages = append(pages, ids(items))
+			if cursor == "" {
+				break
+			}
+			query.Cursor = cursor
+
+			// items stored between pages don't shift the next page
+			mdb.StoreItem("late", &testItem{"late", "red", -1})
+		}
+
+		if iterDescending {
+			assert.Equal([][]string{{"item4", "item3"}, {"item2", "item1"}, {"item0", "late"}}, pages, "Descending pages")
+		} else {
+			assert.Equal([][]string{{"item0", "item1"}, {"item2", "item3"}, {"item4"}}, pages, "Ascending pages")
+		}
+	}
+
+	_, _, err := mdb.QueryItems(Query{SortBy: "amount", Cursor: "not a cursor"})
+	assert.Equal(ErrInvalidCursor, err, "Invalid cursor")
+}
+
+type partitionedItem struct {
+	id string
+	kind string
+	merchant string
+	color string
+	amount float64
+}
+
+func (pi *partitionedItem) IndexValues() map[string][]string {
+	return map[string][]string{"kind": {pi.kind}, "merchant": {pi.merchant}, "color": {pi.color}}
+}
+
+func (pi *partitionedItem) SortValues() map[string]float64 {
+	return map[string]float64{"amount": pi.amount}
+}
+
+func TestQueryItemsPartitions(t *testing.T) {
+	assert := assert.New(t)
+
+	mdb := InitMemoryDB()
+	var all []*partitionedItem
+	for i := 0; i < 60; i++ {
+		item := &partitionedItem{fmt.Sprintf("item%02d", i), "authorization", "Checkout", "red", float64(i % 7)}
+		if i%3 == 0 {
+			item.merchant = "Shop"
+		}
+		if i%10 == 0 {
+			item.color = "blue"
+		}
+		if i%20 == 0 {
+			item.kind = "dispute"
+		}
+		mdb.StoreItem(item.id, item)
+		all = append(all, item)
+	}
+
+	assert.Len(mdb.sort_index["kind=authorization\x00merchant=Checkout\x00"]["amount"], 38, "Partition - Merchant's items")
+
+	tests := []struct{
+		equals map[string]string
+		description string
+	}{
+		{map[string]string{"kind": "authorization", "merchant": "Checkout"}, "Walk - Kind and merchant partition"},
+		{map[string]string{"kind": "authorization", "merchant": "Checkout", "color": "red"}, "Walk - Other fields checked per entry"},
+		{map[string]string{"kind": "authorization", "color": "blue"}, "Candidates - Few items"},
+		{map[string]string{"merchant": "Shop"}, "Walk - No kind, every item"},
+		{map[string]string{"kind": "dispute", "merchant": "Shop"}, "Walk - Other kind"},
+	}
+
+	for _, iterTest := range tests {
+		for _, iterDescending := range []bool{false, true} {
+			var expected []string
+			for _, iterItem := range all {
+				item := map[string]string{"kind": iterItem.kind, "merchant": iterItem.merchant, "color": iterItem.color}
+				matches := true
+				for field, value := range iterTest.equals {
+					matches = matches && item[field] == value
+				}
+				if matches && iterItem.amount >= 2 {
+					expected = append(expected, iterItem.id)
+				}
+			}
+			sort.Slice(expected, func(i, j int) bool {
+				first, second := mdb.item_keys[expected[i]].sorts["amount"], mdb.item_keys[expected[j]].sorts["amount"]
+				if first != second {
+					return (first < second) != iterDescending
+				}
+				return (expected[i] < expected[j]) != iterDescending
+			})
+
+			var got []string
+			query := Query{Equals: iterTest.equals, Ranges: map[string]Range{"amount": {Min: float(2)}}, SortBy: "amount", Descending: iterDescending, Limit: 4}
+			for {
+				items, cursor, err := mdb.QueryItems(query)
+				assert.NoError(err, iterTest.description)
+				for _, iterItem := range items {
+					got = append(got, iterItem.(*partitionedItem).id)
+				}
+				if cursor == "" {
+					break
+				}
+				query.Cursor = cursor
+			}
+
+			assert.Equal(expected, got, iterTest.description)
+		}
+	}
+
+	// re-storing moves the item across partitions
+	mdb.StoreItem("item01", &partitionedItem{"item01", "authorization", "Shop", "red", 1})
+	assert.Len(mdb.sort_index["kind=authorization\x00merchant=Checkout\x00"]["amount"], 37, "Re-stored - Left its old partition")
+	assert.Len(mdb.sort_index["kind=authorization\x00merchant=Shop\x00"]["amount"], 20, "Re-stored - In its new partition")
+}
+
+func TestCollectCandidates(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct{
+		candidates int
+		entries int
+		limit int
+		expected bool
+		description string
+	}{
+		{1, 100000, 1, true, "Lookup - Sorted"},
+		{50000, 100000, 20, false, "Page of a large set - Walked"},
+		{1000, 100000, 20, true, "Selective filter - Sorted"},
+		{100, 1000, 0, true, "No limit, smaller set - Sorted"},
+		{1000, 1000, 0, false, "No limit, every entry - Walked"},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.expected, collectCandidates(iterTest.candidates, iterTest.entries, iterTest.limit), iterTest.description)
+	}
+}
@@ -0,0 +1,431 @@
+package disputes
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/events"
+	"github.com/nktsitas/checkout-techlab/gateway"
+)
+
+// Dispute statuses
+const (
+	StatusNeedsResponse = "needs_response"
+	StatusUnderReview = "under_review"
+	StatusWon = "won"
+	StatusLost = "lost"
+)
+
+// Event types, one per transition
+const (
+	EventOpened = "dispute.opened"
+	EventUnderReview = "dispute.under_review"
+	EventWon = "dispute.won"
+	EventLost = "dispute.lost"
+)
+
+// Notification types sent by acquirers
+const (
+	NotificationOpened = "opened"
+	NotificationWon = "won"
+	NotificationLost = "lost"
+)
+
+const disputeKind = "dispute"
+
+// DefaultResponseWindow is how long merchants have to submit evidence
+const DefaultResponseWindow = 7 * 24 * time.Hour
+
+var ResponseWindow = DefaultResponseWindow
+
+var (
+	ErrNotFound = errors.New("Dispute failure - Dispute not found")
+	ErrUnknownCapture = errors.New("Dispute failure - No capture with this acquirer reference")
+	ErrDeadlinePassed = errors.New("Dispute failure - The deadline to submit evidence has passed")
+)
+
+// Dispute is a cardholder dispute of a capture. The disputed amount is taken from the
+// merchant's balance when it is opened, and given back if the merchant wins it.
+type Dispute struct {
+	Id string										`json:"id"`
+	AuthorizationId string			`json:"authorization_id"`
+	Merchant string							`json:"merchant"`
+	Acquirer string							`json:"acquirer"`
+	// AcquirerReference is the acquirer reference of the disputed capture
+	AcquirerReference string		`json:"acquirer_reference"`
+	// AcquirerDisputeId is the acquirer's own id of the dispute, when it sent one
+	AcquirerDisputeId string		`json:"acquirer_dispute_id,omitempty"`
+	Amount float64							`json:"amount" example:"100.00"`
+	Currency string							`json:"currency" example:"EUR"`
+	SettlementAmount float64		`json:"settlement_amount" example:"100.00"`
+	SettlementCurrency string		`json:"settlement_currency" example:"EUR"`
+	ReasonCode string						`json:"reason_code,omitempty" example:"10.4"`
+	Reason string								`json:"reason,omitempty" example:"Fraud - card absent environment"`
+	Status string								`json:"status" example:"needs_response"`
+	EvidenceDueBy time.Time			`json:"evidence_due_by"`
+	Evidence []Evidence					`json:"evidence"`
+
+	// Reference and CaptureReference are the merchant's references of the authorization and of the disputed capture
+	Reference string						`json:"reference,omitempty"`
+	CaptureReference string			`json:"capture_reference,omitempty"`
+	Metadata map[string]string	`json:"metadata,omitempty"`
+
+	CreatedAt time.Time					`json:"created_at"`
+	UpdatedAt time.Time					`json:"updated_at"`
+
+	mu sync.Mutex
+}
+
+// Evidence is something the merchant submits to challenge a dispute, e.g. a delivery proof
+type Evidence struct {
+	Type string							`json:"type" example:"proof_of_delivery"`
+	Description string			`json:"description" example:"Signed for by the cardholder on 2020-07-02"`
+	SubmittedAt time.Time		`json:"submitted_at"`
+}
+
+// Notification is what an acquirer sends when a dispute is opened or decided
+type Notification struct {
+	Type string								`json:"type" example:"opened"`
+	// Acquirer sending the notification, it only applies to its own captures and disputes
+	Acquirer string						`json:"acquirer" example:"simulator"`
+	// AcquirerReference of the disputed capture, for opened notifications
+	AcquirerReference string	`json:"acquirer_reference,omitempty" example:"sim_5f0c3a1e9b2d4c68"`
+	// AcquirerDisputeId tells apart the disputes of a capture for opened notifications, redeliveries carry the same one.
+	// Without it a capture is disputed once.
+	AcquirerDisputeId string	`json:"acquirer_dispute_id,omitempty" example:"cb_20200702_0001"`
+	Amount float64						`json:"amount,omitempty" example:"100.00"`
+	ReasonCode string					`json:"reason_code,omitempty" example:"10.4"`
+	Reason string							`json:"reason,omitempty" example:"Fraud - card absent environment"`
+	// DisputeId of the decided dispute, for won and lost notifications
+	DisputeId string					`json:"dispute_id,omitempty"`
+}
+
+// Notify applies an acquirer notification and returns the dispute it is about
+func Notify(notification Notification) (*Dispute, error) {
+	if notification.Acquirer == "" {
+		return nil, errors.New("Dispute failure - Notification needs the acquirer")
+	}
+
+	switch notification.Type {
+	case NotificationOpened:
+		return Open(notification.Acquirer, notification.AcquirerReference, notification.AcquirerDisputeId, notification.Amount, notification.ReasonCode, notification.Reason)
+	case NotificationWon, NotificationLost:
+		// disputes of other acquirers are unknown to this one
+		dispute := Get(notification.DisputeId)
+		if dispute == nil || dispute.Acquirer != notification.Acquirer {
+			return nil, ErrNotFound
+		}
+
+		return dispute, dispute.Resolve(notification.Type == NotificationWon)
+	}
+
+	return nil, errors.New("Dispute failure - Notification type must be opened, won or lost")
+}
+
+// opening serializes Open, so a notification delivered twice at once can't open two disputes
+var opening sync.Mutex
+
+// Open opens a dispute of amount on acquirer's capture with acquirerReference and debits the merchant.
+// A redelivered notification returns the dispute it opened, the merchant is debited once.
+func Open(acquirer string, acquirerReference string, acquirerDisputeId string, amount float64, reasonCode string, reason string) (*Dispute, error) {
+	if acquirer == "" || acquirerReference == "" {
+		return nil, ErrUnknownCapture
+	}
+
+	opening.Lock()
+	defer opening.Unlock()
+
+	opened, err := find(acquirerCase(acquirer, acquirerReference, acquirerDisputeId))
+	if err != nil {
+		return nil, err
+	}
+	if opened != nil {
+		log.WithFields(log.Fields{"id": opened.Id, "acquirer_reference": acquirerReference}).Info("Disputes.Open - Dispute already opened")
+		return opened, nil
+	}
+
+	auths, _, err := gateway.Gateway.ListAuthorizations(gateway.AuthorizationFilter{Acquirer: acquirer, AcquirerReference: acquirerReference, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(auths) == 0 {
+		log.WithFields(log.Fields{"acquirer": acquirer, "acquirer_reference": acquirerReference}).Error("Disputes.Open - Unknown capture")
+		return nil, ErrUnknownCapture
+	}
+	auth := auths[0]
+
+	capture, err := auth.Dispute(acquirerReference, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	createdAt := time.Now().UTC()
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", auth.Id, acquirerReference, createdAt.UnixNano())))
+
+	dispute := &Dispute{
+		Id: fmt.Sprintf("dsp_%x", sum[:8]),
+		AuthorizationId: auth.Id,
+		Merchant: auth.Merchant,
+		Acquirer: auth.Acquirer,
+		AcquirerReference: acquirerReference,
+		AcquirerDisputeId: acquirerDisputeId,
+		Amount: amount,
+		Currency: strings.ToUpper(auth.Currency),
+		SettlementAmount: auth.Convert(amount),
+		SettlementCurrency: auth.SettlementCurrency(),
+		ReasonCode: reasonCode,
+		Reason: reason,
+		Status: StatusNeedsResponse,
+		EvidenceDueBy: createdAt.Add(ResponseWindow),
+		Evidence: []Evidence{},
+		Reference: auth.Reference,
+		CaptureReference: capture.Reference,
+		Metadata: copyMetadata(auth.Metadata),
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+
+	dispute.mu.Lock()
+	defer dispute.mu.Unlock()
+
+	dispute.save(EventOpened)
+
+	return dispute, nil
+}
+
+// Get returns a stored dispute, nil if there is none with id
+func Get(id string) *Dispute {
+	dispute, _ := db.DB.FetchItem(id).(*Dispute)
+	return dispute
+}
+
+// find returns the dispute of an acquirer case, nil if none was opened
+func find(acquirerCase string) (*Dispute, error) {
+	items, _, err := db.DB.QueryItems(db.Query{
+		Equals: map[string]string{
+			"kind": disputeKind,
+			"acquirer_case": acquirerCase,
+		},
+		SortBy: "created_at",
+		Limit: 1,
+	})
+	if err != nil || len(items) == 0 {
+		return nil, err
+	}
+
+	dispute, _ := items[0].(*Dispute)
+	return dispute, nil
+}
+
+// acquirerCase identifies a dispute as the acquirer knows it
+func acquirerCase(acquirer string, acquirerReference string, acquirerDisputeId string) string {
+	return acquirer + "\x00" + acquirerReference + "\x00" + acquirerDisputeId
+}
+
+// Snapshot copies the dispute under its lock, for reading it while evidence or the expiry scheduler may change it
+func (dispute *Dispute) Snapshot() *Dispute {
+	dispute.mu.Lock()
+	defer dispute.mu.Unlock()
+
+	return &Dispute{
+		Id: dispute.Id,
+		AuthorizationId: dispute.AuthorizationId,
+		Merchant: dispute.Merchant,
+		Acquirer: dispute.Acquirer,
+		AcquirerReference: dispute.AcquirerReference,
+		AcquirerDisputeId: dispute.AcquirerDisputeId,
+		Amount: dispute.Amount,
+		Currency: dispute.Currency,
+		SettlementAmount: dispute.SettlementAmount,
+		SettlementCurrency: dispute.SettlementCurrency,
+		ReasonCode: dispute.ReasonCode,
+		Reason: dispute.Reason,
+		Status: dispute.Status,
+		EvidenceDueBy: dispute.EvidenceDueBy,
+		Evidence: append([]Evidence{}, dispute.Evidence...),
+		Reference: dispute.Reference,
+		CaptureReference: dispute.CaptureReference,
+		Metadata: copyMetadata(dispute.Metadata),
+		CreatedAt: dispute.CreatedAt,
+		UpdatedAt: dispute.UpdatedAt,
+	}
+}
+
+// List returns the disputes of a merchant, newest first, only those in status when set
+func List(merchant string, status string) ([]*Dispute, error) {
+	equals := map[string]string{
+		"kind": disputeKind,
+		"merchant": merchant,
+	}
+	if status != "" {
+		equals["status"] = status
+	}
+
+	items, _, err := db.DB.QueryItems(db.Query{
+		Equals: equals,
+		SortBy: "created_at",
+		Descending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Dispute{}
+	for _, iterItem := range items {
+		if dispute, ok := iterItem.(*Dispute); ok {
+			result = append(result, dispute)
+		}
+	}
+
+	return result, nil
+}
+
+// SubmitEvidence adds the merchant's evidence and sends the dispute for review
+func (dispute *Dispute) SubmitEvidence(evidence []Evidence) error {
+	if len(evidence) == 0 {
+		return errors.New("Dispute failure - No evidence provided")
+	}
+
+	for _, iterEvidence := range evidence {
+		if iterEvidence.Type == "" || iterEvidence.Description == "" {
+			return errors.New("Dispute failure - Evidence needs a type and a description")
+		}
+	}
+
+	dispute.mu.Lock()
+	defer dispute.mu.Unlock()
+
+	if dispute.Status != StatusNeedsResponse {
+		return errors.New("Dispute failure - Evidence can only be submitted on disputes needing a response")
+	}
+
+	now := time.Now().UTC()
+	if now.After(dispute.EvidenceDueBy) {
+		return ErrDeadlinePassed
+	}
+
+	for _, iterEvidence := range evidence {
+		iterEvidence.SubmittedAt = now
+		dispute.Evidence = append(dispute.Evidence, iterEvidence)
+	}
+
+	dispute.Status = StatusUnderReview
+	dispute.UpdatedAt = now
+	dispute.save(EventUnderReview)
+
+	return nil
+}
+
+// Resolve closes the dispute with the acquirer's decision, won disputes are credited back to the merchant
+func (dispute *Dispute) Resolve(won bool) error {
+	dispute.mu.Lock()
+	defer dispute.mu.Unlock()
+
+	if dispute.Status == StatusWon || dispute.Status == StatusLost {
+		return fmt.Errorf("Dispute failure - Dispute already %s", dispute.Status)
+	}
+
+	dispute.close(won)
+
+	return nil
+}
+
+// ExpireOverdue loses the disputes still waiting for evidence after their deadline
+func ExpireOverdue(now time.Time) []*Dispute {
+	items, _, err := db.DB.QueryItems(db.Query{
+		Equals: map[string]string{
+			"kind": disputeKind,
+			"status": StatusNeedsResponse,
+		},
+		SortBy: "created_at",
+	})
+	if err != nil {
+		log.WithField("err", err).Error("Disputes.ExpireOverdue - Error querying disputes")
+		return nil
+	}
+
+	var expired []*Dispute
+	for _, iterItem := range items {
+		dispute, ok := iterItem.(*Dispute)
+		if !ok {
+			continue
+		}
+
+		dispute.mu.Lock()
+		if dispute.Status == StatusNeedsResponse && now.After(dispute.EvidenceDueBy) {
+			dispute.close(false)
+			expired = append(expired, dispute)
+		}
+		dispute.mu.Unlock()
+	}
+
+	return expired
+}
+
+// close records the decision, called with dispute.mu held
+func (dispute *Dispute) close(won bool) {
+	eventType := EventLost
+	dispute.Status = StatusLost
+
+	if won {
+		eventType = EventWon
+		dispute.Status = StatusWon
+
+		if auth, ok := db.DB.FetchItem(dispute.AuthorizationId).(*gateway.Authorization); ok {
+			auth.ReverseDispute(dispute.AcquirerReference, dispute.Amount)
+		} else {
+			log.WithField("id", dispute.Id).Error("Dispute.close - Authorization not found, disputed amount not credited back")
+		}
+	}
+
+	dispute.UpdatedAt = time.Now().UTC()
+	dispute.save(eventType)
+}
+
+// save stores the dispute and publishes the event of its transition, called with dispute.mu held
+func (dispute *Dispute) save(eventType string) {
+	db.DB.StoreItem(dispute.Id, dispute)
+
+	log.WithFields(log.Fields{
+		"id": dispute.Id,
+		"status": dispute.Status,
+	}).Info("Dispute.save - Dispute " + dispute.Status)
+
+	events.Publish(dispute.Merchant, eventType, dispute)
+}
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	if metadata == nil {
+		return nil
+	}
+
+	copied := make(map[string]string, len(metadata))
+	for key, value := range metadata {
+		copied[key] = value
+	}
+
+	return copied
+}
+
+func (dispute *Dispute) IndexValues() map[string][]string {
+	return map[string][]string{
+		"kind": {disputeKind},
+		"merchant": {dispute.Merchant},
+		"status": {dispute.Status},
+		"authorization": {dispute.AuthorizationId},
+		"acquirer_case": {acquirerCase(dispute.Acquirer, dispute.AcquirerReference, dispute.AcquirerDisputeId)},
+	}
+}
+
+func (dispute *Dispute) SortValues() map[string]float64 {
+	return map[string]float64{
+		"created_at": float64(dispute.CreatedAt.UnixNano() / int64(time.Microsecond)),
+	}
+}
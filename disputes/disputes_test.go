@@ -0,0 +1,242 @@
+package disputes
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/events"
+	"github.com/nktsitas/checkout-techlab/gateway"
+	"github.com/nktsitas/checkout-techlab/ledger"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAuthorization = `{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"EUR","reference":"order-1"}`
+
+var published []*events.Event
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+
+	bank.Scenarios = &bank.ScenarioRegistry{}
+
+	events.Deliver = func(event *events.Event) {
+		published = append(published, event)
+	}
+}
+
+func setup(t *testing.T) *gateway.Capture {
+	db.DB = db.InitMemoryDB()
+	ledger.Ledger = ledger.InitMemoryLedger()
+	gateway.Gateway = new(gateway.GatewayS)
+	published = nil
+
+	newAuth, err := gateway.Gateway.NewAuthorization(auth.WithMerchant(context.Background(), "Checkout"), []byte(testAuthorization), time.Now().String())
+	assert.NoError(t, err)
+
+	capture, err := newAuth.Capture(context.Background(), 80.00, "EUR", gateway.Details{Reference: "shipment-1"})
+	assert.NoError(t, err)
+
+	return capture
+}
+
+func publishedTypes() []string {
+	types := []string{}
+	for _, iterEvent := range published {
+		types = append(types, iterEvent.Type)
+	}
+
+	return types
+}
+
+func TestLifecycle(t *testing.T) {
+	assert := assert.New(t)
+
+	capture := setup(t)
+	pending := ledger.MerchantPending("Checkout")
+
+	dispute, err := Notify(Notification{Type: NotificationOpened, Acquirer: bank.DefaultAcquirer, AcquirerReference: capture.AcquirerReference, Amount: 50.00, ReasonCode: "10.4"})
+	assert.NoError(err)
+	assert.Equal(StatusNeedsResponse, dispute.Status)
+	assert.Equal("order-1", dispute.Reference, "Opened - Merchant references kept")
+	assert.Equal("shipment-1", dispute.CaptureReference, "Opened - Merchant references kept")
+	assert.Equal(-30.00, ledger.Ledger.Balance(pending, "EUR"), "Opened - Disputed amount debited")
+	assert.Equal(30.00, capture.Authorization.TotalCapturedAmount(), "Opened - Disputed amount cannot be refunded")
+
+	_, err = capture.Authorization.Refund(context.Background(), 40.00, "EUR", gateway.Details{})
+	assert.Equal(errors.New("Refund failure - Cannot refund more than total captured amount"), err, "Opened - Refund of the disputed amount")
+
+	assert.Equal(errors.New("Dispute failure - Evidence needs a type and a description"), dispute.SubmitEvidence([]Evidence{{Type: "receipt"}}))
+	assert.NoError(dispute.SubmitEvidence([]Evidence{{Type: "proof_of_delivery", Description: "Signed for"}}))
+	assert.Equal(StatusUnderReview, dispute.Status)
+	assert.False(dispute.Evidence[0].SubmittedAt.IsZero(), "Evidence - Submission time recorded")
+
+	_, err = Notify(Notification{Type: NotificationWon, Acquirer: bank.DefaultAcquirer, DisputeId: dispute.Id})
+	assert.NoError(err)
+	assert.Equal(StatusWon, dispute.Status)
+	assert.Equal(-80.00, ledger.Ledger.Balance(pending, "EUR"), "Won - Disputed amount credited back")
+	assert.Equal(80.00, capture.Authorization.TotalCapturedAmount(), "Won - Refundable again")
+
+	assert.Equal(errors.New("Dispute failure - Dispute already won"), dispute.Resolve(false), "Won - Final")
+	assert.Equal([]string{EventOpened, EventUnderReview, EventWon}, publishedTypes(), "An event per transition")
+
+	var data Dispute
+	assert.NoError(json.Unmarshal(published[0].Data, &data))
+	assert.Equal(StatusNeedsResponse, data.Status, "Events hold a snapshot of the dispute")
+
+	assert.NoError(ledger.Ledger.Check(), "Ledger invariants hold")
+}
+
+func TestNotifyErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	capture := setup(t)
+
+	tests := []struct{
+		notification Notification
+		err error
+		description string
+	}{
+		{
+			Notification{Type: NotificationOpened, AcquirerReference: capture.AcquirerReference, Amount: 10},
+			errors.New("Dispute failure - Notification needs the acquirer"),
+			"Error - No acquirer",
+		},
+		{
+			Notification{Type: NotificationOpened, Acquirer: "other", AcquirerReference: capture.AcquirerReference, Amount: 10},
+			ErrUnknownCapture,
+			"Error - Capture of another acquirer",
+		},
+		{
+			Notification{Type: NotificationOpened, Acquirer: bank.DefaultAcquirer, AcquirerReference: "sim_unknown", Amount: 10},
+			ErrUnknownCapture,
+			"Error - Unknown capture",
+		},
+		{
+			Notification{Type: NotificationOpened, Acquirer: bank.DefaultAcquirer, AcquirerReference: capture.AcquirerReference, Amount: 90},
+			errors.New("Dispute failure - Disputed amount must be positive and at most the captured amount not yet disputed"),
+			"Error - More than captured",
+		},
+		{
+			Notification{Type: NotificationLost, Acquirer: bank.DefaultAcquirer, DisputeId: "dsp_unknown"},
+			ErrNotFound,
+			"Error - Unknown dispute",
+		},
+		{
+			Notification{Type: "closed", Acquirer: bank.DefaultAcquirer},
+			errors.New("Dispute failure - Notification type must be opened, won or lost"),
+			"Error - Unknown notification",
+		},
+	}
+
+	for _, iterTest := range tests {
+		_, err := Notify(iterTest.notification)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+
+	assert.Empty(published, "No events for rejected notifications")
+}
+
+func TestRedelivery(t *testing.T) {
+	assert := assert.New(t)
+
+	capture := setup(t)
+	pending := ledger.MerchantPending("Checkout")
+
+	tests := []struct{
+		acquirerDisputeId string
+		amount float64
+		err error
+		expectedPending float64
+		description string
+	}{
+		{"cb_1", 50.00, nil, -30.00, "Opened"},
+		{"cb_1", 50.00, nil, -30.00, "Redelivered - Same dispute, debited once"},
+		{"cb_2", 40.00, errors.New("Dispute failure - Disputed amount must be positive and at most the captured amount not yet disputed"), -30.00, "Error - More than left undisputed"},
+		{"cb_2", 30.00, nil, 0.00, "Second dispute of the capture"},
+	}
+
+	opened := map[string]string{}
+	for _, iterTest := range tests {
+		dispute, err := Notify(Notification{Type: NotificationOpened, Acquirer: bank.DefaultAcquirer, AcquirerReference: capture.AcquirerReference, AcquirerDisputeId: iterTest.acquirerDisputeId, Amount: iterTest.amount})
+		assert.Equal(iterTest.err, err, iterTest.description)
+		assert.Equal(iterTest.expectedPending, ledger.Ledger.Balance(pending, "EUR"), iterTest.description)
+
+		if err == nil {
+			if id, ok := opened[iterTest.acquirerDisputeId]; ok {
+				assert.Equal(id, dispute.Id, iterTest.description)
+			}
+			opened[iterTest.acquirerDisputeId] = dispute.Id
+		}
+	}
+
+	assert.Equal([]string{EventOpened, EventOpened}, publishedTypes(), "An event per dispute opened")
+
+	// a won dispute can be disputed again
+	_, err := Notify(Notification{Type: NotificationWon, Acquirer: bank.DefaultAcquirer, DisputeId: opened["cb_2"]})
+	assert.NoError(err)
+	_, err = Notify(Notification{Type: NotificationOpened, Acquirer: bank.DefaultAcquirer, AcquirerReference: capture.AcquirerReference, AcquirerDisputeId: "cb_3", Amount: 30.00})
+	assert.NoError(err, "Won - Amount can be disputed again")
+
+	assert.NoError(ledger.Ledger.Check(), "Ledger invariants hold")
+}
+
+func TestExpireOverdue(t *testing.T) {
+	assert := assert.New(t)
+
+	capture := setup(t)
+
+	dispute, err := Open(bank.DefaultAcquirer, capture.AcquirerReference, "", 80.00, "13.1", "Merchandise not received")
+	assert.NoError(err)
+
+	assert.Empty(ExpireOverdue(time.Now()), "Before the deadline")
+
+	dispute.EvidenceDueBy = time.Now().UTC().Add(-time.Minute)
+	assert.Equal(ErrDeadlinePassed, dispute.SubmitEvidence([]Evidence{{Type: "receipt", Description: "Too late"}}), "After the deadline - No evidence")
+
+	expired := ExpireOverdue(time.Now())
+	assert.Equal([]*Dispute{dispute}, expired, "After the deadline")
+	assert.Equal(StatusLost, dispute.Status)
+
+	assert.Equal(errors.New("Dispute failure - Evidence can only be submitted on disputes needing a response"), dispute.SubmitEvidence([]Evidence{{Type: "receipt", Description: "Too late"}}))
+	assert.Equal(0.00, ledger.Ledger.Balance(ledger.MerchantPending("Checkout"), "EUR"), "Lost - Disputed amount stays debited")
+	assert.Equal([]string{EventOpened, EventLost}, publishedTypes())
+}
+
+func TestSnapshot(t *testing.T) {
+	assert := assert.New(t)
+
+	capture := setup(t)
+
+	dispute, err := Open(bank.DefaultAcquirer, capture.AcquirerReference, "", 50.00, "10.4", "Fraud")
+	assert.NoError(err)
+
+	// read while the merchant submits evidence, as handlers do
+	done := make(chan *Dispute)
+	go func() {
+		var snapshot *Dispute
+		for i := 0; i < 100; i++ {
+			snapshot = dispute.Snapshot()
+			json.Marshal(snapshot)
+		}
+		done <- snapshot
+	}()
+	assert.NoError(dispute.SubmitEvidence([]Evidence{{Type: "proof_of_delivery", Description: "Signed for"}}))
+	<-done
+
+	snapshot := dispute.Snapshot()
+	assert.Equal(StatusUnderReview, snapshot.Status)
+	assert.Equal(dispute.Evidence, snapshot.Evidence)
+
+	assert.NoError(dispute.Resolve(true))
+	assert.Equal(StatusUnderReview, snapshot.Status, "Snapshot - Not changed with the dispute")
+}
@@ -0,0 +1,32 @@
+package disputes
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultExpiryInterval is how often disputes past their evidence deadline are looked for
+const DefaultExpiryInterval = time.Minute
+
+// Schedule loses the disputes left without evidence past their deadline, every interval until ctx is done
+func Schedule(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultExpiryInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if expired := ExpireOverdue(now.UTC()); len(expired) > 0 {
+				log.WithField("disputes", len(expired)).Info("Disputes.Schedule - Disputes lost for lack of evidence")
+			}
+		}
+	}
+}
@@ -32,9 +32,55 @@ var doc = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/authorize": {
-            "post": {
-                "description": "Creates a new authorization",
+        "/health/live": {
+            "get": {
+                "description": "Answers as long as the process serves requests, no dependency is checked. Restart the service when it fails.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Tell whether the process is alive",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/handlers.liveResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/health/ready": {
+            "get": {
+                "description": "Check storage, acquirers and configuration, with the status and latency of each. Not ready while a check is down or the service is shutting down.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Tell whether the service can take traffic",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    },
+                    "503": {
+                        "description": "Not ready",
+                        "schema": {
+                            "$ref": "#/definitions/health.Report"
+                        }
+                    }
+                }
+            }
+        },
+        "/status/acquirers": {
+            "get": {
+                "description": "Get the circuit breaker state of each acquirer, so on-call can see when we are failing fast",
                 "consumes": [
                     "application/json"
                 ],
@@ -44,16 +90,98 @@ var doc = `{
                 "tags": [
                     "status"
                 ],
-                "summary": "Creates a new authorization",
-                "parameters": [
-                    {
-                        "description": "Create authorization",
-                        "name": "authorization",
-                        "in": "body",
-                        "required": true,
+                "summary": "Get the circuit breaker state of each acquirer",
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/gateway.Authorization"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/bank.BreakerStatus"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/status/ping": {
+            "get": {
+                "description": "Get a server status update",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Get a server status update",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
                         }
+                    }
+                }
+            }
+        },
+        "/v1/admin/audit": {
+            "get": {
+                "description": "Audit records of logins, payment operations and admin actions, oldest first, with the verification of the hash chain. format=jsonl exports one record per line. Requires an admin token.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Query and export the audit log",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Authenticated client, or the username of a login",
+                        "name": "actor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Merchant",
+                        "name": "merchant",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "login, authorize, capture, refund, void, dispute.notification, dispute.evidence, risk_list.view, risk_list.add, risk_list.remove or audit.export",
+                        "name": "operation",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Id of the object operated on, e.g. an authorization",
+                        "name": "target",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 timestamp, inclusive",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 timestamp, inclusive",
+                        "name": "created_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "json (default) or jsonl",
+                        "name": "format",
+                        "in": "query"
                     },
                     {
                         "type": "string",
@@ -67,15 +195,21 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.authResponse"
+                            "$ref": "#/definitions/v1.AuditResponse"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/capture": {
+        "/v1/admin/disputes/notifications": {
             "post": {
-                "description": "Captures amount from authorization",
+                "description": "Simulates an acquirer notifying a dispute: opened on one of its captures (the disputed amount is debited from the merchant), or decided (won disputes are credited back). Needs the admin role.",
                 "consumes": [
                     "application/json"
                 ],
@@ -83,17 +217,17 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "status"
+                    "disputes"
                 ],
-                "summary": "Captures amount from authorization",
+                "summary": "Receive a dispute notification from an acquirer",
                 "parameters": [
                     {
-                        "description": "Capture Amount",
-                        "name": "captureRequest",
+                        "description": "Acquirer notification",
+                        "name": "notification",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.requestParams"
+                            "$ref": "#/definitions/disputes.Notification"
                         }
                     },
                     {
@@ -108,15 +242,21 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.actionsResponse"
+                            "$ref": "#/definitions/disputes.Dispute"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/login": {
-            "post": {
-                "description": "Logins a user and provides an authentication token",
+        "/v1/admin/reconciliations": {
+            "get": {
+                "description": "List the reconciliations of imported acquirer settlement files, newest first, with their discrepancies. Requires an admin token.",
                 "consumes": [
                     "application/json"
                 ],
@@ -124,33 +264,40 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "status"
+                    "reconciliation"
                 ],
-                "summary": "Logins a user and provides an authentication token",
+                "summary": "List the reconciliations of acquirer settlement files",
                 "parameters": [
                     {
-                        "description": "User Credentials",
-                        "name": "Credentials",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/auth.loginRequest"
-                        }
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/auth.tokenResponse"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/reconciliation.Reconciliation"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/refund": {
-            "post": {
-                "description": "Refunds a previously captured amount from authorization",
+        "/v1/admin/reconciliations/{id}": {
+            "get": {
+                "description": "Get the discrepancy report of an imported acquirer settlement file. Requires an admin token.",
                 "consumes": [
                     "application/json"
                 ],
@@ -158,18 +305,16 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "status"
+                    "reconciliation"
                 ],
-                "summary": "Refunds a previously captured amount from authorization",
+                "summary": "Get the discrepancy report of a settlement file",
                 "parameters": [
                     {
-                        "description": "Refund Amount",
-                        "name": "refundRequest",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/handlers.requestParams"
-                        }
+                        "type": "string",
+                        "description": "Reconciliation id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
                     },
                     {
                         "type": "string",
@@ -183,15 +328,27 @@ var doc = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/handlers.actionsResponse"
+                            "$ref": "#/definitions/reconciliation.Reconciliation"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "404": {
+                        "description": "Reconciliation not found",
+                        "schema": {
+                            "type": "string"
                         }
                     }
                 }
             }
         },
-        "/status/ping": {
+        "/v1/admin/risk/lists": {
             "get": {
-                "description": "Get a server status update",
+                "description": "List the block and allow list entries added through the admin API, newest first. Requires an admin token.",
                 "consumes": [
                     "application/json"
                 ],
@@ -199,22 +356,44 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "status"
+                    "admin"
+                ],
+                "summary": "List the managed block and allow list entries",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "allow or block",
+                        "name": "list",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
                 ],
-                "summary": "Get a server status update",
                 "responses": {
                     "200": {
                         "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/risk.ListEntry"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
                             "type": "string"
                         }
                     }
                 }
-            }
-        },
-        "/void": {
+            },
             "post": {
-                "description": "Voids a transaction without charging the user",
+                "description": "Block or allow a card fingerprint, a BIN prefix, an email or an IP (address or CIDR range). It applies from the next authorization, without a restart. Requires an admin token.",
                 "consumes": [
                     "application/json"
                 ],
@@ -222,17 +401,17 @@ var doc = `{
                     "application/json"
                 ],
                 "tags": [
-                    "status"
+                    "admin"
                 ],
-                "summary": "Voids a transaction without charging the user",
+                "summary": "Add a block or allow list entry",
                 "parameters": [
                     {
-                        "description": "Refund Amount",
-                        "name": "voidRequest",
+                        "description": "List entry",
+                        "name": "entry",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/handlers.voidRequestParams"
+                            "$ref": "#/definitions/v1.ListEntryRequest"
                         }
                     },
                     {
@@ -244,69 +423,1442 @@ var doc = `{
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/risk.ListEntry"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
                         "schema": {
-                            "$ref": "#/definitions/handlers.actionsResponse"
+                            "type": "string"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "auth.loginRequest": {
-            "type": "object",
-            "properties": {
-                "password": {
-                    "type": "string"
-                },
-                "username": {
-                    "type": "string"
-                }
-            }
-        },
-        "auth.tokenResponse": {
-            "type": "object",
-            "properties": {
-                "access_token": {
-                    "type": "string",
-                    "example": "generated.jwt.token"
-                }
-            }
         },
-        "bank.CreditCard": {
-            "type": "object",
-            "properties": {
-                "cvv": {
-                    "type": "string"
-                },
-                "expiry": {
-                    "type": "string"
-                },
-                "number": {
-                    "type": "string"
+        "/v1/admin/risk/lists/{id}": {
+            "delete": {
+                "description": "Remove an entry added through the admin API, it stops applying from the next authorization. Requires an admin token.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Remove a block or allow list entry",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Entry id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {},
+                    "404": {
+                        "description": "Entry not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
                 }
             }
         },
-        "gateway.Authorization": {
-            "type": "object",
-            "properties": {
+        "/v1/authorizations": {
+            "get": {
+                "description": "Lists the authorizations of the authenticated merchant. Pass next_cursor back as cursor to fetch the next page.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Lists the merchant's authorizations",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "authorized, partially_captured, captured, partially_refunded, refunded, voided or declined",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Currency code",
+                        "name": "currency",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Minimum amount, inclusive",
+                        "name": "min_amount",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "Maximum amount, inclusive",
+                        "name": "max_amount",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 timestamp, inclusive",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "RFC3339 timestamp, inclusive",
+                        "name": "created_to",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last 4 digits of the card",
+                        "name": "card_last4",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "visa, mastercard, amex, discover or unknown",
+                        "name": "card_brand",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "allow, review or block",
+                        "name": "risk_decision",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Reference of the authorization or of one of its captures or refunds",
+                        "name": "reference",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Metadata value of a key, e.g. metadata.order_id=1234. Can be repeated for several keys",
+                        "name": "metadata.key",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "created_at (default) or amount",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "desc (default) or asc",
+                        "name": "order",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 20 by default, at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cursor of the next page",
+                        "name": "cursor",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.ListResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/authorize": {
+            "post": {
+                "description": "Creates a new authorization",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Creates a new authorization",
+                "parameters": [
+                    {
+                        "description": "Create authorization",
+                        "name": "authorization",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/gateway.Authorization"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.AuthResponse"
+                        }
+                    },
+                    "402": {
+                        "description": "Declined - the authorization is stored with status declined",
+                        "schema": {
+                            "$ref": "#/definitions/v1.AuthResponse"
+                        }
+                    },
+                    "502": {
+                        "description": "Acquirer unreachable",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "503": {
+                        "description": "Acquirer unavailable, failing fast",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "504": {
+                        "description": "No answer from acquirer, the authorization is reversed",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/balances": {
+            "get": {
+                "description": "Get the balances of the authenticated merchant by currency: pending (captured, not settled yet) and available (settled)",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "ledger"
+                ],
+                "summary": "Get the merchant's balances",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.BalancesResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/capture": {
+            "post": {
+                "description": "Captures amount from authorization",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Captures amount from authorization",
+                "parameters": [
+                    {
+                        "description": "Capture Amount",
+                        "name": "captureRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.RequestParams"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.ActionsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/disputes": {
+            "get": {
+                "description": "List the disputes of the authenticated merchant, newest first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "disputes"
+                ],
+                "summary": "List the merchant's disputes",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "needs_response, under_review, won or lost",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/disputes.Dispute"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/disputes/{id}": {
+            "get": {
+                "description": "Get a dispute of the authenticated merchant",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "disputes"
+                ],
+                "summary": "Get a dispute",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dispute id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/disputes.Dispute"
+                        }
+                    },
+                    "404": {
+                        "description": "Dispute not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/disputes/{id}/evidence": {
+            "post": {
+                "description": "Submit evidence before the dispute's evidence_due_by deadline, the dispute goes under review",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "disputes"
+                ],
+                "summary": "Submit evidence to challenge a dispute",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Dispute id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Evidence",
+                        "name": "evidence",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.EvidenceRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/disputes.Dispute"
+                        }
+                    },
+                    "404": {
+                        "description": "Dispute not found",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/events": {
+            "get": {
+                "description": "List the events sent to the authenticated merchant's webhook, newest first",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "events"
+                ],
+                "summary": "List the merchant's events",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Event type, e.g. dispute.opened",
+                        "name": "type",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/events.Event"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/login": {
+            "post": {
+                "description": "Logins a user and provides an authentication token",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Logins a user and provides an authentication token",
+                "parameters": [
+                    {
+                        "description": "User Credentials",
+                        "name": "Credentials",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/auth.loginRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/auth.tokenResponse"
+                        }
+                    },
+                    "401": {
+                        "description": "Wrong Username or Password",
+                        "schema": {
+                            "type": "string"
+                        }
+                    },
+                    "429": {
+                        "description": "Too many failed logins or requests, see Retry-After",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/refund": {
+            "post": {
+                "description": "Refunds a previously captured amount from authorization",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Refunds a previously captured amount from authorization",
+                "parameters": [
+                    {
+                        "description": "Refund Amount",
+                        "name": "refundRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.RequestParams"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.ActionsResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/settlements/report": {
+            "get": {
+                "description": "Get the settlement batches of the authenticated merchant for a settlement date, as JSON or CSV",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json",
+                    "text/csv"
+                ],
+                "tags": [
+                    "settlement"
+                ],
+                "summary": "Get the merchant's settlement report of a day",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Settlement date (YYYY-MM-DD), today by default",
+                        "name": "date",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "json (default) or csv",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/settlement.Report"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/void": {
+            "post": {
+                "description": "Voids a transaction without charging the user",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "status"
+                ],
+                "summary": "Voids a transaction without charging the user",
+                "parameters": [
+                    {
+                        "description": "Refund Amount",
+                        "name": "voidRequest",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/v1.VoidRequestParams"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "generated.jwt.token",
+                        "name": "Token",
+                        "in": "header",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/v1.ActionsResponse"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "audit.Record": {
+            "type": "object",
+            "properties": {
+                "actor": {
+                    "description": "Actor is the authenticated client, or the username a login was attempted with",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "hash": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "merchant": {
+                    "description": "Merchant whose payments the operation is about, empty for admin operations",
+                    "type": "string"
+                },
+                "operation": {
+                    "type": "string",
+                    "example": "refund"
+                },
+                "payload": {
+                    "description": "Payload is the request body with card numbers, CVVs, expiry dates and passwords masked",
+                    "type": "object"
+                },
+                "prev_hash": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "result": {
+                    "type": "string",
+                    "example": "success"
+                },
+                "role": {
+                    "type": "string"
+                },
+                "sequence": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "integer",
+                    "example": 200
+                },
+                "target": {
+                    "description": "Target is the id of the object operated on, e.g. the authorization",
+                    "type": "string"
+                }
+            }
+        },
+        "audit.Verification": {
+            "type": "object",
+            "properties": {
+                "broken_at": {
+                    "description": "BrokenAt is the sequence of the first record that doesn't match the chain",
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "records": {
+                    "type": "integer"
+                },
+                "valid": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "auth.loginRequest": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
+        },
+        "auth.tokenResponse": {
+            "type": "object",
+            "properties": {
+                "access_token": {
+                    "type": "string",
+                    "example": "generated.jwt.token"
+                }
+            }
+        },
+        "bank.BreakerStatus": {
+            "type": "object",
+            "properties": {
+                "consecutive_failures": {
+                    "type": "integer"
+                },
+                "failures": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "opened_at": {
+                    "type": "string"
+                },
+                "rejected": {
+                    "type": "integer"
+                },
+                "state": {
+                    "type": "string"
+                },
+                "successes": {
+                    "type": "integer"
+                },
+                "trips": {
+                    "type": "integer"
+                }
+            }
+        },
+        "bank.CreditCard": {
+            "type": "object",
+            "properties": {
+                "cvv": {
+                    "type": "string"
+                },
+                "expiry": {
+                    "type": "string"
+                },
+                "number": {
+                    "type": "string"
+                }
+            }
+        },
+        "disputes.Dispute": {
+            "type": "object",
+            "properties": {
+                "acquirer": {
+                    "type": "string"
+                },
+                "acquirer_dispute_id": {
+                    "description": "AcquirerDisputeId is the acquirer's own id of the dispute, when it sent one",
+                    "type": "string"
+                },
+                "acquirer_reference": {
+                    "description": "AcquirerReference is the acquirer reference of the disputed capture",
+                    "type": "string"
+                },
+                "amount": {
+                    "type": "number",
+                    "example": 100
+                },
+                "authorization_id": {
+                    "type": "string"
+                },
+                "capture_reference": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "EUR"
+                },
+                "evidence": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/disputes.Evidence"
+                    }
+                },
+                "evidence_due_by": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "merchant": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Fraud - card absent environment"
+                },
+                "reason_code": {
+                    "type": "string",
+                    "example": "10.4"
+                },
+                "reference": {
+                    "description": "Reference and CaptureReference are the merchant's references of the authorization and of the disputed capture",
+                    "type": "string"
+                },
+                "settlement_amount": {
+                    "type": "number",
+                    "example": 100
+                },
+                "settlement_currency": {
+                    "type": "string",
+                    "example": "EUR"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "needs_response"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "disputes.Evidence": {
+            "type": "object",
+            "properties": {
+                "description": {
+                    "type": "string",
+                    "example": "Signed for by the cardholder on 2020-07-02"
+                },
+                "submitted_at": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "proof_of_delivery"
+                }
+            }
+        },
+        "disputes.Notification": {
+            "type": "object",
+            "properties": {
+                "acquirer": {
+                    "description": "Acquirer sending the notification, it only applies to its own captures and disputes",
+                    "type": "string",
+                    "example": "simulator"
+                },
+                "acquirer_dispute_id": {
+                    "description": "AcquirerDisputeId tells apart the disputes of a capture for opened notifications, redeliveries carry the same one.\nWithout it a capture is disputed once.",
+                    "type": "string",
+                    "example": "cb_20200702_0001"
+                },
+                "acquirer_reference": {
+                    "description": "AcquirerReference of the disputed capture, for opened notifications",
+                    "type": "string",
+                    "example": "sim_5f0c3a1e9b2d4c68"
+                },
+                "amount": {
+                    "type": "number",
+                    "example": 100
+                },
+                "dispute_id": {
+                    "description": "DisputeId of the decided dispute, for won and lost notifications",
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "Fraud - card absent environment"
+                },
+                "reason_code": {
+                    "type": "string",
+                    "example": "10.4"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "opened"
+                }
+            }
+        },
+        "events.Event": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "data": {
+                    "type": "object"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "merchant": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "dispute.opened"
+                }
+            }
+        },
+        "fx.LockedRate": {
+            "type": "object",
+            "properties": {
+                "from": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "locked_at": {
+                    "type": "string"
+                },
+                "markup": {
+                    "description": "Markup is the percentage taken off the mid-market rate",
+                    "type": "number",
+                    "example": 1.5
+                },
+                "mid_rate": {
+                    "type": "number",
+                    "example": 0.909091
+                },
+                "rate": {
+                    "type": "number",
+                    "example": 0.895455
+                },
+                "to": {
+                    "type": "string",
+                    "example": "EUR"
+                }
+            }
+        },
+        "gateway.Authorization": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number",
+                    "example": 100
+                },
+                "credit_card": {
+                    "type": "object",
+                    "$ref": "#/definitions/bank.CreditCard"
+                },
+                "currency": {
+                    "type": "string",
+                    "example": "EUR"
+                },
+                "customer": {
+                    "type": "object",
+                    "$ref": "#/definitions/gateway.Customer"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "2 x T-Shirt"
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "order-1234"
+                }
+            }
+        },
+        "gateway.AuthorizationSummary": {
+            "type": "object",
+            "properties": {
+                "acquirer": {
+                    "type": "string"
+                },
                 "amount": {
+                    "type": "number"
+                },
+                "approval_code": {
+                    "type": "string"
+                },
+                "captured_amount": {
+                    "type": "number"
+                },
+                "card_brand": {
+                    "type": "string"
+                },
+                "card_fingerprint": {
+                    "description": "CardFingerprint identifies the card in block and allow lists",
+                    "type": "string"
+                },
+                "card_last4": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "decline_code": {
+                    "type": "string"
+                },
+                "description": {
+                    "type": "string"
+                },
+                "fx": {
+                    "type": "object",
+                    "$ref": "#/definitions/fx.LockedRate"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "merchant": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "reference": {
+                    "type": "string"
+                },
+                "refunded_amount": {
+                    "type": "number"
+                },
+                "risk_decision": {
+                    "type": "string"
+                },
+                "risk_score": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "gateway.Customer": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "example": "shopper@example.com"
+                },
+                "ip": {
+                    "description": "IP is the shopper's IP address, not the merchant server's",
+                    "type": "string",
+                    "example": "81.2.69.160"
+                }
+            }
+        },
+        "handlers.liveResponse": {
+            "type": "object",
+            "properties": {
+                "status": {
+                    "type": "string",
+                    "example": "up"
+                }
+            }
+        },
+        "health.Component": {
+            "type": "object",
+            "properties": {
+                "details": {
+                    "type": "object"
+                },
+                "latency_ms": {
                     "type": "number",
-                    "example": 100
+                    "example": 0.12
                 },
-                "credit_card": {
-                    "type": "object",
-                    "$ref": "#/definitions/bank.CreditCard"
+                "message": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string",
+                    "example": "storage"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "up"
+                }
+            }
+        },
+        "health.Report": {
+            "type": "object",
+            "properties": {
+                "components": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/health.Component"
+                    }
+                },
+                "shutting_down": {
+                    "type": "boolean",
+                    "example": false
+                },
+                "status": {
+                    "type": "string",
+                    "example": "up"
+                }
+            }
+        },
+        "ledger.MerchantBalance": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "number",
+                    "example": 1000
                 },
                 "currency": {
                     "type": "string",
                     "example": "EUR"
+                },
+                "pending": {
+                    "type": "number",
+                    "example": 120.5
+                }
+            }
+        },
+        "reconciliation.Discrepancy": {
+            "type": "object",
+            "properties": {
+                "acquirer_amount": {
+                    "type": "number"
+                },
+                "acquirer_currency": {
+                    "type": "string"
+                },
+                "acquirer_reference": {
+                    "type": "string"
+                },
+                "authorization_id": {
+                    "type": "string"
+                },
+                "gateway_amount": {
+                    "type": "number"
+                },
+                "gateway_currency": {
+                    "type": "string"
+                },
+                "line": {
+                    "description": "Line of the settlement file, 0 when missing at the acquirer",
+                    "type": "integer"
+                },
+                "transaction_type": {
+                    "type": "string",
+                    "example": "capture"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "amount_mismatch"
+                }
+            }
+        },
+        "reconciliation.Reconciliation": {
+            "type": "object",
+            "properties": {
+                "acquirer": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "discrepancies": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/reconciliation.Discrepancy"
+                    }
+                },
+                "file": {
+                    "type": "string"
+                },
+                "from": {
+                    "type": "string",
+                    "example": "2020-07-01"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "lines": {
+                    "type": "integer"
+                },
+                "matched": {
+                    "type": "integer"
+                },
+                "to": {
+                    "type": "string",
+                    "example": "2020-07-01"
+                }
+            }
+        },
+        "risk.Assessment": {
+            "type": "object",
+            "properties": {
+                "decision": {
+                    "type": "string",
+                    "example": "allow"
+                },
+                "reasons": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/risk.Reason"
+                    }
+                },
+                "score": {
+                    "type": "integer",
+                    "example": 0
+                }
+            }
+        },
+        "risk.ListEntry": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "created_by": {
+                    "description": "CreatedBy is the admin who added the entry",
+                    "type": "string",
+                    "example": "Admin"
+                },
+                "id": {
+                    "type": "string",
+                    "example": "rle_5f0c3a1e9b2d4c68"
+                },
+                "list": {
+                    "type": "string",
+                    "example": "block"
+                },
+                "note": {
+                    "description": "Note says why the entry was listed",
+                    "type": "string",
+                    "example": "Chargeback fraud"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "card"
+                },
+                "value": {
+                    "type": "string",
+                    "example": "3f2a9c0d4b1e8f7a6c5d4e3f2a1b0c9d"
+                }
+            }
+        },
+        "risk.Reason": {
+            "type": "object",
+            "properties": {
+                "message": {
+                    "type": "string",
+                    "example": "6 attempts with this card in the last 3600s"
+                },
+                "rule": {
+                    "type": "string",
+                    "example": "velocity_card"
+                },
+                "score": {
+                    "type": "integer",
+                    "example": 60
+                }
+            }
+        },
+        "settlement.Batch": {
+            "type": "object",
+            "properties": {
+                "capture_count": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "currency": {
+                    "type": "string"
+                },
+                "cutoff": {
+                    "type": "string"
+                },
+                "date": {
+                    "type": "string",
+                    "example": "2020-07-01"
+                },
+                "entries": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/settlement.Entry"
+                    }
+                },
+                "fees": {
+                    "type": "number"
+                },
+                "gross": {
+                    "type": "number"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "merchant": {
+                    "type": "string"
+                },
+                "net": {
+                    "type": "number"
+                },
+                "refund_count": {
+                    "type": "integer"
+                },
+                "refunds": {
+                    "type": "number"
+                }
+            }
+        },
+        "settlement.Entry": {
+            "type": "object",
+            "properties": {
+                "acquirer_reference": {
+                    "type": "string"
+                },
+                "amount": {
+                    "type": "number"
+                },
+                "authorization_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "fee": {
+                    "type": "number"
+                },
+                "presentment_amount": {
+                    "description": "PresentmentAmount is the amount the shopper paid, in the currency they paid in",
+                    "type": "number"
+                },
+                "presentment_currency": {
+                    "type": "string",
+                    "example": "USD"
+                },
+                "reference": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "capture"
+                }
+            }
+        },
+        "settlement.Report": {
+            "type": "object",
+            "properties": {
+                "batches": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/settlement.Batch"
+                    }
+                },
+                "date": {
+                    "type": "string",
+                    "example": "2020-07-01"
+                },
+                "fees": {
+                    "type": "number"
+                },
+                "gross": {
+                    "type": "number"
+                },
+                "merchant": {
+                    "type": "string"
+                },
+                "net": {
+                    "type": "number"
+                },
+                "refunds": {
+                    "type": "number"
                 }
             }
         },
-        "handlers.actionsResponse": {
+        "v1.ActionsResponse": {
             "type": "object",
             "properties": {
                 "amount": {
@@ -316,40 +1868,209 @@ var doc = `{
                 "currency": {
                     "type": "string",
                     "example": "EUR"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "2 x T-Shirt"
+                },
+                "fee": {
+                    "description": "Fee charged to the merchant and Net, what the operation adds to (or, for refunds, takes from) the merchant's balance, in the settlement currency",
+                    "type": "number",
+                    "example": 1.65
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "net": {
+                    "type": "number",
+                    "example": 98.35
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "order-1234"
+                },
+                "settlement_amount": {
+                    "type": "number",
+                    "example": 100
+                },
+                "settlement_currency": {
+                    "type": "string",
+                    "example": "EUR"
+                }
+            }
+        },
+        "v1.AuditResponse": {
+            "type": "object",
+            "properties": {
+                "records": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/audit.Record"
+                    }
+                },
+                "verification": {
+                    "description": "Verification covers the whole chain, whatever the filters",
+                    "type": "object",
+                    "$ref": "#/definitions/audit.Verification"
                 }
             }
         },
-        "handlers.authResponse": {
+        "v1.AuthResponse": {
             "type": "object",
             "properties": {
+                "acquirer_reference": {
+                    "type": "string",
+                    "example": "sim_5f0c3a1e9b2d4c68"
+                },
                 "amount": {
                     "type": "number",
                     "example": 100
                 },
+                "approval_code": {
+                    "type": "string",
+                    "example": "123456"
+                },
                 "currency": {
                     "type": "string",
                     "example": "EUR"
                 },
+                "decline_code": {
+                    "type": "string",
+                    "example": "05"
+                },
+                "decline_reason": {
+                    "type": "string",
+                    "example": "Do not honour"
+                },
+                "description": {
+                    "type": "string",
+                    "example": "2 x T-Shirt"
+                },
+                "fx": {
+                    "description": "FX is the exchange rate locked for captures and refunds when the merchant settles in another currency",
+                    "type": "object",
+                    "$ref": "#/definitions/fx.LockedRate"
+                },
                 "id": {
                     "type": "string",
                     "example": "unique_authorization_id"
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "order-1234"
+                },
+                "risk": {
+                    "description": "Risk is the fraud screening assessment, blocked authorizations are declined with decline_code risk_blocked",
+                    "type": "object",
+                    "$ref": "#/definitions/risk.Assessment"
+                },
+                "status": {
+                    "type": "string",
+                    "example": "authorized"
+                }
+            }
+        },
+        "v1.BalancesResponse": {
+            "type": "object",
+            "properties": {
+                "balances": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/ledger.MerchantBalance"
+                    }
+                },
+                "merchant": {
+                    "type": "string",
+                    "example": "Checkout"
+                }
+            }
+        },
+        "v1.EvidenceRequest": {
+            "type": "object",
+            "properties": {
+                "evidence": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/disputes.Evidence"
+                    }
+                }
+            }
+        },
+        "v1.ListEntryRequest": {
+            "type": "object",
+            "properties": {
+                "list": {
+                    "description": "List is allow or block",
+                    "type": "string",
+                    "example": "block"
+                },
+                "note": {
+                    "description": "Note says why the entry was listed",
+                    "type": "string",
+                    "example": "Chargeback fraud"
+                },
+                "type": {
+                    "type": "string",
+                    "example": "card"
+                },
+                "value": {
+                    "type": "string",
+                    "example": "3f2a9c0d4b1e8f7a6c5d4e3f2a1b0c9d"
+                }
+            }
+        },
+        "v1.ListResponse": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/gateway.AuthorizationSummary"
+                    }
+                },
+                "next_cursor": {
+                    "type": "string",
+                    "example": "eyJ2IjoxLCJpZCI6ImFiYyJ9"
                 }
             }
         },
-        "handlers.requestParams": {
+        "v1.RequestParams": {
             "type": "object",
             "properties": {
                 "amount": {
                     "type": "number",
                     "example": 100
                 },
+                "description": {
+                    "type": "string",
+                    "example": "2 x T-Shirt"
+                },
                 "id": {
                     "type": "string",
                     "example": "unique_authorization_id"
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "reference": {
+                    "type": "string",
+                    "example": "order-1234"
                 }
             }
         },
-        "handlers.voidRequestParams": {
+        "v1.VoidRequestParams": {
             "type": "object",
             "properties": {
                 "id": {
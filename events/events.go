@@ -0,0 +1,146 @@
+package events
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/db"
+)
+
+const eventKind = "event"
+
+// Event is something that happened to a merchant's payments. Data is a snapshot of the
+// object it happened to, taken when the event was published.
+type Event struct {
+	Id string								`json:"id"`
+	Type string							`json:"type" example:"dispute.opened"`
+	Merchant string					`json:"merchant"`
+	CreatedAt time.Time			`json:"created_at"`
+	Data json.RawMessage		`json:"data" swaggertype:"object"`
+}
+
+// Deliver sends published events to the merchants' webhooks, replaced in tests
+var Deliver = func(event *Event) {
+	deliveries.Add(1)
+	atomic.AddInt64(&pendingDeliveries, 1)
+
+	go func() {
+		defer deliveries.Done()
+		defer atomic.AddInt64(&pendingDeliveries, -1)
+
+		Webhooks.Send(event)
+	}()
+}
+
+// deliveries tracks the webhook deliveries still running, so shutdown can wait for them
+var deliveries sync.WaitGroup
+var pendingDeliveries int64
+
+// Flush waits for the webhook deliveries in progress, retries included, until ctx is done.
+// Events left undelivered are still stored and listed by the events endpoint.
+func Flush(ctx context.Context) error {
+	if atomic.LoadInt64(&pendingDeliveries) == 0 {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		deliveries.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		log.WithField("pending", atomic.LoadInt64(&pendingDeliveries)).Error("Events.Flush - Gave up waiting for webhook deliveries")
+		return ctx.Err()
+	}
+}
+
+var sequence uint64
+
+// Publish stores an event for merchant and hands it over for delivery
+func Publish(merchant string, eventType string, data interface{}) *Event {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"type": eventType,
+			"err": err,
+		}).Error("Events.Publish - Error marshaling event data")
+		return nil
+	}
+
+	createdAt := time.Now().UTC()
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%d", merchant, eventType, createdAt.UnixNano(), atomic.AddUint64(&sequence, 1))))
+
+	event := &Event{
+		Id: fmt.Sprintf("evt_%x", sum[:8]),
+		Type: eventType,
+		Merchant: merchant,
+		CreatedAt: createdAt,
+		Data: payload,
+	}
+
+	db.DB.StoreItem(event.Id, event)
+
+	log.WithFields(log.Fields{
+		"id": event.Id,
+		"type": eventType,
+		"merchant": merchant,
+	}).Info("Events.Publish - Event published")
+
+	Deliver(event)
+
+	return event
+}
+
+// List returns the events of a merchant, newest first, only those of eventType when set
+func List(merchant string, eventType string) ([]*Event, error) {
+	equals := map[string]string{
+		"kind": eventKind,
+		"merchant": merchant,
+	}
+	if eventType != "" {
+		equals["type"] = eventType
+	}
+
+	items, _, err := db.DB.QueryItems(db.Query{
+		Equals: equals,
+		SortBy: "created_at",
+		Descending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Event{}
+	for _, iterItem := range items {
+		if event, ok := iterItem.(*Event); ok {
+			result = append(result, event)
+		}
+	}
+
+	return result, nil
+}
+
+func (event *Event) IndexValues() map[string][]string {
+	return map[string][]string{
+		"kind": {eventKind},
+		"merchant": {event.Merchant},
+		"type": {event.Type},
+	}
+}
+
+func (event *Event) SortValues() map[string]float64 {
+	return map[string]float64{
+		"created_at": float64(event.CreatedAt.UnixNano() / int64(time.Microsecond)),
+	}
+}
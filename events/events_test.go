@@ -0,0 +1,126 @@
+package events
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/merchants"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+}
+
+func TestPublish(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+
+	defaultDeliver := Deliver
+	defer func() {
+		Deliver = defaultDeliver
+	}()
+
+	var delivered []*Event
+	Deliver = func(event *Event) {
+		delivered = append(delivered, event)
+	}
+
+	first := Publish("Checkout", "dispute.opened", map[string]string{"id": "dsp_1"})
+	second := Publish("Checkout", "dispute.won", map[string]string{"id": "dsp_1"})
+	Publish("Other", "dispute.opened", map[string]string{"id": "dsp_2"})
+
+	assert.JSONEq(`{"id":"dsp_1"}`, string(first.Data), "Data snapshot")
+	assert.Len(delivered, 3, "Every event is delivered")
+
+	events, err := List("Checkout", "")
+	assert.NoError(err)
+	assert.Equal([]*Event{second, first}, events, "Merchant's events, newest first")
+
+	events, err = List("Checkout", "dispute.opened")
+	assert.NoError(err)
+	assert.Equal([]*Event{first}, events, "Filtered by type")
+}
+
+func TestWebhookSend(t *testing.T) {
+	assert := assert.New(t)
+
+	event := &Event{Id: "evt_1", Type: "dispute.opened", Merchant: "Checkout", Data: []byte(`{"id":"dsp_1"}`)}
+
+	attempts := 0
+	var signature, body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		signature = r.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	defer func() {
+		merchants.Merchants = &merchants.Registry{}
+	}()
+	merchants.Merchants = &merchants.Registry{
+		Merchants: []merchants.Merchant{{Id: "Checkout", WebhookURL: server.URL, WebhookSecret: "secret"}},
+	}
+
+	sender := &WebhookSender{Client: server.Client(), MaxAttempts: 3, BaseDelay: time.Millisecond}
+
+	assert.NoError(sender.Send(event), "Delivered on the last attempt")
+	assert.Equal(3, attempts, "Failed deliveries are retried")
+	assert.Equal(Sign("secret", []byte(body)), signature, "Body is signed with the merchant's secret")
+	assert.Contains(body, `"type":"dispute.opened"`)
+
+	attempts = -10
+	assert.Error(sender.Send(event), "Gives up after MaxAttempts")
+	assert.Equal(-7, attempts)
+
+	event.Merchant = "Other"
+	assert.NoError(sender.Send(event), "No webhook URL - Skipped")
+	assert.Equal(-7, attempts)
+}
+
+func TestFlush(t *testing.T) {
+	assert := assert.New(t)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	defaultWebhooks := Webhooks
+	defer func() {
+		Webhooks = defaultWebhooks
+		merchants.Merchants = &merchants.Registry{}
+	}()
+	Webhooks = &WebhookSender{Client: server.Client(), MaxAttempts: 1}
+	merchants.Merchants = &merchants.Registry{
+		Merchants: []merchants.Merchant{{Id: "Checkout", WebhookURL: server.URL, WebhookSecret: "secret"}},
+	}
+
+	assert.NoError(Flush(context.Background()), "Nothing pending")
+
+	Deliver(&Event{Id: "evt_1", Type: "dispute.opened", Merchant: "Checkout"})
+
+	expired, cancel := context.WithTimeout(context.Background(), 10 * time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, Flush(expired), "Delivery in progress")
+
+	close(release)
+	assert.NoError(Flush(context.Background()), "Delivered")
+}
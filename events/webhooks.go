@@ -0,0 +1,107 @@
+package events
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/merchants"
+)
+
+// SignatureHeader carries the hex HMAC-SHA256 of the body, keyed with the merchant's webhook secret
+const SignatureHeader = "Webhook-Signature"
+
+// WebhookSender posts events to the webhook URL of their merchant, retrying failed deliveries
+type WebhookSender struct {
+	Client *http.Client
+	MaxAttempts int
+	// BaseDelay doubles after every failed attempt
+	BaseDelay time.Duration
+}
+
+var Webhooks = &WebhookSender{
+	Client: &http.Client{Timeout: 5 * time.Second},
+	MaxAttempts: 5,
+	BaseDelay: time.Second,
+}
+
+// Send delivers an event, merchants without a webhook URL are skipped
+func (ws *WebhookSender) Send(event *Event) error {
+	merchant := merchants.Merchants.Get(event.Merchant)
+	if merchant.WebhookURL == "" {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	delay := ws.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err = ws.post(merchant, event, body)
+		if err == nil {
+			log.WithFields(log.Fields{
+				"id": event.Id,
+				"attempt": attempt,
+			}).Debug("WebhookSender.Send - Event delivered")
+			return nil
+		}
+
+		if attempt >= ws.MaxAttempts {
+			log.WithFields(log.Fields{
+				"id": event.Id,
+				"merchant": event.Merchant,
+				"err": err,
+			}).Error("WebhookSender.Send - Giving up delivering event")
+			return err
+		}
+
+		log.WithFields(log.Fields{
+			"id": event.Id,
+			"attempt": attempt,
+			"err": err,
+		}).Warn("WebhookSender.Send - Delivery failed, retrying")
+
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (ws *WebhookSender) post(merchant merchants.Merchant, event *Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, merchant.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Webhook-Id", event.Id)
+	req.Header.Set(SignatureHeader, Sign(merchant.WebhookSecret, body))
+
+	resp, err := ws.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Webhook failure - endpoint answered %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign is the signature of a webhook body, for merchants to check it came from us
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
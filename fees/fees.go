@@ -0,0 +1,207 @@
+package fees
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Refund policies
+const (
+	// RefundKeep keeps the whole capture fee when the capture is refunded
+	RefundKeep = "keep"
+	// RefundProportional gives back the percentage part of the capture fee on the refunded amount
+	RefundProportional = "proportional"
+)
+
+// Rate is a percentage of the amount plus a fixed fee, both in the transaction's currency
+type Rate struct {
+	Percentage float64	`json:"percentage" yaml:"percentage"`
+	Fixed float64				`json:"fixed" yaml:"fixed"`
+}
+
+// Plan prices the captures and refunds of the merchants on it
+type Plan struct {
+	Name string							`json:"name" yaml:"name"`
+	// Rate applies to captures with cards of brands missing from Brands
+	Rate Rate								`json:"rate" yaml:"rate"`
+	Brands map[string]Rate	`json:"brands" yaml:"brands"`
+	// CrossBorder is added on top when the card was issued outside the merchant's country
+	CrossBorder Rate				`json:"cross_border" yaml:"cross_border"`
+
+	RefundPolicy string			`json:"refund_policy" yaml:"refund_policy"`
+	// RefundFee applies to every refund, whatever the policy
+	RefundFee Rate					`json:"refund_fee" yaml:"refund_fee"`
+}
+
+type Config struct {
+	// Default is the name of the plan of merchants without one, no fees when empty
+	Default string	`json:"default" yaml:"default"`
+	Plans []Plan		`json:"plans" yaml:"plans"`
+}
+
+// Pricing holds the plans fees are computed with, no fees unless loaded
+var Pricing = &Config{}
+
+// Transaction holds what fees depend on
+type Transaction struct {
+	Plan string
+	Brand string
+	Amount float64
+	// Countries are ISO 3166-1 alpha-2 codes, an unknown country counts as domestic
+	MerchantCountry string
+	CardCountry string
+}
+
+// LoadPricing reads pricing plans from a YAML (.yaml/.yml) or JSON file, unknown keys are errors
+func LoadPricing(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading pricing file - %s", err.Error())
+	}
+
+	config := &Config{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, config)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing pricing file - %s", err.Error())
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) Validate() error {
+	known := make(map[string]bool)
+
+	for i := range c.Plans {
+		plan := &c.Plans[i]
+
+		if plan.Name == "" {
+			return fmt.Errorf("Invalid pricing - plan without a name")
+		}
+
+		if known[plan.Name] {
+			return fmt.Errorf("Invalid pricing - plan %q declared twice", plan.Name)
+		}
+		known[plan.Name] = true
+
+		if err := plan.validate(); err != nil {
+			return fmt.Errorf("Invalid pricing - %s: %s", plan.Name, err.Error())
+		}
+	}
+
+	if c.Default != "" && !known[c.Default] {
+		return fmt.Errorf("Invalid pricing - unknown default plan %q", c.Default)
+	}
+
+	return nil
+}
+
+func (p *Plan) validate() error {
+	switch p.RefundPolicy {
+	case "":
+		p.RefundPolicy = RefundKeep
+	case RefundKeep, RefundProportional:
+	default:
+		return fmt.Errorf("refund_policy must be %s or %s", RefundKeep, RefundProportional)
+	}
+
+	rates := []Rate{p.Rate, p.CrossBorder, p.RefundFee}
+
+	// brands are matched the way cards report them
+	brands := make(map[string]Rate, len(p.Brands))
+	for brand, rate := range p.Brands {
+		brands[strings.ToLower(brand)] = rate
+		rates = append(rates, rate)
+	}
+	p.Brands = brands
+
+	for _, iterRate := range rates {
+		if iterRate.Percentage < 0 || iterRate.Percentage > 100 || iterRate.Fixed < 0 {
+			return fmt.Errorf("rates need a percentage between 0 and 100 and a positive fixed fee")
+		}
+	}
+
+	return nil
+}
+
+// Plan returns the named plan, the default plan when there is no such plan
+func (c *Config) Plan(name string) Plan {
+	for _, iterPlan := range c.Plans {
+		if iterPlan.Name == name {
+			return iterPlan
+		}
+	}
+
+	for _, iterPlan := range c.Plans {
+		if iterPlan.Name == c.Default {
+			return iterPlan
+		}
+	}
+
+	return Plan{RefundPolicy: RefundKeep}
+}
+
+// CaptureFee is the fee charged to the merchant for a capture
+func CaptureFee(tx Transaction) float64 {
+	plan := Pricing.Plan(tx.Plan)
+	rate := plan.captureRate(tx)
+
+	return round(tx.Amount * rate.Percentage / 100 + rate.Fixed)
+}
+
+// RefundFee is the fee charged to the merchant for a refund. It is negative when the plan
+// gives back more of the capture fee than it charges for the refund.
+func RefundFee(tx Transaction) float64 {
+	plan := Pricing.Plan(tx.Plan)
+
+	fee := tx.Amount * plan.RefundFee.Percentage / 100 + plan.RefundFee.Fixed
+	if plan.RefundPolicy == RefundProportional {
+		fee -= tx.Amount * plan.captureRate(tx).Percentage / 100
+	}
+
+	return round(fee)
+}
+
+func (p Plan) captureRate(tx Transaction) Rate {
+	rate, ok := p.Brands[strings.ToLower(tx.Brand)]
+	if !ok {
+		rate = p.Rate
+	}
+
+	if crossBorder(tx) {
+		rate.Percentage += p.CrossBorder.Percentage
+		rate.Fixed += p.CrossBorder.Fixed
+	}
+
+	return rate
+}
+
+func crossBorder(tx Transaction) bool {
+	if tx.MerchantCountry == "" || tx.CardCountry == "" {
+		return false
+	}
+
+	return !strings.EqualFold(tx.MerchantCountry, tx.CardCountry)
+}
+
+// fees are charged in cents
+func round(amount float64) float64 {
+	return math.Round(amount * 100) / 100
+}
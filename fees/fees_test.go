@@ -0,0 +1,161 @@
+package fees
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPricing() *Config {
+	return &Config{
+		Default: "standard",
+		Plans: []Plan{
+			{
+				Name: "standard",
+				Rate: Rate{Percentage: 2.9, Fixed: 0.30},
+				Brands: map[string]Rate{"amex": {Percentage: 3.5, Fixed: 0.30}},
+				CrossBorder: Rate{Percentage: 1},
+				RefundPolicy: RefundKeep,
+				RefundFee: Rate{Fixed: 0.25},
+			},
+			{
+				Name: "enterprise",
+				Rate: Rate{Percentage: 1.4, Fixed: 0.20},
+				CrossBorder: Rate{Percentage: 0.5},
+				RefundPolicy: RefundProportional,
+			},
+		},
+	}
+}
+
+func TestFees(t *testing.T) {
+	assert := assert.New(t)
+
+	defaultPricing := Pricing
+	defer func() {
+		Pricing = defaultPricing
+	}()
+	Pricing = testPricing()
+
+	tests := []struct{
+		tx Transaction
+		captureFee float64
+		refundFee float64
+		description string
+	}{
+		{
+			Transaction{Plan: "standard", Brand: "visa", Amount: 100, MerchantCountry: "GB", CardCountry: "GB"},
+			3.20,
+			0.25,
+			"Standard - Domestic, refund fee kept",
+		},
+		{
+			Transaction{Plan: "standard", Brand: "amex", Amount: 100, MerchantCountry: "GB", CardCountry: "GB"},
+			3.80,
+			0.25,
+			"Standard - Brand rate",
+		},
+		{
+			Transaction{Plan: "standard", Brand: "visa", Amount: 100, MerchantCountry: "GB", CardCountry: "us"},
+			4.20,
+			0.25,
+			"Standard - Cross-border surcharge",
+		},
+		{
+			Transaction{Plan: "standard", Brand: "visa", Amount: 100, MerchantCountry: "GB"},
+			3.20,
+			0.25,
+			"Standard - Unknown card country is domestic",
+		},
+		{
+			Transaction{Plan: "unknown", Brand: "visa", Amount: 10.55, MerchantCountry: "GB", CardCountry: "GB"},
+			0.61,
+			0.25,
+			"Unknown plan - Default plan, rounded to cents",
+		},
+		{
+			Transaction{Plan: "enterprise", Brand: "visa", Amount: 50, MerchantCountry: "GB", CardCountry: "FR"},
+			1.15,
+			-0.95,
+			"Enterprise - Percentage given back on refunds",
+		},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.captureFee, CaptureFee(iterTest.tx), iterTest.description)
+		assert.Equal(iterTest.refundFee, RefundFee(iterTest.tx), iterTest.description)
+	}
+
+	Pricing = &Config{}
+	assert.Equal(0.0, CaptureFee(tests[0].tx), "No pricing - No fees")
+	assert.Equal(0.0, RefundFee(tests[0].tx), "No pricing - No fees")
+}
+
+func TestLoadPricing(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "pricing")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct{
+		file string
+		content string
+		err error
+		description string
+	}{
+		{
+			"pricing.yaml",
+			"default: standard\nplans:\n  - name: standard\n    rate: {percentage: 2.9, fixed: 0.30}\n    brands:\n      AMEX: {percentage: 3.5}\n",
+			nil,
+			"OK - YAML",
+		},
+		{
+			"pricing.json",
+			`{"plans":[{"name":"standard","refund_policy":"proportional"}]}`,
+			nil,
+			"OK - JSON",
+		},
+		{
+			"typo.json",
+			`{"plans":[{"name":"standard","refund_polcy":"proportional"}]}`,
+			errors.New(`Error parsing pricing file - json: unknown field "refund_polcy"`),
+			"Error - Unknown JSON key",
+		},
+		{
+			"policy.yaml",
+			"plans:\n  - name: standard\n    refund_policy: refund_all\n",
+			errors.New("Invalid pricing - standard: refund_policy must be keep or proportional"),
+			"Error - Unknown refund policy",
+		},
+		{
+			"negative.yaml",
+			"plans:\n  - name: standard\n    rate: {percentage: -1}\n",
+			errors.New("Invalid pricing - standard: rates need a percentage between 0 and 100 and a positive fixed fee"),
+			"Error - Negative rate",
+		},
+		{
+			"default.yaml",
+			"default: premium\nplans:\n  - name: standard\n",
+			errors.New(`Invalid pricing - unknown default plan "premium"`),
+			"Error - Unknown default plan",
+		},
+	}
+
+	for _, iterTest := range tests {
+		path := filepath.Join(dir, iterTest.file)
+		assert.NoError(ioutil.WriteFile(path, []byte(iterTest.content), 0644))
+
+		_, err := LoadPricing(path)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+
+	config, err := LoadPricing(filepath.Join(dir, "pricing.yaml"))
+	assert.NoError(err)
+	assert.Equal(RefundKeep, config.Plan("standard").RefundPolicy, "Refund policy defaults to keep")
+	assert.Equal(Rate{Percentage: 3.5}, config.Plan("standard").Brands["amex"], "Brands are lowercased")
+}
@@ -0,0 +1,147 @@
+package fx
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// RateProviderI gives mid-market exchange rates, implemented by a static table for now
+type RateProviderI interface {
+	// Rate is the amount of to one unit of from buys
+	Rate(string, string) (float64, error)
+}
+
+// Provider answers every rate lookup, only same currency conversions unless rates are loaded
+var Provider RateProviderI = &StaticRates{}
+
+// StaticRates is a fixed rate table against a base currency, e.g. base EUR and USD 1.10
+type StaticRates struct {
+	Base string								`json:"base" yaml:"base"`
+	Rates map[string]float64	`json:"rates" yaml:"rates"`
+}
+
+// LockedRate is the rate locked for an authorization, all its captures and refunds are converted with it
+type LockedRate struct {
+	From string					`json:"from" example:"USD"`
+	To string						`json:"to" example:"EUR"`
+	MidRate float64			`json:"mid_rate" example:"0.909091"`
+	// Markup is the percentage taken off the mid-market rate
+	Markup float64			`json:"markup" example:"1.5"`
+	Rate float64				`json:"rate" example:"0.895455"`
+	LockedAt time.Time	`json:"locked_at"`
+}
+
+// LoadStaticRates reads a rate table from a YAML (.yaml/.yml) or JSON file, unknown keys are errors
+func LoadStaticRates(path string) (*StaticRates, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading FX rates file - %s", err.Error())
+	}
+
+	rates := &StaticRates{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, rates)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(rates)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing FX rates file - %s", err.Error())
+	}
+
+	if err := rates.Validate(); err != nil {
+		return nil, err
+	}
+
+	return rates, nil
+}
+
+func (sr *StaticRates) Validate() error {
+	if len(sr.Base) != 3 {
+		return fmt.Errorf("Invalid FX rates - base must be a 3 letter currency code")
+	}
+	sr.Base = strings.ToUpper(sr.Base)
+
+	rates := make(map[string]float64, len(sr.Rates))
+	for currency, rate := range sr.Rates {
+		if len(currency) != 3 {
+			return fmt.Errorf("Invalid FX rates - %q is not a 3 letter currency code", currency)
+		}
+
+		if rate <= 0 {
+			return fmt.Errorf("Invalid FX rates - %s rate must be positive", currency)
+		}
+
+		rates[strings.ToUpper(currency)] = rate
+	}
+	sr.Rates = rates
+
+	return nil
+}
+
+func (sr *StaticRates) Rate(from string, to string) (float64, error) {
+	from = strings.ToUpper(from)
+	to = strings.ToUpper(to)
+
+	if from == to {
+		return 1, nil
+	}
+
+	fromRate, ok := sr.baseRate(from)
+	if !ok {
+		return 0, fmt.Errorf("FX failure - No rate for %s", from)
+	}
+
+	toRate, ok := sr.baseRate(to)
+	if !ok {
+		return 0, fmt.Errorf("FX failure - No rate for %s", to)
+	}
+
+	return toRate / fromRate, nil
+}
+
+func (sr *StaticRates) baseRate(currency string) (float64, bool) {
+	if currency == sr.Base {
+		return 1, true
+	}
+
+	rate, ok := sr.Rates[currency]
+	return rate, ok
+}
+
+// Lock fetches the rate from one currency to another and takes markup (a percentage) off it
+func Lock(from string, to string, markup float64) (*LockedRate, error) {
+	midRate, err := Provider.Rate(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LockedRate{
+		From: strings.ToUpper(from),
+		To: strings.ToUpper(to),
+		MidRate: roundRate(midRate),
+		Markup: markup,
+		Rate: roundRate(midRate * (1 - markup / 100)),
+		LockedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Convert converts an amount at the locked rate, rounded to cents
+func (lr *LockedRate) Convert(amount float64) float64 {
+	return math.Round(amount * lr.Rate * 100) / 100
+}
+
+// rates are kept to six decimals, like most rate feeds publish them
+func roundRate(rate float64) float64 {
+	return math.Round(rate * 1000000) / 1000000
+}
@@ -0,0 +1,92 @@
+package fx
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRate(t *testing.T) {
+	assert := assert.New(t)
+
+	rates := &StaticRates{Base: "EUR", Rates: map[string]float64{"USD": 1.25, "GBP": 0.8}}
+
+	tests := []struct{
+		from string
+		to string
+		expected float64
+		err error
+		description string
+	}{
+		{"EUR", "USD", 1.25, nil, "OK - From the base"},
+		{"USD", "EUR", 0.8, nil, "OK - To the base"},
+		{"gbp", "usd", 1.5625, nil, "OK - Cross rate"},
+		{"JPY", "JPY", 1, nil, "OK - Same currency"},
+		{"EUR", "JPY", 0, errors.New("FX failure - No rate for JPY"), "Error - Unknown currency"},
+	}
+
+	for _, iterTest := range tests {
+		rate, err := rates.Rate(iterTest.from, iterTest.to)
+
+		assert.Equal(iterTest.expected, rate, iterTest.description)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+}
+
+func TestLock(t *testing.T) {
+	assert := assert.New(t)
+
+	defaultProvider := Provider
+	defer func() {
+		Provider = defaultProvider
+	}()
+	Provider = &StaticRates{Base: "EUR", Rates: map[string]float64{"USD": 1.1}}
+
+	locked, err := Lock("usd", "eur", 1.5)
+	assert.NoError(err)
+	assert.Equal("USD", locked.From)
+	assert.Equal("EUR", locked.To)
+	assert.Equal(0.909091, locked.MidRate, "Mid-market rate to six decimals")
+	assert.Equal(0.895455, locked.Rate, "Markup taken off")
+	assert.Equal(89.55, locked.Convert(100), "Converted to cents")
+
+	_, err = Lock("USD", "GBP", 0)
+	assert.Equal(errors.New("FX failure - No rate for GBP"), err)
+}
+
+func TestLoadStaticRates(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "fx")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct{
+		file string
+		content string
+		err error
+		description string
+	}{
+		{"rates.yaml", "base: eur\nrates:\n  usd: 1.10\n  GBP: 0.86\n", nil, "OK - YAML"},
+		{"rates.json", `{"base":"EUR","rates":{"USD":1.10}}`, nil, "OK - JSON"},
+		{"typo.json", `{"bsae":"EUR","rates":{"USD":1.10}}`, errors.New(`Error parsing FX rates file - json: unknown field "bsae"`), "Error - Unknown JSON key"},
+		{"base.yaml", "rates:\n  USD: 1.10\n", errors.New("Invalid FX rates - base must be a 3 letter currency code"), "Error - No base"},
+		{"negative.yaml", "base: EUR\nrates:\n  USD: -1\n", errors.New("Invalid FX rates - USD rate must be positive"), "Error - Negative rate"},
+	}
+
+	for _, iterTest := range tests {
+		path := filepath.Join(dir, iterTest.file)
+		assert.NoError(ioutil.WriteFile(path, []byte(iterTest.content), 0644))
+
+		_, err := LoadStaticRates(path)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+
+	rates, err := LoadStaticRates(filepath.Join(dir, "rates.yaml"))
+	assert.NoError(err)
+	assert.Equal(&StaticRates{Base: "EUR", Rates: map[string]float64{"USD": 1.10, "GBP": 0.86}}, rates, "Currencies are uppercased")
+}
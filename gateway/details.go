@@ -0,0 +1,94 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on merchant supplied details
+const (
+	MaxReferenceLength = 128
+	MaxDescriptionLength = 500
+	MaxMetadataKeys = 20
+	MaxMetadataKeyLength = 40
+	MaxMetadataValueLength = 500
+)
+
+// Details are optional merchant supplied fields kept with an authorization, capture or refund,
+// so merchants can correlate them with their own orders.
+type Details struct {
+	Reference string							`json:"reference,omitempty" example:"order-1234"`
+	Description string						`json:"description,omitempty" example:"2 x T-Shirt"`
+	Metadata map[string]string		`json:"metadata,omitempty"`
+}
+
+func (d *Details) Validate() error {
+	if utf8.RuneCountInString(d.Reference) > MaxReferenceLength {
+		return fmt.Errorf("Invalid details - reference cannot be longer than %d characters", MaxReferenceLength)
+	}
+
+	if utf8.RuneCountInString(d.Description) > MaxDescriptionLength {
+		return fmt.Errorf("Invalid details - description cannot be longer than %d characters", MaxDescriptionLength)
+	}
+
+	if len(d.Metadata) > MaxMetadataKeys {
+		return fmt.Errorf("Invalid details - metadata cannot have more than %d keys", MaxMetadataKeys)
+	}
+
+	for key, value := range d.Metadata {
+		if key == "" {
+			return fmt.Errorf("Invalid details - metadata keys cannot be empty")
+		}
+
+		if utf8.RuneCountInString(key) > MaxMetadataKeyLength {
+			return fmt.Errorf("Invalid details - metadata key %q is longer than %d characters", key, MaxMetadataKeyLength)
+		}
+
+		if utf8.RuneCountInString(value) > MaxMetadataValueLength {
+			return fmt.Errorf("Invalid details - metadata value of %q is longer than %d characters", key, MaxMetadataValueLength)
+		}
+	}
+
+	return nil
+}
+
+// Customer is the shopper behind an authorization, as seen by the merchant. It feeds risk screening.
+type Customer struct {
+	Email string	`json:"email,omitempty" example:"shopper@example.com"`
+	// IP is the shopper's IP address, not the merchant server's
+	IP string			`json:"ip,omitempty" example:"81.2.69.160"`
+}
+
+func (c *Customer) Validate() error {
+	if c.Email != "" && (!strings.Contains(c.Email, "@") || utf8.RuneCountInString(c.Email) > 254) {
+		return fmt.Errorf("Invalid customer - email is not a valid address")
+	}
+
+	if c.IP != "" && net.ParseIP(c.IP) == nil {
+		return fmt.Errorf("Invalid customer - ip is not a valid IP address")
+	}
+
+	return nil
+}
+
+// copy keeps stored records independent of the caller's map
+func (d Details) copy() Details {
+	if d.Metadata == nil {
+		return d
+	}
+
+	metadata := make(map[string]string, len(d.Metadata))
+	for key, value := range d.Metadata {
+		metadata[key] = value
+	}
+	d.Metadata = metadata
+
+	return d
+}
+
+// metadataField is the index field of a metadata key
+func metadataField(key string) string {
+	return "metadata." + key
+}
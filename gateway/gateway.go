@@ -1,35 +1,77 @@
 package gateway
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"errors"
 	"crypto/sha256"
+	"math"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"strconv"
 
 	log "github.com/sirupsen/logrus"
 
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/nktsitas/checkout-techlab/auth"
 	"github.com/nktsitas/checkout-techlab/bank"
 	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/fees"
+	"github.com/nktsitas/checkout-techlab/fx"
+	"github.com/nktsitas/checkout-techlab/ledger"
+	"github.com/nktsitas/checkout-techlab/logger"
+	"github.com/nktsitas/checkout-techlab/merchants"
+	"github.com/nktsitas/checkout-techlab/metrics"
+	"github.com/nktsitas/checkout-techlab/risk"
+	"github.com/nktsitas/checkout-techlab/tracing"
 )
 
 // Create a GatewayI interface as well as an AuthorizationI interface
 // to have the ability of mocking the actions of this package in handlers testing
 type GatewayI interface{
-	NewAuthorization([]byte, string) (*Authorization, error)
+	NewAuthorization(context.Context, []byte, string) (*Authorization, error)
+	ListAuthorizations(AuthorizationFilter) ([]*Authorization, string, error)
 	GetSalt() string
 }
 
 type GatewayS struct {}
 var Gateway GatewayI
 
+// reversals tracks the background reversals of authorizations with an unknown outcome, so shutdown can wait for them
+var reversals sync.WaitGroup
+var pendingReversals int64
+
+// Drain waits for the background reversals until ctx is done
+func Drain(ctx context.Context) error {
+	if atomic.LoadInt64(&pendingReversals) == 0 {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		reversals.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		log.WithField("pending", atomic.LoadInt64(&pendingReversals)).Error("Gateway.Drain - Gave up waiting for reversals")
+		return ctx.Err()
+	}
+}
+
 type AuthorizationI interface{
-	Void() error
-	Capture(float64, string) (*Capture, error)
-	Refund(float64, string) (*Refund, error)
+	Void(context.Context) error
+	Capture(context.Context, float64, string, Details) (*Capture, error)
+	Refund(context.Context, float64, string, Details) (*Refund, error)
 	GetCurrency() string
+	GetMerchant() string
 }
 
 type Authorization struct {
@@ -38,6 +80,27 @@ type Authorization struct {
 	Amount float64							 `json:"amount" example:"100.00"`
 	Currency string							 `json:"currency" example:"EUR"`
 
+	Details
+
+	Customer *Customer					 `json:"customer,omitempty"`
+
+	Merchant string							 `json:"merchant" swaggerignore:"true"`
+	// Acquirer that authorized the payment, all later operations go to the same one
+	Acquirer string							 `json:"acquirer" swaggerignore:"true"`
+
+	Status string								 `json:"status" swaggerignore:"true"`
+	ApprovalCode string					 `json:"approval_code,omitempty" swaggerignore:"true"`
+	AcquirerReference string		 `json:"acquirer_reference,omitempty" swaggerignore:"true"`
+	DeclineCode string					 `json:"decline_code,omitempty" swaggerignore:"true"`
+	DeclineReason string				 `json:"decline_reason,omitempty" swaggerignore:"true"`
+
+	// FX is the rate locked at authorization when the merchant settles in another currency
+	FX *fx.LockedRate						 `json:"fx,omitempty" swaggerignore:"true"`
+	// Risk is the fraud screening assessment made before sending the authorization to an acquirer
+	Risk *risk.Assessment				 `json:"risk,omitempty" swaggerignore:"true"`
+
+	CreatedAt time.Time					 `json:"created_at" swaggerignore:"true"`
+
 	captures []*Capture						
 	refunds []*Refund							
 
@@ -46,44 +109,203 @@ type Authorization struct {
 	mu sync.Mutex
 }
 
+// Authorization statuses
+const (
+	AuthStatusAuthorized = "authorized"
+	AuthStatusDeclined = "declined"
+	AuthStatusVoided = "voided"
+	AuthStatusPartiallyCaptured = "partially_captured"
+	AuthStatusCaptured = "captured"
+	AuthStatusPartiallyRefunded = "partially_refunded"
+	AuthStatusRefunded = "refunded"
+)
+
+// Decline codes of authorizations blocked by risk screening, because of a block list entry or of their score
+const (
+	DeclineCardBlocked = "card_blocked"
+	DeclineRiskBlocked = "risk_blocked"
+)
+
+// Captures and refunds whose acquirer call timed out after being sent are kept with
+// an unknown status: they hold their amount until reconciled, so we never over-capture or over-refund.
+const (
+	StatusSucceeded = "succeeded"
+	StatusUnknown = "unknown"
+)
+
 type Capture struct {
 	Authorization *Authorization
 	Amount float64
+	// SettlementAmount is Amount converted to the merchant's settlement currency at the locked rate
+	SettlementAmount float64
+	SettlementCurrency string
+	// Fee charged to the merchant in the settlement currency, known once the capture succeeded
+	Fee float64
+	Status string
+	AcquirerReference string
+	CreatedAt time.Time
+	// SettlementBatch is set once the capture was included in a settlement batch
+	SettlementBatch string
+	// Disputed is the amount of the capture taken back by disputes, it can't be disputed again
+	Disputed float64
+
+	Details
 }
 
 type Refund struct {
 	Authorization *Authorization
 	Amount float64
+	// SettlementAmount is Amount converted to the merchant's settlement currency at the locked rate
+	SettlementAmount float64
+	SettlementCurrency string
+	// Fee charged to the merchant in the settlement currency, known once the refund succeeded
+	Fee float64
+	Status string
+	AcquirerReference string
+	CreatedAt time.Time
+	// SettlementBatch is set once the refund was included in a settlement batch
+	SettlementBatch string
+
+	Details
 }
 
-func (g *GatewayS) NewAuthorization(req_body []byte, salt string) (*Authorization, error) {
+// Net is what the capture adds to the merchant's balance, in the settlement currency
+func (c *Capture) Net() float64 {
+	return math.Round((c.SettlementAmount - c.Fee) * 100) / 100
+}
+
+// Net is what the refund adds to the merchant's balance in the settlement currency, negative unless fees are given back
+func (r *Refund) Net() float64 {
+	return math.Round((0 - r.SettlementAmount - r.Fee) * 100) / 100
+}
+
+func (g *GatewayS) NewAuthorization(ctx context.Context, req_body []byte, salt string) (_ *Authorization, err error) {
+	ctx, span := tracing.Start(ctx, "gateway.NewAuthorization")
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	var newAuth Authorization
-	err := json.Unmarshal(req_body, &newAuth)
+	err = json.Unmarshal(req_body, &newAuth)
 	if err != nil {
-		log.WithField("err", err).Error("NewAuthorization - Error Reading request body")
+		logger.FromContext(ctx).WithField("err", err).Error("NewAuthorization - Error Reading request body")
 		return nil, fmt.Errorf("Error Unmarshaling JSON - %s", err.Error())
 	}
 
+	// the outcome is always the acquirer's, never the client's
+	newAuth.Acquirer, newAuth.Status = "", ""
+	newAuth.ApprovalCode, newAuth.AcquirerReference = "", ""
+	newAuth.DeclineCode, newAuth.DeclineReason = "", ""
+
 	if newAuth.CreditCard == nil {
-		log.WithField("err", err).Error("NewAuthorization - No Credit Card provided")
-		return nil, err
+		logger.FromContext(ctx).Error("NewAuthorization - No Credit Card provided")
+		return nil, errors.New("Authorization failure - No credit card provided")
 	}
 	
 	if err := newAuth.CreditCard.Validate(); err != nil {
-		log.WithField("err", err).Error("NewAuthorization - Invalid Credit Card provided")
+		logger.FromContext(ctx).WithField("err", err).Error("NewAuthorization - Invalid Credit Card provided")
 		return nil, err
 	}
 
-	if newAuth.CreditCard.Number == "4000 0000 0000 0119" {
-		log.Error("NewAuthorization - Manually triggered auth error")
-		return nil, errors.New("Authorization failure - Unknown Error")
+	if newAuth.Amount <= 0 {
+		logger.FromContext(ctx).Error("NewAuthorization - Amount is not positive")
+		return nil, errors.New("Authorization failure - Amount must be positive")
+	}
+
+	if err := newAuth.Details.Validate(); err != nil {
+		logger.FromContext(ctx).WithField("err", err).Error("NewAuthorization - Invalid details provided")
+		return nil, err
 	}
 
+	if newAuth.Customer != nil {
+		if err := newAuth.Customer.Validate(); err != nil {
+			logger.FromContext(ctx).WithField("err", err).Error("NewAuthorization - Invalid customer provided")
+			return nil, err
+		}
+	}
+
+	newAuth.Merchant = auth.MerchantFromContext(ctx)
+
+	// merchants settling in another currency get the rate locked now, before the shopper is charged
+	if err := newAuth.lockRate(); err != nil {
+		logger.FromContext(ctx).WithField("err", err).Error("NewAuthorization - No exchange rate to the settlement currency")
+		return nil, err
+	}
+
+	newAuth.Risk = risk.Engine.Assess(newAuth.riskRequest())
+	if newAuth.Risk.Decision == risk.DecisionBlock {
+		// blocked authorizations never reach an acquirer, they are kept as declines
+		logger.FromContext(ctx).WithField("risk", newAuth.Risk).Info("NewAuthorization - Blocked by risk screening")
+
+		newAuth.Status = AuthStatusDeclined
+		newAuth.DeclineCode = DeclineRiskBlocked
+		newAuth.DeclineReason = "Blocked by risk screening"
+		if newAuth.Risk.BlockListed() {
+			newAuth.DeclineCode = DeclineCardBlocked
+			newAuth.DeclineReason = "Card blocked"
+		}
+		metrics.Payments.WithLabelValues("authorize", metrics.OutcomeBlocked, metrics.CurrencyLabel(newAuth.Currency), metrics.DeclineCodeLabel(newAuth.DeclineCode)).Inc()
+		newAuth.Id = generateID(req_body, salt)
+		newAuth.CreatedAt = time.Now().UTC()
+
+		db.StoreItemContext(ctx, newAuth.Id, &newAuth)
+
+		return &newAuth, nil
+	}
+
+	candidates := bank.Routing.Route(bank.RouteRequest{
+		Brand: newAuth.CreditCard.Brand(),
+		BIN: newAuth.CreditCard.BIN(),
+		Currency: newAuth.Currency,
+		Amount: newAuth.Amount,
+		Merchant: newAuth.Merchant,
+	})
+
+	acquirer, resp, err := newAuth.CreditCard.Authorize(ctx, candidates, newAuth.Amount)
+	recordOutcome("authorize", newAuth.Currency, err)
+
+	var declineErr *bank.DeclineError
+	switch {
+	case errors.As(err, &declineErr):
+		// declines are kept so the merchant can look them up later
+		logger.FromContext(ctx).WithField("err", err).Error("NewAuthorization - Authorization declined")
+
+		newAuth.Status = AuthStatusDeclined
+		newAuth.DeclineCode = declineErr.Code
+		newAuth.DeclineReason = declineErr.Message
+	case errors.Is(err, bank.ErrUnknownOutcome):
+		logger.FromContext(ctx).WithField("err", err).Error("NewAuthorization - Authorization outcome unknown, reversing")
+
+		// a hold may exist at the issuer, release it in the background
+		reversals.Add(1)
+		atomic.AddInt64(&pendingReversals, 1)
+		go func() {
+			defer reversals.Done()
+			defer atomic.AddInt64(&pendingReversals, -1)
+			newAuth.CreditCard.Transaction(context.Background(), acquirer, "void", newAuth.Amount)
+		}()
+
+		return nil, err
+	case err != nil:
+		logger.FromContext(ctx).WithField("err", err).Error("NewAuthorization - Acquirer did not authorize")
+		return nil, err
+	default:
+		newAuth.Status = AuthStatusAuthorized
+		newAuth.ApprovalCode = resp.ApprovalCode
+		newAuth.AcquirerReference = resp.Reference
+	}
+
+	newAuth.Acquirer = acquirer
 	newAuth.Id = generateID(req_body, salt)
+	newAuth.CreatedAt = time.Now().UTC()
+
+	if newAuth.Status == AuthStatusAuthorized {
+		newAuth.postHold()
+	}
 
-	db.DB.StoreItem(newAuth.Id, &newAuth)
+	db.StoreItemContext(ctx, newAuth.Id, &newAuth)
 
-	log.WithField("newAuth", newAuth).Debug("New Authorization Successfully created")
+	logger.FromContext(ctx).WithField("newAuth", &newAuth).Debug("New Authorization Successfully created")
 
 	return &newAuth, nil
 }
@@ -107,10 +329,23 @@ func (auth *Authorization) GetCurrency() string {
 	return auth.Currency
 }
 
-func (auth *Authorization) Void() error {
-	auth.mu.Lock()
+func (auth *Authorization) GetMerchant() string {
+	return auth.Merchant
+}
+
+func (auth *Authorization) Void(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "gateway.Void", attribute.String("authorization.id", auth.Id))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	auth.lock(ctx)
 	defer auth.mu.Unlock()
 
+	if auth.Status == AuthStatusDeclined {
+		return errors.New("Void Failure - Cannot void a declined authorization")
+	}
+
 	if auth.void {
 		return errors.New("Void Failure - Transaction already void")
 	}
@@ -119,38 +354,99 @@ func (auth *Authorization) Void() error {
 		return errors.New("Void Failure - Cannot void transaction with captured amount")
 	}
 
+	if auth.hasUnknownOutcomes() {
+		return errors.New("Void Failure - Cannot void transaction with captures or refunds of unknown outcome")
+	}
+
+	// release the hold at the issuer before considering the authorization void
+	balance := auth.Balance()
+	_, err = auth.CreditCard.Transaction(ctx, auth.Acquirer, "void", balance)
+	recordOutcome("void", auth.Currency, err)
+	if err != nil {
+		logger.FromContext(ctx).WithField("err", err).Error("Authorization.Void - Error trying to reverse authorization")
+
+		return err
+	}
+
+	auth.postVoid(balance)
 	auth.void = true
+	auth.Status = AuthStatusVoided
+	auth.save(ctx)
 
-	log.WithField("auth", auth).Debug("Void Successfully executed.")
+	logger.FromContext(ctx).WithField("auth", auth).Debug("Void Successfully executed.")
 
 	return nil
 }
 
-func (auth *Authorization) Capture(amount float64, currency string) (*Capture, error) {
-	auth.mu.Lock()
+func (auth *Authorization) Capture(ctx context.Context, amount float64, currency string, details Details) (_ *Capture, err error) {
+	ctx, span := tracing.Start(ctx, "gateway.Capture", attribute.String("authorization.id", auth.Id), attribute.Float64("amount", amount))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	auth.lock(ctx)
 	defer auth.mu.Unlock()
 
+	if auth.Status == AuthStatusDeclined {
+		logger.FromContext(ctx).Error("Authorization.Capture - authorization was declined")
+
+		return nil, errors.New("Capture failure - Cannot capture on declined authorization")
+	}
+
 	if auth.void {
-		log.Error("Authorization.Capture - transaction is void")
+		logger.FromContext(ctx).Error("Authorization.Capture - transaction is void")
 
 		return nil, errors.New("Capture failure - Cannot capture on void transaction")
 	}
 
+	// a negative capture would give the hold back and let later captures exceed the authorization
+	if amount <= 0 {
+		logger.FromContext(ctx).Error("Authorization.Capture - Capture amount is not positive")
+
+		return nil, errors.New("Capture failure - Amount must be positive")
+	}
+
 	if amount > auth.Amount {
-		log.Error("Authorization.Capture - Capture amount is greater than Auth amount")
+		logger.FromContext(ctx).Error("Authorization.Capture - Capture amount is greater than Auth amount")
 
 		return nil, errors.New("Capture failure - Cannot capture amount that exceeds authorization's availability.")
 	}
 
 	if amount > auth.Balance() {
-		log.Error("Authorization.Capture - Capture amount is greater than remaining Auth amount")
+		logger.FromContext(ctx).Error("Authorization.Capture - Capture amount is greater than remaining Auth amount")
 
 		return nil, errors.New("Capture failure - Cannot capture more than the remaining amount")
 	}
 
-	err := auth.CreditCard.Transaction("charge")
+	if err := details.Validate(); err != nil {
+		logger.FromContext(ctx).WithField("err", err).Error("Authorization.Capture - Invalid details provided")
+
+		return nil, err
+	}
+
+	resp, err := auth.CreditCard.Transaction(ctx, auth.Acquirer, "charge", amount)
+	recordOutcome("capture", auth.Currency, err)
+	if errors.Is(err, bank.ErrUnknownOutcome) {
+		logger.FromContext(ctx).WithField("err", err).Error("Authorization.Capture - Charge outcome unknown, holding amount")
+
+		unknownCapture := &Capture{
+			Authorization: auth,
+			Amount: amount,
+			SettlementAmount: auth.Convert(amount),
+			SettlementCurrency: auth.SettlementCurrency(),
+			Status: StatusUnknown,
+			CreatedAt: time.Now().UTC(),
+			Details: details.copy(),
+		}
+
+		auth.captures = append(auth.captures, unknownCapture)
+		auth.postCapture(unknownCapture)
+		auth.save(ctx)
+
+		return nil, err
+	}
 	if err != nil {
-		log.WithField("err", err).Error("Authorization.Capture - Error trying to charge CC")
+		logger.FromContext(ctx).WithField("err", err).Error("Authorization.Capture - Error trying to charge CC")
 		
 		return nil, err
 	}
@@ -158,34 +454,90 @@ func (auth *Authorization) Capture(amount float64, currency string) (*Capture, e
 	newCapture := &Capture{
 		Authorization: auth,
 		Amount: amount,
+		SettlementAmount: auth.Convert(amount),
+		SettlementCurrency: auth.SettlementCurrency(),
+		Status: StatusSucceeded,
+		AcquirerReference: resp.Reference,
+		CreatedAt: time.Now().UTC(),
+		Details: details.copy(),
 	}
 
+	newCapture.Fee = fees.CaptureFee(auth.feeTransaction(newCapture.SettlementAmount))
+
 	auth.captures = append(auth.captures, newCapture)
+	auth.postCapture(newCapture)
+	auth.postFee(newCapture.Fee)
+	auth.save(ctx)
+	// counted once persisted, so the totals never get ahead of the stored transactions
+	recordAmount("capture", auth.Currency, amount)
 
-	log.WithField("newCapture", newCapture).Debug("New Capture Successfully created")
+	logger.FromContext(ctx).WithField("newCapture", newCapture).Debug("New Capture Successfully created")
 
 	return newCapture, nil
 }
 
-func (auth *Authorization) Refund(amount float64, currency string) (*Refund, error) {
-	auth.mu.Lock()
+func (auth *Authorization) Refund(ctx context.Context, amount float64, currency string, details Details) (_ *Refund, err error) {
+	ctx, span := tracing.Start(ctx, "gateway.Refund", attribute.String("authorization.id", auth.Id), attribute.Float64("amount", amount))
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	auth.lock(ctx)
 	defer auth.mu.Unlock()
 	
+	if auth.Status == AuthStatusDeclined {
+		logger.FromContext(ctx).Error("Authorization.Refund - authorization was declined")
+
+		return nil, errors.New("Refund failure - Cannot refund on declined authorization")
+	}
+
 	if auth.void {
-		log.Error("Authorization.Refund - transaction is void")
+		logger.FromContext(ctx).Error("Authorization.Refund - transaction is void")
 
 		return nil, errors.New("Refund failure - Cannot refund on void transaction")
 	}
 
+	if amount <= 0 {
+		logger.FromContext(ctx).Error("Authorization.Refund - Refund amount is not positive")
+
+		return nil, errors.New("Refund failure - Amount must be positive")
+	}
+
 	if amount > auth.TotalCapturedAmount() {
-		log.Error("Authorization.Refund - Trying to refund more than total captured amount")
+		logger.FromContext(ctx).Error("Authorization.Refund - Trying to refund more than total captured amount")
 
 		return nil, errors.New("Refund failure - Cannot refund more than total captured amount")
 	}
 
-	err := auth.CreditCard.Transaction("refund")
+	if err := details.Validate(); err != nil {
+		logger.FromContext(ctx).WithField("err", err).Error("Authorization.Refund - Invalid details provided")
+
+		return nil, err
+	}
+
+	resp, err := auth.CreditCard.Transaction(ctx, auth.Acquirer, "refund", amount)
+	recordOutcome("refund", auth.Currency, err)
+	if errors.Is(err, bank.ErrUnknownOutcome) {
+		logger.FromContext(ctx).WithField("err", err).Error("Authorization.Refund - Refund outcome unknown, holding amount")
+
+		unknownRefund := &Refund{
+			Authorization: auth,
+			Amount: amount,
+			SettlementAmount: auth.Convert(amount),
+			SettlementCurrency: auth.SettlementCurrency(),
+			Status: StatusUnknown,
+			CreatedAt: time.Now().UTC(),
+			Details: details.copy(),
+		}
+
+		auth.refunds = append(auth.refunds, unknownRefund)
+		auth.postRefund(unknownRefund)
+		auth.save(ctx)
+
+		return nil, err
+	}
 	if err != nil {
-		log.WithField("err", err).Error("Authorization.Refund - Error trying to charge CC")
+		logger.FromContext(ctx).WithField("err", err).Error("Authorization.Refund - Error trying to charge CC")
 		
 		return nil, err
 	}
@@ -193,39 +545,291 @@ func (auth *Authorization) Refund(amount float64, currency string) (*Refund, err
 	newRefund := &Refund{
 		Authorization: auth,
 		Amount: amount,
+		SettlementAmount: auth.Convert(amount),
+		SettlementCurrency: auth.SettlementCurrency(),
+		Status: StatusSucceeded,
+		AcquirerReference: resp.Reference,
+		CreatedAt: time.Now().UTC(),
+		Details: details.copy(),
 	}
 
+	newRefund.Fee = fees.RefundFee(auth.feeTransaction(newRefund.SettlementAmount))
+
 	auth.refunds = append(auth.refunds, newRefund)
+	auth.postRefund(newRefund)
+	auth.postFee(newRefund.Fee)
+	auth.save(ctx)
+	recordAmount("refund", auth.Currency, amount)
 
-	log.WithField("newRefund", newRefund).Debug("New Refund Successfully created")
+	logger.FromContext(ctx).WithField("newRefund", newRefund).Debug("New Refund Successfully created")
 
 	return newRefund, nil
 }
 
+// Dispute takes a disputed amount of a succeeded capture back from the merchant. The amount can no
+// longer be refunded, and goes back to the merchant if the dispute is won (ReverseDispute).
+func (auth *Authorization) Dispute(acquirerReference string, amount float64) (*Capture, error) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	var disputed *Capture
+	for _, iterCapture := range auth.captures {
+		if iterCapture.Status == StatusSucceeded && iterCapture.AcquirerReference == acquirerReference {
+			disputed = iterCapture
+		}
+	}
+
+	if disputed == nil {
+		log.WithField("acquirer_reference", acquirerReference).Error("Authorization.Dispute - No such capture")
+
+		return nil, errors.New("Dispute failure - No succeeded capture with this acquirer reference")
+	}
+
+	if amount <= 0 || amount > disputed.Amount - disputed.Disputed {
+		log.Error("Authorization.Dispute - Disputed amount is not within the captured amount left undisputed")
+
+		return nil, errors.New("Dispute failure - Disputed amount must be positive and at most the captured amount not yet disputed")
+	}
+
+	if amount > auth.TotalCapturedAmount() {
+		log.Error("Authorization.Dispute - Disputed amount was already refunded")
+
+		return nil, errors.New("Dispute failure - Cannot dispute more than what was not refunded")
+	}
+
+	auth.postDispute(amount, false)
+	disputed.Disputed += amount
+
+	capture := *disputed
+	return &capture, nil
+}
+
+// ReverseDispute gives a disputed amount of the capture with acquirerReference back to the merchant
+func (auth *Authorization) ReverseDispute(acquirerReference string, amount float64) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	for _, iterCapture := range auth.captures {
+		if iterCapture.Status == StatusSucceeded && iterCapture.AcquirerReference == acquirerReference {
+			iterCapture.Disputed -= amount
+		}
+	}
+
+	auth.postDispute(amount, true)
+}
+
+// lockRate locks the exchange rate to the merchant's settlement currency, if it differs from the authorization's
+func (auth *Authorization) lockRate() error {
+	// the rate is always ours, never the client's
+	auth.FX = nil
+
+	merchant := merchants.Merchants.Get(auth.Merchant)
+	if merchant.SettlementCurrency == "" || strings.EqualFold(merchant.SettlementCurrency, auth.Currency) {
+		return nil
+	}
+
+	rate, err := fx.Lock(auth.Currency, merchant.SettlementCurrency, merchant.FxMarkup)
+	if err != nil {
+		return err
+	}
+
+	auth.FX = rate
+	return nil
+}
+
+// SettlementCurrency is the currency the merchant is paid in for this authorization
+func (auth *Authorization) SettlementCurrency() string {
+	if auth.FX != nil {
+		return auth.FX.To
+	}
+
+	return strings.ToUpper(auth.Currency)
+}
+
+// Convert converts an amount of the authorization to the settlement currency at the locked rate
+func (auth *Authorization) Convert(amount float64) float64 {
+	if auth.FX == nil {
+		return amount
+	}
+
+	return auth.FX.Convert(amount)
+}
+
+// feeTransaction describes a capture or refund of amount, in the settlement currency, to the fee engine
+func (auth *Authorization) feeTransaction(amount float64) fees.Transaction {
+	merchant := merchants.Merchants.Get(auth.Merchant)
+
+	return fees.Transaction{
+		Plan: merchant.PricingPlan,
+		Brand: auth.CreditCard.Brand(),
+		Amount: amount,
+		MerchantCountry: merchant.Country,
+		CardCountry: auth.CreditCard.Country(),
+	}
+}
+
+// riskRequest describes the authorization to the risk engine
+func (auth *Authorization) riskRequest() *risk.Request {
+	req := &risk.Request{
+		Merchant: auth.Merchant,
+		Amount: auth.Amount,
+		Currency: auth.Currency,
+		CardFingerprint: auth.CreditCard.Fingerprint(),
+		BIN: auth.CreditCard.BIN(),
+		CardCountry: auth.CreditCard.Country(),
+	}
+
+	if auth.Customer != nil {
+		req.IP = auth.Customer.IP
+		req.Email = auth.Customer.Email
+	}
+
+	return req
+}
+
+// Balance is the amount still available for capture, the authorization's customer hold in the ledger.
+// Captures of unknown outcome are treated as taken, refunds of unknown outcome as not yet given back.
 func (auth *Authorization) Balance() float64 {
+	return ledger.Ledger.Balance(ledger.CustomerHold(auth.Id), auth.Currency)
+}
+
+// TotalCapturedAmount is the amount that can still be refunded, a credit balance in the ledger
+func (auth *Authorization) TotalCapturedAmount() float64 {
+	return 0 - ledger.Ledger.Balance(ledger.Refundable(auth.Id), auth.Currency)
+}
+
+func (auth *Authorization) capturedAmount(includeUnknown bool) float64 {
 	capturedAmount := 0.0
 	for _, iterCapture := range auth.captures {
-		capturedAmount += iterCapture.Amount
+		if iterCapture.Status == StatusSucceeded || includeUnknown {
+			capturedAmount += iterCapture.Amount
+		}
 	}
 
+	return capturedAmount
+}
+
+func (auth *Authorization) refundedAmount(includeUnknown bool) float64 {
 	refundedAmount := 0.0
 	for _, iterRefund := range auth.refunds {
-		refundedAmount += iterRefund.Amount
+		if iterRefund.Status == StatusSucceeded || includeUnknown {
+			refundedAmount += iterRefund.Amount
+		}
 	}
 
-	return auth.Amount - capturedAmount + refundedAmount
+	return refundedAmount
 }
 
-func (auth *Authorization) TotalCapturedAmount() float64 {
-	capturedAmount := 0.0
+// Transactions returns copies of the captures and refunds of the authorization
+func (auth *Authorization) Transactions() ([]Capture, []Refund) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	captures := make([]Capture, 0, len(auth.captures))
 	for _, iterCapture := range auth.captures {
-		capturedAmount += iterCapture.Amount
+		captures = append(captures, *iterCapture)
 	}
 
-	refundedAmount := 0.0
+	refunds := make([]Refund, 0, len(auth.refunds))
 	for _, iterRefund := range auth.refunds {
-		refundedAmount += iterRefund.Amount
+		refunds = append(refunds, *iterRefund)
 	}
 
-	return capturedAmount - refundedAmount
-}
\ No newline at end of file
+	return captures, refunds
+}
+
+// Settle marks the succeeded captures and refunds made before cutoff that are not settled yet
+// as part of batch, and returns copies of them
+func (auth *Authorization) Settle(cutoff time.Time, batch string) ([]Capture, []Refund) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	var captures []Capture
+	for _, iterCapture := range auth.captures {
+		if iterCapture.Status == StatusSucceeded && iterCapture.SettlementBatch == "" && iterCapture.CreatedAt.Before(cutoff) {
+			iterCapture.SettlementBatch = batch
+			captures = append(captures, *iterCapture)
+		}
+	}
+
+	var refunds []Refund
+	for _, iterRefund := range auth.refunds {
+		if iterRefund.Status == StatusSucceeded && iterRefund.SettlementBatch == "" && iterRefund.CreatedAt.Before(cutoff) {
+			iterRefund.SettlementBatch = batch
+			refunds = append(refunds, *iterRefund)
+		}
+	}
+
+	return captures, refunds
+}
+
+// Unsettle releases the captures and refunds marked as part of batch, for a batch that couldn't be settled
+func (auth *Authorization) Unsettle(batch string) {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	for _, iterCapture := range auth.captures {
+		if iterCapture.SettlementBatch == batch {
+			iterCapture.SettlementBatch = ""
+		}
+	}
+
+	for _, iterRefund := range auth.refunds {
+		if iterRefund.SettlementBatch == batch {
+			iterRefund.SettlementBatch = ""
+		}
+	}
+}
+
+// save refreshes the status and stores the authorization again so its indexes follow. Called with auth.mu held.
+func (auth *Authorization) save(ctx context.Context) {
+	auth.refreshStatus()
+
+	db.StoreItemContext(ctx, auth.Id, auth)
+}
+
+// lock takes auth.mu, traced so time spent waiting for other operations on the authorization shows up
+func (auth *Authorization) lock(ctx context.Context) {
+	_, span := tracing.Start(ctx, "Authorization.lock")
+	defer span.End()
+
+	auth.mu.Lock()
+}
+
+func (auth *Authorization) refreshStatus() {
+	if auth.Status == AuthStatusDeclined || auth.void {
+		return
+	}
+
+	captured := auth.capturedAmount(false)
+	refunded := auth.refundedAmount(false)
+
+	switch {
+	case refunded > 0 && refunded >= captured:
+		auth.Status = AuthStatusRefunded
+	case refunded > 0:
+		auth.Status = AuthStatusPartiallyRefunded
+	case captured > 0 && captured >= auth.Amount:
+		auth.Status = AuthStatusCaptured
+	case captured > 0:
+		auth.Status = AuthStatusPartiallyCaptured
+	default:
+		auth.Status = AuthStatusAuthorized
+	}
+}
+
+func (auth *Authorization) hasUnknownOutcomes() bool {
+	for _, iterCapture := range auth.captures {
+		if iterCapture.Status == StatusUnknown {
+			return true
+		}
+	}
+
+	for _, iterRefund := range auth.refunds {
+		if iterRefund.Status == StatusUnknown {
+			return true
+		}
+	}
+
+	return false
+}
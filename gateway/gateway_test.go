@@ -1,21 +1,35 @@
 package gateway
 
 import (
+	"context"
 	"testing"
+	"time"
 	"errors"
 	"io/ioutil"
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	log "github.com/sirupsen/logrus"
 	
+	"github.com/nktsitas/checkout-techlab/auth"
 	"github.com/nktsitas/checkout-techlab/db"
 	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/fees"
+	"github.com/nktsitas/checkout-techlab/fx"
+	"github.com/nktsitas/checkout-techlab/ledger"
+	"github.com/nktsitas/checkout-techlab/merchants"
+	"github.com/nktsitas/checkout-techlab/metrics"
+	"github.com/nktsitas/checkout-techlab/risk"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
 var testAuthorizations map[string]*Authorization
 var testAuthorizationStrings map[string][]byte
+var testAuthorizationCount int
 
 type MockDB struct {
 	mock.Mock
@@ -34,6 +48,11 @@ func (m *MockDB) DeleteItem(id string) {
 	m.Called()
 }
 
+func (m *MockDB) QueryItems(query db.Query) ([]interface{}, string, error) {
+	args := m.Called(query)
+	return args.Get(0).([]interface{}), args.String(1), args.Error(2)
+}
+
 // --- --- ---
 
 // We predefine a series of Auth objects in order to observe the correct behavior when
@@ -125,11 +144,21 @@ func init() {
 
 		if key == "void" {
 			auth.void = true
+			auth.Status = AuthStatusVoided
 		}
 
 		testAuthorizations[key] = auth
 		testAuthorizationStrings[key] = authJSON
 	}
+
+	for key, iterAmount := range map[string]float64{"NegativeAmount": -50.00, "ZeroAmount": 0} {
+		authJSON, err := json.Marshal(&Authorization{Amount: iterAmount, Currency: "EUR", CreditCard: testCreditCards["OK"]})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		testAuthorizationStrings[key] = authJSON
+	}
 }
 
 func getNewTestAuth(cc *bank.CreditCard) (*Authorization, []byte) {
@@ -139,11 +168,16 @@ func getNewTestAuth(cc *bank.CreditCard) (*Authorization, []byte) {
 		CreditCard: cc,
 	}
 
-	authJSON, err := json.Marshal(auth)
+	authJSON, err := json.Marshal(&auth)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	// held in the ledger, as NewAuthorization does once the acquirer approved
+	testAuthorizationCount++
+	auth.Id = fmt.Sprintf("test_%d", testAuthorizationCount)
+	auth.postHold()
+
 	return &auth, authJSON
 }
 
@@ -151,6 +185,9 @@ func getNewTestCapture(auth *Authorization, amount float64) *Capture {
 	return &Capture{
 		Authorization: auth,
 		Amount: amount,
+		SettlementAmount: amount,
+		SettlementCurrency: auth.SettlementCurrency(),
+		Status: StatusSucceeded,
 	}
 }
 
@@ -158,6 +195,9 @@ func getNewTestRefund(auth *Authorization, amount float64) *Refund {
 	return &Refund{
 		Authorization: auth,
 		Amount: amount,
+		SettlementAmount: amount,
+		SettlementCurrency: auth.SettlementCurrency(),
+		Status: StatusSucceeded,
 	}
 }
 
@@ -166,6 +206,12 @@ func getNewTestRefund(auth *Authorization, amount float64) *Refund {
 func TestNewAuthorization(t *testing.T) {
 	assert := assert.New(t)
 
+	testAuthorizations["OK"].Status = AuthStatusAuthorized
+
+	testAuthorizations["AuthFailure"].Status = AuthStatusDeclined
+	testAuthorizations["AuthFailure"].DeclineCode = "05"
+	testAuthorizations["AuthFailure"].DeclineReason = "Unknown Error"
+
 	tests := []struct{
 		input []byte
 		expected *Authorization
@@ -186,9 +232,9 @@ func TestNewAuthorization(t *testing.T) {
 		},
 		{
 			testAuthorizationStrings["AuthFailure"],
+			testAuthorizations["AuthFailure"],
 			nil,
-			errors.New("Authorization failure - Unknown Error"),
-			"Error - Manually triggered authorization failure.",
+			"Declined - Manually triggered authorization failure is stored as declined.",
 		},
 		{
 			testAuthorizationStrings["NoCvv"],
@@ -208,6 +254,24 @@ func TestNewAuthorization(t *testing.T) {
 			errors.New("Invalid CreditCard - No Number provided"),
 			"Error - No Number Provided",
 		},
+		{
+			[]byte(`{"amount":10}`),
+			nil,
+			errors.New("Authorization failure - No credit card provided"),
+			"Error - No Credit Card Provided",
+		},
+		{
+			testAuthorizationStrings["NegativeAmount"],
+			nil,
+			errors.New("Authorization failure - Amount must be positive"),
+			"Error - Negative amount",
+		},
+		{
+			testAuthorizationStrings["ZeroAmount"],
+			nil,
+			errors.New("Authorization failure - Amount must be positive"),
+			"Error - Zero amount",
+		},
 	}
 
 	for _, iterTest := range tests {
@@ -217,24 +281,83 @@ func TestNewAuthorization(t *testing.T) {
 
 		testGateway := new(GatewayS)
 
-		auth, err := testGateway.NewAuthorization(iterTest.input, "salt")
+		auth, err := testGateway.NewAuthorization(context.Background(), iterTest.input, "salt")
 
 		if iterTest.expected != nil {
 			iterTest.expected.Id = generateID(iterTest.input, "salt")	
+			iterTest.expected.Acquirer = bank.DefaultAcquirer
+			iterTest.expected.Risk = &risk.Assessment{Decision: risk.DecisionAllow, Reasons: []risk.Reason{}}
+			assert.False(auth.CreatedAt.IsZero(), iterTest.description)
+			iterTest.expected.CreatedAt = auth.CreatedAt
 			testDB.AssertNumberOfCalls(t, "StoreItem", 1)	
 		}
 
+		if auth != nil && auth.Status == AuthStatusAuthorized {
+			assert.NotEmpty(auth.ApprovalCode, iterTest.description)
+			assert.NotEmpty(auth.AcquirerReference, iterTest.description)
+
+			iterTest.expected.ApprovalCode = auth.ApprovalCode
+			iterTest.expected.AcquirerReference = auth.AcquirerReference
+		}
+
 		assert.Equal(iterTest.expected, auth, iterTest.description)
 		assert.Equal(iterTest.err, err, iterTest.description)
 	}
 }
 
+func TestNewAuthorizationOutcomeFields(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	testGateway := new(GatewayS)
+
+	tests := []struct{
+		body string
+		status string
+		description string
+	}{
+		{
+			`{"credit_card":{"number":"4000 0000 0000 0119","expiry":"12/22","cvv":"123"},"amount":100,"currency":"EUR","approval_code":"123456","acquirer_reference":"sim_forged"}`,
+			AuthStatusDeclined,
+			"Declined - Client approval ignored",
+		},
+		{
+			`{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"EUR","status":"captured","decline_code":"05","decline_reason":"Forged"}`,
+			AuthStatusAuthorized,
+			"Authorized - Client decline ignored",
+		},
+	}
+
+	for i, iterTest := range tests {
+		newAuth, err := testGateway.NewAuthorization(context.Background(), []byte(iterTest.body), strconv.Itoa(i))
+		assert.NoError(err, iterTest.description)
+		assert.Equal(iterTest.status, newAuth.Status, iterTest.description)
+
+		if iterTest.status == AuthStatusDeclined {
+			assert.Empty(newAuth.ApprovalCode, iterTest.description)
+			assert.Empty(newAuth.AcquirerReference, iterTest.description)
+			assert.Equal("05", newAuth.DeclineCode, iterTest.description)
+		} else {
+			assert.Empty(newAuth.DeclineCode, iterTest.description)
+			assert.Empty(newAuth.DeclineReason, iterTest.description)
+			assert.NotEqual("sim_forged", newAuth.AcquirerReference, iterTest.description)
+		}
+	}
+}
+
 // ---
 
 func TestVoid(t *testing.T) {
 	assert := assert.New(t)
 
-	testAuthorizations["OK_void2"].Capture(10.00, "EUR")
+	testAuthorizations["OK_void2"].Capture(context.Background(), 10.00, "EUR", Details{})
+
+	declinedAuth, _ := getNewTestAuth(&bank.CreditCard{
+		Number: "4000 0000 0000 0119",
+		Expiry: "12/22",
+		Cvv: "123",
+	})
+	declinedAuth.Status = AuthStatusDeclined
 
 	tests := []struct{
 		input *Authorization
@@ -260,14 +383,21 @@ func TestVoid(t *testing.T) {
 			errors.New("Void Failure - Cannot void transaction with captured amount"),
 			"Error - Try void a transaction with captures",
 		},
+		{
+			declinedAuth,
+			declinedAuth,
+			errors.New("Void Failure - Cannot void a declined authorization"),
+			"Error - Try void a declined authorization",
+		},
 	}
 
 	for _, iterTest := range tests {
 		log.Info(iterTest.input.void)
-		err := iterTest.input.Void()
+		err := iterTest.input.Void(context.Background())
 		log.Info(iterTest.input.void)
 
 		assert.Equal(iterTest.expected.void, iterTest.input.void, iterTest.description)
+		assert.Equal(iterTest.expected.Status, iterTest.input.Status, iterTest.description)
 		assert.Equal(iterTest.err, err, iterTest.description)
 	}
 }
@@ -306,7 +436,7 @@ func TestCapture(t *testing.T) {
 			50.00,
 			"EUR",
 			nil,
-			errors.New("Capture failure - Unknown Error"),
+			&bank.DeclineError{Operation: "charge", Code: "05", Message: "Unknown Error"},
 			"Error - Manually triggered capture failure.",
 		},
 		{
@@ -317,6 +447,22 @@ func TestCapture(t *testing.T) {
 			errors.New("Capture failure - Cannot capture more than the remaining amount"),
 			"Error - Try Capture more than remaining amount",
 		},
+		{
+			testAuthorizations["OK"],
+			-50.00,
+			"EUR",
+			nil,
+			errors.New("Capture failure - Amount must be positive"),
+			"Error - Try Capture a negative amount",
+		},
+		{
+			testAuthorizations["OK"],
+			0,
+			"EUR",
+			nil,
+			errors.New("Capture failure - Amount must be positive"),
+			"Error - Try Capture a zero amount",
+		},
 		{
 			testAuthorizations["void"],
 			10.00,
@@ -328,7 +474,14 @@ func TestCapture(t *testing.T) {
 	}
 
 	for _, iterTest := range tests {
-		capture, err := iterTest.authorization.Capture(iterTest.amount, iterTest.currency)
+		capture, err := iterTest.authorization.Capture(context.Background(), iterTest.amount, iterTest.currency, Details{})
+
+		if capture != nil {
+			assert.NotEmpty(capture.AcquirerReference, iterTest.description)
+			iterTest.expected.AcquirerReference = capture.AcquirerReference
+			assert.False(capture.CreatedAt.IsZero(), iterTest.description)
+			iterTest.expected.CreatedAt = capture.CreatedAt
+		}
 
 		assert.Equal(iterTest.expected, capture, iterTest.description)
 		assert.Equal(iterTest.err, err, iterTest.description)
@@ -338,8 +491,8 @@ func TestCapture(t *testing.T) {
 func TestRefund(t *testing.T) {
 	assert := assert.New(t)
 
-	testAuthorizations["OK_refund"].Capture(10.00, "EUR")
-	testAuthorizations["RefundFailure"].Capture(100.00, "EUR")
+	testAuthorizations["OK_refund"].Capture(context.Background(), 10.00, "EUR", Details{})
+	testAuthorizations["RefundFailure"].Capture(context.Background(), 100.00, "EUR", Details{})
 
 	testRefund := getNewTestRefund(testAuthorizations["OK_refund"], 5.00)
 
@@ -367,6 +520,22 @@ func TestRefund(t *testing.T) {
 			errors.New("Refund failure - Cannot refund more than total captured amount"),
 			"Error - Try refund more than total captured amount",
 		},
+		{
+			testAuthorizations["OK_refund"],
+			-50.00,
+			"EUR",
+			nil,
+			errors.New("Refund failure - Amount must be positive"),
+			"Error - Try refund a negative amount",
+		},
+		{
+			testAuthorizations["OK_refund"],
+			0,
+			"EUR",
+			nil,
+			errors.New("Refund failure - Amount must be positive"),
+			"Error - Try refund a zero amount",
+		},
 		{
 			testAuthorizations["void"],
 			10.00,
@@ -380,16 +549,484 @@ func TestRefund(t *testing.T) {
 			50.00,
 			"EUR",
 			nil,
-			errors.New("Refund failure - Unknown Error"),
+			&bank.DeclineError{Operation: "refund", Code: "05", Message: "Unknown Error"},
 			"Error - Manually triggered refund failure.",
 		},
 	}
 	
 	for _, iterTest := range tests {
 
-		refund, err := iterTest.authorization.Refund(iterTest.amount, iterTest.currency)
+		refund, err := iterTest.authorization.Refund(context.Background(), iterTest.amount, iterTest.currency, Details{})
+
+		if refund != nil {
+			assert.NotEmpty(refund.AcquirerReference, iterTest.description)
+			iterTest.expected.AcquirerReference = refund.AcquirerReference
+			assert.False(refund.CreatedAt.IsZero(), iterTest.description)
+			iterTest.expected.CreatedAt = refund.CreatedAt
+		}
 
 		assert.Equal(iterTest.expected, refund, iterTest.description)
 		assert.Equal(iterTest.err, err, iterTest.description)
 	}
 }
+
+func TestCaptureUnknownOutcome(t *testing.T) {
+	assert := assert.New(t)
+
+	defaultScenarios, defaultTimeout := bank.Scenarios, bank.Timeouts["charge"]
+	defer func() {
+		bank.Scenarios, bank.Timeouts["charge"] = defaultScenarios, defaultTimeout
+	}()
+
+	bank.Scenarios = &bank.ScenarioRegistry{
+		Scenarios: []bank.Scenario{
+			{
+				Card: "4000 0000 0000 0408",
+				Operations: []string{"charge"},
+				Outcome: bank.OutcomeTimeout,
+			},
+		},
+	}
+	bank.Timeouts["charge"] = 20 * time.Millisecond
+
+	auth, _ := getNewTestAuth(&bank.CreditCard{
+		Number: "4000 0000 0000 0408",
+		Expiry: "12/22",
+		Cvv: "123",
+	})
+
+	capture, err := auth.Capture(context.Background(), 150.00, "EUR", Details{})
+
+	assert.Nil(capture, "Unknown outcome - No capture returned")
+	assert.True(errors.Is(err, bank.ErrUnknownOutcome), "Unknown outcome - Error matches ErrUnknownOutcome")
+	assert.Equal(50.00, auth.Balance(), "Unknown outcome - Amount is held")
+	assert.Equal(0.00, auth.TotalCapturedAmount(), "Unknown outcome - Amount is not refundable")
+
+	_, err = auth.Capture(context.Background(), 100.00, "EUR", Details{})
+	assert.Equal(errors.New("Capture failure - Cannot capture more than the remaining amount"), err, "Unknown outcome - Held amount cannot be captured again")
+
+	err = auth.Void(context.Background())
+	assert.Equal(errors.New("Void Failure - Cannot void transaction with captures or refunds of unknown outcome"), err, "Unknown outcome - Cannot void")
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err = auth.Capture(cancelled, 10.00, "EUR", Details{})
+	assert.False(errors.Is(err, bank.ErrUnknownOutcome), "Cancelled - Request never sent")
+	assert.True(errors.Is(err, context.Canceled), "Cancelled - Request never sent")
+	assert.Equal(50.00, auth.Balance(), "Cancelled - Nothing held")
+}
+
+func TestDrainReversals(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+
+	defaultScenarios, defaultTimeout := bank.Scenarios, bank.Timeouts["authorize"]
+	defer func() {
+		bank.Scenarios, bank.Timeouts["authorize"] = defaultScenarios, defaultTimeout
+	}()
+
+	bank.Scenarios = &bank.ScenarioRegistry{
+		Scenarios: []bank.Scenario{
+			{
+				Card: "4000 0000 0000 0408",
+				Operations: []string{"authorize"},
+				Outcome: bank.OutcomeTimeout,
+			},
+			{
+				Card: "4000 0000 0000 0408",
+				Operations: []string{"void"},
+				Latency: &bank.Latency{MinMs: 100, MaxMs: 100},
+				Outcome: bank.OutcomeApprove,
+			},
+		},
+	}
+	bank.Timeouts["authorize"] = 20 * time.Millisecond
+
+	assert.NoError(Drain(context.Background()), "Nothing to drain")
+
+	_, authJSON := getNewTestAuth(&bank.CreditCard{Number: "4000 0000 0000 0408", Expiry: "12/22", Cvv: "123"})
+	_, err := new(GatewayS).NewAuthorization(context.Background(), authJSON, "salt")
+	assert.True(errors.Is(err, bank.ErrUnknownOutcome), "Unknown outcome - Reversal sent in the background")
+
+	expired, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	assert.Equal(context.DeadlineExceeded, Drain(expired), "Deadline - Reversal still running")
+
+	assert.NoError(Drain(context.Background()), "Reversal finished")
+}
+
+func TestNewAuthorizationFailover(t *testing.T) {
+	assert := assert.New(t)
+
+	defer bank.ConfigureAcquirers(bank.DefaultRoutingConfig(), bank.DefaultBreakerSettings(), bank.DefaultRetryPolicy())
+
+	err := bank.ConfigureAcquirers(&bank.RoutingConfig{
+		Acquirers: []bank.AcquirerConfig{{Name: "primary"}, {Name: "secondary"}},
+		Default: []string{"primary", "secondary"},
+	}, bank.DefaultBreakerSettings(), bank.RetryPolicy{MaxAttempts: 1})
+	assert.NoError(err)
+
+	primary, _ := bank.GetConnector("primary")
+	primary.Backend = &bank.Simulator{
+		Scenarios: &bank.ScenarioRegistry{
+			Scenarios: []bank.Scenario{{Outcome: bank.OutcomeNetworkError}},
+		},
+	}
+
+	testDB := new(MockDB)
+	db.DB = testDB
+	testDB.On("StoreItem", mock.Anything).Return()
+
+	ctx := auth.WithMerchant(context.Background(), "Checkout")
+
+	newAuth, err := new(GatewayS).NewAuthorization(ctx, testAuthorizationStrings["OK"], "salt")
+	assert.NoError(err, "Failover - Authorized")
+	assert.Equal("secondary", newAuth.Acquirer, "Failover - Secondary acquirer recorded")
+	assert.Equal("Checkout", newAuth.Merchant, "Failover - Merchant recorded")
+
+	_, err = newAuth.Capture(context.Background(), 10.00, "EUR", Details{})
+	assert.NoError(err, "Failover - Capture sticks to the secondary acquirer")
+
+	assert.Equal(int64(0), primary.Breaker.Status().Successes, "Failover - Primary only saw the failed authorization")
+	assert.Equal(int64(1), primary.Breaker.Status().Failures, "Failover - Primary only saw the failed authorization")
+}
+
+func TestListAuthorizations(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	testGateway := new(GatewayS)
+
+	ctx := auth.WithMerchant(context.Background(), "Checkout")
+	otherCtx := auth.WithMerchant(context.Background(), "Other")
+
+	first, err := testGateway.NewAuthorization(ctx, testAuthorizationStrings["OK"], "first")
+	assert.NoError(err)
+	second, err := testGateway.NewAuthorization(ctx, testAuthorizationStrings["OK"], "second")
+	assert.NoError(err)
+	_, err = testGateway.NewAuthorization(otherCtx, testAuthorizationStrings["OK"], "other")
+	assert.NoError(err)
+
+	_, err = second.Capture(context.Background(), 10.00, "EUR", Details{Reference: "shipment-2"})
+	assert.NoError(err)
+
+	tagged, err := testGateway.NewAuthorization(ctx, []byte(`{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":50,"currency":"EUR","reference":"order-3","metadata":{"channel":"web"}}`), "tagged")
+	assert.NoError(err)
+
+	auths, cursor, err := testGateway.ListAuthorizations(AuthorizationFilter{Merchant: "Checkout", Descending: true})
+	assert.NoError(err)
+	assert.Empty(cursor, "Merchant - Single page")
+	assert.Equal([]*Authorization{tagged, second, first}, auths, "Merchant - Newest first, other merchants excluded")
+
+	auths, _, err = testGateway.ListAuthorizations(AuthorizationFilter{Reference: "shipment-2"})
+	assert.NoError(err)
+	assert.Equal([]*Authorization{second}, auths, "Reference - Capture references are searchable")
+
+	auths, _, err = testGateway.ListAuthorizations(AuthorizationFilter{Reference: "order-3", Metadata: map[string]string{"channel": "web"}})
+	assert.NoError(err)
+	assert.Equal([]*Authorization{tagged}, auths, "Metadata - Reference and metadata match")
+
+	auths, _, err = testGateway.ListAuthorizations(AuthorizationFilter{Metadata: map[string]string{"channel": "store"}})
+	assert.NoError(err)
+	assert.Empty(auths, "Metadata - Different value")
+
+	auths, _, err = testGateway.ListAuthorizations(AuthorizationFilter{Merchant: "Checkout", MaxAmount: &tagged.Amount})
+	assert.NoError(err)
+	assert.Equal([]*Authorization{tagged}, auths, "Amount - Range filter")
+
+	auths, _, err = testGateway.ListAuthorizations(AuthorizationFilter{Merchant: "Checkout", Status: AuthStatusPartiallyCaptured})
+	assert.NoError(err)
+	assert.Equal([]*Authorization{second}, auths, "Status - Capture re-indexed the authorization")
+
+	auths, cursor, err = testGateway.ListAuthorizations(AuthorizationFilter{Merchant: "Checkout", Limit: 1})
+	assert.NoError(err)
+	assert.Equal([]*Authorization{first}, auths, "Pagination - First page")
+	assert.NotEmpty(cursor, "Pagination - More pages")
+
+	auths, cursor, err = testGateway.ListAuthorizations(AuthorizationFilter{Merchant: "Checkout", Limit: 2, Cursor: cursor})
+	assert.NoError(err)
+	assert.Equal([]*Authorization{second, tagged}, auths, "Pagination - Second page")
+	assert.Empty(cursor, "Pagination - Last page")
+
+	_, _, err = testGateway.ListAuthorizations(AuthorizationFilter{SortBy: "card"})
+	assert.Equal(errors.New("List failure - sort must be created_at or amount"), err, "Error - Unknown sort key")
+}
+
+func TestDetailsValidate(t *testing.T) {
+	assert := assert.New(t)
+
+	tooManyKeys := make(map[string]string)
+	for i := 0; i <= MaxMetadataKeys; i++ {
+		tooManyKeys[strconv.Itoa(i)] = "value"
+	}
+
+	tests := []struct{
+		details Details
+		err error
+		description string
+	}{
+		{
+			Details{Reference: "order-1", Description: "T-Shirt", Metadata: map[string]string{"channel": "web"}},
+			nil,
+			"OK - Valid details",
+		},
+		{
+			Details{},
+			nil,
+			"OK - Details are optional",
+		},
+		{
+			Details{Reference: strings.Repeat("a", MaxReferenceLength + 1)},
+			errors.New("Invalid details - reference cannot be longer than 128 characters"),
+			"Error - Reference too long",
+		},
+		{
+			Details{Description: strings.Repeat("a", MaxDescriptionLength + 1)},
+			errors.New("Invalid details - description cannot be longer than 500 characters"),
+			"Error - Description too long",
+		},
+		{
+			Details{Metadata: tooManyKeys},
+			errors.New("Invalid details - metadata cannot have more than 20 keys"),
+			"Error - Too many metadata keys",
+		},
+		{
+			Details{Metadata: map[string]string{strings.Repeat("k", MaxMetadataKeyLength + 1): "value"}},
+			fmt.Errorf("Invalid details - metadata key %q is longer than 40 characters", strings.Repeat("k", MaxMetadataKeyLength + 1)),
+			"Error - Metadata key too long",
+		},
+		{
+			Details{Metadata: map[string]string{"note": strings.Repeat("v", MaxMetadataValueLength + 1)}},
+			errors.New("Invalid details - metadata value of \"note\" is longer than 500 characters"),
+			"Error - Metadata value too long",
+		},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.err, iterTest.details.Validate(), iterTest.description)
+	}
+}
+
+func TestLedgerPostings(t *testing.T) {
+	assert := assert.New(t)
+
+	auth, _ := getNewTestAuth(&bank.CreditCard{
+		Number: "4000 0000 0000 0123",
+		Expiry: "12/22",
+		Cvv: "123",
+	})
+
+	_, err := auth.Capture(context.Background(), 50.00, "EUR", Details{})
+	assert.NoError(err)
+	_, err = auth.Refund(context.Background(), 20.00, "EUR", Details{})
+	assert.NoError(err)
+
+	assert.Equal(170.00, auth.Balance(), "Balance - Refunded amount can be captured again")
+	assert.Equal(30.00, auth.TotalCapturedAmount(), "Refundable - Captured minus refunded")
+
+	err = auth.Void(context.Background())
+	assert.Equal(errors.New("Void Failure - Cannot void transaction with captured amount"), err)
+
+	_, err = auth.Refund(context.Background(), 30.00, "EUR", Details{})
+	assert.NoError(err)
+	assert.NoError(auth.Void(context.Background()))
+	assert.Equal(0.00, auth.Balance(), "Void - Hold released")
+
+	types := []string{}
+	for _, iterEntry := range ledger.Ledger.EntriesOf(auth.Id) {
+		types = append(types, iterEntry.Type)
+	}
+	assert.Equal([]string{ledger.EntryHold, ledger.EntryCapture, ledger.EntryRefund, ledger.EntryRefund, ledger.EntryVoid}, types, "Every movement is posted")
+
+	assert.NoError(ledger.Ledger.Check(), "Ledger invariants hold")
+}
+
+func TestFees(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func() {
+		fees.Pricing = &fees.Config{}
+		merchants.Merchants = &merchants.Registry{}
+		bank.BinCountries = nil
+	}()
+
+	fees.Pricing = &fees.Config{
+		Plans: []fees.Plan{{
+			Name: "enterprise",
+			Rate: fees.Rate{Percentage: 1.5, Fixed: 0.20},
+			CrossBorder: fees.Rate{Percentage: 0.5},
+			RefundPolicy: fees.RefundProportional,
+			RefundFee: fees.Rate{Fixed: 0.10},
+		}},
+	}
+	merchants.Merchants = &merchants.Registry{
+		Merchants: []merchants.Merchant{{Id: "Priced", Country: "GB", PricingPlan: "enterprise"}},
+	}
+	bank.BinCountries = []bank.BinCountry{{From: "400000", To: "400000", Country: "FR"}}
+
+	auth, _ := getNewTestAuth(&bank.CreditCard{
+		Number: "4000 0000 0000 0123",
+		Expiry: "12/22",
+		Cvv: "123",
+	})
+	auth.Merchant = "Priced"
+
+	capture, err := auth.Capture(context.Background(), 100.00, "EUR", Details{})
+	assert.NoError(err)
+	assert.Equal(2.20, capture.Fee, "Capture - Cross-border rate")
+	assert.Equal(97.80, capture.Net(), "Capture - Net of fee")
+
+	refund, err := auth.Refund(context.Background(), 50.00, "EUR", Details{})
+	assert.NoError(err)
+	assert.Equal(-0.90, refund.Fee, "Refund - Percentage given back, minus the refund fee")
+	assert.Equal(-49.10, refund.Net(), "Refund - Net of fee")
+
+	types := []string{}
+	for _, iterEntry := range ledger.Ledger.EntriesOf(auth.Id) {
+		types = append(types, iterEntry.Type)
+	}
+	assert.Equal([]string{ledger.EntryHold, ledger.EntryCapture, ledger.EntryFee, ledger.EntryRefund, ledger.EntryFee}, types, "Fees are posted")
+
+	assert.Equal(-48.70, ledger.Ledger.Balance(ledger.MerchantPending("Priced"), "EUR"), "Merchant pending - Net of fees")
+	assert.NoError(ledger.Ledger.Check(), "Ledger invariants hold")
+}
+
+func TestLockedRate(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	testGateway := new(GatewayS)
+
+	defer func() {
+		fx.Provider = &fx.StaticRates{}
+		merchants.Merchants = &merchants.Registry{}
+	}()
+
+	fx.Provider = &fx.StaticRates{Base: "EUR", Rates: map[string]float64{"USD": 1.25}}
+	merchants.Merchants = &merchants.Registry{
+		Merchants: []merchants.Merchant{
+			{Id: "Converted", SettlementCurrency: "EUR", FxMarkup: 2},
+			{Id: "Unpriced", SettlementCurrency: "JPY"},
+		},
+	}
+
+	body := []byte(`{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"usd"}`)
+
+	_, err := testGateway.NewAuthorization(auth.WithMerchant(context.Background(), "Unpriced"), body, "unpriced")
+	assert.Equal(errors.New("FX failure - No rate for JPY"), err, "Error - No rate to the settlement currency")
+
+	same, err := testGateway.NewAuthorization(auth.WithMerchant(context.Background(), "Other"), body, "same")
+	assert.NoError(err)
+	assert.Nil(same.FX, "Same currency - No rate locked")
+
+	supplied, err := testGateway.NewAuthorization(auth.WithMerchant(context.Background(), "Other"), []byte(`{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"usd","fx":{"rate":2}}`), "supplied")
+	assert.NoError(err)
+	assert.Nil(supplied.FX, "Same currency - Client supplied rate ignored")
+	assert.Equal("USD", same.SettlementCurrency())
+
+	converted, err := testGateway.NewAuthorization(auth.WithMerchant(context.Background(), "Converted"), body, "converted")
+	assert.NoError(err)
+	assert.Equal(0.8, converted.FX.MidRate, "Locked - Mid-market rate")
+	assert.Equal(0.784, converted.FX.Rate, "Locked - Markup taken off")
+
+	// rates moving after the authorization don't change its conversions
+	fx.Provider = &fx.StaticRates{Base: "EUR", Rates: map[string]float64{"USD": 1}}
+
+	capture, err := converted.Capture(context.Background(), 50.00, "USD", Details{})
+	assert.NoError(err)
+	assert.Equal(39.20, capture.SettlementAmount, "Capture - Converted at the locked rate")
+	assert.Equal("EUR", capture.SettlementCurrency)
+
+	refund, err := converted.Refund(context.Background(), 20.00, "USD", Details{})
+	assert.NoError(err)
+	assert.Equal(15.68, refund.SettlementAmount, "Refund - Converted at the locked rate")
+
+	assert.Equal(-23.52, ledger.Ledger.Balance(ledger.MerchantPending("Converted"), "EUR"), "Merchant pending - In the settlement currency")
+	assert.Equal(0.0, ledger.Ledger.Balance(ledger.MerchantPending("Converted"), "USD"), "Merchant pending - Nothing in the presentment currency")
+	assert.NoError(ledger.Ledger.Check(), "Ledger invariants hold")
+}
+
+func TestRiskScreening(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	testGateway := new(GatewayS)
+
+	defaultEngine := risk.Engine
+	defer func() {
+		risk.Engine = defaultEngine
+	}()
+
+	card := &bank.CreditCard{Number: "4000 0000 0000 0123"}
+	risk.Engine = &risk.RuleEngine{
+		Rules: []risk.RuleI{&risk.AmountRule{Max: 500, Score: 60}},
+		Block: risk.List{{Type: risk.EntryCard, Value: card.Fingerprint()}},
+		Allow: risk.List{{Type: risk.EntryEmail, Value: "qa@example.com"}},
+	}
+
+	tests := []struct{
+		body string
+		status string
+		decision string
+		description string
+	}{
+		{
+			`{"credit_card":{"number":"4000 0000 0000 0259","expiry":"12/22","cvv":"123"},"amount":100,"currency":"EUR"}`,
+			AuthStatusAuthorized,
+			risk.DecisionAllow,
+			"Allow - No rule triggered",
+		},
+		{
+			`{"credit_card":{"number":"4000 0000 0000 0259","expiry":"12/22","cvv":"123"},"amount":1000,"currency":"EUR"}`,
+			AuthStatusAuthorized,
+			risk.DecisionReview,
+			"Review - Authorized and flagged",
+		},
+		{
+			`{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"EUR","acquirer":"sim"}`,
+			AuthStatusDeclined,
+			risk.DecisionBlock,
+			"Block - Block listed card",
+		},
+		{
+			`{"credit_card":{"number":"4000 0000 0000 0259","expiry":"12/22","cvv":"123"},"amount":1000,"currency":"EUR","customer":{"email":"QA@example.com"}}`,
+			AuthStatusAuthorized,
+			risk.DecisionAllow,
+			"Allow - Allow list skips rules",
+		},
+		{
+			`{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"EUR","customer":{"email":"QA@example.com"}}`,
+			AuthStatusDeclined,
+			risk.DecisionBlock,
+			"Block - Allow listed email doesn't lift a block",
+		},
+	}
+
+	for i, iterTest := range tests {
+		newAuth, err := testGateway.NewAuthorization(auth.WithMerchant(context.Background(), "Screened"), []byte(iterTest.body), strconv.Itoa(i))
+		assert.NoError(err, iterTest.description)
+		assert.Equal(iterTest.status, newAuth.Status, iterTest.description)
+		assert.Equal(iterTest.decision, newAuth.Risk.Decision, iterTest.description)
+
+		if iterTest.decision == risk.DecisionBlock {
+			assert.Equal(DeclineCardBlocked, newAuth.DeclineCode, iterTest.description)
+			assert.Empty(newAuth.Acquirer, "Block - Never sent to an acquirer")
+			assert.Equal(0.0, newAuth.Balance(), "Block - No hold")
+		}
+	}
+
+	_, err := testGateway.NewAuthorization(context.Background(), []byte(`{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"EUR","customer":{"ip":"not-an-ip"}}`), "invalid")
+	assert.Equal(errors.New("Invalid customer - ip is not a valid IP address"), err)
+
+	risk.Engine = &risk.RuleEngine{Rules: []risk.RuleI{&risk.AmountRule{Max: 500, Score: 90}}}
+	scored, err := testGateway.NewAuthorization(auth.WithMerchant(context.Background(), "Screened"), []byte(`{"credit_card":{"number":"4000 0000 0000 0259","expiry":"12/22","cvv":"123"},"amount":1000,"currency":"EUR"}`), "scored")
+	assert.NoError(err)
+	assert.Equal(DeclineRiskBlocked, scored.DeclineCode, "Block - Score over the block threshold")
+	assert.Equal(1.0, testutil.ToFloat64(metrics.Payments.WithLabelValues("authorize", metrics.OutcomeBlocked, "EUR", DeclineRiskBlocked)), "Metrics - Blocked authorizations counted")
+
+	reviewed, _, err := testGateway.ListAuthorizations(AuthorizationFilter{Merchant: "Screened", RiskDecision: risk.DecisionReview})
+	assert.NoError(err)
+	assert.Len(reviewed, 1, "Listing - Filtered by risk decision")
+}
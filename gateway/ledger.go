@@ -0,0 +1,164 @@
+package gateway
+
+import (
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/ledger"
+)
+
+// Every money movement of an authorization is posted to the ledger, with auth.mu held.
+//
+//   hold             Dr customer_hold      Cr issuer_hold
+//   capture          Dr issuer_hold        Cr customer_hold
+//                    Dr refundable_control Cr refundable
+//                    Dr acquirer_receivable Cr merchant_pending
+//   capture_unknown  Dr issuer_hold        Cr customer_hold
+//   refund           Dr refundable         Cr refundable_control
+//                    Dr customer_hold      Cr issuer_hold
+//                    Dr merchant_pending   Cr acquirer_receivable
+//   refund_unknown   Dr refundable         Cr refundable_control
+//   void             Dr issuer_hold        Cr customer_hold
+//   fee              Dr merchant_pending   Cr fees, the other way around for fees given back
+//   dispute          Dr refundable         Cr refundable_control
+//                    Dr merchant_pending   Cr acquirer_receivable
+//   dispute_reversal the other way around, when the merchant wins the dispute
+//
+// Captures of unknown outcome take from the hold, refunds of unknown outcome from the refundable amount,
+// neither moves money until their outcome is known.
+//
+// When the merchant settles in another currency, merchant_pending is replaced by fx_clearing in the capture
+// and refund entries, and a conversion entry in the settlement currency moves the converted amount
+// between fx_clearing and merchant_pending. Fees are posted in the settlement currency.
+
+func (auth *Authorization) postHold() {
+	auth.post(ledger.EntryHold, []ledger.Posting{
+		{Account: ledger.CustomerHold(auth.Id), Amount: auth.Amount},
+		{Account: ledger.IssuerHold, Amount: -auth.Amount},
+	})
+}
+
+func (auth *Authorization) postCapture(capture *Capture) {
+	amount := capture.Amount
+
+	postings := []ledger.Posting{
+		{Account: ledger.IssuerHold, Amount: amount},
+		{Account: ledger.CustomerHold(auth.Id), Amount: -amount},
+	}
+
+	if capture.Status == StatusUnknown {
+		auth.post(ledger.EntryCaptureUnknown, postings)
+		return
+	}
+
+	postings = append(postings,
+		ledger.Posting{Account: ledger.RefundableControl, Amount: amount},
+		ledger.Posting{Account: ledger.Refundable(auth.Id), Amount: -amount},
+		ledger.Posting{Account: ledger.AcquirerReceivable, Amount: amount},
+		ledger.Posting{Account: auth.merchantAccount(), Amount: -amount},
+	)
+
+	auth.post(ledger.EntryCapture, postings)
+	auth.postConversion(capture.SettlementAmount)
+}
+
+func (auth *Authorization) postRefund(refund *Refund) {
+	amount := refund.Amount
+
+	postings := []ledger.Posting{
+		{Account: ledger.Refundable(auth.Id), Amount: amount},
+		{Account: ledger.RefundableControl, Amount: -amount},
+	}
+
+	if refund.Status == StatusUnknown {
+		auth.post(ledger.EntryRefundUnknown, postings)
+		return
+	}
+
+	postings = append(postings,
+		ledger.Posting{Account: ledger.CustomerHold(auth.Id), Amount: amount},
+		ledger.Posting{Account: ledger.IssuerHold, Amount: -amount},
+		ledger.Posting{Account: auth.merchantAccount(), Amount: amount},
+		ledger.Posting{Account: ledger.AcquirerReceivable, Amount: -amount},
+	)
+
+	auth.post(ledger.EntryRefund, postings)
+	auth.postConversion(0 - refund.SettlementAmount)
+}
+
+func (auth *Authorization) postDispute(amount float64, reversal bool) {
+	entryType := ledger.EntryDispute
+	if reversal {
+		entryType = ledger.EntryDisputeReversal
+		amount = -amount
+	}
+
+	auth.post(entryType, []ledger.Posting{
+		{Account: ledger.Refundable(auth.Id), Amount: amount},
+		{Account: ledger.RefundableControl, Amount: -amount},
+		{Account: auth.merchantAccount(), Amount: amount},
+		{Account: ledger.AcquirerReceivable, Amount: -amount},
+	})
+	auth.postConversion(0 - auth.Convert(amount))
+}
+
+func (auth *Authorization) postVoid(amount float64) {
+	auth.post(ledger.EntryVoid, []ledger.Posting{
+		{Account: ledger.IssuerHold, Amount: amount},
+		{Account: ledger.CustomerHold(auth.Id), Amount: -amount},
+	})
+}
+
+func (auth *Authorization) postFee(fee float64) {
+	if fee == 0 {
+		return
+	}
+
+	auth.postIn(ledger.EntryFee, auth.SettlementCurrency(), []ledger.Posting{
+		{Account: ledger.MerchantPending(auth.Merchant), Amount: fee},
+		{Account: ledger.Fees, Amount: -fee},
+	})
+}
+
+// postConversion credits the merchant with a converted amount, a debit when negative
+func (auth *Authorization) postConversion(amount float64) {
+	if auth.FX == nil || amount == 0 {
+		return
+	}
+
+	auth.postIn(ledger.EntryConversion, auth.SettlementCurrency(), []ledger.Posting{
+		{Account: ledger.FxClearing, Amount: amount},
+		{Account: ledger.MerchantPending(auth.Merchant), Amount: -amount},
+	})
+}
+
+// merchantAccount takes the merchant's side of captures and refunds in the authorization's currency
+func (auth *Authorization) merchantAccount() string {
+	if auth.FX != nil {
+		return ledger.FxClearing
+	}
+
+	return ledger.MerchantPending(auth.Merchant)
+}
+
+func (auth *Authorization) post(entryType string, postings []ledger.Posting) {
+	auth.postIn(entryType, auth.Currency, postings)
+}
+
+func (auth *Authorization) postIn(entryType string, currency string, postings []ledger.Posting) {
+	err := ledger.Ledger.Post(&ledger.Entry{
+		Type: entryType,
+		AuthorizationId: auth.Id,
+		Merchant: auth.Merchant,
+		Currency: currency,
+		Postings: postings,
+	})
+
+	// the acquirer already answered, the operation stands even if the books are off
+	if err != nil {
+		log.WithFields(log.Fields{
+			"auth": auth.Id,
+			"type": entryType,
+			"err": err,
+		}).Error("Authorization.postIn - Error posting ledger entry")
+	}
+}
@@ -0,0 +1,31 @@
+package gateway
+
+import (
+	"errors"
+
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/metrics"
+)
+
+// recordOutcome counts an operation sent to an acquirer, labelled with its outcome
+func recordOutcome(operation string, currency string, err error) {
+	outcome, declineCode := metrics.OutcomeApproved, ""
+
+	var declineErr *bank.DeclineError
+	switch {
+	case err == nil:
+	case errors.As(err, &declineErr):
+		outcome, declineCode = metrics.OutcomeDeclined, declineErr.Code
+	case errors.Is(err, bank.ErrUnknownOutcome):
+		outcome = metrics.OutcomeUnknown
+	default:
+		outcome = metrics.OutcomeError
+	}
+
+	metrics.Payments.WithLabelValues(operation, outcome, metrics.CurrencyLabel(currency), metrics.DeclineCodeLabel(declineCode)).Inc()
+}
+
+// recordAmount adds a succeeded capture or refund to the amount totals
+func recordAmount(operation string, currency string, amount float64) {
+	metrics.Amounts.WithLabelValues(operation, metrics.CurrencyLabel(currency)).Add(amount)
+}
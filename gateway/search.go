@@ -0,0 +1,289 @@
+package gateway
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/fx"
+)
+
+// Sort keys accepted when listing authorizations
+const (
+	SortCreatedAt = "created_at"
+	SortAmount = "amount"
+)
+
+const (
+	DefaultListLimit = 20
+	MaxListLimit = 100
+)
+
+// AuthorizationFilter narrows a listing, zero values don't filter
+type AuthorizationFilter struct {
+	Merchant string
+	Acquirer string
+	Status string
+	Currency string
+	CardLast4 string
+	CardBrand string
+	// RiskDecision is the decision of the authorization's risk assessment: allow, review or block
+	RiskDecision string
+	// Reference matches the authorization's reference or the reference of any of its captures and refunds
+	Reference string
+	// AcquirerReference matches the acquirer reference of the authorization or of any of its captures and refunds
+	AcquirerReference string
+	// Metadata matches authorizations holding all of these key/value pairs
+	Metadata map[string]string
+
+	MinAmount *float64
+	MaxAmount *float64
+	CreatedFrom *time.Time
+	CreatedTo *time.Time
+
+	SortBy string
+	Descending bool
+	Limit int
+	Cursor string
+}
+
+// AuthorizationSummary is the listing view of an authorization, the card is masked
+type AuthorizationSummary struct {
+	Id string								`json:"id"`
+	Merchant string					`json:"merchant,omitempty"`
+	Acquirer string					`json:"acquirer"`
+	Status string						`json:"status"`
+	Amount float64					`json:"amount"`
+	Currency string					`json:"currency"`
+	FX *fx.LockedRate				`json:"fx,omitempty"`
+	CardBrand string				`json:"card_brand"`
+	CardLast4 string				`json:"card_last4"`
+	// CardFingerprint identifies the card in block and allow lists
+	CardFingerprint string	`json:"card_fingerprint"`
+	Reference string				`json:"reference,omitempty"`
+	Description string			`json:"description,omitempty"`
+	Metadata map[string]string	`json:"metadata,omitempty"`
+	CapturedAmount float64	`json:"captured_amount"`
+	RefundedAmount float64	`json:"refunded_amount"`
+	ApprovalCode string			`json:"approval_code,omitempty"`
+	DeclineCode string			`json:"decline_code,omitempty"`
+	RiskDecision string			`json:"risk_decision,omitempty"`
+	RiskScore int						`json:"risk_score"`
+	CreatedAt time.Time			`json:"created_at"`
+}
+
+const authorizationKind = "authorization"
+
+func (g *GatewayS) ListAuthorizations(filter AuthorizationFilter) ([]*Authorization, string, error) {
+	query, err := filter.query()
+	if err != nil {
+		log.WithField("err", err).Error("ListAuthorizations - Invalid filter")
+		return nil, "", err
+	}
+
+	items, cursor, err := db.DB.QueryItems(*query)
+	if err != nil {
+		log.WithField("err", err).Error("ListAuthorizations - Error querying authorizations")
+		return nil, "", err
+	}
+
+	auths := make([]*Authorization, 0, len(items))
+	for _, iterItem := range items {
+		if auth, ok := iterItem.(*Authorization); ok {
+			auths = append(auths, auth)
+		}
+	}
+
+	return auths, cursor, nil
+}
+
+func (filter *AuthorizationFilter) query() (*db.Query, error) {
+	query := &db.Query{
+		Equals: map[string]string{"kind": authorizationKind},
+		Ranges: make(map[string]db.Range),
+		SortBy: filter.SortBy,
+		Descending: filter.Descending,
+		Limit: filter.Limit,
+		Cursor: filter.Cursor,
+	}
+
+	switch query.SortBy {
+	case "":
+		query.SortBy = SortCreatedAt
+	case SortCreatedAt, SortAmount:
+	default:
+		return nil, errors.New("List failure - sort must be created_at or amount")
+	}
+
+	switch {
+	case query.Limit == 0:
+		query.Limit = DefaultListLimit
+	case query.Limit < 0 || query.Limit > MaxListLimit:
+		return nil, errors.New("List failure - limit must be between 1 and 100")
+	}
+
+	equals := map[string]string{
+		"merchant": filter.Merchant,
+		"acquirer": filter.Acquirer,
+		"status": filter.Status,
+		"currency": strings.ToUpper(filter.Currency),
+		"card_last4": filter.CardLast4,
+		"card_brand": strings.ToLower(filter.CardBrand),
+		"risk_decision": strings.ToLower(filter.RiskDecision),
+	}
+	for key, value := range filter.Metadata {
+		equals[metadataField(key)] = value
+	}
+	equals["reference"] = filter.Reference
+	equals["acquirer_reference"] = filter.AcquirerReference
+
+	for field, value := range equals {
+		if value != "" {
+			query.Equals[field] = value
+		}
+	}
+
+	if filter.MinAmount != nil || filter.MaxAmount != nil {
+		if filter.MinAmount != nil && filter.MaxAmount != nil && *filter.MinAmount > *filter.MaxAmount {
+			return nil, errors.New("List failure - min_amount is greater than max_amount")
+		}
+		query.Ranges[SortAmount] = db.Range{Min: filter.MinAmount, Max: filter.MaxAmount}
+	}
+
+	if filter.CreatedFrom != nil || filter.CreatedTo != nil {
+		var bounds db.Range
+		if filter.CreatedFrom != nil {
+			from := timeValue(*filter.CreatedFrom)
+			bounds.Min = &from
+		}
+		if filter.CreatedTo != nil {
+			to := timeValue(*filter.CreatedTo)
+			bounds.Max = &to
+		}
+		if bounds.Min != nil && bounds.Max != nil && *bounds.Min > *bounds.Max {
+			return nil, errors.New("List failure - created_from is after created_to")
+		}
+		query.Ranges[SortCreatedAt] = bounds
+	}
+
+	return query, nil
+}
+
+// IndexValues is called by the storage layer while the authorization is being stored,
+// which happens with auth.mu held, so it must not lock.
+func (auth *Authorization) IndexValues() map[string][]string {
+	values := map[string][]string{
+		"kind": {authorizationKind},
+		"status": {auth.Status},
+		"currency": {strings.ToUpper(auth.Currency)},
+	}
+
+	if auth.Merchant != "" {
+		values["merchant"] = []string{auth.Merchant}
+	}
+
+	if auth.Acquirer != "" {
+		values["acquirer"] = []string{auth.Acquirer}
+	}
+
+	if auth.Risk != nil {
+		values["risk_decision"] = []string{auth.Risk.Decision}
+	}
+
+	if auth.CreditCard != nil {
+		values["card_last4"] = []string{auth.CreditCard.Last4()}
+		values["card_brand"] = []string{auth.CreditCard.Brand()}
+	}
+
+	references := []string{}
+	if auth.Reference != "" {
+		references = append(references, auth.Reference)
+	}
+	for _, iterCapture := range auth.captures {
+		if iterCapture.Reference != "" {
+			references = append(references, iterCapture.Reference)
+		}
+	}
+	for _, iterRefund := range auth.refunds {
+		if iterRefund.Reference != "" {
+			references = append(references, iterRefund.Reference)
+		}
+	}
+	values["reference"] = references
+
+	acquirerReferences := []string{}
+	if auth.AcquirerReference != "" {
+		acquirerReferences = append(acquirerReferences, auth.AcquirerReference)
+	}
+	for _, iterCapture := range auth.captures {
+		if iterCapture.AcquirerReference != "" {
+			acquirerReferences = append(acquirerReferences, iterCapture.AcquirerReference)
+		}
+	}
+	for _, iterRefund := range auth.refunds {
+		if iterRefund.AcquirerReference != "" {
+			acquirerReferences = append(acquirerReferences, iterRefund.AcquirerReference)
+		}
+	}
+	values["acquirer_reference"] = acquirerReferences
+
+	for key, value := range auth.Metadata {
+		values[metadataField(key)] = []string{value}
+	}
+
+	return values
+}
+
+func (auth *Authorization) SortValues() map[string]float64 {
+	return map[string]float64{
+		SortCreatedAt: timeValue(auth.CreatedAt),
+		SortAmount: auth.Amount,
+	}
+}
+
+func (auth *Authorization) Summary() *AuthorizationSummary {
+	auth.mu.Lock()
+	defer auth.mu.Unlock()
+
+	summary := &AuthorizationSummary{
+		Id: auth.Id,
+		Merchant: auth.Merchant,
+		Acquirer: auth.Acquirer,
+		Status: auth.Status,
+		Amount: auth.Amount,
+		Currency: auth.Currency,
+		FX: auth.FX,
+		CapturedAmount: auth.capturedAmount(false),
+		RefundedAmount: auth.refundedAmount(false),
+		ApprovalCode: auth.ApprovalCode,
+		DeclineCode: auth.DeclineCode,
+		Reference: auth.Reference,
+		Description: auth.Description,
+		Metadata: auth.Metadata,
+		CreatedAt: auth.CreatedAt,
+	}
+
+	if auth.Risk != nil {
+		summary.RiskDecision = auth.Risk.Decision
+		summary.RiskScore = auth.Risk.Score
+	}
+
+	if auth.CreditCard != nil {
+		summary.CardBrand = auth.CreditCard.Brand()
+		summary.CardFingerprint = auth.CreditCard.Fingerprint()
+		summary.CardLast4 = auth.CreditCard.Last4()
+	} else {
+		summary.CardBrand = bank.BrandUnknown
+	}
+
+	return summary
+}
+
+// timestamps are indexed in microseconds, float64 holds them exactly
+func timeValue(t time.Time) float64 {
+	return float64(t.UnixNano() / int64(time.Microsecond))
+}
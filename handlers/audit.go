@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "github.com/nktsitas/checkout-techlab/api/v1"
+	"github.com/nktsitas/checkout-techlab/audit"
+)
+
+// ExportAudit godoc
+// @Summary Query and export the audit log
+// @Description Audit records of logins, payment operations and admin actions, oldest first, with the verification of the hash chain. format=jsonl exports one record per line. Requires an admin token.
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param actor query string false "Authenticated client, or the username of a login"
+// @Param merchant query string false "Merchant"
+// @Param operation query string false "login, authorize, capture, refund, void, dispute.notification, dispute.evidence, risk_list.view, risk_list.add, risk_list.remove or audit.export"
+// @Param target query string false "Id of the object operated on, e.g. an authorization"
+// @Param created_from query string false "RFC3339 timestamp, inclusive"
+// @Param created_to query string false "RFC3339 timestamp, inclusive"
+// @Param format query string false "json (default) or jsonl"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {object} v1.AuditResponse
+// @Failure 403 {string} string "Forbidden"
+// @Router /v1/admin/audit [get]
+func ExportAuditHandler(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+
+	filter := audit.Filter{
+		Actor: params.Get("actor"),
+		Merchant: params.Get("merchant"),
+		Operation: params.Get("operation"),
+		Target: params.Get("target"),
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(params.Get("created_from"), "created_from"); err != nil {
+		log.WithField("err", err).Error("ExportAuditHandler - Invalid query")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if filter.To, err = parseTimeParam(params.Get("created_to"), "created_to"); err != nil {
+		log.WithField("err", err).Error("ExportAuditHandler - Invalid query")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	format := params.Get("format")
+	if format != "" && format != "json" && format != "jsonl" {
+		log.WithField("format", format).Error("ExportAuditHandler - Invalid format")
+		http.Error(w, "List failure - format must be json or jsonl", http.StatusBadRequest)
+		return
+	}
+
+	records, err := audit.Records(filter)
+	if err != nil {
+		log.WithField("err", err).Error("ExportAuditHandler - Error listing records")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if format == "jsonl" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit.jsonl"`)
+
+		encoder := json.NewEncoder(w)
+		for _, iterRecord := range records {
+			encoder.Encode(iterRecord)
+		}
+		return
+	}
+
+	verification, err := audit.Verify()
+	if err != nil {
+		log.WithField("err", err).Error("ExportAuditHandler - Error verifying the chain")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, &v1.AuditResponse{
+		Verification: verification,
+		Records: records,
+	})
+}
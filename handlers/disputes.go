@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+
+	v1 "github.com/nktsitas/checkout-techlab/api/v1"
+	"github.com/nktsitas/checkout-techlab/audit"
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/disputes"
+	"github.com/nktsitas/checkout-techlab/events"
+)
+
+// DisputeNotification godoc
+// @Summary Receive a dispute notification from an acquirer
+// @Description Simulates an acquirer notifying a dispute: opened on one of its captures (the disputed amount is debited from the merchant), or decided (won disputes are credited back). Needs the admin role.
+// @Tags disputes
+// @Accept  json
+// @Produce  json
+// @Param notification body disputes.Notification true "Acquirer notification"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {object} disputes.Dispute
+// @Failure 403 {string} string "Forbidden"
+// @Router /v1/admin/disputes/notifications [post]
+func DisputeNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	var notification disputes.Notification
+	if err := json.NewDecoder(r.Body).Decode(&notification); err != nil {
+		log.WithField("err", err).Error("DisputeNotificationHandler - Error reading body")
+		http.Error(w, "Can't read body", http.StatusBadRequest)
+		return
+	}
+
+	dispute, err := disputes.Notify(notification)
+	if err != nil {
+		log.WithField("err", err).Error("DisputeNotificationHandler - Error applying notification")
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	audit.Target(r.Context(), dispute.Id)
+	writeResponse(w, dispute.Snapshot())
+}
+
+// ListDisputes godoc
+// @Summary List the merchant's disputes
+// @Description List the disputes of the authenticated merchant, newest first
+// @Tags disputes
+// @Accept  json
+// @Produce  json
+// @Param status query string false "needs_response, under_review, won or lost"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {array} disputes.Dispute
+// @Router /v1/disputes [get]
+func ListDisputesHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := disputes.List(auth.MerchantFromContext(r.Context()), r.URL.Query().Get("status"))
+	if err != nil {
+		log.WithField("err", err).Error("ListDisputesHandler - Error listing disputes")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	snapshots := make([]*disputes.Dispute, 0, len(result))
+	for _, iterDispute := range result {
+		snapshots = append(snapshots, iterDispute.Snapshot())
+	}
+
+	writeResponse(w, snapshots)
+}
+
+// GetDispute godoc
+// @Summary Get a dispute
+// @Description Get a dispute of the authenticated merchant
+// @Tags disputes
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Dispute id"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {object} disputes.Dispute
+// @Failure 404 {string} string "Dispute not found"
+// @Router /v1/disputes/{id} [get]
+func GetDisputeHandler(w http.ResponseWriter, r *http.Request) {
+	dispute := merchantDispute(r)
+	if dispute == nil {
+		log.WithField("id", mux.Vars(r)["id"]).Error("GetDisputeHandler - Dispute not found")
+		http.Error(w, disputes.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	writeResponse(w, dispute.Snapshot())
+}
+
+// SubmitEvidence godoc
+// @Summary Submit evidence to challenge a dispute
+// @Description Submit evidence before the dispute's evidence_due_by deadline, the dispute goes under review
+// @Tags disputes
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Dispute id"
+// @Param evidence body v1.EvidenceRequest true "Evidence"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {object} disputes.Dispute
+// @Failure 404 {string} string "Dispute not found"
+// @Router /v1/disputes/{id}/evidence [post]
+func SubmitEvidenceHandler(w http.ResponseWriter, r *http.Request) {
+	audit.Target(r.Context(), mux.Vars(r)["id"])
+	dispute := merchantDispute(r)
+	if dispute == nil {
+		log.WithField("id", mux.Vars(r)["id"]).Error("SubmitEvidenceHandler - Dispute not found")
+		http.Error(w, disputes.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	var req v1.EvidenceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.WithField("err", err).Error("SubmitEvidenceHandler - Error reading body")
+		http.Error(w, "Can't read body", http.StatusBadRequest)
+		return
+	}
+
+	if err := dispute.SubmitEvidence(req.Evidence); err != nil {
+		log.WithField("err", err).Error("SubmitEvidenceHandler - Error submitting evidence")
+		http.Error(w, err.Error(), disputeErrorStatus(err))
+		return
+	}
+
+	writeResponse(w, dispute.Snapshot())
+}
+
+// ListEvents godoc
+// @Summary List the merchant's events
+// @Description List the events sent to the authenticated merchant's webhook, newest first
+// @Tags events
+// @Accept  json
+// @Produce  json
+// @Param type query string false "Event type, e.g. dispute.opened"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {array} events.Event
+// @Router /v1/events [get]
+func ListEventsHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := events.List(auth.MerchantFromContext(r.Context()), r.URL.Query().Get("type"))
+	if err != nil {
+		log.WithField("err", err).Error("ListEventsHandler - Error listing events")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, result)
+}
+
+// merchantDispute returns the dispute of the request's path, nil unless it belongs to the authenticated merchant
+func merchantDispute(r *http.Request) *disputes.Dispute {
+	dispute := disputes.Get(mux.Vars(r)["id"])
+	if dispute == nil || dispute.Merchant != auth.MerchantFromContext(r.Context()) {
+		return nil
+	}
+
+	return dispute
+}
+
+func disputeErrorStatus(err error) int {
+	switch err {
+	case disputes.ErrNotFound, disputes.ErrUnknownCapture:
+		return http.StatusNotFound
+	case disputes.ErrDeadlinePassed:
+		return http.StatusConflict
+	}
+
+	return http.StatusBadRequest
+}
@@ -3,38 +3,25 @@ package handlers
 import (
 	"net/http"
 	"io"
-	// "time"
+	"time"
 	"io/ioutil"
-	// "strconv"
+	"strconv"
+	"strings"
 	"reflect"
 	"encoding/json"
+	"errors"
 
 	log "github.com/sirupsen/logrus"
 
+	v1 "github.com/nktsitas/checkout-techlab/api/v1"
+	"github.com/nktsitas/checkout-techlab/audit"
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/bank"
 	"github.com/nktsitas/checkout-techlab/gateway"
 	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/logger"
 )
 
-type requestParams struct {
-	Id string `json:"id" example:"unique_authorization_id"`
-	Amount float64 `json:"amount" example:"100.00"`
-}
-
-type voidRequestParams struct {
-	Id string `json:"id" example:"unique_authorization_id"`
-}
-
-type authResponse struct {
-	Id string `json:"id" example:"unique_authorization_id"`
-	Amount float64 `json:"amount" example:"100.00"`
-	Currency string `json:"currency" example:"EUR"`
-}
-
-type actionsResponse struct {
-	Amount float64 `json:"amount" example:"100.00"`
-	Currency string `json:"currency" example:"EUR"`
-}
-
 // --- --- ---
 
 // Ping godoc
@@ -52,6 +39,18 @@ func Ping(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, `Pong!`)
 }
 
+// AcquirerStatus godoc
+// @Summary Get the circuit breaker state of each acquirer
+// @Description Get the circuit breaker state of each acquirer, so on-call can see when we are failing fast
+// @Tags status
+// @Accept  json
+// @Produce  json
+// @Success 200 {array} bank.BreakerStatus
+// @Router /status/acquirers [get]
+func AcquirerStatus(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, bank.Statuses())
+}
+
 // CreateAuthorization godoc
 // @Summary Creates a new authorization
 // @Description Creates a new authorization
@@ -60,8 +59,12 @@ func Ping(w http.ResponseWriter, r *http.Request) {
 // @Produce  json
 // @Param authorization body gateway.Authorization true "Create authorization"
 // @Param Token header string true "generated.jwt.token"
-// @Success 200 {object} authResponse
-// @Router /authorize [post]
+// @Success 200 {object} v1.AuthResponse
+// @Failure 402 {object} v1.AuthResponse "Declined - the authorization is stored with status declined"
+// @Failure 502 {string} string "Acquirer unreachable"
+// @Failure 503 {string} string "Acquirer unavailable, failing fast"
+// @Failure 504 {string} string "No answer from acquirer, the authorization is reversed"
+// @Router /v1/authorize [post]
 func CreateAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -72,17 +75,32 @@ func CreateAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
 
 	salt := gateway.Gateway.GetSalt()
 	
-	auth, err := gateway.Gateway.NewAuthorization(body, salt)
+	auth, err := gateway.Gateway.NewAuthorization(r.Context(), body, salt)
 	if err != nil {
 		log.WithField("err", err).Error("CreateAuthorizationHandler - Error Creating Authorization")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
+	logger.Annotate(r.Context(), "auth_id", auth.Id)
+	audit.Target(r.Context(), auth.Id)
 
-	resp := &authResponse{
+	resp := &v1.AuthResponse{
 		Id: auth.Id,
 		Amount: auth.Amount,
 		Currency: auth.GetCurrency(),
+		Status: auth.Status,
+		ApprovalCode: auth.ApprovalCode,
+		AcquirerReference: auth.AcquirerReference,
+		DeclineCode: auth.DeclineCode,
+		DeclineReason: auth.DeclineReason,
+		FX: auth.FX,
+		Risk: auth.Risk,
+		Details: auth.Details,
+	}
+
+	if auth.Status == gateway.AuthStatusDeclined {
+		writeResponseStatus(w, http.StatusPaymentRequired, resp)
+		return
 	}
 
 	writeResponse(w, resp)
@@ -94,12 +112,12 @@ func CreateAuthorizationHandler(w http.ResponseWriter, r *http.Request) {
 // @Tags status
 // @Accept  json
 // @Produce  json
-// @Param captureRequest body requestParams true "Capture Amount"
+// @Param captureRequest body v1.RequestParams true "Capture Amount"
 // @Param Token header string true "generated.jwt.token"
-// @Success 200 {object} actionsResponse
-// @Router /capture [post]
+// @Success 200 {object} v1.ActionsResponse
+// @Router /v1/capture [post]
 func CaptureHandler(w http.ResponseWriter, r *http.Request) {
-	var req requestParams
+	var req v1.RequestParams
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
 			log.WithField("err", err).Error("CaptureHandler - Error reading body")
@@ -107,25 +125,30 @@ func CaptureHandler(w http.ResponseWriter, r *http.Request) {
 			return
 	}
 
-	authI := db.DB.FetchItem(req.Id)
-
-	if authI == nil || reflect.ValueOf(authI).IsNil() {
+	logger.Annotate(r.Context(), "auth_id", req.Id)
+	audit.Target(r.Context(), req.Id)
+	auth := merchantAuthorization(r, req.Id)
+	if auth == nil {
 		log.WithField("id", req.Id).Error("CaptureHandler - Wrong auth Id")
 		http.Error(w, "Wrong auth Id", http.StatusBadRequest)
 		return
 	}
 
-	auth := authI.(gateway.AuthorizationI)
-	capture, err := auth.Capture(req.Amount, auth.GetCurrency())
+	capture, err := auth.Capture(r.Context(), req.Amount, auth.GetCurrency(), req.Details)
 	if err != nil {
 		log.WithField("err", err).Error("CaptureHandler - Error in Capture")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
-	resp := &actionsResponse{
+	resp := &v1.ActionsResponse{
 		Amount: capture.Amount,
 		Currency: auth.GetCurrency(),
+		SettlementAmount: capture.SettlementAmount,
+		SettlementCurrency: capture.SettlementCurrency,
+		Fee: capture.Fee,
+		Net: capture.Net(),
+		Details: capture.Details,
 	}
 
 	writeResponse(w, resp)
@@ -137,12 +160,12 @@ func CaptureHandler(w http.ResponseWriter, r *http.Request) {
 // @Tags status
 // @Accept  json
 // @Produce  json
-// @Param voidRequest body voidRequestParams true "Refund Amount"
+// @Param voidRequest body v1.VoidRequestParams true "Refund Amount"
 // @Param Token header string true "generated.jwt.token"
-// @Success 200 {object} actionsResponse
-// @Router /void [post]
+// @Success 200 {object} v1.ActionsResponse
+// @Router /v1/void [post]
 func VoidHandler(w http.ResponseWriter, r *http.Request) {
-	var req voidRequestParams
+	var req v1.VoidRequestParams
 	var err error
 	err = json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -151,23 +174,23 @@ func VoidHandler(w http.ResponseWriter, r *http.Request) {
 			return
 	}
 
-	authI := db.DB.FetchItem(req.Id)
-
-	if authI == nil || reflect.ValueOf(authI).IsNil() {
+	logger.Annotate(r.Context(), "auth_id", req.Id)
+	audit.Target(r.Context(), req.Id)
+	auth := merchantAuthorization(r, req.Id)
+	if auth == nil {
 		log.WithField("id", req.Id).Error("VoidHandler - Wrong auth Id")
 		http.Error(w, "Wrong auth Id", http.StatusBadRequest)
 		return
 	}
 
-	auth := authI.(gateway.AuthorizationI)
-	err = auth.Void()
+	err = auth.Void(r.Context())
 	if err != nil {
 		log.WithField("err", err).Error("VoidHandler - Error executing void")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
-	resp := &actionsResponse{
+	resp := &v1.ActionsResponse{
 		Amount: 0,
 		Currency: auth.GetCurrency(),
 	}
@@ -181,12 +204,12 @@ func VoidHandler(w http.ResponseWriter, r *http.Request) {
 // @Tags status
 // @Accept  json
 // @Produce  json
-// @Param refundRequest body requestParams true "Refund Amount"
+// @Param refundRequest body v1.RequestParams true "Refund Amount"
 // @Param Token header string true "generated.jwt.token"
-// @Success 200 {object} actionsResponse
-// @Router /refund [post]
+// @Success 200 {object} v1.ActionsResponse
+// @Router /v1/refund [post]
 func RefundHandler(w http.ResponseWriter, r *http.Request) {
-	var req requestParams
+	var req v1.RequestParams
 
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
@@ -195,31 +218,206 @@ func RefundHandler(w http.ResponseWriter, r *http.Request) {
 			return
 	}
 
-	authI := db.DB.FetchItem(req.Id)
-
-	if authI == nil || reflect.ValueOf(authI).IsNil() {
+	logger.Annotate(r.Context(), "auth_id", req.Id)
+	audit.Target(r.Context(), req.Id)
+	auth := merchantAuthorization(r, req.Id)
+	if auth == nil {
 		log.WithField("id", req.Id).Error("RefundHandler - Wrong auth Id")
 		http.Error(w, "Wrong auth Id", http.StatusBadRequest)
 		return
 	}
 
-	auth := authI.(gateway.AuthorizationI)
-	refund, err := auth.Refund(req.Amount, auth.GetCurrency())
+	refund, err := auth.Refund(r.Context(), req.Amount, auth.GetCurrency(), req.Details)
 	if err != nil {
 		log.WithField("err", err).Error("RefundHandler - Error executing refund")
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
-	resp := &actionsResponse{
+	resp := &v1.ActionsResponse{
 		Amount: refund.Amount,
 		Currency: auth.GetCurrency(),
+		SettlementAmount: refund.SettlementAmount,
+		SettlementCurrency: refund.SettlementCurrency,
+		Fee: refund.Fee,
+		Net: refund.Net(),
+		Details: refund.Details,
+	}
+
+	writeResponse(w, resp)
+}
+
+// ListAuthorizations godoc
+// @Summary Lists the merchant's authorizations
+// @Description Lists the authorizations of the authenticated merchant. Pass next_cursor back as cursor to fetch the next page.
+// @Tags status
+// @Accept  json
+// @Produce  json
+// @Param status query string false "authorized, partially_captured, captured, partially_refunded, refunded, voided or declined"
+// @Param currency query string false "Currency code"
+// @Param min_amount query number false "Minimum amount, inclusive"
+// @Param max_amount query number false "Maximum amount, inclusive"
+// @Param created_from query string false "RFC3339 timestamp, inclusive"
+// @Param created_to query string false "RFC3339 timestamp, inclusive"
+// @Param card_last4 query string false "Last 4 digits of the card"
+// @Param card_brand query string false "visa, mastercard, amex, discover or unknown"
+// @Param risk_decision query string false "allow, review or block"
+// @Param reference query string false "Reference of the authorization or of one of its captures or refunds"
+// @Param metadata.key query string false "Metadata value of a key, e.g. metadata.order_id=1234. Can be repeated for several keys"
+// @Param sort query string false "created_at (default) or amount"
+// @Param order query string false "desc (default) or asc"
+// @Param limit query int false "Page size, 20 by default, at most 100"
+// @Param cursor query string false "Cursor of the next page"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {object} v1.ListResponse
+// @Router /v1/authorizations [get]
+func ListAuthorizationsHandler(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseAuthorizationFilter(r)
+	if err != nil {
+		log.WithField("err", err).Error("ListAuthorizationsHandler - Invalid query")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// merchants only ever see their own authorizations
+	filter.Merchant = auth.MerchantFromContext(r.Context())
+
+	auths, cursor, err := gateway.Gateway.ListAuthorizations(*filter)
+	if err != nil {
+		log.WithField("err", err).Error("ListAuthorizationsHandler - Error listing authorizations")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := &v1.ListResponse{
+		Data: make([]*gateway.AuthorizationSummary, 0, len(auths)),
+		NextCursor: cursor,
+	}
+	for _, iterAuth := range auths {
+		resp.Data = append(resp.Data, iterAuth.Summary())
 	}
 
 	writeResponse(w, resp)
 }
 
+// merchantAuthorization returns the authorization with id, nil unless it belongs to the authenticated merchant
+func merchantAuthorization(r *http.Request, id string) gateway.AuthorizationI {
+	authI := db.FetchItemContext(r.Context(), id)
+	if authI == nil || reflect.ValueOf(authI).IsNil() {
+		return nil
+	}
+
+	authorization, ok := authI.(gateway.AuthorizationI)
+	if !ok || authorization.GetMerchant() != auth.MerchantFromContext(r.Context()) {
+		return nil
+	}
+
+	return authorization
+}
+
+func parseAuthorizationFilter(r *http.Request) (*gateway.AuthorizationFilter, error) {
+	params := r.URL.Query()
+
+	filter := &gateway.AuthorizationFilter{
+		Status: params.Get("status"),
+		Currency: params.Get("currency"),
+		CardLast4: params.Get("card_last4"),
+		CardBrand: params.Get("card_brand"),
+		RiskDecision: params.Get("risk_decision"),
+		Reference: params.Get("reference"),
+		SortBy: params.Get("sort"),
+		Descending: true,
+		Cursor: params.Get("cursor"),
+	}
+
+	for param := range params {
+		if strings.HasPrefix(param, "metadata.") {
+			if filter.Metadata == nil {
+				filter.Metadata = make(map[string]string)
+			}
+			filter.Metadata[strings.TrimPrefix(param, "metadata.")] = params.Get(param)
+		}
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		filter.Descending = false
+	default:
+		return nil, errors.New("List failure - order must be asc or desc")
+	}
+
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, errors.New("List failure - limit must be a positive integer")
+		}
+		filter.Limit = limit
+	}
+
+	var err error
+	if filter.MinAmount, err = parseAmountParam(params.Get("min_amount"), "min_amount"); err != nil {
+		return nil, err
+	}
+	if filter.MaxAmount, err = parseAmountParam(params.Get("max_amount"), "max_amount"); err != nil {
+		return nil, err
+	}
+	if filter.CreatedFrom, err = parseTimeParam(params.Get("created_from"), "created_from"); err != nil {
+		return nil, err
+	}
+	if filter.CreatedTo, err = parseTimeParam(params.Get("created_to"), "created_to"); err != nil {
+		return nil, err
+	}
+
+	return filter, nil
+}
+
+func parseAmountParam(value string, name string) (*float64, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	amount, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return nil, errors.New("List failure - " + name + " must be a number")
+	}
+
+	return &amount, nil
+}
+
+func parseTimeParam(value string, name string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New("List failure - " + name + " must be an RFC3339 timestamp")
+	}
+
+	return &t, nil
+}
+
+// errorStatus maps gateway errors to a response status. An acquirer call that timed out
+// after being sent is a 504 so clients know the outcome is unknown rather than failed.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, bank.ErrUnknownOutcome):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, bank.ErrCircuitOpen):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, bank.ErrNetwork):
+		return http.StatusBadGateway
+	}
+
+	return http.StatusBadRequest
+}
+
 func writeResponse(w http.ResponseWriter, resp interface{}) {
+	writeResponseStatus(w, http.StatusOK, resp)
+}
+
+func writeResponseStatus(w http.ResponseWriter, status int, resp interface{}) {
 	respJSON, err := json.Marshal(resp)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -227,5 +425,6 @@ func writeResponse(w http.ResponseWriter, resp interface{}) {
 	}
 	
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
   w.Write(respJSON)
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+		"context"
 		"net/http"
 		"net/http/httptest"
 		"testing"
@@ -8,12 +9,24 @@ import (
 		"bytes"
 		"io/ioutil"
 		"errors"
+		"fmt"
+		"time"
 		"github.com/stretchr/testify/assert"
 		"github.com/stretchr/testify/mock"
+		"github.com/gorilla/mux"
 
+		v1 "github.com/nktsitas/checkout-techlab/api/v1"
+		"github.com/nktsitas/checkout-techlab/audit"
+		"github.com/nktsitas/checkout-techlab/auth"
 		"github.com/nktsitas/checkout-techlab/gateway"
 		"github.com/nktsitas/checkout-techlab/bank"
 		"github.com/nktsitas/checkout-techlab/db"
+		"github.com/nktsitas/checkout-techlab/disputes"
+		"github.com/nktsitas/checkout-techlab/health"
+		"github.com/nktsitas/checkout-techlab/ledger"
+		"github.com/nktsitas/checkout-techlab/reconciliation"
+		"github.com/nktsitas/checkout-techlab/risk"
+		"github.com/nktsitas/checkout-techlab/settlement"
 
 		log "github.com/sirupsen/logrus"
 )
@@ -24,12 +37,18 @@ type MockGateway struct {
 	mock.Mock
 }
 
-func (m *MockGateway) NewAuthorization(req_body []byte, salt string) (*gateway.Authorization, error) {
+func (m *MockGateway) NewAuthorization(ctx context.Context, req_body []byte, salt string) (*gateway.Authorization, error) {
 	args := m.Called(req_body, salt)
 
 	return args.Get(0).(*gateway.Authorization), args.Error(1)
 }
 
+func (m *MockGateway) ListAuthorizations(filter gateway.AuthorizationFilter) ([]*gateway.Authorization, string, error) {
+	args := m.Called(filter)
+
+	return args.Get(0).([]*gateway.Authorization), args.String(1), args.Error(2)
+}
+
 func (m *MockGateway) GetSalt() string {
 	args := m.Called()
 
@@ -55,26 +74,31 @@ func (m *MockDB) DeleteItem(id string) {
 	m.Called()
 }
 
+func (m *MockDB) QueryItems(query db.Query) ([]interface{}, string, error) {
+	args := m.Called(query)
+	return args.Get(0).([]interface{}), args.String(1), args.Error(2)
+}
+
 // ---
 
 type MockAuthorization struct {
 	mock.Mock
 }
 
-func (m *MockAuthorization) Void() error {
+func (m *MockAuthorization) Void(ctx context.Context) error {
 	args := m.Called()
 
 	return args.Error(0)
 }
 
-func (m *MockAuthorization) Capture(amount float64, currency string) (*gateway.Capture, error) {
-	args := m.Called(amount, currency)
+func (m *MockAuthorization) Capture(ctx context.Context, amount float64, currency string, details gateway.Details) (*gateway.Capture, error) {
+	args := m.Called(amount, currency, details)
 
 	return args.Get(0).(*gateway.Capture), args.Error(1)
 }
 
-func (m *MockAuthorization) Refund(amount float64, currency string) (*gateway.Refund, error) {
-	args := m.Called(amount, currency)
+func (m *MockAuthorization) Refund(ctx context.Context, amount float64, currency string, details gateway.Details) (*gateway.Refund, error) {
+	args := m.Called(amount, currency, details)
 
 	return args.Get(0).(*gateway.Refund), args.Error(1)
 }
@@ -85,6 +109,12 @@ func (m *MockAuthorization) GetCurrency() string {
 	return args.String(0)
 }
 
+func (m *MockAuthorization) GetMerchant() string {
+	args := m.Called()
+
+	return args.String(0)
+}
+
 // --- --- ---
 
 func init() {
@@ -104,6 +134,65 @@ func TestPing(t *testing.T) {
 	assert.Equal("Pong!", w.Body.String(), "Pong")
 }
 
+func TestAcquirerStatus(t *testing.T) {
+	assert := assert.New(t)
+
+	req, err := http.NewRequest("GET", "/status/acquirers", nil)
+	assert.NoError(err)
+
+	w := httptest.NewRecorder()
+	AcquirerStatus(w, req)
+
+	var statuses []bank.BreakerStatus
+	assert.NoError(json.Unmarshal(w.Body.Bytes(), &statuses))
+
+	assert.Equal(200, w.Code, "Acquirer status")
+	assert.Len(statuses, 1, "Acquirer status")
+	assert.Equal(bank.StateClosed, statuses[0].State, "Acquirer status")
+}
+
+func TestReadyHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(checks *health.Checker) { health.Checks = checks }(health.Checks)
+
+	tests := []struct{
+		status string
+		shuttingDown bool
+		expectedCode int
+		description string
+	}{
+		{health.StatusUp, false, 200, "Ready"},
+		{health.StatusDegraded, false, 200, "Ready - Degraded"},
+		{health.StatusDown, false, 503, "Not ready - Check down"},
+		{health.StatusUp, true, 503, "Not ready - Shutting down"},
+	}
+
+	for _, iterTest := range tests {
+		status := iterTest.status
+		health.Checks = &health.Checker{}
+		health.Checks.Register("storage", func(ctx context.Context) health.Result { return health.Result{Status: status} })
+		if iterTest.shuttingDown {
+			health.Checks.ShutDown()
+		}
+
+		w := httptest.NewRecorder()
+		ReadyHandler(w, httptest.NewRequest("GET", "/health/ready", nil))
+
+		var report health.Report
+		assert.NoError(json.Unmarshal(w.Body.Bytes(), &report), iterTest.description)
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Equal(iterTest.shuttingDown, report.ShuttingDown, iterTest.description)
+		if assert.Len(report.Components, 1, iterTest.description) {
+			assert.Equal(iterTest.status, report.Components[0].Status, iterTest.description)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	LiveHandler(w, httptest.NewRequest("GET", "/health/live", nil))
+	assert.Equal(200, w.Code, "Live - Even while shutting down")
+}
+
 // in handlers tests we mock out the gateway functionality (NewAuthorization, Capture, etc)
 // as it is thoroughly tested in gateway package. Here we check that responses are as they should and errors are caught
 
@@ -120,14 +209,24 @@ func TestCreateAuthorizationHandler(t *testing.T) {
 		},
 	}
 
-	testResp := &authResponse{
+	testResp := &v1.AuthResponse{
+		Id: "test",
+		Amount: 100.00,
+		Currency: "EUR",
+	}
+
+	testDeclinedResp := &v1.AuthResponse{
 		Id: "test",
 		Amount: 100.00,
 		Currency: "EUR",
+		Status: gateway.AuthStatusDeclined,
+		DeclineCode: "05",
+		DeclineReason: "Do not honour",
 	}
 
 	testAuthJSON, _ := json.Marshal(testAuth)
 	testRespJSON, _ := json.Marshal(testResp)
+	testDeclinedRespJSON, _ := json.Marshal(testDeclinedResp)
 
 	tests := []struct{
 		body []byte
@@ -149,6 +248,21 @@ func TestCreateAuthorizationHandler(t *testing.T) {
 			string(testRespJSON),
 			"OK - Authorization Created",
 		},
+		{
+			testAuthJSON,
+			&gateway.Authorization{
+				Id: "test",
+				Amount: 100.00,
+				Currency: "EUR",
+				Status: gateway.AuthStatusDeclined,
+				DeclineCode: "05",
+				DeclineReason: "Do not honour",
+			},
+			nil,
+			402,
+			string(testDeclinedRespJSON),
+			"Declined - Authorization Stored as declined",
+		},
 		{
 			testAuthJSON,
 			nil,
@@ -157,6 +271,30 @@ func TestCreateAuthorizationHandler(t *testing.T) {
 			"Something went wrong\n",
 			"Error",
 		},
+		{
+			testAuthJSON,
+			nil,
+			&bank.UnknownOutcomeError{Operation: "authorize", Err: context.DeadlineExceeded},
+			504,
+			"Authorization failure - No answer from acquirer, outcome unknown (context deadline exceeded)\n",
+			"Error - Acquirer timed out, outcome unknown",
+		},
+		{
+			testAuthJSON,
+			nil,
+			fmt.Errorf("Authorization failure - %w", bank.ErrCircuitOpen),
+			503,
+			"Authorization failure - Acquirer unavailable, failing fast\n",
+			"Error - Acquirer circuit open",
+		},
+		{
+			testAuthJSON,
+			nil,
+			fmt.Errorf("Authorization failure - %w", bank.ErrNetwork),
+			502,
+			"Authorization failure - " + bank.ErrNetwork.Error() + "\n",
+			"Error - Acquirer unreachable",
+		},
 	}
 
 	for _, iterTest := range tests {
@@ -196,14 +334,25 @@ func TestCaptureHandler(t *testing.T) {
 		},
 	}
 
-	testCaptureRequest := &requestParams{
+	testDetails := gateway.Details{
+		Reference: "capture-1",
+		Metadata: map[string]string{"shipment": "1"},
+	}
+
+	testCaptureRequest := &v1.RequestParams{
 		Id: "test",
 		Amount: testAmount,
+		Details: testDetails,
 	}
 
-	testResp := &actionsResponse{
+	testResp := &v1.ActionsResponse{
 		Amount: testAmount,
 		Currency: "EUR",
+		SettlementAmount: 110.00,
+		SettlementCurrency: "USD",
+		Fee: 3.20,
+		Net: 106.80,
+		Details: testDetails,
 	}
 
 	testCaptureRequestJSON, _ := json.Marshal(testCaptureRequest)
@@ -224,6 +373,10 @@ func TestCaptureHandler(t *testing.T) {
 			&gateway.Capture{
 				Authorization: testAuth,
 				Amount: testAmount,
+				SettlementAmount: 110.00,
+				SettlementCurrency: "USD",
+				Fee: 3.20,
+				Details: testDetails,
 			},
 			nil,
 			200,
@@ -248,18 +401,38 @@ func TestCaptureHandler(t *testing.T) {
 			"Capture Error - Something went wrong\n",
 			"Error - Capture Error - Something went wrong",
 		},
+		{
+			testCaptureRequestJSON,
+			new(MockAuthorization),
+			nil,
+			&bank.UnknownOutcomeError{Operation: "charge", Err: context.DeadlineExceeded},
+			504,
+			"Capture failure - No answer from acquirer, outcome unknown (context deadline exceeded)\n",
+			"Error - Acquirer timed out, outcome unknown",
+		},
+		{
+			testCaptureRequestJSON,
+			new(MockAuthorization),
+			nil,
+			fmt.Errorf("Capture failure - %w", bank.ErrCircuitOpen),
+			503,
+			"Capture failure - Acquirer unavailable, failing fast\n",
+			"Error - Acquirer circuit open",
+		},
 	}
 
 	for _, iterTest := range tests {
 		req, err := http.NewRequest("POST", "/capture", bytes.NewBuffer(iterTest.body))
 		assert.NoError(err)
+		req = req.WithContext(auth.WithMerchant(req.Context(), "Checkout"))
 
 		mockAuth := iterTest.authReturned
 		if mockAuth != nil {
 			mockAuth.On("GetCurrency").Return("EUR")
-			mockAuth.On("Capture", testAmount, "EUR").Return(iterTest.captureCreated, iterTest.err)
+			mockAuth.On("GetMerchant").Return("Checkout")
+			mockAuth.On("Capture", testAmount, "EUR", testDetails).Return(iterTest.captureCreated, iterTest.err)
 
-			mockAuth.MethodCalled("Capture", testAmount, "EUR")
+			mockAuth.MethodCalled("Capture", testAmount, "EUR", testDetails)
 			mockAuth.AssertNumberOfCalls(t, "Capture", 1)
 		}
 		
@@ -292,14 +465,18 @@ func TestRefundHandler(t *testing.T) {
 		},
 	}
 
-	testRefundRequest := &requestParams{
+	testRefundRequest := &v1.RequestParams{
 		Id: "test",
 		Amount: testAmount,
 	}
 
-	testResp := &actionsResponse{
+	testResp := &v1.ActionsResponse{
 		Amount: testAmount,
 		Currency: "EUR",
+		SettlementAmount: testAmount,
+		SettlementCurrency: "EUR",
+		Fee: 0.25,
+		Net: -(testAmount + 0.25),
 	}
 
 	testRefundRequestJSON, _ := json.Marshal(testRefundRequest)
@@ -320,6 +497,9 @@ func TestRefundHandler(t *testing.T) {
 			&gateway.Refund{
 				Authorization: testAuth,
 				Amount: testAmount,
+				SettlementAmount: testAmount,
+				SettlementCurrency: "EUR",
+				Fee: 0.25,
 			},
 			nil,
 			200,
@@ -349,13 +529,15 @@ func TestRefundHandler(t *testing.T) {
 	for _, iterTest := range tests {
 		req, err := http.NewRequest("POST", "/refund", bytes.NewBuffer(iterTest.body))
 		assert.NoError(err)
+		req = req.WithContext(auth.WithMerchant(req.Context(), "Checkout"))
 
 		mockAuth := iterTest.authReturned
 		if mockAuth != nil {
 			mockAuth.On("GetCurrency").Return("EUR")
-			mockAuth.On("Refund", testAmount, "EUR").Return(iterTest.refundCreated, iterTest.err)
+			mockAuth.On("GetMerchant").Return("Checkout")
+			mockAuth.On("Refund", testAmount, "EUR", gateway.Details{}).Return(iterTest.refundCreated, iterTest.err)
 
-			mockAuth.MethodCalled("Refund", testAmount, "EUR")
+			mockAuth.MethodCalled("Refund", testAmount, "EUR", gateway.Details{})
 			mockAuth.AssertNumberOfCalls(t, "Refund", 1)
 		}
 		
@@ -375,11 +557,11 @@ func TestRefundHandler(t *testing.T) {
 func TestVoidHandler(t *testing.T) {
 	assert := assert.New(t)
 
-	testVoidRequest := &requestParams{
+	testVoidRequest := &v1.RequestParams{
 		Id: "test",
 	}
 
-	testResp := &actionsResponse{
+	testResp := &v1.ActionsResponse{
 		Amount: 0.0,
 		Currency: "EUR",
 	}
@@ -424,10 +606,12 @@ func TestVoidHandler(t *testing.T) {
 	for _, iterTest := range tests {
 		req, err := http.NewRequest("POST", "/void", bytes.NewBuffer(iterTest.body))
 		assert.NoError(err)
+		req = req.WithContext(auth.WithMerchant(req.Context(), "Checkout"))
 
 		mockAuth := iterTest.authReturned
 		if mockAuth != nil {
 			mockAuth.On("GetCurrency").Return("EUR")
+			mockAuth.On("GetMerchant").Return("Checkout")
 			mockAuth.On("Void").Return(iterTest.err)
 
 			mockAuth.MethodCalled("Void")
@@ -446,3 +630,433 @@ func TestVoidHandler(t *testing.T) {
 		assert.Equal(iterTest.expectedBody, w.Body.String(), iterTest.description)
 	}
 }
+
+func TestOtherMerchantAuthorization(t *testing.T) {
+	assert := assert.New(t)
+
+	body, _ := json.Marshal(&v1.RequestParams{Id: "test", Amount: 100.00})
+
+	tests := []struct{
+		handler http.HandlerFunc
+		description string
+	}{
+		{CaptureHandler, "Capture"},
+		{VoidHandler, "Void"},
+		{RefundHandler, "Refund"},
+	}
+
+	for _, iterTest := range tests {
+		mockAuth := new(MockAuthorization)
+		mockAuth.On("GetMerchant").Return("Shop")
+
+		testDB := new(MockDB)
+		db.DB = testDB
+		testDB.On("FetchItem", "test").Return(mockAuth)
+
+		req := httptest.NewRequest("POST", "/", bytes.NewBuffer(body))
+		req = req.WithContext(auth.WithMerchant(req.Context(), "Checkout"))
+		w := httptest.NewRecorder()
+		iterTest.handler(w, req)
+
+		assert.Equal(400, w.Code, iterTest.description)
+		assert.Equal("Wrong auth Id\n", w.Body.String(), iterTest.description)
+		mockAuth.AssertNotCalled(t, "Capture", mock.Anything, mock.Anything, mock.Anything)
+		mockAuth.AssertNotCalled(t, "Refund", mock.Anything, mock.Anything, mock.Anything)
+		mockAuth.AssertNotCalled(t, "Void")
+	}
+}
+
+func TestListAuthorizationsHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	createdAt := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	createdFrom := time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)
+	minAmount := 10.0
+
+	testAuth := &gateway.Authorization{
+		Id: "test",
+		Amount: 100.00,
+		Currency: "EUR",
+		Status: gateway.AuthStatusAuthorized,
+		Merchant: "merchant",
+		CreditCard: &bank.CreditCard{
+			Number: "4000 0000 0000 0123",
+			Expiry: "12/22",
+			Cvv: "123",
+		},
+		CreatedAt: createdAt,
+	}
+
+	testRespJSON, _ := json.Marshal(&v1.ListResponse{
+		Data: []*gateway.AuthorizationSummary{testAuth.Summary()},
+		NextCursor: "next",
+	})
+
+	tests := []struct{
+		query string
+		filter *gateway.AuthorizationFilter
+		expectedCode int
+		expectedBody string
+		description string
+	}{
+		{
+			"?status=authorized&currency=EUR&min_amount=10&created_from=2020-07-01T00:00:00Z&sort=amount&order=asc&limit=5&cursor=abc",
+			&gateway.AuthorizationFilter{
+				Merchant: "merchant",
+				Status: "authorized",
+				Currency: "EUR",
+				MinAmount: &minAmount,
+				CreatedFrom: &createdFrom,
+				SortBy: "amount",
+				Limit: 5,
+				Cursor: "abc",
+			},
+			200,
+			string(testRespJSON),
+			"OK - Filters are passed to the gateway, scoped to the merchant",
+		},
+		{
+			"?limit=ten",
+			nil,
+			400,
+			"List failure - limit must be a positive integer\n",
+			"Error - Invalid limit",
+		},
+		{
+			"?created_to=yesterday",
+			nil,
+			400,
+			"List failure - created_to must be an RFC3339 timestamp\n",
+			"Error - Invalid timestamp",
+		},
+		{
+			"?order=random",
+			nil,
+			400,
+			"List failure - order must be asc or desc\n",
+			"Error - Invalid order",
+		},
+	}
+
+	for _, iterTest := range tests {
+		req, err := http.NewRequest("GET", "/authorizations" + iterTest.query, nil)
+		assert.NoError(err)
+		req = req.WithContext(auth.WithMerchant(req.Context(), "merchant"))
+
+		mockGateway := new(MockGateway)
+		gateway.Gateway = mockGateway
+
+		if iterTest.filter != nil {
+			mockGateway.On("ListAuthorizations", *iterTest.filter).Return([]*gateway.Authorization{testAuth}, "next", nil)
+		}
+
+		w := httptest.NewRecorder()
+		ListAuthorizationsHandler(w, req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Equal(iterTest.expectedBody, w.Body.String(), iterTest.description)
+	}
+}
+
+func TestSettlementReportHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	db.DB.StoreItem("stl_1", &settlement.Batch{
+		Id: "stl_1",
+		Merchant: "merchant",
+		Currency: "EUR",
+		Date: "2020-07-01",
+		Gross: 100,
+		Net: 100,
+	})
+
+	tests := []struct{
+		query string
+		expectedCode int
+		expectedType string
+		expectedBody string
+		description string
+	}{
+		{
+			"?date=2020-07-01",
+			200,
+			"application/json",
+			`"net":100`,
+			"OK - JSON report",
+		},
+		{
+			"?date=2020-07-01&format=csv",
+			200,
+			"text/csv",
+			"batch,stl_1,merchant,EUR,2020-07-01,,,100.00,0.00,100.00",
+			"OK - CSV report",
+		},
+		{
+			"?date=01/07/2020",
+			400,
+			"text/plain; charset=utf-8",
+			"Settlement failure - date must be formatted as YYYY-MM-DD",
+			"Error - Invalid date",
+		},
+		{
+			"?format=pdf",
+			400,
+			"text/plain; charset=utf-8",
+			"Settlement failure - format must be json or csv",
+			"Error - Invalid format",
+		},
+	}
+
+	for _, iterTest := range tests {
+		req, err := http.NewRequest("GET", "/settlements/report" + iterTest.query, nil)
+		assert.NoError(err)
+		req = req.WithContext(auth.WithMerchant(req.Context(), "merchant"))
+
+		w := httptest.NewRecorder()
+		SettlementReportHandler(w, req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Equal(iterTest.expectedType, w.Header().Get("Content-Type"), iterTest.description)
+		assert.Contains(w.Body.String(), iterTest.expectedBody, iterTest.description)
+	}
+}
+
+func TestGetReconciliationHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	db.DB.StoreItem("rec_1", &reconciliation.Reconciliation{
+		Id: "rec_1",
+		Acquirer: "simulator",
+		Matched: 2,
+	})
+
+	tests := []struct{
+		id string
+		expectedCode int
+		expectedBody string
+		description string
+	}{
+		{
+			"rec_1",
+			200,
+			`"matched":2`,
+			"OK - Reconciliation found",
+		},
+		{
+			"rec_2",
+			404,
+			"Reconciliation not found",
+			"Error - Unknown reconciliation",
+		},
+	}
+
+	for _, iterTest := range tests {
+		req, err := http.NewRequest("GET", "/reconciliations/" + iterTest.id, nil)
+		assert.NoError(err)
+		req = mux.SetURLVars(req, map[string]string{"id": iterTest.id})
+
+		w := httptest.NewRecorder()
+		GetReconciliationHandler(w, req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Contains(w.Body.String(), iterTest.expectedBody, iterTest.description)
+	}
+}
+
+func TestSubmitEvidenceHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	db.DB.StoreItem("dsp_1", &disputes.Dispute{
+		Id: "dsp_1",
+		Merchant: "Checkout",
+		Status: disputes.StatusNeedsResponse,
+		EvidenceDueBy: time.Now().Add(time.Hour),
+	})
+
+	tests := []struct{
+		id string
+		merchant string
+		body string
+		expectedCode int
+		expectedBody string
+		description string
+	}{
+		{
+			"dsp_1",
+			"Other",
+			`{"evidence":[{"type":"receipt","description":"Paid in store"}]}`,
+			404,
+			"Dispute failure - Dispute not found",
+			"Error - Dispute of another merchant",
+		},
+		{
+			"dsp_1",
+			"Checkout",
+			`{"evidence":[]}`,
+			400,
+			"Dispute failure - No evidence provided",
+			"Error - No evidence",
+		},
+		{
+			"dsp_1",
+			"Checkout",
+			`{"evidence":[{"type":"receipt","description":"Paid in store"}]}`,
+			200,
+			`"status":"under_review"`,
+			"OK - Evidence submitted",
+		},
+	}
+
+	for _, iterTest := range tests {
+		req, err := http.NewRequest("POST", "/disputes/" + iterTest.id + "/evidence", bytes.NewBufferString(iterTest.body))
+		assert.NoError(err)
+		req = mux.SetURLVars(req, map[string]string{"id": iterTest.id})
+		req = req.WithContext(auth.WithMerchant(req.Context(), iterTest.merchant))
+
+		w := httptest.NewRecorder()
+		SubmitEvidenceHandler(w, req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Contains(w.Body.String(), iterTest.expectedBody, iterTest.description)
+	}
+}
+
+func TestBalancesHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	defaultLedger := ledger.Ledger
+	defer func() {
+		ledger.Ledger = defaultLedger
+	}()
+
+	ledger.Ledger = ledger.InitMemoryLedger()
+	ledger.Ledger.Post(&ledger.Entry{
+		Type: ledger.EntryCapture,
+		Currency: "EUR",
+		Postings: []ledger.Posting{
+			{Account: ledger.AcquirerReceivable, Amount: 100},
+			{Account: ledger.MerchantPending("merchant"), Amount: -100},
+		},
+	})
+
+	req, err := http.NewRequest("GET", "/balances", nil)
+	assert.NoError(err)
+	req = req.WithContext(auth.WithMerchant(req.Context(), "merchant"))
+
+	w := httptest.NewRecorder()
+	BalancesHandler(w, req)
+
+	assert.Equal(200, w.Code)
+	assert.Equal(`{"merchant":"merchant","balances":[{"currency":"EUR","pending":100,"available":0}]}`, w.Body.String())
+}
+
+func TestAddRiskEntryHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	defer func() {
+		risk.Lists = &risk.ManagedLists{}
+	}()
+
+	tests := []struct{
+		role string
+		body string
+		expectedCode int
+		expectedBody string
+		description string
+	}{
+		{
+			"",
+			`{"list":"block","type":"bin","value":"412345"}`,
+			403,
+			"Forbidden",
+			"Error - Merchant token",
+		},
+		{
+			auth.RoleAdmin,
+			`{"list":"block","type":"card","value":"4000 0000 0000 0123"}`,
+			400,
+			"Invalid list entry - card value must be a card fingerprint",
+			"Error - Card number instead of a fingerprint",
+		},
+		{
+			auth.RoleAdmin,
+			`{"list":"block","type":"bin","value":"412345","note":"Compromised range"}`,
+			201,
+			`"created_by":"Admin"`,
+			"OK - Entry added",
+		},
+	}
+
+	for _, iterTest := range tests {
+		req, err := http.NewRequest("POST", "/admin/risk/lists", bytes.NewBufferString(iterTest.body))
+		assert.NoError(err)
+		req = req.WithContext(auth.WithRole(auth.WithMerchant(req.Context(), "Admin"), iterTest.role))
+
+		w := httptest.NewRecorder()
+		auth.RequireRole(http.HandlerFunc(AddRiskEntryHandler), auth.RoleAdmin).ServeHTTP(w, req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Contains(w.Body.String(), iterTest.expectedBody, iterTest.description)
+	}
+
+	assert.Len(risk.Lists.Block(), 1, "Enforced right away")
+}
+
+func TestExportAuditHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	audit.Trail = &audit.AuditTrail{}
+	defer func() {
+		audit.Trail = &audit.AuditTrail{}
+	}()
+
+	audit.Trail.Append(&audit.Record{Actor: "Checkout", Merchant: "Checkout", Operation: audit.OperationAuthorize, Target: "auth_1", Result: audit.ResultSuccess, Status: 200})
+	audit.Trail.Append(&audit.Record{Actor: "Checkout", Merchant: "Checkout", Operation: audit.OperationVoid, Target: "auth_1", Result: audit.ResultSuccess, Status: 200})
+	audit.Trail.Append(&audit.Record{Actor: "Other", Merchant: "Other", Operation: audit.OperationAuthorize, Target: "auth_2", Result: audit.ResultDeclined, Status: 402})
+
+	tests := []struct{
+		query string
+		expectedCode int
+		expectedRecords int
+		description string
+	}{
+		{"", 200, 3, "OK - Every record"},
+		{"?target=auth_1", 200, 2, "OK - Records of an authorization"},
+		{"?merchant=Other&operation=authorize", 200, 1, "OK - Merchant and operation"},
+		{"?created_to=2000-01-01T00:00:00Z", 200, 0, "OK - Nothing in range"},
+		{"?created_from=yesterday", 400, 0, "Error - Invalid timestamp"},
+		{"?format=csv", 400, 0, "Error - Invalid format"},
+	}
+
+	for _, iterTest := range tests {
+		req, err := http.NewRequest("GET", "/admin/audit" + iterTest.query, nil)
+		assert.NoError(err)
+
+		w := httptest.NewRecorder()
+		http.HandlerFunc(ExportAuditHandler).ServeHTTP(w, req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var resp v1.AuditResponse
+		assert.NoError(json.Unmarshal(w.Body.Bytes(), &resp), iterTest.description)
+		assert.Len(resp.Records, iterTest.expectedRecords, iterTest.description)
+		assert.Equal(&audit.Verification{Valid: true, Records: 3}, resp.Verification, iterTest.description)
+	}
+
+	req, err := http.NewRequest("GET", "/admin/audit?format=jsonl&target=auth_1", nil)
+	assert.NoError(err)
+
+	w := httptest.NewRecorder()
+	http.HandlerFunc(ExportAuditHandler).ServeHTTP(w, req)
+
+	assert.Equal("application/x-ndjson", w.Header().Get("Content-Type"), "Export")
+	assert.Equal(2, bytes.Count(w.Body.Bytes(), []byte("\n")), "Export - One line per record")
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/health"
+)
+
+type liveResponse struct {
+	Status string `json:"status" example:"up"`
+}
+
+// Live godoc
+// @Summary Tell whether the process is alive
+// @Description Answers as long as the process serves requests, no dependency is checked. Restart the service when it fails.
+// @Tags status
+// @Produce  json
+// @Success 200 {object} liveResponse
+// @Router /health/live [get]
+func LiveHandler(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, liveResponse{Status: health.StatusUp})
+}
+
+// Ready godoc
+// @Summary Tell whether the service can take traffic
+// @Description Check storage, acquirers and configuration, with the status and latency of each. Not ready while a check is down or the service is shutting down.
+// @Tags status
+// @Produce  json
+// @Success 200 {object} health.Report
+// @Failure 503 {object} health.Report "Not ready"
+// @Router /health/ready [get]
+func ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	report, ready := health.Checks.Ready(r.Context())
+	if !ready {
+		log.WithField("report", report).Warn("ReadyHandler - Not ready")
+		writeResponseStatus(w, http.StatusServiceUnavailable, report)
+		return
+	}
+
+	writeResponse(w, report)
+}
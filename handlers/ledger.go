@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+
+	v1 "github.com/nktsitas/checkout-techlab/api/v1"
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/ledger"
+)
+
+// Balances godoc
+// @Summary Get the merchant's balances
+// @Description Get the balances of the authenticated merchant by currency: pending (captured, not settled yet) and available (settled)
+// @Tags ledger
+// @Accept  json
+// @Produce  json
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {object} v1.BalancesResponse
+// @Router /v1/balances [get]
+func BalancesHandler(w http.ResponseWriter, r *http.Request) {
+	merchant := auth.MerchantFromContext(r.Context())
+
+	writeResponse(w, &v1.BalancesResponse{
+		Merchant: merchant,
+		Balances: ledger.MerchantBalances(merchant),
+	})
+}
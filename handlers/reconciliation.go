@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+
+	"github.com/nktsitas/checkout-techlab/reconciliation"
+)
+
+// ListReconciliations godoc
+// @Summary List the reconciliations of acquirer settlement files
+// @Description List the reconciliations of imported acquirer settlement files, newest first, with their discrepancies. Requires an admin token.
+// @Tags reconciliation
+// @Accept  json
+// @Produce  json
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {array} reconciliation.Reconciliation
+// @Failure 403 {string} string "Forbidden"
+// @Router /v1/admin/reconciliations [get]
+func ListReconciliationsHandler(w http.ResponseWriter, r *http.Request) {
+	reconciliations, err := reconciliation.List()
+	if err != nil {
+		log.WithField("err", err).Error("ListReconciliationsHandler - Error listing reconciliations")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, reconciliations)
+}
+
+// GetReconciliation godoc
+// @Summary Get the discrepancy report of a settlement file
+// @Description Get the discrepancy report of an imported acquirer settlement file. Requires an admin token.
+// @Tags reconciliation
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Reconciliation id"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {object} reconciliation.Reconciliation
+// @Failure 403 {string} string "Forbidden"
+// @Failure 404 {string} string "Reconciliation not found"
+// @Router /v1/admin/reconciliations/{id} [get]
+func GetReconciliationHandler(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	rec := reconciliation.Get(id)
+	if rec == nil {
+		log.WithField("id", id).Error("GetReconciliationHandler - Reconciliation not found")
+		http.Error(w, "Reconciliation not found", http.StatusNotFound)
+		return
+	}
+
+	writeResponse(w, rec)
+}
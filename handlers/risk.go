@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+
+	v1 "github.com/nktsitas/checkout-techlab/api/v1"
+	"github.com/nktsitas/checkout-techlab/audit"
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/risk"
+)
+
+// ListRiskEntries godoc
+// @Summary List the managed block and allow list entries
+// @Description List the block and allow list entries added through the admin API, newest first. Requires an admin token.
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param list query string false "allow or block"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {array} risk.ListEntry
+// @Failure 403 {string} string "Forbidden"
+// @Router /v1/admin/risk/lists [get]
+func ListRiskEntriesHandler(w http.ResponseWriter, r *http.Request) {
+	entries, err := risk.Entries(r.URL.Query().Get("list"))
+	if err != nil {
+		log.WithField("err", err).Error("ListRiskEntriesHandler - Error listing entries")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeResponse(w, entries)
+}
+
+// AddRiskEntry godoc
+// @Summary Add a block or allow list entry
+// @Description Block or allow a card fingerprint, a BIN prefix, an email or an IP (address or CIDR range). It applies from the next authorization, without a restart. Requires an admin token.
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param entry body v1.ListEntryRequest true "List entry"
+// @Param Token header string true "generated.jwt.token"
+// @Success 201 {object} risk.ListEntry
+// @Failure 403 {string} string "Forbidden"
+// @Router /v1/admin/risk/lists [post]
+func AddRiskEntryHandler(w http.ResponseWriter, r *http.Request) {
+	var req v1.ListEntryRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		log.WithField("err", err).Error("AddRiskEntryHandler - Error reading body")
+		http.Error(w, "Can't read body", http.StatusBadRequest)
+		return
+	}
+
+	entry, err := risk.AddEntry(req.List, req.Entry, auth.MerchantFromContext(r.Context()))
+	if err != nil {
+		log.WithField("err", err).Error("AddRiskEntryHandler - Error adding entry")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	audit.Target(r.Context(), entry.Id)
+	writeResponseStatus(w, http.StatusCreated, entry)
+}
+
+// RemoveRiskEntry godoc
+// @Summary Remove a block or allow list entry
+// @Description Remove an entry added through the admin API, it stops applying from the next authorization. Requires an admin token.
+// @Tags admin
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Entry id"
+// @Param Token header string true "generated.jwt.token"
+// @Success 204
+// @Failure 404 {string} string "Entry not found"
+// @Router /v1/admin/risk/lists/{id} [delete]
+func RemoveRiskEntryHandler(w http.ResponseWriter, r *http.Request) {
+	audit.Target(r.Context(), mux.Vars(r)["id"])
+	err := risk.RemoveEntry(mux.Vars(r)["id"], auth.MerchantFromContext(r.Context()))
+	if err == risk.ErrEntryNotFound {
+		log.WithField("id", mux.Vars(r)["id"]).Error("RemoveRiskEntryHandler - Entry not found")
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.WithField("err", err).Error("RemoveRiskEntryHandler - Error removing entry")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
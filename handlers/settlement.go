@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/settlement"
+)
+
+// SettlementReport godoc
+// @Summary Get the merchant's settlement report of a day
+// @Description Get the settlement batches of the authenticated merchant for a settlement date, as JSON or CSV
+// @Tags settlement
+// @Accept  json
+// @Produce  json
+// @Produce  text/csv
+// @Param date query string false "Settlement date (YYYY-MM-DD), today by default"
+// @Param format query string false "json (default) or csv"
+// @Param Token header string true "generated.jwt.token"
+// @Success 200 {object} settlement.Report
+// @Router /v1/settlements/report [get]
+func SettlementReportHandler(w http.ResponseWriter, r *http.Request) {
+	date := r.URL.Query().Get("date")
+	if date == "" {
+		date = time.Now().UTC().Format(settlement.DateLayout)
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "json" && format != "csv" {
+		http.Error(w, "Settlement failure - format must be json or csv", http.StatusBadRequest)
+		return
+	}
+
+	report, err := settlement.GetReport(auth.MerchantFromContext(r.Context()), date)
+	if err != nil {
+		log.WithField("err", err).Error("SettlementReportHandler - Error getting report")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if format != "csv" {
+		writeResponse(w, report)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="settlement-` + date + `.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	if err := settlement.WriteCSV(w, report); err != nil {
+		log.WithField("err", err).Error("SettlementReportHandler - Error writing CSV")
+	}
+}
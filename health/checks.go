@@ -0,0 +1,148 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/ledger"
+)
+
+// AcquirerHealth is the readiness of one acquirer
+type AcquirerHealth struct {
+	Name string `json:"name" example:"acquirer_a"`
+	Breaker string `json:"breaker" example:"closed"`
+	Reachable bool `json:"reachable" example:"true"`
+}
+
+// AcquirersCacheTTL is how long the acquirers check is answered from its last result, readiness isn't
+// authenticated nor rate limited and mustn't dial every acquirer on each probe
+const AcquirersCacheTTL = 5 * time.Second
+
+var acquirersCache struct {
+	mu sync.Mutex
+	result Result
+	at time.Time
+}
+
+// Storage checks the storage backend answers
+func Storage(ctx context.Context) Result {
+	return FromError(db.Ping(ctx))
+}
+
+// Acquirers checks each acquirer is reachable and its breaker isn't open, at most once per AcquirersCacheTTL.
+// Degraded while some can't take payments, down when none can.
+func Acquirers(ctx context.Context) Result {
+	acquirersCache.mu.Lock()
+	defer acquirersCache.mu.Unlock()
+
+	if !acquirersCache.at.IsZero() && time.Since(acquirersCache.at) < AcquirersCacheTTL {
+		return acquirersCache.result
+	}
+
+	acquirersCache.result, acquirersCache.at = checkAcquirers(ctx), time.Now()
+
+	return acquirersCache.result
+}
+
+func checkAcquirers(ctx context.Context) Result {
+	connectors := bank.Connectors()
+	if len(connectors) == 0 {
+		return Result{Status: StatusDown, Message: "No acquirers configured"}
+	}
+
+	acquirers := make([]AcquirerHealth, len(connectors))
+	var wg sync.WaitGroup
+	for i, iterConnector := range connectors {
+		wg.Add(1)
+		go func(i int, connector *bank.Connector) {
+			defer wg.Done()
+
+			acquirers[i] = AcquirerHealth{Name: connector.Name, Breaker: connector.Breaker.Status().State, Reachable: true}
+			// the error stays in the logs, the report is public
+			if err := connector.Ping(ctx); err != nil {
+				log.WithFields(log.Fields{"acquirer": connector.Name, "err": err}).Warn("Health.Acquirers - Acquirer unreachable")
+				acquirers[i].Reachable = false
+			}
+		}(i, iterConnector)
+	}
+	wg.Wait()
+
+	available := 0
+	for _, iterAcquirer := range acquirers {
+		if iterAcquirer.Reachable && iterAcquirer.Breaker != bank.StateOpen {
+			available++
+		}
+	}
+
+	result := Result{Status: StatusUp, Details: acquirers}
+	switch {
+	case available == 0:
+		result.Status = StatusDown
+		result.Message = "No acquirer available"
+	case available < len(acquirers):
+		result.Status = StatusDegraded
+		result.Message = fmt.Sprintf("%d of %d acquirers available", available, len(acquirers))
+	}
+
+	return result
+}
+
+// LedgerHealth is the outcome of the last scheduled ledger check
+type LedgerHealth struct {
+	CheckedAt *time.Time `json:"checked_at,omitempty"`
+}
+
+// Ledger reports the last scheduled check of the ledger invariants. Broken invariants degrade the service
+// rather than take it down: no other instance would have better books, operations have to look into it.
+func Ledger(ctx context.Context) Result {
+	checkedAt, err := ledger.LastCheck()
+	if checkedAt.IsZero() {
+		return Result{Status: StatusUp, Message: "Not checked yet", Details: LedgerHealth{}}
+	}
+
+	// which invariant broke is logged by the check, the report is public
+	result := Result{Status: StatusUp, Details: LedgerHealth{CheckedAt: &checkedAt}}
+	if err != nil {
+		result.Status = StatusDegraded
+		result.Message = "Ledger invariants broken"
+	}
+
+	return result
+}
+
+// Files checks the configured files, by setting, can still be read. The running service has them loaded already,
+// unreadable files are degraded: a restart or a certificate rotation would fail.
+func Files(files map[string]string) CheckFunc {
+	return func(ctx context.Context) Result {
+		unreadable := []string{}
+		for setting, path := range files {
+			file, err := os.Open(path)
+			if err != nil {
+				log.WithFields(log.Fields{"setting": setting, "err": err}).Warn("Health.Files - File unreadable")
+				unreadable = append(unreadable, setting)
+				continue
+			}
+			file.Close()
+		}
+
+		if len(unreadable) == 0 {
+			return Result{Status: StatusUp}
+		}
+
+		sort.Strings(unreadable)
+
+		return Result{
+			Status: StatusDegraded,
+			Message: fmt.Sprintf("%d of %d files unreadable", len(unreadable), len(files)),
+			Details: unreadable,
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statuses of a component and of the service as a whole
+const (
+	StatusUp = "up"
+	// StatusDegraded still takes traffic, e.g. one acquirer of several is failing
+	StatusDegraded = "degraded"
+	StatusDown = "down"
+)
+
+// CheckTimeout bounds each check, so a hanging dependency can't hang the probe
+const CheckTimeout = 2 * time.Second
+
+// Result is what a check found
+type Result struct {
+	Status string
+	Message string
+	Details interface{}
+}
+
+// CheckFunc checks one dependency
+type CheckFunc func(ctx context.Context) Result
+
+// Component is the outcome of one check in a report
+type Component struct {
+	Name string `json:"name" example:"storage"`
+	Status string `json:"status" example:"up"`
+	LatencyMs float64 `json:"latency_ms" example:"0.12"`
+	Message string `json:"message,omitempty" example:""`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Report is the readiness of the service, down if any component is down
+type Report struct {
+	Status string `json:"status" example:"up"`
+	ShuttingDown bool `json:"shutting_down,omitempty" example:"false"`
+	Components []Component `json:"components"`
+}
+
+type namedCheck struct {
+	name string
+	check CheckFunc
+}
+
+// Checker runs the registered readiness checks
+type Checker struct {
+	mu sync.RWMutex
+	checks []namedCheck
+	shuttingDown int32
+}
+
+// Checks is the service's checker, filled in at startup
+var Checks = &Checker{}
+
+// Register adds a check, reported under name in the order registered
+func (c *Checker) Register(name string, check CheckFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.checks = append(c.checks, namedCheck{name, check})
+}
+
+// ShutDown marks the service not ready, so load balancers stop sending it traffic while it drains
+func (c *Checker) ShutDown() {
+	atomic.StoreInt32(&c.shuttingDown, 1)
+}
+
+// ShuttingDown tells whether ShutDown was called
+func (c *Checker) ShuttingDown() bool {
+	return atomic.LoadInt32(&c.shuttingDown) == 1
+}
+
+// Ready runs every check concurrently and reports whether the service can take traffic
+func (c *Checker) Ready(ctx context.Context) (*Report, bool) {
+	c.mu.RLock()
+	checks := append([]namedCheck(nil), c.checks...)
+	c.mu.RUnlock()
+
+	report := &Report{
+		Status: StatusUp,
+		ShuttingDown: c.ShuttingDown(),
+		Components: make([]Component, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, iterCheck := range checks {
+		wg.Add(1)
+		go func(i int, named namedCheck) {
+			defer wg.Done()
+			report.Components[i] = run(ctx, named)
+		}(i, iterCheck)
+	}
+	wg.Wait()
+
+	for _, iterComponent := range report.Components {
+		report.Status = worst(report.Status, iterComponent.Status)
+	}
+	if report.ShuttingDown {
+		report.Status = StatusDown
+	}
+
+	return report, report.Status != StatusDown
+}
+
+// run times a check, giving up on it after CheckTimeout
+func run(ctx context.Context, named namedCheck) Component {
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout)
+	defer cancel()
+
+	started := time.Now()
+	done := make(chan Result, 1)
+	go func() {
+		done <- named.check(ctx)
+	}()
+
+	var result Result
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = Result{Status: StatusDown, Message: "Check timed out"}
+	}
+
+	return Component{
+		Name: named.name,
+		Status: result.Status,
+		LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+		Message: result.Message,
+		Details: result.Details,
+	}
+}
+
+func worst(a, b string) string {
+	if a == StatusDown || b == StatusDown {
+		return StatusDown
+	}
+	if a == StatusDegraded || b == StatusDegraded {
+		return StatusDegraded
+	}
+
+	return StatusUp
+}
+
+// FromError is up when err is nil, down with its message otherwise
+func FromError(err error) Result {
+	if err != nil {
+		return Result{Status: StatusDown, Message: err.Error()}
+	}
+
+	return Result{Status: StatusUp}
+}
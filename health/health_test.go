@@ -0,0 +1,175 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/ledger"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func check(status string) CheckFunc {
+	return func(ctx context.Context) Result {
+		return Result{Status: status}
+	}
+}
+
+func TestReady(t *testing.T) {
+	assert := assert.New(t)
+
+	hanging := func(ctx context.Context) Result {
+		time.Sleep(CheckTimeout + time.Second)
+		return Result{Status: StatusUp}
+	}
+
+	tests := []struct{
+		checks []CheckFunc
+		shuttingDown bool
+		expectedStatus string
+		expectedReady bool
+		description string
+	}{
+		{[]CheckFunc{check(StatusUp), check(StatusUp)}, false, StatusUp, true, "OK - Every check up"},
+		{[]CheckFunc{check(StatusUp), check(StatusDegraded)}, false, StatusDegraded, true, "OK - Degraded still takes traffic"},
+		{[]CheckFunc{check(StatusDegraded), check(StatusDown)}, false, StatusDown, false, "Not ready - A check down"},
+		{[]CheckFunc{check(StatusUp), hanging}, false, StatusDown, false, "Not ready - A check timed out"},
+		{[]CheckFunc{check(StatusUp)}, true, StatusDown, false, "Not ready - Shutting down"},
+	}
+
+	for _, iterTest := range tests {
+		checker := &Checker{}
+		for _, iterCheck := range iterTest.checks {
+			checker.Register("check", iterCheck)
+		}
+		if iterTest.shuttingDown {
+			checker.ShutDown()
+		}
+
+		report, ready := checker.Ready(context.Background())
+		assert.Equal(iterTest.expectedStatus, report.Status, iterTest.description)
+		assert.Equal(iterTest.expectedReady, ready, iterTest.description)
+		assert.Equal(iterTest.shuttingDown, report.ShuttingDown, iterTest.description)
+		assert.Len(report.Components, len(iterTest.checks), iterTest.description)
+	}
+}
+
+func TestAcquirers(t *testing.T) {
+	assert := assert.New(t)
+
+	defer bank.ConfigureAcquirers(bank.DefaultRoutingConfig(), bank.DefaultBreakerSettings(), bank.DefaultRetryPolicy())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// a port nothing listens on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(err)
+	closedURL := "http://" + listener.Addr().String()
+	listener.Close()
+
+	configure := func(urls ...string) {
+		routing := &bank.RoutingConfig{}
+		for i, iterURL := range urls {
+			name := string(rune('a' + i))
+			routing.Acquirers = append(routing.Acquirers, bank.AcquirerConfig{Name: name, URL: iterURL})
+			routing.Default = append(routing.Default, name)
+		}
+		assert.NoError(bank.ConfigureAcquirers(routing, bank.BreakerSettings{FailureThreshold: 1, OpenTimeout: time.Minute, HalfOpenMaxCalls: 1}, bank.DefaultRetryPolicy()))
+	}
+
+	// each call checks again, as once AcquirersCacheTTL passed
+	uncached := func() Result {
+		acquirersCache.at = time.Time{}
+		return Acquirers(context.Background())
+	}
+
+	configure(server.URL, server.URL)
+	result := uncached()
+	assert.Equal(StatusUp, result.Status, "Up - Every acquirer reachable")
+	assert.Equal([]AcquirerHealth{{Name: "a", Breaker: bank.StateClosed, Reachable: true}, {Name: "b", Breaker: bank.StateClosed, Reachable: true}}, result.Details)
+
+	connector, err := bank.GetConnector("b")
+	assert.NoError(err)
+	connector.Breaker.Record(false)
+	result = uncached()
+	assert.Equal(StatusDegraded, result.Status, "Degraded - Breaker open")
+	assert.Equal("1 of 2 acquirers available", result.Message)
+
+	configure(closedURL)
+	assert.Equal(StatusDegraded, Acquirers(context.Background()).Status, "Cached - Last result within the TTL")
+
+	result = uncached()
+	assert.Equal(StatusDown, result.Status, "Down - No acquirer reachable")
+	assert.Equal([]AcquirerHealth{{Name: "a", Breaker: bank.StateClosed, Reachable: false}}, result.Details, "Down - No dial error in the report")
+}
+
+func TestFromError(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(Result{Status: StatusUp}, FromError(nil))
+	assert.Equal(Result{Status: StatusDown, Message: "Invalid config - port"}, FromError(errors.New("Invalid config - port")))
+}
+
+type brokenLedger struct {
+	ledger.LedgerI
+	err error
+}
+
+func (bl *brokenLedger) Check() error {
+	return bl.err
+}
+
+func TestLedger(t *testing.T) {
+	assert := assert.New(t)
+
+	defaultLedger := ledger.Ledger
+	defer func() { ledger.Ledger = defaultLedger }()
+
+	assert.Equal(StatusUp, Ledger(context.Background()).Status, "Up - Not checked yet")
+
+	ledger.Ledger = &brokenLedger{}
+	ledger.RunCheck(time.Now())
+	assert.Equal(StatusUp, Ledger(context.Background()).Status, "Up - Invariants hold")
+
+	ledger.Ledger = &brokenLedger{err: errors.New("Ledger failure - entry je_1 does not balance")}
+	ledger.RunCheck(time.Now())
+	result := Ledger(context.Background())
+	assert.Equal(StatusDegraded, result.Status, "Degraded - Invariants broken")
+	assert.Equal("Ledger invariants broken", result.Message, "Degraded - Which invariant is only logged")
+}
+
+func TestFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := ioutil.TempFile("", "pricing")
+	assert.NoError(err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	tests := []struct{
+		files map[string]string
+		expected Result
+		description string
+	}{
+		{map[string]string{}, Result{Status: StatusUp}, "Up - No files configured"},
+		{map[string]string{"pricing_file": file.Name()}, Result{Status: StatusUp}, "Up - Files readable"},
+		{
+			map[string]string{"pricing_file": file.Name(), "risk_file": file.Name() + ".missing"},
+			Result{Status: StatusDegraded, Message: "1 of 2 files unreadable", Details: []string{"risk_file"}},
+			"Degraded - A file went missing",
+		},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.expected, Files(iterTest.files)(context.Background()), iterTest.description)
+	}
+}
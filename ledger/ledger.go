@@ -0,0 +1,279 @@
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry types
+const (
+	EntryHold = "hold"
+	EntryCapture = "capture"
+	EntryCaptureUnknown = "capture_unknown"
+	EntryRefund = "refund"
+	EntryRefundUnknown = "refund_unknown"
+	EntryVoid = "void"
+	EntryFee = "fee"
+	EntryConversion = "conversion"
+	EntryDispute = "dispute"
+	EntryDisputeReversal = "dispute_reversal"
+	EntrySettlement = "settlement"
+)
+
+// Shared accounts. Per authorization and per merchant accounts are named with the helpers below.
+const (
+	// IssuerHold is the contra account of customer holds
+	IssuerHold = "issuer_hold"
+	// RefundableControl is the contra account of refundable amounts
+	RefundableControl = "refundable_control"
+	// AcquirerReceivable is what acquirers owe us for captures, net of refunds
+	AcquirerReceivable = "acquirer_receivable"
+	// Fees collected from merchants
+	Fees = "fees"
+	// FxClearing takes the presentment currency leg of converted amounts in one currency and
+	// gives the settlement currency leg in the other, what it holds over all currencies is the FX position
+	FxClearing = "fx_clearing"
+)
+
+// amounts are in major units, anything below this is a rounding error
+const epsilon = 0.000001
+
+var ErrUnbalanced = errors.New("Ledger failure - Entry does not balance")
+
+// CustomerHold is the amount of an authorization still held on the card
+func CustomerHold(authorizationId string) string {
+	return "customer_hold:" + authorizationId
+}
+
+// Refundable is the captured amount of an authorization that can still be refunded
+func Refundable(authorizationId string) string {
+	return "refundable:" + authorizationId
+}
+
+// MerchantPending is what we owe a merchant for captures not settled yet
+func MerchantPending(merchant string) string {
+	return "merchant_pending:" + merchant
+}
+
+// MerchantAvailable is what we owe a merchant for settled captures
+func MerchantAvailable(merchant string) string {
+	return "merchant_available:" + merchant
+}
+
+// Posting moves Amount in or out of an account: positive amounts are debits, negative amounts credits
+type Posting struct {
+	Account string	`json:"account"`
+	Amount float64	`json:"amount"`
+}
+
+// Entry is a journal entry, its postings always sum to zero
+type Entry struct {
+	Id string								`json:"id"`
+	Type string							`json:"type"`
+	AuthorizationId string	`json:"authorization_id,omitempty"`
+	Merchant string					`json:"merchant,omitempty"`
+	Currency string					`json:"currency"`
+	CreatedAt time.Time			`json:"created_at"`
+	Postings []Posting			`json:"postings"`
+}
+
+type LedgerI interface {
+	Post(*Entry) error
+	// Balance is the sum of the postings of an account in a currency, debits minus credits
+	Balance(string, string) float64
+	// AccountBalances returns the balance of an account in every currency it holds
+	AccountBalances(string) map[string]float64
+	EntriesOf(string) []*Entry
+	Check() error
+}
+
+var Ledger LedgerI = InitMemoryLedger()
+
+type memoryLedger struct {
+	entries []*Entry
+	// account -> currency -> balance
+	balances map[string]map[string]float64
+	// authorization id -> entries
+	by_authorization map[string][]*Entry
+
+	mu sync.RWMutex
+}
+
+func InitMemoryLedger() *memoryLedger {
+	return &memoryLedger{
+		balances: make(map[string]map[string]float64),
+		by_authorization: make(map[string][]*Entry),
+	}
+}
+
+// Post validates and appends an entry, it is immutable afterwards
+func (ml *memoryLedger) Post(entry *Entry) error {
+	if len(entry.Postings) < 2 {
+		return fmt.Errorf("Ledger failure - %s entry needs at least two postings", entry.Type)
+	}
+
+	if entry.Currency == "" {
+		return fmt.Errorf("Ledger failure - %s entry has no currency", entry.Type)
+	}
+
+	if !balanced(entry.Postings) {
+		return ErrUnbalanced
+	}
+
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	entry.Currency = strings.ToUpper(entry.Currency)
+	entry.Id = fmt.Sprintf("je_%d", len(ml.entries) + 1)
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now().UTC()
+	}
+
+	ml.entries = append(ml.entries, entry)
+	if entry.AuthorizationId != "" {
+		ml.by_authorization[entry.AuthorizationId] = append(ml.by_authorization[entry.AuthorizationId], entry)
+	}
+
+	for _, iterPosting := range entry.Postings {
+		if ml.balances[iterPosting.Account] == nil {
+			ml.balances[iterPosting.Account] = make(map[string]float64)
+		}
+		ml.balances[iterPosting.Account][entry.Currency] += iterPosting.Amount
+	}
+
+	return nil
+}
+
+func (ml *memoryLedger) Balance(account string, currency string) float64 {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	return round(ml.balances[account][strings.ToUpper(currency)])
+}
+
+func (ml *memoryLedger) AccountBalances(account string) map[string]float64 {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	balances := make(map[string]float64)
+	for currency, balance := range ml.balances[account] {
+		balances[currency] = round(balance)
+	}
+
+	return balances
+}
+
+// EntriesOf returns the entries of an authorization, oldest first
+func (ml *memoryLedger) EntriesOf(authorizationId string) []*Entry {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	return append([]*Entry{}, ml.by_authorization[authorizationId]...)
+}
+
+// Check verifies that every entry balances, that every currency sums to zero over all accounts
+// and that the running balances match the ones recomputed from the journal
+func (ml *memoryLedger) Check() error {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	recomputed := make(map[string]map[string]float64)
+	totals := make(map[string]float64)
+
+	for _, iterEntry := range ml.entries {
+		if !balanced(iterEntry.Postings) {
+			return fmt.Errorf("Ledger failure - entry %s does not balance", iterEntry.Id)
+		}
+
+		for _, iterPosting := range iterEntry.Postings {
+			if recomputed[iterPosting.Account] == nil {
+				recomputed[iterPosting.Account] = make(map[string]float64)
+			}
+			recomputed[iterPosting.Account][iterEntry.Currency] += iterPosting.Amount
+			totals[iterEntry.Currency] += iterPosting.Amount
+		}
+	}
+
+	for currency, total := range totals {
+		if math.Abs(total) > epsilon {
+			return fmt.Errorf("Ledger failure - %s accounts sum to %f instead of zero", currency, total)
+		}
+	}
+
+	accounts := make([]string, 0, len(ml.balances))
+	for account := range ml.balances {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+
+	for _, iterAccount := range accounts {
+		for currency, balance := range ml.balances[iterAccount] {
+			if math.Abs(balance - recomputed[iterAccount][currency]) > epsilon {
+				return fmt.Errorf("Ledger failure - %s balance in %s does not match its entries", iterAccount, currency)
+			}
+		}
+	}
+
+	return nil
+}
+
+func balanced(postings []Posting) bool {
+	sum := 0.0
+	for _, iterPosting := range postings {
+		sum += iterPosting.Amount
+	}
+
+	return math.Abs(sum) < epsilon
+}
+
+// balances are shown rounded to cents
+func round(amount float64) float64 {
+	return math.Round(amount * 100) / 100
+}
+
+// MerchantBalance is what we owe a merchant in a currency
+type MerchantBalance struct {
+	Currency string		`json:"currency" example:"EUR"`
+	Pending float64		`json:"pending" example:"120.50"`
+	Available float64	`json:"available" example:"1000.00"`
+}
+
+// MerchantBalances returns the balances of a merchant by currency. Merchant accounts
+// are credit accounts, so balances are shown as credits: positive means owed to the merchant.
+func MerchantBalances(merchant string) []MerchantBalance {
+	byCurrency := make(map[string]*MerchantBalance)
+	balanceOf := func(currency string) *MerchantBalance {
+		if byCurrency[currency] == nil {
+			byCurrency[currency] = &MerchantBalance{Currency: currency}
+		}
+		return byCurrency[currency]
+	}
+
+	for currency, balance := range Ledger.AccountBalances(MerchantPending(merchant)) {
+		balanceOf(currency).Pending = credit(balance)
+	}
+
+	for currency, balance := range Ledger.AccountBalances(MerchantAvailable(merchant)) {
+		balanceOf(currency).Available = credit(balance)
+	}
+
+	balances := make([]MerchantBalance, 0, len(byCurrency))
+	for _, iterBalance := range byCurrency {
+		balances = append(balances, *iterBalance)
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Currency < balances[j].Currency
+	})
+
+	return balances
+}
+
+// credit shows a balance as a credit, without turning zero into -0
+func credit(balance float64) float64 {
+	return 0 - balance
+}
@@ -0,0 +1,152 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPost(t *testing.T) {
+	assert := assert.New(t)
+
+	testLedger := InitMemoryLedger()
+
+	tests := []struct{
+		entry *Entry
+		err error
+		description string
+	}{
+		{
+			&Entry{
+				Type: EntryHold,
+				AuthorizationId: "auth_1",
+				Currency: "eur",
+				Postings: []Posting{
+					{CustomerHold("auth_1"), 100},
+					{IssuerHold, -100},
+				},
+			},
+			nil,
+			"OK - Balanced entry",
+		},
+		{
+			&Entry{
+				Type: EntryCapture,
+				AuthorizationId: "auth_1",
+				Currency: "EUR",
+				Postings: []Posting{
+					{IssuerHold, 0.1},
+					{IssuerHold, 0.2},
+					{CustomerHold("auth_1"), -0.3},
+				},
+			},
+			nil,
+			"OK - Balanced up to float rounding",
+		},
+		{
+			&Entry{
+				Type: EntryCapture,
+				Currency: "EUR",
+				Postings: []Posting{
+					{AcquirerReceivable, 10},
+					{MerchantPending("Checkout"), -9},
+				},
+			},
+			ErrUnbalanced,
+			"Error - Unbalanced entry",
+		},
+		{
+			&Entry{
+				Type: EntryFee,
+				Currency: "EUR",
+				Postings: []Posting{
+					{Fees, -1},
+				},
+			},
+			errors.New("Ledger failure - fee entry needs at least two postings"),
+			"Error - Single posting",
+		},
+		{
+			&Entry{
+				Type: EntryFee,
+				Postings: []Posting{
+					{MerchantPending("Checkout"), 1},
+					{Fees, -1},
+				},
+			},
+			errors.New("Ledger failure - fee entry has no currency"),
+			"Error - No currency",
+		},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.err, testLedger.Post(iterTest.entry), iterTest.description)
+	}
+
+	assert.Equal(99.70, testLedger.Balance(CustomerHold("auth_1"), "EUR"), "Balance - Sum of postings")
+	assert.Equal(0.0, testLedger.Balance(MerchantPending("Checkout"), "EUR"), "Balance - Rejected entries are not posted")
+	assert.Len(testLedger.EntriesOf("auth_1"), 2, "Entries of the authorization")
+	assert.Equal("EUR", testLedger.EntriesOf("auth_1")[0].Currency, "Currency is normalized")
+	assert.NoError(testLedger.Check(), "Invariants hold")
+}
+
+func TestCheck(t *testing.T) {
+	assert := assert.New(t)
+
+	testLedger := InitMemoryLedger()
+	assert.NoError(testLedger.Post(&Entry{
+		Type: EntryCapture,
+		Currency: "EUR",
+		Postings: []Posting{
+			{AcquirerReceivable, 10},
+			{MerchantPending("Checkout"), -10},
+		},
+	}))
+	assert.NoError(testLedger.Check())
+
+	// tampering with a posted entry breaks the invariants
+	testLedger.entries[0].Postings[0].Amount = 20
+	assert.Equal(errors.New("Ledger failure - entry je_1 does not balance"), testLedger.Check())
+
+	testLedger.entries[0].Postings[1].Amount = -20
+	assert.Equal(errors.New("Ledger failure - acquirer_receivable balance in EUR does not match its entries"), testLedger.Check())
+
+	defaultLedger := Ledger
+	defer func() { Ledger = defaultLedger }()
+	Ledger = testLedger
+
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	assert.Error(RunCheck(now), "Scheduled check")
+	checkedAt, err := LastCheck()
+	assert.Equal(now, checkedAt, "Scheduled check - Recorded")
+	assert.Equal(errors.New("Ledger failure - acquirer_receivable balance in EUR does not match its entries"), err, "Scheduled check - Recorded")
+}
+
+func TestMerchantBalances(t *testing.T) {
+	assert := assert.New(t)
+
+	defaultLedger := Ledger
+	defer func() {
+		Ledger = defaultLedger
+	}()
+	Ledger = InitMemoryLedger()
+
+	entries := []*Entry{
+		{Type: EntryCapture, Currency: "EUR", Postings: []Posting{{AcquirerReceivable, 100}, {MerchantPending("Checkout"), -100}}},
+		{Type: EntryRefund, Currency: "EUR", Postings: []Posting{{MerchantPending("Checkout"), 20}, {AcquirerReceivable, -20}}},
+		{Type: EntrySettlement, Currency: "EUR", Postings: []Posting{{MerchantPending("Checkout"), 50}, {MerchantAvailable("Checkout"), -50}}},
+		{Type: EntryCapture, Currency: "USD", Postings: []Posting{{AcquirerReceivable, 10}, {MerchantPending("Checkout"), -10}}},
+		{Type: EntryCapture, Currency: "EUR", Postings: []Posting{{AcquirerReceivable, 5}, {MerchantPending("Other"), -5}}},
+	}
+	for _, iterEntry := range entries {
+		assert.NoError(Ledger.Post(iterEntry))
+	}
+
+	assert.Equal([]MerchantBalance{
+		{Currency: "EUR", Pending: 30, Available: 50},
+		{Currency: "USD", Pending: 10, Available: 0},
+	}, MerchantBalances("Checkout"))
+	assert.Equal([]MerchantBalance{}, MerchantBalances("Unknown"))
+}
@@ -0,0 +1,61 @@
+package ledger
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCheckInterval is how often the running service verifies the ledger invariants
+const DefaultCheckInterval = 5 * time.Minute
+
+var lastCheck struct {
+	mu sync.RWMutex
+	at time.Time
+	err error
+}
+
+// Schedule checks the ledger invariants every interval until ctx is done. Failures are logged and
+// kept for LastCheck, the ledger is never changed.
+func Schedule(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultCheckInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			RunCheck(now.UTC())
+		}
+	}
+}
+
+// RunCheck verifies the ledger invariants now and records the outcome
+func RunCheck(now time.Time) error {
+	err := Ledger.Check()
+	if err != nil {
+		log.WithField("err", err).Error("Ledger.RunCheck - Invariants broken")
+	}
+
+	lastCheck.mu.Lock()
+	defer lastCheck.mu.Unlock()
+
+	lastCheck.at, lastCheck.err = now, err
+
+	return err
+}
+
+// LastCheck is when the invariants were last verified and what was found, a zero time before the first check
+func LastCheck() (time.Time, error) {
+	lastCheck.mu.RLock()
+	defer lastCheck.mu.RUnlock()
+
+	return lastCheck.at, lastCheck.err
+}
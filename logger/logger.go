@@ -1,26 +1,142 @@
 package logger
 
 import (
-	log "github.com/sirupsen/logrus"
-
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
+	"regexp"
+	"sync"
 	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RequestIDHeader carries the id of a request, taken from the client when valid and always returned
+const RequestIDHeader = "X-Request-ID"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+type contextKey string
+
+const (
+	requestIDKey contextKey = "request_id"
+	fieldsKey contextKey = "access_fields"
 )
 
-func APICallsLogger(inner http.Handler, name string) http.Handler {
+// accessFields are added to the access log line by the handlers serving the request
+type accessFields struct {
+	fields log.Fields
+	mu sync.Mutex
+}
+
+// AccessLogger assigns the request id and logs one structured line per request once it is served
+func AccessLogger(inner http.Handler, name string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		r.Close = true
+		requestID := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, requestID)
+
+		annotations := &accessFields{fields: log.Fields{}}
+		ctx := context.WithValue(WithRequestID(r.Context(), requestID), fieldsKey, annotations)
+
+		recorder := NewResponseRecorder(w)
+		inner.ServeHTTP(recorder, r.WithContext(ctx))
 
-		inner.ServeHTTP(w, r)
+		fields := log.Fields{
+			"request_id": requestID,
+			"route": name,
+			"method": r.Method,
+			"path": r.URL.Path,
+			"status": recorder.Status,
+			"bytes": recorder.Bytes,
+			"latency_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"remote_addr": r.RemoteAddr,
+		}
 
-		log.Printf(
-			"%s %s %s %s",
-			r.Method,
-			r.RequestURI,
-			name,
-			time.Since(start),
-		)
+		annotations.mu.Lock()
+		for key, value := range annotations.fields {
+			fields[key] = value
+		}
+		annotations.mu.Unlock()
+
+		entry := log.WithFields(fields)
+		if recorder.Status >= http.StatusInternalServerError {
+			entry.Warn("AccessLogger - Request served")
+			return
+		}
+		entry.Info("AccessLogger - Request served")
 	})
 }
+
+// Annotate adds a field, e.g. the merchant or the authorization id, to the access log line of the request of ctx
+func Annotate(ctx context.Context, key string, value interface{}) {
+	annotations, ok := ctx.Value(fieldsKey).(*accessFields)
+	if !ok {
+		return
+	}
+
+	annotations.mu.Lock()
+	defer annotations.mu.Unlock()
+
+	annotations.fields[key] = value
+}
+
+// WithRequestID stores the request id in the context
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromContext returns the id of the request being served, if any
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey).(string)
+	return requestID
+}
+
+// FromContext is a log entry carrying the request id of ctx, so every line logged while serving a request can be correlated
+func FromContext(ctx context.Context) *log.Entry {
+	entry := log.NewEntry(log.StandardLogger())
+
+	if requestID := RequestIDFromContext(ctx); requestID != "" {
+		entry = entry.WithField("request_id", requestID)
+	}
+
+	return entry
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return hex.EncodeToString([]byte(time.Now().UTC().Format(time.RFC3339Nano)))
+	}
+
+	return hex.EncodeToString(id)
+}
+
+// ResponseRecorder keeps the status code and the size of the response written through it
+type ResponseRecorder struct {
+	http.ResponseWriter
+	// Status is 200 unless the handler writes another one
+	Status int
+	Bytes int
+}
+
+func NewResponseRecorder(w http.ResponseWriter) *ResponseRecorder {
+	return &ResponseRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+func (rr *ResponseRecorder) WriteHeader(status int) {
+	rr.Status = status
+	rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *ResponseRecorder) Write(data []byte) (int, error) {
+	written, err := rr.ResponseWriter.Write(data)
+	rr.Bytes += written
+
+	return written, err
+}
@@ -0,0 +1,68 @@
+package logger
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessLogger(t *testing.T) {
+	assert := assert.New(t)
+
+	log.SetOutput(ioutil.Discard)
+	hook := test.NewGlobal()
+	defer hook.Reset()
+
+	var innerRequestID string
+	handler := AccessLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		innerRequestID = RequestIDFromContext(r.Context())
+		Annotate(r.Context(), "merchant", "Checkout")
+		FromContext(r.Context()).Info("Handler - Serving")
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("Created"))
+	}), "CreateAuthorization")
+
+	tests := []struct{
+		requestID string
+		propagated bool
+		description string
+	}{
+		{"client-id-1", true, "Client id - Propagated"},
+		{"", false, "No id - Generated"},
+		{"bad id\n", false, "Invalid id - Replaced"},
+	}
+
+	for _, iterTest := range tests {
+		hook.Reset()
+
+		req := httptest.NewRequest("POST", "/authorize", nil)
+		if iterTest.requestID != "" {
+			req.Header.Set(RequestIDHeader, iterTest.requestID)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+
+		requestID := w.Header().Get(RequestIDHeader)
+		assert.NotEmpty(requestID, iterTest.description)
+		assert.Equal(iterTest.propagated, requestID == iterTest.requestID, iterTest.description)
+		assert.Equal(requestID, innerRequestID, iterTest.description)
+
+		entries := hook.AllEntries()
+		assert.Len(entries, 2, iterTest.description)
+		assert.Equal(requestID, entries[0].Data["request_id"], "Handler logs carry the request id")
+
+		access := entries[1].Data
+		assert.Equal(requestID, access["request_id"], iterTest.description)
+		assert.Equal("CreateAuthorization", access["route"], iterTest.description)
+		assert.Equal(http.StatusCreated, access["status"], iterTest.description)
+		assert.Equal(7, access["bytes"], iterTest.description)
+		assert.Equal("Checkout", access["merchant"], "Annotations logged")
+	}
+}
@@ -1,18 +1,42 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"flag"
+	stdlog "log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/certs"
+	"github.com/nktsitas/checkout-techlab/config"
+	"github.com/nktsitas/checkout-techlab/ratelimit"
 	"github.com/nktsitas/checkout-techlab/router"
 	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/disputes"
+	"github.com/nktsitas/checkout-techlab/events"
+	"github.com/nktsitas/checkout-techlab/fees"
+	"github.com/nktsitas/checkout-techlab/fx"
 	"github.com/nktsitas/checkout-techlab/gateway"
+	"github.com/nktsitas/checkout-techlab/health"
+	"github.com/nktsitas/checkout-techlab/ledger"
+	"github.com/nktsitas/checkout-techlab/merchants"
+	"github.com/nktsitas/checkout-techlab/reconciliation"
+	"github.com/nktsitas/checkout-techlab/risk"
+	"github.com/nktsitas/checkout-techlab/settlement"
+	"github.com/nktsitas/checkout-techlab/tracing"
 	
 	log "github.com/sirupsen/logrus"
 )
 
-const port = "2012"
-
 // @title Checkout.com API Challenge
 // @version 1.0
 // @description This is a simple Gateway service for Payments
@@ -24,21 +48,329 @@ const port = "2012"
 // @host localhost:2012
 // @BasePath /
 func main() {
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.WithField("err", err).Fatal("Error loading configuration")
+	}
+
+	// structured logs by default, text for reading them in a terminal
+	if cfg.Log.Format != config.LogFormatText {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+	level, _ := log.ParseLevel(cfg.Log.Level)
+	log.SetLevel(level)
+
+	shutdownTracing, err := tracing.Init(cfg.Tracing.Exporter, "checkout-techlab")
+	if err != nil {
+		log.WithField("err", err).Fatal("Error initializing tracing")
+	}
+
+	auth.Users = cfg.Auth.Users
+	auth.AdminPassword = cfg.Auth.AdminPassword
+	auth.AccessSecret = cfg.Auth.AccessSecret
+	auth.TokenExpiry = cfg.Auth.TokenExpiry.Duration
+	auth.ClientCertMerchants = cfg.TLS.ClientCertMerchants
+	auth.TrustForwardedFor = cfg.RateLimit.TrustForwardedFor
+	auth.Lockout = auth.NewLoginLockout(cfg.Auth.Lockout.MaxFailures, cfg.Auth.Lockout.Window.Duration, cfg.Auth.Lockout.Duration.Duration)
+
+	ratelimit.Configure(cfg.RateLimit.Settings())
+
+	// storage.backend is validated, memory is the only backend for now
 	db.DB = db.InitMemoryDB()
 	gateway.Gateway = new(gateway.GatewayS)
 
+	latencyMs := int(cfg.Bank.SimulatorLatency.Duration / time.Millisecond)
+	bank.Scenarios.DefaultLatency = bank.Latency{MinMs: latencyMs, MaxMs: latencyMs}
+
+	// QA can script acquirer behaviour without recompiling
+	if scenariosFile := cfg.Bank.ScenariosFile; scenariosFile != "" {
+		scenarios, err := bank.LoadScenarios(scenariosFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error loading bank scenarios")
+		}
+
+		bank.Scenarios = scenarios
+		log.Info(fmt.Sprintf("Checkout Tech Test API - Loaded %d bank scenarios from %s", len(scenarios.Scenarios), scenariosFile))
+	}
+
+	for operation, timeout := range cfg.Bank.Timeouts {
+		bank.Timeouts[operation] = timeout.Duration
+	}
+
+	routing := bank.DefaultRoutingConfig()
+	if routingFile := cfg.Bank.RoutingFile; routingFile != "" {
+		routing, err = bank.LoadRouting(routingFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error loading acquirer routing")
+		}
+	}
+
+	if err := routing.SetEndpoints(cfg.Bank.AcquirerURLs); err != nil {
+		log.WithField("err", err).Fatal("Error configuring acquirer endpoints")
+	}
+
+	if err := bank.ConfigureAcquirers(routing, cfg.Bank.BreakerSettings(), cfg.Bank.RetryPolicy()); err != nil {
+		log.WithField("err", err).Fatal("Error configuring acquirers")
+	}
+
+	// issuing countries tell domestic from cross-border cards for pricing
+	if binCountriesFile := cfg.Bank.BinCountriesFile; binCountriesFile != "" {
+		binCountries, err := bank.LoadBinCountries(binCountriesFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error loading BIN countries")
+		}
+
+		bank.BinCountries = binCountries
+	}
+
+	if merchantsFile := cfg.MerchantsFile; merchantsFile != "" {
+		registry, err := merchants.LoadMerchants(merchantsFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error loading merchants")
+		}
+
+		merchants.Merchants = registry
+		log.Info(fmt.Sprintf("Checkout Tech Test API - Loaded %d merchants from %s", len(registry.Merchants), merchantsFile))
+	}
+
+	if pricingFile := cfg.PricingFile; pricingFile != "" {
+		pricing, err := fees.LoadPricing(pricingFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error loading pricing plans")
+		}
+
+		fees.Pricing = pricing
+		log.Info(fmt.Sprintf("Checkout Tech Test API - Loaded %d pricing plans from %s", len(pricing.Plans), pricingFile))
+	}
+
+	// merchants settling in another currency need rates, only same currency conversions work without them
+	if ratesFile := cfg.FXRatesFile; ratesFile != "" {
+		rates, err := fx.LoadStaticRates(ratesFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error loading FX rates")
+		}
+
+		fx.Provider = rates
+		log.Info(fmt.Sprintf("Checkout Tech Test API - Loaded %d FX rates against %s from %s", len(rates.Rates), rates.Base, ratesFile))
+	}
+
+	if riskFile := cfg.RiskFile; riskFile != "" {
+		engine, err := risk.LoadEngine(riskFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error loading risk rules")
+		}
+
+		risk.Engine = engine
+		log.Info(fmt.Sprintf("Checkout Tech Test API - Loaded %d risk rules, %d allow and %d block list entries from %s", len(engine.Rules), len(engine.Allow), len(engine.Block), riskFile))
+	}
+
+	// background workers stop when the service shuts down
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	workers := &workerGroup{}
+	startWorker := func(run func(context.Context)) {
+		workers.start(workersCtx, run)
+	}
+
+	// validated with the configuration
+	cutoff, _ := settlement.ParseCutoff(cfg.Settlement.Cutoff)
+
+	startWorker(func(ctx context.Context) { settlement.Schedule(ctx, cutoff) })
+
+	// acquirer settlement files dropped here are reconciled and moved to processed/
+	if reconciliationDir := cfg.Reconciliation.Dir; reconciliationDir != "" {
+		startWorker(func(ctx context.Context) { reconciliation.Watch(ctx, reconciliationDir, cfg.Reconciliation.Interval.Duration) })
+	}
+
+	disputes.ResponseWindow = cfg.Disputes.ResponseWindow.Duration
+	startWorker(func(ctx context.Context) { disputes.Schedule(ctx, disputes.DefaultExpiryInterval) })
+	startWorker(func(ctx context.Context) { ledger.Schedule(ctx, ledger.DefaultCheckInterval) })
+
+	health.Checks.Register("storage", health.Storage)
+	health.Checks.Register("acquirers", health.Acquirers)
+	health.Checks.Register("config", health.Files(cfg.Files()))
+	health.Checks.Register("ledger", health.Ledger)
+
+	router.LegacyRoutes = cfg.API.LegacyRoutes
+	router.LegacySunset = cfg.API.LegacySunsetDate()
+
 	router := router.NewRouter()
 
 	// Fire up server
 	server := &http.Server{
-		Addr:    ":" + port,
+		Addr:    ":" + strconv.Itoa(cfg.Port),
 		Handler: router,
+		// TLS handshake errors and the like, in the same format as the rest of the logs
+		ErrorLog: stdlog.New(log.StandardLogger().WriterLevel(log.WarnLevel), "", 0),
+	}
+
+	servers := []*http.Server{server}
+
+	if cfg.TLS.CertFile != "" {
+		reloader, err := certs.NewReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error loading TLS certificate")
+		}
+
+		server.TLSConfig, err = certs.ServerConfig(reloader, cfg.TLS.ClientAuth, cfg.TLS.ClientCAFile)
+		if err != nil {
+			log.WithField("err", err).Fatal("Error configuring TLS")
+		}
+
+		// a rotated certificate is served from the next handshake
+		startWorker(func(ctx context.Context) { reloader.Watch(ctx, cfg.TLS.ReloadInterval.Duration) })
+
+		if cfg.TLS.RedirectPort != 0 {
+			servers = append(servers, &http.Server{
+				Addr:    ":" + strconv.Itoa(cfg.TLS.RedirectPort),
+				Handler: certs.RedirectHandler(cfg.Port),
+				ErrorLog: server.ErrorLog,
+			})
+		}
 	}
 
 	log.Info(fmt.Sprintf("Checkout Tech Test API - Candidate: Nikos Tsitas"))
-	log.Info(fmt.Sprintf("Checkout Tech Test API - Listening on port: %s", port))
+	log.Info(fmt.Sprintf("Checkout Tech Test API - Listening on port: %d (TLS: %t)", cfg.Port, server.TLSConfig != nil))
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	serveErr := make(chan error, len(servers))
+	go func() {
+		if server.TLSConfig != nil {
+			// the certificate comes from TLSConfig.GetCertificate
+			serveErr <- server.ListenAndServeTLS("", "")
+			return
+		}
+		serveErr <- server.ListenAndServe()
+	}()
+	for _, iterServer := range servers[1:] {
+		log.Info(fmt.Sprintf("Checkout Tech Test API - Redirecting HTTP to HTTPS from port: %d", cfg.TLS.RedirectPort))
+		go func(redirect *http.Server) {
+			serveErr <- redirect.ListenAndServe()
+		}(iterServer)
+	}
+
+	select {
+	case err := <-serveErr:
+		log.WithField("err", err).Error("Error initializing server")
+		stopWorkers()
+		os.Exit(exitServeFailure)
+	case received := <-signals:
+		log.WithField("signal", received.String()).Info("Checkout Tech Test API - Shutting down, draining in-flight requests")
+	}
+
+	// a second signal gives up on draining
+	go func() {
+		received := <-signals
+		log.WithField("signal", received.String()).Error("Checkout Tech Test API - Forced shutdown")
+		os.Exit(exitShutdownIncomplete)
+	}()
+
+	// load balancers see the instance not ready and stop routing to it before the listener closes
+	health.Checks.ShutDown()
+	if delay := cfg.ShutdownDelay.Duration; delay > 0 {
+		log.WithField("delay", delay.String()).Info("Checkout Tech Test API - Reporting not ready before draining")
+		time.Sleep(delay)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout.Duration)
+	defer cancel()
+
+	os.Exit(shutdown(ctx, servers, stopWorkers, workers, shutdownTracing))
+}
+
+// Exit codes
+const (
+	exitOK = 0
+	// exitServeFailure is a server that couldn't start or stopped on its own, e.g. the port is taken
+	exitServeFailure = 1
+	// exitShutdownIncomplete is a shutdown that gave up on in-flight work: the deadline passed or a second signal came
+	exitShutdownIncomplete = 2
+)
+
+// shutdown stops accepting requests, waits for in-flight requests, background workers, reversals and
+// webhook deliveries until ctx is done, then closes storage and flushes spans. It returns the exit code.
+func shutdown(ctx context.Context, servers []*http.Server, stopWorkers context.CancelFunc, workers *workerGroup, shutdownTracing func(context.Context) error) int {
+	code := exitOK
+
+	// no new settlement, reconciliation or expiry run starts, one in progress is finished
+	stopWorkers()
+
+	// handlers run to completion, so an approved capture is always recorded (and audited) before we go on
+	for _, iterServer := range servers {
+		if err := iterServer.Shutdown(ctx); err != nil {
+			log.WithField("err", err).Error("Shutdown - In-flight requests did not finish in time")
+			code = exitShutdownIncomplete
+		}
+	}
+
+	if err := workers.wait(ctx); err != nil {
+		log.Error("Shutdown - Background workers did not finish in time")
+		code = exitShutdownIncomplete
+	}
+
+	if err := gateway.Drain(ctx); err != nil {
+		code = exitShutdownIncomplete
+	}
+
+	if err := events.Flush(ctx); err != nil {
+		code = exitShutdownIncomplete
+	}
+
+	if err := db.Close(); err != nil {
+		log.WithField("err", err).Error("Shutdown - Error closing storage")
+		code = exitShutdownIncomplete
+	}
+
+	// spans get a moment of their own, even when the deadline already passed
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		log.WithField("err", err).Error("Shutdown - Error flushing spans")
+	}
+
+	log.WithField("exit_code", code).Info("Checkout Tech Test API - Stopped")
+
+	return code
+}
+
+// workerGroup runs the background workers, a settlement or a reconciliation in progress is finished before its worker returns
+type workerGroup struct {
+	wg sync.WaitGroup
+	running int64
+}
+
+func (wg *workerGroup) start(ctx context.Context, run func(context.Context)) {
+	wg.wg.Add(1)
+	atomic.AddInt64(&wg.running, 1)
+
+	go func() {
+		defer wg.wg.Done()
+		defer atomic.AddInt64(&wg.running, -1)
+
+		run(ctx)
+	}()
+}
+
+// wait waits for the workers to return until ctx is done
+func (wg *workerGroup) wait(ctx context.Context) error {
+	if atomic.LoadInt64(&wg.running) == 0 {
+		return nil
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.wg.Wait()
+		close(done)
+	}()
 
-	if err := server.ListenAndServe(); err != nil {
-		log.WithField("err", err).Fatal("Error initializing server")
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-}
\ No newline at end of file
+}
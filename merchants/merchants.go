@@ -0,0 +1,128 @@
+package merchants
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Merchant is the profile of a merchant, identified by the client of its authentication token
+type Merchant struct {
+	Id string						`json:"id" yaml:"id"`
+	// Country is the ISO 3166-1 alpha-2 country the merchant trades from
+	Country string			`json:"country" yaml:"country"`
+	// PricingPlan is the name of the merchant's pricing plan, the default plan when empty
+	PricingPlan string	`json:"pricing_plan" yaml:"pricing_plan"`
+	// SettlementCurrency is the currency the merchant is paid in, the currency shoppers paid in when empty
+	SettlementCurrency string	`json:"settlement_currency" yaml:"settlement_currency"`
+	// FxMarkup is the percentage taken off exchange rates when converting to the settlement currency
+	FxMarkup float64		`json:"fx_markup" yaml:"fx_markup"`
+	// WebhookURL receives the merchant's events, signed with WebhookSecret
+	WebhookURL string		`json:"webhook_url" yaml:"webhook_url"`
+	WebhookSecret string	`json:"webhook_secret" yaml:"webhook_secret"`
+}
+
+type Registry struct {
+	// Default applies to merchants without a profile
+	Default Merchant		`json:"default" yaml:"default"`
+	Merchants []Merchant	`json:"merchants" yaml:"merchants"`
+}
+
+var Merchants = &Registry{}
+
+// LoadMerchants reads merchant profiles from a YAML (.yaml/.yml) or JSON file, unknown keys are errors
+func LoadMerchants(path string) (*Registry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading merchants file - %s", err.Error())
+	}
+
+	registry := &Registry{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, registry)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(registry)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing merchants file - %s", err.Error())
+	}
+
+	if err := registry.Validate(); err != nil {
+		return nil, err
+	}
+
+	return registry, nil
+}
+
+func (r *Registry) Validate() error {
+	if err := r.Default.validate(); err != nil {
+		return fmt.Errorf("Invalid merchants - default: %s", err.Error())
+	}
+
+	known := make(map[string]bool)
+	for i := range r.Merchants {
+		iterMerchant := &r.Merchants[i]
+
+		if iterMerchant.Id == "" {
+			return fmt.Errorf("Invalid merchants - merchant without an id")
+		}
+
+		if known[iterMerchant.Id] {
+			return fmt.Errorf("Invalid merchants - merchant %q declared twice", iterMerchant.Id)
+		}
+		known[iterMerchant.Id] = true
+
+		if err := iterMerchant.validate(); err != nil {
+			return fmt.Errorf("Invalid merchants - %s: %s", iterMerchant.Id, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (m *Merchant) validate() error {
+	if m.Country != "" && len(m.Country) != 2 {
+		return fmt.Errorf("country must be a 2 letter code")
+	}
+	m.Country = strings.ToUpper(m.Country)
+
+	if m.SettlementCurrency != "" && len(m.SettlementCurrency) != 3 {
+		return fmt.Errorf("settlement_currency must be a 3 letter code")
+	}
+	m.SettlementCurrency = strings.ToUpper(m.SettlementCurrency)
+
+	if m.FxMarkup < 0 || m.FxMarkup >= 100 {
+		return fmt.Errorf("fx_markup must be a percentage between 0 and 100")
+	}
+
+	if m.WebhookURL != "" {
+		if parsed, err := url.Parse(m.WebhookURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("webhook_url must be an http(s) URL")
+		}
+	}
+
+	return nil
+}
+
+// Get returns the profile of a merchant, falling back to the default profile
+func (r *Registry) Get(id string) Merchant {
+	for _, iterMerchant := range r.Merchants {
+		if iterMerchant.Id == id {
+			return iterMerchant
+		}
+	}
+
+	merchant := r.Default
+	merchant.Id = id
+
+	return merchant
+}
@@ -0,0 +1,70 @@
+package merchants
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMerchants(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "merchants")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct{
+		file string
+		content string
+		err error
+		description string
+	}{
+		{
+			"merchants.yaml",
+			"default:\n  country: GB\nmerchants:\n  - id: Checkout\n    country: FR\n    pricing_plan: enterprise\n    settlement_currency: eur\n",
+			nil,
+			"OK - YAML",
+		},
+		{
+			"duplicate.json",
+			`{"merchants":[{"id":"Checkout"},{"id":"Checkout"}]}`,
+			errors.New(`Invalid merchants - merchant "Checkout" declared twice`),
+			"Error - Duplicate merchant",
+		},
+		{
+			"typo.json",
+			`{"merchants":[{"id":"Checkout","setlement_currency":"EUR"}]}`,
+			errors.New(`Error parsing merchants file - json: unknown field "setlement_currency"`),
+			"Error - Unknown JSON key",
+		},
+		{
+			"country.yaml",
+			"merchants:\n  - id: Checkout\n    country: France\n",
+			errors.New("Invalid merchants - Checkout: country must be a 2 letter code"),
+			"Error - Invalid country",
+		},
+		{
+			"markup.yaml",
+			"merchants:\n  - id: Checkout\n    settlement_currency: GBP\n    fx_markup: 150\n",
+			errors.New("Invalid merchants - Checkout: fx_markup must be a percentage between 0 and 100"),
+			"Error - Invalid FX markup",
+		},
+	}
+
+	for _, iterTest := range tests {
+		path := filepath.Join(dir, iterTest.file)
+		assert.NoError(ioutil.WriteFile(path, []byte(iterTest.content), 0644))
+
+		_, err := LoadMerchants(path)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+
+	registry, err := LoadMerchants(filepath.Join(dir, "merchants.yaml"))
+	assert.NoError(err)
+	assert.Equal(Merchant{Id: "Checkout", Country: "FR", PricingPlan: "enterprise", SettlementCurrency: "EUR"}, registry.Get("Checkout"), "Known merchant")
+	assert.Equal(Merchant{Id: "Other", Country: "GB"}, registry.Get("Other"), "Unknown merchant - Default profile")
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"strings"
+)
+
+// CurrencyOther labels the currencies that are not ISO 4217 codes
+const CurrencyOther = "other"
+
+// currencies are the active ISO 4217 codes. Currencies come from clients, labelling them as sent would let
+// anyone create series without bound.
+var currencies = map[string]bool{}
+
+func init() {
+	for _, iterCode := range strings.Fields(`
+		AED AFN ALL AMD ANG AOA ARS AUD AWG AZN BAM BBD BDT BGN BHD BIF BMD BND BOB BRL BSD BTN BWP BYN BZD
+		CAD CDF CHF CLP CNY COP CRC CUP CVE CZK DJF DKK DOP DZD EGP ERN ETB EUR FJD FKP GBP GEL GHS GIP GMD
+		GNF GTQ GYD HKD HNL HTG HUF IDR ILS INR IQD IRR ISK JMD JOD JPY KES KGS KHR KMF KPW KRW KWD KYD KZT
+		LAK LBP LKR LRD LSL LYD MAD MDL MGA MKD MMK MNT MOP MRU MUR MVR MWK MXN MYR MZN NAD NGN NIO NOK NPR
+		NZD OMR PAB PEN PGK PHP PKR PLN PYG QAR RON RSD RUB RWF SAR SBD SCR SDG SEK SGD SHP SLE SOS SRD SSP
+		STN SVC SYP SZL THB TJS TMT TND TOP TRY TTD TWD TZS UAH UGX USD UYU UZS VES VND VUV WST XAF XCD XOF
+		XPF YER ZAR ZMW ZWL`) {
+		currencies[iterCode] = true
+	}
+}
+
+// CurrencyLabel is the currency label of currency, CurrencyOther unless it is an ISO 4217 code
+func CurrencyLabel(currency string) string {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if !currencies[currency] {
+		return CurrencyOther
+	}
+
+	return currency
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"strings"
+)
+
+// DeclineCodeOther labels the decline codes that are not known response codes
+const DeclineCodeOther = "other"
+
+// declineCodes are the ISO 8583 response codes acquirers decline with, and the gateway's own risk codes.
+// Decline codes come from acquirers, labelling them as sent would let an acquirer create series without bound.
+var declineCodes = map[string]bool{}
+
+func init() {
+	for _, iterCode := range strings.Fields(`
+		01 02 03 04 05 06 07 12 13 14 15 19 21 25 28 30 39 41 43 51 52 53 54 55 57 58 59 61 62 63 65 75 76 77
+		78 79 82 85 86 91 92 93 94 96 N7 R0 R1 R3
+		card_blocked risk_blocked`) {
+		declineCodes[iterCode] = true
+	}
+}
+
+// DeclineCodeLabel is the decline code label of code, empty for no code and DeclineCodeOther unless it is known
+func DeclineCodeLabel(code string) string {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return ""
+	}
+
+	if !declineCodes[code] {
+		return DeclineCodeOther
+	}
+
+	return code
+}
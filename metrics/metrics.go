@@ -0,0 +1,80 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/nktsitas/checkout-techlab/logger"
+)
+
+// Payment outcomes
+const (
+	OutcomeApproved = "approved"
+	OutcomeDeclined = "declined"
+	// OutcomeBlocked is an authorization stopped by risk screening, it never reached an acquirer
+	OutcomeBlocked = "blocked"
+	OutcomeUnknown = "unknown"
+	OutcomeError = "error"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_http_requests_total",
+		Help: "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "gateway_http_request_duration_seconds",
+		Help: "HTTP request latency by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	HTTPInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gateway_http_requests_in_flight",
+		Help: "HTTP requests being served by route.",
+	}, []string{"route"})
+
+	Payments = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_payments_total",
+		Help: "Payment operations sent to acquirers by operation, outcome, currency and decline code.",
+	}, []string{"operation", "outcome", "currency", "decline_code"})
+
+	Amounts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gateway_payment_amount_total",
+		Help: "Captured and refunded amounts by operation and currency.",
+	}, []string{"operation", "currency"})
+
+	AcquirerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "gateway_acquirer_request_duration_seconds",
+		Help: "Acquirer call latency by acquirer, operation and outcome, one observation per attempt.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"acquirer", "operation", "outcome"})
+)
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Instrument counts and times the requests of a route, name is the route's name
+func Instrument(inner http.Handler, name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		inFlight := HTTPInFlight.WithLabelValues(name)
+		inFlight.Inc()
+		defer inFlight.Dec()
+
+		recorder := logger.NewResponseRecorder(w)
+		inner.ServeHTTP(recorder, r)
+
+		code := strconv.Itoa(recorder.Status)
+		HTTPRequests.WithLabelValues(name, r.Method, code).Inc()
+		HTTPDuration.WithLabelValues(name, r.Method, code).Observe(time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrument(t *testing.T) {
+	assert := assert.New(t)
+
+	var inFlight float64
+	handler := Instrument(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		inFlight = testutil.ToFloat64(HTTPInFlight.WithLabelValues("Capture"))
+
+		if r.URL.Query().Get("fail") != "" {
+			http.Error(w, "Capture failure", http.StatusBadRequest)
+			return
+		}
+
+		w.Write([]byte("OK"))
+	}), "Capture")
+
+	for _, iterTarget := range []string{"/capture", "/capture", "/capture?fail=1"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", iterTarget, nil))
+	}
+
+	assert.Equal(1.0, inFlight, "In flight while served")
+	assert.Equal(0.0, testutil.ToFloat64(HTTPInFlight.WithLabelValues("Capture")), "Done")
+	assert.Equal(2.0, testutil.ToFloat64(HTTPRequests.WithLabelValues("Capture", "POST", "200")), "Counted by status code")
+	assert.Equal(1.0, testutil.ToFloat64(HTTPRequests.WithLabelValues("Capture", "POST", "400")), "Counted by status code")
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(http.StatusOK, w.Code)
+	assert.True(strings.Contains(w.Body.String(), `gateway_http_request_duration_seconds_count{code="200",method="POST",route="Capture"} 2`), "Latency histogram exposed")
+}
+
+func TestCurrencyLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct{
+		currency string
+		expected string
+		description string
+	}{
+		{"EUR", "EUR", "ISO code"},
+		{"usd", "USD", "Lower case"},
+		{"XYZ", CurrencyOther, "Unknown code"},
+		{"EUR1", CurrencyOther, "Not a code"},
+		{"", CurrencyOther, "Empty"},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.expected, CurrencyLabel(iterTest.currency), iterTest.description)
+	}
+}
+
+func TestDeclineCodeLabel(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct{
+		code string
+		expected string
+		description string
+	}{
+		{"05", "05", "ISO response code"},
+		{"card_blocked", "card_blocked", "Risk code"},
+		{"", "", "No code"},
+		{"05; DROP", DeclineCodeOther, "Unknown code"},
+		{"do_not_honor_1234", DeclineCodeOther, "Free text"},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.expected, DeclineCodeLabel(iterTest.code), iterTest.description)
+	}
+}
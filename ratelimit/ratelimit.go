@@ -0,0 +1,203 @@
+package ratelimit
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+)
+
+// Limit lets Burst requests through at once, the bucket refilling at PerMinute
+type Limit struct {
+	PerMinute int	`json:"per_minute" yaml:"per_minute"`
+	Burst int			`json:"burst" yaml:"burst"`
+}
+
+// Settings are the limits Middleware applies
+type Settings struct {
+	Enabled bool
+	// Default is shared by the routes without a limit of their own
+	Default Limit
+	// Routes have their own limit and bucket, by route name
+	Routes map[string]Limit
+	// TrustForwardedFor keys unauthenticated requests by the last X-Forwarded-For address, set by the load balancer
+	TrustForwardedFor bool
+}
+
+// DefaultSettings are stricter on login, to slow down password guessing, and on refunds
+func DefaultSettings() Settings {
+	return Settings{
+		Enabled: true,
+		Default: Limit{PerMinute: 600, Burst: 60},
+		Routes: map[string]Limit{
+			"Login": {PerMinute: 10, Burst: 5},
+			"Refund": {PerMinute: 60, Burst: 10},
+		},
+	}
+}
+
+type bucket struct {
+	tokens float64
+	updated time.Time
+}
+
+// Decision is the outcome of a request against a limiter
+type Decision struct {
+	Allowed bool
+	Limit int
+	Remaining int
+	// RetryAfter is when the next request is allowed, zero when this one was
+	RetryAfter time.Duration
+	// Reset is when the bucket is full again
+	Reset time.Duration
+}
+
+// Limiter keeps a token bucket per key
+type Limiter struct {
+	limit Limit
+
+	mu sync.Mutex
+	buckets map[string]*bucket
+	swept time.Time
+	now func() time.Time
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit: limit,
+		buckets: make(map[string]*bucket),
+		now: time.Now,
+	}
+}
+
+// Take spends a token of key's bucket if there is one
+func (l *Limiter) Take(key string) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	perSecond := float64(l.limit.PerMinute) / 60
+	l.sweep(now, perSecond)
+
+	current, ok := l.buckets[key]
+	if !ok {
+		current = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = current
+	}
+	current.tokens = math.Min(float64(l.limit.Burst), current.tokens + now.Sub(current.updated).Seconds() * perSecond)
+	current.updated = now
+
+	decision := Decision{Limit: l.limit.Burst}
+	if current.tokens >= 1 {
+		current.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - current.tokens) / perSecond)
+	}
+	decision.Remaining = int(current.tokens)
+	decision.Reset = seconds((float64(l.limit.Burst) - current.tokens) / perSecond)
+
+	return decision
+}
+
+// sweep forgets the buckets full again, at most once a minute, so idle clients don't pile up
+func (l *Limiter) sweep(now time.Time, perSecond float64) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+
+	for key, iterBucket := range l.buckets {
+		if iterBucket.tokens + now.Sub(iterBucket.updated).Seconds() * perSecond >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func seconds(value float64) time.Duration {
+	return time.Duration(value * float64(time.Second))
+}
+
+var (
+	mu sync.RWMutex
+	settings Settings
+	defaultLimiter *Limiter
+	routeLimiters map[string]*Limiter
+)
+
+func init() {
+	Configure(DefaultSettings())
+}
+
+// Configure replaces the limits, with empty buckets
+func Configure(newSettings Settings) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	settings = newSettings
+	defaultLimiter = NewLimiter(newSettings.Default)
+	routeLimiters = make(map[string]*Limiter)
+	for route, iterLimit := range newSettings.Routes {
+		routeLimiters[route] = NewLimiter(iterLimit)
+	}
+}
+
+// Routes returns the names of the routes with a limit of their own
+func Routes() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	routes := make([]string, 0, len(routeLimiters))
+	for route := range routeLimiters {
+		routes = append(routes, route)
+	}
+
+	return routes
+}
+
+// Middleware limits the requests of each merchant, or of each client IP before authentication, on route.
+// It goes inside auth.Authenticate. Rejected requests get a 429 with Retry-After.
+func Middleware(inner http.Handler, route string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.RLock()
+		enabled, trustForwardedFor := settings.Enabled, settings.TrustForwardedFor
+		limiter, ok := routeLimiters[route]
+		if !ok {
+			limiter = defaultLimiter
+		}
+		mu.RUnlock()
+
+		if !enabled {
+			inner.ServeHTTP(w, r)
+			return
+		}
+
+		key := "ip:" + auth.ClientIP(r, trustForwardedFor)
+		if merchant := auth.MerchantFromContext(r.Context()); merchant != "" {
+			key = "merchant:" + merchant
+		}
+
+		decision := limiter.Take(key)
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(decision.Limit))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.Itoa(ceilSeconds(decision.Reset)))
+
+		if !decision.Allowed {
+			log.WithFields(log.Fields{"route": route, "key": key}).Warn("Middleware - Rate limit exceeded")
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(decision.RetryAfter)))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+
+		inner.ServeHTTP(w, r)
+	})
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
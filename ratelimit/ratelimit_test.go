@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+}
+
+func TestTake(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	limiter := NewLimiter(Limit{PerMinute: 60, Burst: 2})
+	limiter.now = func() time.Time { return now }
+
+	tests := []struct{
+		advance time.Duration
+		key string
+		expected Decision
+		description string
+	}{
+		{0, "a", Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, "Burst - First"},
+		{0, "a", Decision{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, "Burst - Second"},
+		{0, "a", Decision{Allowed: false, Limit: 2, Remaining: 0, RetryAfter: time.Second, Reset: 2 * time.Second}, "Empty - Rejected"},
+		{0, "b", Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, "Other key - Own bucket"},
+		{500 * time.Millisecond, "a", Decision{Allowed: false, Limit: 2, Remaining: 0, RetryAfter: 500 * time.Millisecond, Reset: 1500 * time.Millisecond}, "Half refilled - Rejected"},
+		{500 * time.Millisecond, "a", Decision{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, "Refilled - Allowed"},
+	}
+
+	for _, iterTest := range tests {
+		now = now.Add(iterTest.advance)
+		assert.Equal(iterTest.expected, limiter.Take(iterTest.key), iterTest.description)
+	}
+
+	now = now.Add(2 * time.Minute)
+	limiter.Take("a")
+	assert.Len(limiter.buckets, 1, "Idle buckets swept")
+}
+
+func TestMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	defer Configure(DefaultSettings())
+	Configure(Settings{
+		Enabled: true,
+		Default: Limit{PerMinute: 60, Burst: 2},
+		Routes: map[string]Limit{"Refund": {PerMinute: 1, Burst: 1}},
+		TrustForwardedFor: true,
+	})
+
+	handler := func(route string) http.Handler {
+		return Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), route)
+	}
+	request := func(merchant string, forwardedFor string) *http.Request {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		return req.WithContext(auth.WithMerchant(req.Context(), merchant))
+	}
+
+	tests := []struct{
+		route string
+		req *http.Request
+		expectedCode int
+		expectedRemaining string
+		description string
+	}{
+		{"Refund", request("Checkout", ""), 200, "0", "Route limit - Allowed"},
+		{"Refund", request("Checkout", ""), 429, "0", "Route limit - Exhausted"},
+		{"Refund", request("Shop", ""), 200, "0", "Route limit - Per merchant"},
+		{"Capture", request("Checkout", ""), 200, "1", "Default limit - Own bucket"},
+		{"Void", request("Checkout", ""), 200, "0", "Default limit - Shared across routes"},
+		{"Capture", request("Checkout", ""), 429, "0", "Default limit - Exhausted"},
+		{"Login", request("", "10.0.0.1, 192.168.1.1"), 200, "1", "Unauthenticated - By last forwarded address"},
+		{"Login", request("", "10.0.0.2, 192.168.1.1"), 200, "0", "Unauthenticated - Spoofed first address ignored"},
+		{"Login", request("", "192.168.1.2"), 200, "1", "Unauthenticated - Other address"},
+	}
+
+	for _, iterTest := range tests {
+		w := httptest.NewRecorder()
+		handler(iterTest.route).ServeHTTP(w, iterTest.req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Equal(iterTest.expectedRemaining, w.Header().Get("X-RateLimit-Remaining"), iterTest.description)
+		assert.NotEmpty(w.Header().Get("X-RateLimit-Limit"), iterTest.description)
+		assert.NotEmpty(w.Header().Get("X-RateLimit-Reset"), iterTest.description)
+		if iterTest.expectedCode == 429 {
+			assert.NotEmpty(w.Header().Get("Retry-After"), iterTest.description)
+		}
+	}
+
+	Configure(Settings{Default: Limit{PerMinute: 1, Burst: 1}})
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler("Capture").ServeHTTP(w, request("Checkout", ""))
+		assert.Equal(200, w.Code, "Disabled")
+	}
+}
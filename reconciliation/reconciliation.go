@@ -0,0 +1,345 @@
+package reconciliation
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/gateway"
+)
+
+// Discrepancy types
+const (
+	MissingAtGateway = "missing_at_gateway"
+	MissingAtAcquirer = "missing_at_acquirer"
+	AmountMismatch = "amount_mismatch"
+	CurrencyMismatch = "currency_mismatch"
+)
+
+// Transaction types, as found in settlement files
+const (
+	TypeCapture = "capture"
+	TypeRefund = "refund"
+)
+
+const reconciliationKind = "reconciliation"
+
+// DateLayout is the layout of the date column of settlement files
+const DateLayout = "2006-01-02"
+
+// amounts closer than half a cent are equal
+const amountTolerance = 0.005
+
+// Columns of a settlement file, in any order, a header line is required
+var columns = []string{"acquirer_reference", "type", "amount", "currency", "date"}
+
+// Line is a transaction of an acquirer settlement file
+type Line struct {
+	Number int
+	AcquirerReference string
+	Type string
+	Amount float64
+	Currency string
+	Date time.Time
+}
+
+// Reconciliation is the result of reconciling one settlement file. Gateway captures and refunds
+// made between the first and the last date of the file are expected in it.
+type Reconciliation struct {
+	Id string												`json:"id"`
+	Acquirer string									`json:"acquirer"`
+	File string											`json:"file"`
+	From string											`json:"from" example:"2020-07-01"`
+	To string												`json:"to" example:"2020-07-01"`
+	CreatedAt time.Time							`json:"created_at"`
+	Lines int												`json:"lines"`
+	Matched int											`json:"matched"`
+	Discrepancies []Discrepancy			`json:"discrepancies"`
+}
+
+type Discrepancy struct {
+	Type string								`json:"type" example:"amount_mismatch"`
+	TransactionType string		`json:"transaction_type" example:"capture"`
+	AcquirerReference string	`json:"acquirer_reference,omitempty"`
+	AuthorizationId string		`json:"authorization_id,omitempty"`
+	// Line of the settlement file, 0 when missing at the acquirer
+	Line int									`json:"line,omitempty"`
+	GatewayAmount float64			`json:"gateway_amount,omitempty"`
+	AcquirerAmount float64		`json:"acquirer_amount,omitempty"`
+	GatewayCurrency string		`json:"gateway_currency,omitempty"`
+	AcquirerCurrency string		`json:"acquirer_currency,omitempty"`
+}
+
+// record is a succeeded gateway capture or refund
+type record struct {
+	authorizationId string
+	transactionType string
+	acquirerReference string
+	amount float64
+	currency string
+	createdAt time.Time
+}
+
+// ParseFile reads a settlement file
+func ParseFile(r io.Reader) ([]Line, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("Reconciliation failure - cannot read header: %s", err.Error())
+	}
+
+	positions := make(map[string]int)
+	for i, iterColumn := range header {
+		positions[strings.ToLower(strings.TrimSpace(iterColumn))] = i
+	}
+	for _, iterColumn := range columns {
+		if _, ok := positions[iterColumn]; !ok {
+			return nil, fmt.Errorf("Reconciliation failure - missing column %s", iterColumn)
+		}
+	}
+
+	var lines []Line
+	for number := 2; ; number++ {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Reconciliation failure - line %d: %s", number, err.Error())
+		}
+
+		line := Line{
+			Number: number,
+			AcquirerReference: fields[positions["acquirer_reference"]],
+			Type: strings.ToLower(fields[positions["type"]]),
+			Currency: strings.ToUpper(fields[positions["currency"]]),
+		}
+
+		if line.AcquirerReference == "" {
+			return nil, fmt.Errorf("Reconciliation failure - line %d: acquirer_reference is empty", number)
+		}
+
+		if line.Type != TypeCapture && line.Type != TypeRefund {
+			return nil, fmt.Errorf("Reconciliation failure - line %d: type must be capture or refund", number)
+		}
+
+		if line.Amount, err = strconv.ParseFloat(fields[positions["amount"]], 64); err != nil {
+			return nil, fmt.Errorf("Reconciliation failure - line %d: invalid amount", number)
+		}
+
+		if line.Date, err = time.Parse(DateLayout, fields[positions["date"]]); err != nil {
+			return nil, fmt.Errorf("Reconciliation failure - line %d: date must be formatted as YYYY-MM-DD", number)
+		}
+
+		lines = append(lines, line)
+	}
+
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("Reconciliation failure - file has no transactions")
+	}
+
+	return lines, nil
+}
+
+// Reconcile compares the lines of a settlement file of acquirer with the gateway's
+// succeeded captures and refunds, and stores the result
+func Reconcile(acquirer string, file string, lines []Line) (*Reconciliation, error) {
+	from, to := lines[0].Date, lines[0].Date
+	for _, iterLine := range lines {
+		if iterLine.Date.Before(from) {
+			from = iterLine.Date
+		}
+		if iterLine.Date.After(to) {
+			to = iterLine.Date
+		}
+	}
+	end := to.AddDate(0, 0, 1)
+
+	records, err := gatewayRecords(acquirer, end)
+	if err != nil {
+		log.WithField("err", err).Error("Reconciliation.Reconcile - Error listing gateway records")
+		return nil, err
+	}
+
+	createdAt := time.Now().UTC()
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", acquirer, file, createdAt.UnixNano())))
+
+	result := &Reconciliation{
+		Id: fmt.Sprintf("rec_%x", sum[:8]),
+		Acquirer: acquirer,
+		File: file,
+		From: from.Format(DateLayout),
+		To: to.Format(DateLayout),
+		CreatedAt: createdAt,
+		Lines: len(lines),
+		Discrepancies: []Discrepancy{},
+	}
+
+	seen := make(map[string]bool)
+
+	for _, iterLine := range lines {
+		key := iterLine.Type + "|" + iterLine.AcquirerReference
+		rec, ok := records[key]
+		if !ok || seen[key] {
+			result.Discrepancies = append(result.Discrepancies, Discrepancy{
+				Type: MissingAtGateway,
+				TransactionType: iterLine.Type,
+				AcquirerReference: iterLine.AcquirerReference,
+				Line: iterLine.Number,
+				AcquirerAmount: iterLine.Amount,
+				AcquirerCurrency: iterLine.Currency,
+			})
+			continue
+		}
+		seen[key] = true
+
+		discrepancy := Discrepancy{
+			TransactionType: iterLine.Type,
+			AcquirerReference: iterLine.AcquirerReference,
+			AuthorizationId: rec.authorizationId,
+			Line: iterLine.Number,
+			GatewayAmount: rec.amount,
+			AcquirerAmount: iterLine.Amount,
+			GatewayCurrency: rec.currency,
+			AcquirerCurrency: iterLine.Currency,
+		}
+
+		switch {
+		case rec.currency != iterLine.Currency:
+			discrepancy.Type = CurrencyMismatch
+		case math.Abs(rec.amount - iterLine.Amount) >= amountTolerance:
+			discrepancy.Type = AmountMismatch
+		default:
+			result.Matched++
+			continue
+		}
+
+		result.Discrepancies = append(result.Discrepancies, discrepancy)
+	}
+
+	// what the gateway did in the file's period but the acquirer does not know about
+	var missing []record
+	for key, iterRecord := range records {
+		if !seen[key] && !iterRecord.createdAt.Before(from) && iterRecord.createdAt.Before(end) {
+			missing = append(missing, iterRecord)
+		}
+	}
+	sort.Slice(missing, func(i, j int) bool {
+		return missing[i].createdAt.Before(missing[j].createdAt)
+	})
+
+	for _, iterRecord := range missing {
+		result.Discrepancies = append(result.Discrepancies, Discrepancy{
+			Type: MissingAtAcquirer,
+			TransactionType: iterRecord.transactionType,
+			AcquirerReference: iterRecord.acquirerReference,
+			AuthorizationId: iterRecord.authorizationId,
+			GatewayAmount: iterRecord.amount,
+			GatewayCurrency: iterRecord.currency,
+		})
+	}
+
+	db.DB.StoreItem(result.Id, result)
+
+	log.WithFields(log.Fields{
+		"id": result.Id,
+		"acquirer": acquirer,
+		"file": file,
+		"matched": result.Matched,
+		"discrepancies": len(result.Discrepancies),
+	}).Info("Reconciliation.Reconcile - Settlement file reconciled")
+
+	return result, nil
+}
+
+// gatewayRecords returns the succeeded captures and refunds sent to acquirer by authorizations
+// created before end, keyed by type and acquirer reference
+func gatewayRecords(acquirer string, end time.Time) (map[string]record, error) {
+	records := make(map[string]record)
+
+	filter := gateway.AuthorizationFilter{
+		Acquirer: acquirer,
+		CreatedTo: &end,
+		Limit: gateway.MaxListLimit,
+	}
+
+	for {
+		auths, cursor, err := gateway.Gateway.ListAuthorizations(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, iterAuth := range auths {
+			currency := strings.ToUpper(iterAuth.Currency)
+			captures, refunds := iterAuth.Transactions()
+
+			for _, iterCapture := range captures {
+				if iterCapture.Status == gateway.StatusSucceeded {
+					records[TypeCapture + "|" + iterCapture.AcquirerReference] = record{iterAuth.Id, TypeCapture, iterCapture.AcquirerReference, iterCapture.Amount, currency, iterCapture.CreatedAt}
+				}
+			}
+
+			for _, iterRefund := range refunds {
+				if iterRefund.Status == gateway.StatusSucceeded {
+					records[TypeRefund + "|" + iterRefund.AcquirerReference] = record{iterAuth.Id, TypeRefund, iterRefund.AcquirerReference, iterRefund.Amount, currency, iterRefund.CreatedAt}
+				}
+			}
+		}
+
+		if cursor == "" {
+			return records, nil
+		}
+		filter.Cursor = cursor
+	}
+}
+
+// List returns the stored reconciliations, newest first
+func List() ([]*Reconciliation, error) {
+	items, _, err := db.DB.QueryItems(db.Query{
+		Equals: map[string]string{"kind": reconciliationKind},
+		SortBy: "created_at",
+		Descending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*Reconciliation{}
+	for _, iterItem := range items {
+		if rec, ok := iterItem.(*Reconciliation); ok {
+			result = append(result, rec)
+		}
+	}
+
+	return result, nil
+}
+
+// Get returns a stored reconciliation, nil if there is none with id
+func Get(id string) *Reconciliation {
+	rec, _ := db.DB.FetchItem(id).(*Reconciliation)
+	return rec
+}
+
+func (rec *Reconciliation) IndexValues() map[string][]string {
+	return map[string][]string{
+		"kind": {reconciliationKind},
+		"acquirer": {rec.Acquirer},
+	}
+}
+
+func (rec *Reconciliation) SortValues() map[string]float64 {
+	return map[string]float64{
+		"created_at": float64(rec.CreatedAt.UnixNano() / int64(time.Microsecond)),
+	}
+}
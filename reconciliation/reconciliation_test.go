@@ -0,0 +1,184 @@
+package reconciliation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/gateway"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAuthorization = `{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"EUR"}`
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+
+	bank.Scenarios = &bank.ScenarioRegistry{}
+}
+
+func TestParseFile(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := []struct{
+		file string
+		expected []Line
+		err error
+		description string
+	}{
+		{
+			"date,type,acquirer_reference,amount,currency\n2020-07-01,Capture,sim_1,10.50,eur\n2020-07-02,refund,sim_2,5,EUR\n",
+			[]Line{
+				{2, "sim_1", TypeCapture, 10.50, "EUR", time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)},
+				{3, "sim_2", TypeRefund, 5, "EUR", time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC)},
+			},
+			nil,
+			"OK - Columns in any order",
+		},
+		{
+			"acquirer_reference,type,amount,date\nsim_1,capture,10,2020-07-01\n",
+			nil,
+			errors.New("Reconciliation failure - missing column currency"),
+			"Error - Missing column",
+		},
+		{
+			"acquirer_reference,type,amount,currency,date\nsim_1,chargeback,10,EUR,2020-07-01\n",
+			nil,
+			errors.New("Reconciliation failure - line 2: type must be capture or refund"),
+			"Error - Unknown type",
+		},
+		{
+			"acquirer_reference,type,amount,currency,date\nsim_1,capture,ten,EUR,2020-07-01\n",
+			nil,
+			errors.New("Reconciliation failure - line 2: invalid amount"),
+			"Error - Invalid amount",
+		},
+		{
+			"acquirer_reference,type,amount,currency,date\nsim_1,capture,10,EUR,01/07/2020\n",
+			nil,
+			errors.New("Reconciliation failure - line 2: date must be formatted as YYYY-MM-DD"),
+			"Error - Invalid date",
+		},
+		{
+			"acquirer_reference,type,amount,currency,date\n",
+			nil,
+			errors.New("Reconciliation failure - file has no transactions"),
+			"Error - Empty file",
+		},
+	}
+
+	for _, iterTest := range tests {
+		lines, err := ParseFile(strings.NewReader(iterTest.file))
+
+		assert.Equal(iterTest.expected, lines, iterTest.description)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+}
+
+func TestScanDir(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	gateway.Gateway = new(gateway.GatewayS)
+
+	var captures []*gateway.Capture
+	for i := 0; i < 4; i++ {
+		auth, err := gateway.Gateway.NewAuthorization(context.Background(), []byte(testAuthorization), fmt.Sprint(i))
+		assert.NoError(err)
+
+		capture, err := auth.Capture(context.Background(), 10.00, "EUR", gateway.Details{})
+		assert.NoError(err)
+		captures = append(captures, capture)
+	}
+
+	refund, err := captures[0].Authorization.Refund(context.Background(), 4.00, "EUR", gateway.Details{})
+	assert.NoError(err)
+
+	today := time.Now().UTC().Format(DateLayout)
+	file := "acquirer_reference,type,amount,currency,date\n" +
+		fmt.Sprintf("%s,capture,10.00,EUR,%s\n", captures[0].AcquirerReference, today) +
+		fmt.Sprintf("%s,refund,4.00,EUR,%s\n", refund.AcquirerReference, today) +
+		fmt.Sprintf("%s,capture,12.00,EUR,%s\n", captures[1].AcquirerReference, today) +
+		fmt.Sprintf("%s,capture,10.00,USD,%s\n", captures[2].AcquirerReference, today) +
+		fmt.Sprintf("sim_unknown,capture,7.00,EUR,%s\n", today)
+
+	dir, err := ioutil.TempDir("", "reconciliation")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "settlement.csv"), []byte(file), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "broken.csv"), []byte("not,a,settlement,file\n"), 0644))
+
+	ScanDir(dir)
+
+	_, err = os.Stat(filepath.Join(dir, "settlement.csv"))
+	assert.NoError(err, "First scan - File may still be written, left")
+
+	ScanDir(dir)
+
+	_, err = os.Stat(filepath.Join(dir, ProcessedDir, "settlement.csv"))
+	assert.NoError(err, "Imported file moved to processed")
+	_, err = os.Stat(filepath.Join(dir, FailedDir, "broken.csv"))
+	assert.NoError(err, "Invalid file moved to failed")
+
+	reconciliations, err := List()
+	assert.NoError(err)
+	assert.Len(reconciliations, 1, "Only the valid file is reconciled")
+
+	rec := Get(reconciliations[0].Id)
+	assert.Equal(bank.DefaultAcquirer, rec.Acquirer)
+	assert.Equal(5, rec.Lines)
+	assert.Equal(2, rec.Matched, "Capture and refund matched")
+
+	types := []string{}
+	for _, iterDiscrepancy := range rec.Discrepancies {
+		types = append(types, iterDiscrepancy.Type)
+	}
+	assert.Equal([]string{AmountMismatch, CurrencyMismatch, MissingAtGateway, MissingAtAcquirer}, types, "Discrepancies in file order, then missing at acquirer")
+
+	assert.Equal(12.00, rec.Discrepancies[0].AcquirerAmount, "Amount mismatch")
+	assert.Equal(10.00, rec.Discrepancies[0].GatewayAmount, "Amount mismatch")
+	assert.Equal(captures[3].AcquirerReference, rec.Discrepancies[3].AcquirerReference, "Missing at acquirer")
+	assert.Equal(captures[3].Authorization.Id, rec.Discrepancies[3].AuthorizationId, "Missing at acquirer")
+
+	assert.Nil(Get("rec_unknown"), "Unknown reconciliation")
+}
+
+func TestScanDirUnmovable(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	gateway.Gateway = new(gateway.GatewayS)
+
+	dir, err := ioutil.TempDir("", "reconciliation")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// a file in the way of the processed directory, files can't be moved there
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, ProcessedDir), []byte{}, 0644))
+
+	path := filepath.Join(dir, "settlement.csv")
+	assert.NoError(ioutil.WriteFile(path, []byte("acquirer_reference,type,amount,currency,date\nsim_unknown,capture,7.00,EUR,2020-07-01\n"), 0644))
+
+	for i := 0; i < 3; i++ {
+		ScanDir(dir)
+	}
+
+	reconciliations, err := List()
+	assert.NoError(err)
+	assert.Len(reconciliations, 1, "Unmovable file reconciled once")
+
+	_, err = os.Stat(path)
+	assert.NoError(err, "Unmovable file left in place")
+}
@@ -0,0 +1,169 @@
+package reconciliation
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/bank"
+)
+
+// DefaultInterval is how often the drop directory is polled
+const DefaultInterval = time.Minute
+
+// ProcessedDir is where imported files are moved, next to the file
+const ProcessedDir = "processed"
+
+// FailedDir is where files that cannot be parsed are moved, next to the file
+const FailedDir = "failed"
+
+// fileState is what tells whether a file changed between two scans
+type fileState struct {
+	size int64
+	modTime time.Time
+}
+
+func (fs fileState) same(other fileState) bool {
+	return fs.size == other.size && fs.modTime.Equal(other.modTime)
+}
+
+// scanned holds the files seen by the last scan, and the imported files that couldn't be moved away, by path
+var scanned = struct {
+	mu sync.Mutex
+	seen map[string]fileState
+	unmovable map[string]fileState
+}{
+	seen: map[string]fileState{},
+	unmovable: map[string]fileState{},
+}
+
+// Watch polls dir for settlement files until ctx is done. Files in a sub-directory are
+// settlement files of the acquirer named after it, files directly in dir are of the default acquirer.
+func Watch(ctx context.Context, dir string, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		ScanDir(dir)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ScanDir reconciles every .csv file found in dir and moves it away. A file is only picked up once its size and
+// modification time are the same as on the previous scan, so files still being copied are left for later.
+func ScanDir(dir string) {
+	scanned.mu.Lock()
+	defer scanned.mu.Unlock()
+
+	seen := make(map[string]fileState)
+	defer func() {
+		for path := range scanned.unmovable {
+			if _, ok := seen[path]; !ok {
+				delete(scanned.unmovable, path)
+			}
+		}
+		scanned.seen = seen
+	}()
+
+	scanAcquirerDir(dir, bank.DefaultAcquirer, seen)
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.WithField("err", err).Error("Reconciliation.ScanDir - Error reading directory")
+		return
+	}
+
+	for _, iterEntry := range entries {
+		if iterEntry.IsDir() && iterEntry.Name() != ProcessedDir && iterEntry.Name() != FailedDir {
+			scanAcquirerDir(filepath.Join(dir, iterEntry.Name()), iterEntry.Name(), seen)
+		}
+	}
+}
+
+// scanAcquirerDir imports the settlement files of acquirer in dir, recording the files it sees in seen
+func scanAcquirerDir(dir string, acquirer string, seen map[string]fileState) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.WithField("err", err).Error("Reconciliation.ScanDir - Error reading directory")
+		return
+	}
+
+	for _, iterEntry := range entries {
+		if iterEntry.IsDir() || !strings.EqualFold(filepath.Ext(iterEntry.Name()), ".csv") {
+			continue
+		}
+
+		path := filepath.Join(dir, iterEntry.Name())
+		state := fileState{size: iterEntry.Size(), modTime: iterEntry.ModTime()}
+		seen[path] = state
+
+		if previous, ok := scanned.seen[path]; !ok || !previous.same(state) {
+			continue
+		}
+
+		// already imported, importing it again on every scan would pile up reconciliations
+		if unmovable, ok := scanned.unmovable[path]; ok && unmovable.same(state) {
+			continue
+		}
+
+		destination := ProcessedDir
+
+		if err := importFile(acquirer, path); err != nil {
+			log.WithFields(log.Fields{
+				"file": path,
+				"err": err,
+			}).Error("Reconciliation.ScanDir - Error importing settlement file")
+
+			destination = FailedDir
+		}
+
+		// moved so a file is only imported once
+		if err := moveFile(path, filepath.Join(dir, destination)); err != nil {
+			log.WithFields(log.Fields{
+				"file": path,
+				"err": err,
+			}).Error("Reconciliation.ScanDir - Error moving settlement file, skipped until it changes")
+
+			scanned.unmovable[path] = state
+		}
+	}
+}
+
+func importFile(acquirer string, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	lines, err := ParseFile(file)
+	if err != nil {
+		return err
+	}
+
+	_, err = Reconcile(acquirer, filepath.Base(path), lines)
+	return err
+}
+
+func moveFile(path string, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	return os.Rename(path, filepath.Join(dir, filepath.Base(path)))
+}
@@ -0,0 +1,125 @@
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config declares the rules, lists and thresholds of a RuleEngine
+type Config struct {
+	ReviewScore int												`json:"review_score" yaml:"review_score"`
+	BlockScore int												`json:"block_score" yaml:"block_score"`
+	Velocity []*VelocityRule							`json:"velocity" yaml:"velocity"`
+	Amounts []*AmountRule									`json:"amounts" yaml:"amounts"`
+	CountryMismatch *CountryMismatchRule	`json:"country_mismatch" yaml:"country_mismatch"`
+	Allow List														`json:"allow" yaml:"allow"`
+	Block List														`json:"block" yaml:"block"`
+	IPCountries []IPCountry								`json:"ip_countries" yaml:"ip_countries"`
+}
+
+// LoadEngine reads risk rules from a YAML (.yaml/.yml) or JSON file, unknown keys are errors
+func LoadEngine(path string) (*RuleEngine, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading risk file - %s", err.Error())
+	}
+
+	config := &Config{}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, config)
+	default:
+		decoder := json.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(config)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing risk file - %s", err.Error())
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config.Engine(), nil
+}
+
+func (c *Config) Validate() error {
+	if c.ReviewScore < 0 || c.BlockScore < 0 || c.ReviewScore > MaxScore || c.BlockScore > MaxScore {
+		return fmt.Errorf("Invalid risk rules - scores must be between 0 and %d", MaxScore)
+	}
+
+	engine := &RuleEngine{ReviewScore: c.ReviewScore, BlockScore: c.BlockScore}
+	if engine.reviewScore() > engine.blockScore() {
+		return fmt.Errorf("Invalid risk rules - review_score is greater than block_score")
+	}
+
+	for _, iterRule := range c.Velocity {
+		if err := iterRule.validate(); err != nil {
+			return fmt.Errorf("Invalid risk rules - %s", err.Error())
+		}
+	}
+
+	for _, iterRule := range c.Amounts {
+		if err := iterRule.validate(); err != nil {
+			return fmt.Errorf("Invalid risk rules - %s", err.Error())
+		}
+	}
+
+	if c.CountryMismatch != nil {
+		if err := validateScore(c.CountryMismatch.Score); err != nil {
+			return fmt.Errorf("Invalid risk rules - country_mismatch %s", err.Error())
+		}
+	}
+
+	for _, iterList := range []List{c.Allow, c.Block} {
+		for i := range iterList {
+			if err := iterList[i].Validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for i := range c.IPCountries {
+		if _, _, err := net.ParseCIDR(c.IPCountries[i].CIDR); err != nil {
+			return fmt.Errorf("Invalid risk rules - ip_countries: %s is not a CIDR range", c.IPCountries[i].CIDR)
+		}
+
+		if len(c.IPCountries[i].Country) != 2 {
+			return fmt.Errorf("Invalid risk rules - ip_countries %s: country must be a 2 letter code", c.IPCountries[i].CIDR)
+		}
+		c.IPCountries[i].Country = strings.ToUpper(c.IPCountries[i].Country)
+	}
+
+	return nil
+}
+
+// Engine builds the engine of a valid config
+func (c *Config) Engine() *RuleEngine {
+	engine := &RuleEngine{
+		ReviewScore: c.ReviewScore,
+		BlockScore: c.BlockScore,
+		Allow: c.Allow,
+		Block: c.Block,
+		IPCountries: c.IPCountries,
+	}
+
+	for _, iterRule := range c.Velocity {
+		engine.Rules = append(engine.Rules, iterRule)
+	}
+	for _, iterRule := range c.Amounts {
+		engine.Rules = append(engine.Rules, iterRule)
+	}
+	if c.CountryMismatch != nil {
+		engine.Rules = append(engine.Rules, c.CountryMismatch)
+	}
+
+	return engine
+}
@@ -0,0 +1,106 @@
+package risk
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Entry types of block and allow lists
+const (
+	// EntryCard matches a card fingerprint, cards are never listed by number
+	EntryCard = "card"
+	// EntryBIN matches BINs starting with the value, up to 6 digits
+	EntryBIN = "bin"
+	EntryEmail = "email"
+	// EntryIP matches an IP address or a CIDR range
+	EntryIP = "ip"
+)
+
+// Entry of a block or allow list
+type Entry struct {
+	Type string			`json:"type" yaml:"type" example:"card"`
+	Value string		`json:"value" yaml:"value" example:"3f2a9c0d4b1e8f7a6c5d4e3f2a1b0c9d"`
+	// Note says why the entry was listed
+	Note string			`json:"note,omitempty" yaml:"note" example:"Chargeback fraud"`
+}
+
+type List []Entry
+
+// Match returns the first entry matching the request, nil when none does
+func (l List) Match(req *Request) *Entry {
+	for i := range l {
+		if l[i].matches(req) {
+			return &l[i]
+		}
+	}
+
+	return nil
+}
+
+// Validate checks the entry and normalizes its value
+func (e *Entry) Validate() error {
+	e.Value = strings.TrimSpace(e.Value)
+	if e.Value == "" {
+		return fmt.Errorf("Invalid list entry - %s entry without a value", e.Type)
+	}
+
+	switch e.Type {
+	case EntryCard:
+		e.Value = strings.ToLower(e.Value)
+		if _, err := hex.DecodeString(e.Value); err != nil || len(e.Value) != 32 {
+			return fmt.Errorf("Invalid list entry - card value must be a card fingerprint")
+		}
+	case EntryBIN:
+		if len(e.Value) > 6 || strings.Trim(e.Value, "0123456789") != "" {
+			return fmt.Errorf("Invalid list entry - bin value must be up to 6 digits")
+		}
+	case EntryEmail:
+		e.Value = strings.ToLower(e.Value)
+		if !strings.Contains(e.Value, "@") {
+			return fmt.Errorf("Invalid list entry - email value is not an address")
+		}
+	case EntryIP:
+		if _, _, err := net.ParseCIDR(e.Value); err != nil && net.ParseIP(e.Value) == nil {
+			return fmt.Errorf("Invalid list entry - ip value must be an IP address or a CIDR range")
+		}
+	default:
+		return fmt.Errorf("Invalid list entry - type must be %s, %s, %s or %s", EntryCard, EntryBIN, EntryEmail, EntryIP)
+	}
+
+	return nil
+}
+
+func (e *Entry) matches(req *Request) bool {
+	switch e.Type {
+	case EntryCard:
+		return req.CardFingerprint != "" && e.Value == req.CardFingerprint
+	case EntryBIN:
+		return req.BIN != "" && strings.HasPrefix(req.BIN, e.Value)
+	case EntryEmail:
+		return req.Email != "" && strings.EqualFold(e.Value, req.Email)
+	case EntryIP:
+		ip := net.ParseIP(req.IP)
+		if ip == nil {
+			return false
+		}
+
+		if _, network, err := net.ParseCIDR(e.Value); err == nil {
+			return network.Contains(ip)
+		}
+
+		return ip.Equal(net.ParseIP(e.Value))
+	}
+
+	return false
+}
+
+func (e *Entry) reason(rule string, score int) Reason {
+	message := fmt.Sprintf("%s %s is listed", e.Type, e.Value)
+	if e.Note != "" {
+		message += " - " + e.Note
+	}
+
+	return Reason{Rule: rule, Score: score, Message: message}
+}
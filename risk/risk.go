@@ -0,0 +1,180 @@
+package risk
+
+import (
+	"math"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Decisions of an assessment
+const (
+	DecisionAllow = "allow"
+	// DecisionReview lets the authorization through, flagged for the merchant to look at
+	DecisionReview = "review"
+	DecisionBlock = "block"
+)
+
+// Rules of the reasons given for list matches
+const (
+	RuleAllowList = "allow_list"
+	RuleBlockList = "block_list"
+)
+
+// Scores range from 0 to MaxScore, the decision thresholds default to these
+const (
+	MaxScore = 100
+	DefaultReviewScore = 50
+	DefaultBlockScore = 80
+)
+
+// Request holds what rules can screen an authorization on. Countries are ISO 3166-1 alpha-2 codes, empty when unknown.
+type Request struct {
+	Merchant string
+	Amount float64
+	Currency string
+	CardFingerprint string
+	BIN string
+	CardCountry string
+	IP string
+	IPCountry string
+	Email string
+}
+
+// Reason is why a rule scored an authorization
+type Reason struct {
+	Rule string			`json:"rule" example:"velocity_card"`
+	Score int				`json:"score" example:"60"`
+	Message string	`json:"message" example:"6 attempts with this card in the last 3600s"`
+}
+
+// Assessment is the outcome of screening an authorization, stored with it
+type Assessment struct {
+	Decision string		`json:"decision" example:"allow"`
+	Score int					`json:"score" example:"0"`
+	Reasons []Reason	`json:"reasons"`
+}
+
+// EngineI screens authorizations before they are sent to an acquirer
+type EngineI interface{
+	Assess(*Request) *Assessment
+}
+
+// RuleI is a pluggable rule, it returns nil when the request does not trigger it
+type RuleI interface{
+	Assess(*Request) *Reason
+}
+
+// RuleEngine adds up the scores of its rules. Block listed requests are blocked, allow listed ones skip the rules.
+type RuleEngine struct {
+	// ReviewScore and BlockScore are the score from which requests are reviewed or blocked, defaults when 0
+	ReviewScore int
+	BlockScore int
+	Rules []RuleI
+	Allow List
+	Block List
+	// IPCountries locate requests' IPs for rules comparing countries, first matching range wins
+	IPCountries []IPCountry
+}
+
+// IPCountry maps an IP range to a country
+type IPCountry struct {
+	CIDR string			`json:"cidr" yaml:"cidr"`
+	Country string	`json:"country" yaml:"country"`
+}
+
+// Engine screens new authorizations, everything is allowed unless rules are loaded
+var Engine EngineI = &RuleEngine{}
+
+func (re *RuleEngine) Assess(req *Request) *Assessment {
+	if req.IPCountry == "" {
+		req.IPCountry = re.ipCountry(req.IP)
+	}
+
+	if entry := re.match(re.Block, Lists.Block(), req); entry != nil {
+		log.WithFields(log.Fields{
+			"merchant": req.Merchant,
+			"type": entry.Type,
+		}).Info("RuleEngine.Assess - Block listed")
+
+		return &Assessment{
+			Decision: DecisionBlock,
+			Score: MaxScore,
+			Reasons: []Reason{entry.reason(RuleBlockList, MaxScore)},
+		}
+	}
+
+	// allow entries can match what the client sends, e.g. the customer's email, so they never lift a block
+	if entry := re.match(re.Allow, Lists.Allow(), req); entry != nil {
+		return &Assessment{
+			Decision: DecisionAllow,
+			Reasons: []Reason{entry.reason(RuleAllowList, 0)},
+		}
+	}
+
+	assessment := &Assessment{Reasons: []Reason{}}
+	for _, iterRule := range re.Rules {
+		if reason := iterRule.Assess(req); reason != nil {
+			assessment.Score += reason.Score
+			assessment.Reasons = append(assessment.Reasons, *reason)
+		}
+	}
+	assessment.Score = int(math.Min(float64(assessment.Score), MaxScore))
+
+	switch {
+	case assessment.Score >= re.blockScore():
+		assessment.Decision = DecisionBlock
+	case assessment.Score >= re.reviewScore():
+		assessment.Decision = DecisionReview
+	default:
+		assessment.Decision = DecisionAllow
+	}
+
+	return assessment
+}
+
+// BlockListed tells whether the request was blocked by a block list rather than by its score
+func (a *Assessment) BlockListed() bool {
+	return a.Decision == DecisionBlock && len(a.Reasons) > 0 && a.Reasons[0].Rule == RuleBlockList
+}
+
+// match looks the request up in the engine's list, then in the managed one
+func (re *RuleEngine) match(configured List, managed List, req *Request) *Entry {
+	if entry := configured.Match(req); entry != nil {
+		return entry
+	}
+
+	return managed.Match(req)
+}
+
+func (re *RuleEngine) reviewScore() int {
+	if re.ReviewScore == 0 {
+		return DefaultReviewScore
+	}
+
+	return re.ReviewScore
+}
+
+func (re *RuleEngine) blockScore() int {
+	if re.BlockScore == 0 {
+		return DefaultBlockScore
+	}
+
+	return re.BlockScore
+}
+
+func (re *RuleEngine) ipCountry(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+
+	for _, iterRange := range re.IPCountries {
+		if _, network, err := net.ParseCIDR(iterRange.CIDR); err == nil && network.Contains(parsed) {
+			return strings.ToUpper(iterRange.Country)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,231 @@
+package risk
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nktsitas/checkout-techlab/db"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testFingerprint = "3f2a9c0d4b1e8f7a6c5d4e3f2a1b0c9d"
+
+func TestAssess(t *testing.T) {
+	assert := assert.New(t)
+
+	engine := &RuleEngine{
+		Rules: []RuleI{
+			&AmountRule{Currency: "EUR", Max: 1000, Score: 40},
+			&CountryMismatchRule{Score: 30},
+		},
+		Allow: List{{Type: EntryIP, Value: "10.0.0.0/8"}},
+		Block: List{{Type: EntryCard, Value: testFingerprint}, {Type: EntryBIN, Value: "4123"}},
+		IPCountries: []IPCountry{{CIDR: "81.2.69.0/24", Country: "GB"}},
+	}
+
+	tests := []struct{
+		req Request
+		decision string
+		score int
+		rules []string
+		description string
+	}{
+		{
+			Request{Amount: 100, Currency: "EUR", CardCountry: "GB", IP: "81.2.69.160"},
+			DecisionAllow,
+			0,
+			[]string{},
+			"Allow - Nothing triggered",
+		},
+		{
+			Request{Amount: 2000, Currency: "USD", CardCountry: "FR", IP: "192.0.2.1"},
+			DecisionAllow,
+			0,
+			[]string{},
+			"Allow - Other currency, unknown IP country",
+		},
+		{
+			Request{Amount: 2000, Currency: "eur", CardCountry: "FR", IP: "81.2.69.160"},
+			DecisionReview,
+			70,
+			[]string{"amount", "country_mismatch"},
+			"Review - Scores add up",
+		},
+		{
+			Request{Amount: 100, Currency: "EUR", CardFingerprint: testFingerprint},
+			DecisionBlock,
+			MaxScore,
+			[]string{"block_list"},
+			"Block - Block listed card",
+		},
+		{
+			Request{Amount: 100, Currency: "EUR", BIN: "412345"},
+			DecisionBlock,
+			MaxScore,
+			[]string{"block_list"},
+			"Block - Block listed BIN prefix",
+		},
+		{
+			Request{Amount: 2000, Currency: "EUR", CardCountry: "FR", IP: "10.1.2.3"},
+			DecisionAllow,
+			0,
+			[]string{"allow_list"},
+			"Allow - Allow list skips rules",
+		},
+		{
+			Request{Amount: 2000, Currency: "EUR", CardFingerprint: testFingerprint, IP: "10.1.2.3"},
+			DecisionBlock,
+			MaxScore,
+			[]string{"block_list"},
+			"Block - Block list wins over allow list",
+		},
+	}
+
+	for _, iterTest := range tests {
+		req := iterTest.req
+		assessment := engine.Assess(&req)
+
+		rules := []string{}
+		for _, iterReason := range assessment.Reasons {
+			rules = append(rules, iterReason.Rule)
+		}
+
+		assert.Equal(iterTest.decision, assessment.Decision, iterTest.description)
+		assert.Equal(iterTest.score, assessment.Score, iterTest.description)
+		assert.Equal(iterTest.rules, rules, iterTest.description)
+	}
+}
+
+func TestVelocity(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func() {
+		now = time.Now
+	}()
+	current := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	now = func() time.Time {
+		return current
+	}
+
+	velocity := &VelocityRule{Key: KeyCard, WindowSeconds: 60, Max: 2, Score: 80}
+	engine := &RuleEngine{Rules: []RuleI{velocity}}
+	req := &Request{CardFingerprint: testFingerprint}
+
+	assert.Equal(DecisionAllow, engine.Assess(req).Decision, "1st attempt")
+	current = current.Add(10 * time.Second)
+	assert.Equal(DecisionAllow, engine.Assess(req).Decision, "2nd attempt")
+	current = current.Add(10 * time.Second)
+	assert.Equal(DecisionBlock, engine.Assess(req).Decision, "3rd attempt - Over the limit")
+	assert.Equal(DecisionAllow, engine.Assess(&Request{CardFingerprint: "other"}).Decision, "Other card - Counted apart")
+
+	current = current.Add(55 * time.Second)
+	assert.Equal(DecisionAllow, engine.Assess(req).Decision, "Earlier attempts out of the window")
+	assert.Equal(DecisionAllow, engine.Assess(&Request{}).Decision, "No card - Not counted")
+
+	current = current.Add(2 * time.Minute)
+	engine.Assess(&Request{CardFingerprint: "new"})
+	assert.Len(velocity.attempts, 1, "Cards out of the window swept")
+}
+
+func TestLoadEngine(t *testing.T) {
+	assert := assert.New(t)
+
+	engine, err := LoadEngine("../risk.example.yaml")
+	assert.NoError(err, "Example file")
+	assert.Len(engine.Rules, 5)
+
+	dir, err := ioutil.TempDir("", "risk")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := []struct{
+		content string
+		err error
+		description string
+	}{
+		{
+			"review_score: 90\nblock_score: 80\n",
+			errors.New("Invalid risk rules - review_score is greater than block_score"),
+			"Error - Thresholds",
+		},
+		{
+			"velocity:\n  - key: device\n    window_seconds: 60\n    max: 1\n    score: 10\n",
+			errors.New("Invalid risk rules - velocity key must be card, ip, email or merchant"),
+			"Error - Velocity key",
+		},
+		{
+			"amounts:\n  - max: 100\n    score: 0\n",
+			errors.New("Invalid risk rules - score must be between 1 and 100"),
+			"Error - Score",
+		},
+		{
+			"block:\n  - type: card\n    value: 4000000000000123\n",
+			errors.New("Invalid list entry - card value must be a card fingerprint"),
+			"Error - Card listed by number",
+		},
+		{
+			"allow:\n  - type: ip\n    value: 300.1.1.1\n",
+			errors.New("Invalid list entry - ip value must be an IP address or a CIDR range"),
+			"Error - IP",
+		},
+		{
+			"ip_countries:\n  - cidr: 81.2.69.0/24\n    country: GBR\n",
+			errors.New("Invalid risk rules - ip_countries 81.2.69.0/24: country must be a 2 letter code"),
+			"Error - IP country",
+		},
+	}
+
+	for _, iterTest := range tests {
+		path := filepath.Join(dir, "risk.yaml")
+		assert.NoError(ioutil.WriteFile(path, []byte(iterTest.content), 0644))
+
+		_, err := LoadEngine(path)
+		assert.Equal(iterTest.err, err, iterTest.description)
+	}
+
+	path := filepath.Join(dir, "risk.json")
+	assert.NoError(ioutil.WriteFile(path, []byte(`{"review_scor": 50}`), 0644))
+	_, err = LoadEngine(path)
+	assert.Equal(errors.New(`Error parsing risk file - json: unknown field "review_scor"`), err, "Error - Unknown JSON key")
+}
+
+func TestManagedLists(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	defer func() {
+		Lists = &ManagedLists{}
+	}()
+
+	engine := &RuleEngine{}
+	req := &Request{BIN: "412345", Email: "shopper@example.com"}
+
+	_, err := AddEntry("grey", Entry{Type: EntryBIN, Value: "4123"}, "Admin")
+	assert.Equal(errors.New("List failure - list must be allow or block"), err)
+
+	blocked, err := AddEntry(ListBlock, Entry{Type: EntryBIN, Value: "4123", Note: "Compromised range"}, "Admin")
+	assert.NoError(err)
+	assert.Equal("Admin", blocked.CreatedBy)
+	assert.True(engine.Assess(req).BlockListed(), "Added - Enforced without a reload")
+
+	allowed, err := AddEntry(ListAllow, Entry{Type: EntryEmail, Value: "Shopper@Example.com"}, "Admin")
+	assert.NoError(err)
+	assert.True(engine.Assess(req).BlockListed(), "Block list wins over allow list")
+	assert.Equal(DecisionAllow, engine.Assess(&Request{Email: "shopper@example.com"}).Decision, "Allow listed")
+
+	entries, err := Entries(ListBlock)
+	assert.NoError(err)
+	assert.Equal([]*ListEntry{blocked}, entries)
+
+	assert.NoError(RemoveEntry(allowed.Id, "Admin"))
+	assert.Equal(ErrEntryNotFound, RemoveEntry(allowed.Id, "Admin"), "Removed - Not found")
+	assert.True(engine.Assess(req).BlockListed(), "Removed - No longer enforced")
+
+	assert.NoError(RemoveEntry(blocked.Id, "Admin"))
+	assert.Equal(DecisionAllow, engine.Assess(req).Decision)
+}
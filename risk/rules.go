@@ -0,0 +1,169 @@
+package risk
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Velocity keys, what attempts are counted per
+const (
+	KeyCard = "card"
+	KeyIP = "ip"
+	KeyEmail = "email"
+	KeyMerchant = "merchant"
+)
+
+var now = time.Now
+
+// VelocityRule scores requests when there were more than Max attempts with the same key within the window.
+// Every screened attempt counts, whatever its outcome.
+type VelocityRule struct {
+	Key string					`json:"key" yaml:"key"`
+	WindowSeconds int		`json:"window_seconds" yaml:"window_seconds"`
+	Max int							`json:"max" yaml:"max"`
+	Score int						`json:"score" yaml:"score"`
+
+	mu sync.Mutex
+	attempts map[string][]time.Time
+	swept time.Time
+}
+
+func (vr *VelocityRule) Assess(req *Request) *Reason {
+	key := vr.key(req)
+	if key == "" {
+		return nil
+	}
+
+	vr.mu.Lock()
+	defer vr.mu.Unlock()
+
+	if vr.attempts == nil {
+		vr.attempts = make(map[string][]time.Time)
+	}
+
+	current := now()
+	from := current.Add(-time.Duration(vr.WindowSeconds) * time.Second)
+	vr.sweep(current, from)
+
+	// keep the attempts still in the window, oldest first
+	kept := []time.Time{}
+	for _, iterAttempt := range vr.attempts[key] {
+		if iterAttempt.After(from) {
+			kept = append(kept, iterAttempt)
+		}
+	}
+	vr.attempts[key] = append(kept, current)
+
+	if len(vr.attempts[key]) <= vr.Max {
+		return nil
+	}
+
+	return &Reason{
+		Rule: "velocity_" + vr.Key,
+		Score: vr.Score,
+		Message: fmt.Sprintf("%d attempts with this %s in the last %ds", len(vr.attempts[key]), vr.Key, vr.WindowSeconds),
+	}
+}
+
+// sweep forgets the keys without attempts in the window, at most once a window, so cards, IPs and emails seen
+// once don't pile up
+func (vr *VelocityRule) sweep(current time.Time, from time.Time) {
+	if current.Sub(vr.swept) < time.Duration(vr.WindowSeconds) * time.Second {
+		return
+	}
+	vr.swept = current
+
+	for key, iterAttempts := range vr.attempts {
+		if !iterAttempts[len(iterAttempts)-1].After(from) {
+			delete(vr.attempts, key)
+		}
+	}
+}
+
+func (vr *VelocityRule) key(req *Request) string {
+	switch vr.Key {
+	case KeyCard:
+		return req.CardFingerprint
+	case KeyIP:
+		return req.IP
+	case KeyEmail:
+		return strings.ToLower(req.Email)
+	case KeyMerchant:
+		return req.Merchant
+	}
+
+	return ""
+}
+
+func (vr *VelocityRule) validate() error {
+	switch vr.Key {
+	case KeyCard, KeyIP, KeyEmail, KeyMerchant:
+	default:
+		return fmt.Errorf("velocity key must be %s, %s, %s or %s", KeyCard, KeyIP, KeyEmail, KeyMerchant)
+	}
+
+	if vr.WindowSeconds <= 0 || vr.Max <= 0 {
+		return fmt.Errorf("velocity window_seconds and max must be positive")
+	}
+
+	return validateScore(vr.Score)
+}
+
+// AmountRule scores requests above Max, in Currency or in any currency when empty
+type AmountRule struct {
+	Currency string	`json:"currency" yaml:"currency"`
+	Max float64			`json:"max" yaml:"max"`
+	Score int				`json:"score" yaml:"score"`
+}
+
+func (ar *AmountRule) Assess(req *Request) *Reason {
+	if ar.Currency != "" && !strings.EqualFold(ar.Currency, req.Currency) {
+		return nil
+	}
+
+	if req.Amount <= ar.Max {
+		return nil
+	}
+
+	return &Reason{
+		Rule: "amount",
+		Score: ar.Score,
+		Message: fmt.Sprintf("Amount above %.2f %s", ar.Max, strings.ToUpper(req.Currency)),
+	}
+}
+
+func (ar *AmountRule) validate() error {
+	if ar.Max <= 0 {
+		return fmt.Errorf("amount max must be positive")
+	}
+
+	return validateScore(ar.Score)
+}
+
+// CountryMismatchRule scores requests from an IP located outside the card's issuing country.
+// Nothing is scored when either country is unknown.
+type CountryMismatchRule struct {
+	Score int	`json:"score" yaml:"score"`
+}
+
+func (cr *CountryMismatchRule) Assess(req *Request) *Reason {
+	if req.CardCountry == "" || req.IPCountry == "" || strings.EqualFold(req.CardCountry, req.IPCountry) {
+		return nil
+	}
+
+	return &Reason{
+		Rule: "country_mismatch",
+		Score: cr.Score,
+		Message: fmt.Sprintf("Card issued in %s used from %s", req.CardCountry, req.IPCountry),
+	}
+}
+
+func validateScore(score int) error {
+	if score <= 0 || score > MaxScore {
+		return fmt.Errorf("score must be between 1 and %d", MaxScore)
+	}
+
+	return nil
+}
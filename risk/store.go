@@ -0,0 +1,173 @@
+package risk
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/db"
+)
+
+// Lists entries can be added to
+const (
+	ListAllow = "allow"
+	ListBlock = "block"
+)
+
+const listEntryKind = "risk_list_entry"
+
+var ErrEntryNotFound = errors.New("List failure - Entry not found")
+
+// ListEntry is a block or allow list entry managed by operations, kept in storage
+type ListEntry struct {
+	Id string						`json:"id" example:"rle_5f0c3a1e9b2d4c68"`
+	List string					`json:"list" example:"block"`
+
+	Entry
+
+	// CreatedBy is the admin who added the entry
+	CreatedBy string		`json:"created_by" example:"Admin"`
+	CreatedAt time.Time	`json:"created_at"`
+}
+
+// ManagedLists holds the stored entries the engine enforces, reloaded from storage whenever they change
+type ManagedLists struct {
+	allow List
+	block List
+
+	mu sync.RWMutex
+}
+
+// Lists are enforced by every RuleEngine on top of the lists of its config
+var Lists = &ManagedLists{}
+
+// AddEntry validates and stores an entry, it is enforced from the next authorization
+func AddEntry(list string, entry Entry, actor string) (*ListEntry, error) {
+	if list != ListAllow && list != ListBlock {
+		return nil, fmt.Errorf("List failure - list must be %s or %s", ListAllow, ListBlock)
+	}
+
+	if err := entry.Validate(); err != nil {
+		return nil, err
+	}
+
+	createdAt := time.Now().UTC()
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%s|%d", list, entry.Type, entry.Value, createdAt.UnixNano())))
+
+	listEntry := &ListEntry{
+		Id: fmt.Sprintf("rle_%x", sum[:8]),
+		List: list,
+		Entry: entry,
+		CreatedBy: actor,
+		CreatedAt: createdAt,
+	}
+
+	db.DB.StoreItem(listEntry.Id, listEntry)
+
+	log.WithFields(log.Fields{
+		"id": listEntry.Id,
+		"list": list,
+		"type": entry.Type,
+		"actor": actor,
+	}).Info("Risk.AddEntry - Entry added")
+
+	return listEntry, Lists.Reload()
+}
+
+// RemoveEntry deletes a stored entry, it stops being enforced from the next authorization
+func RemoveEntry(id string, actor string) error {
+	if _, ok := db.DB.FetchItem(id).(*ListEntry); !ok {
+		return ErrEntryNotFound
+	}
+
+	db.DB.DeleteItem(id)
+
+	log.WithFields(log.Fields{
+		"id": id,
+		"actor": actor,
+	}).Info("Risk.RemoveEntry - Entry removed")
+
+	return Lists.Reload()
+}
+
+// Entries returns the stored entries of a list, or of both when list is empty, newest first
+func Entries(list string) ([]*ListEntry, error) {
+	equals := map[string]string{"kind": listEntryKind}
+	if list != "" {
+		equals["list"] = list
+	}
+
+	items, _, err := db.DB.QueryItems(db.Query{
+		Equals: equals,
+		SortBy: "created_at",
+		Descending: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*ListEntry{}
+	for _, iterItem := range items {
+		if entry, ok := iterItem.(*ListEntry); ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Reload replaces the enforced entries with those in storage
+func (ml *ManagedLists) Reload() error {
+	entries, err := Entries("")
+	if err != nil {
+		log.WithField("err", err).Error("ManagedLists.Reload - Error querying entries, keeping the current ones")
+		return err
+	}
+
+	allow, block := List{}, List{}
+	for _, iterEntry := range entries {
+		if iterEntry.List == ListAllow {
+			allow = append(allow, iterEntry.Entry)
+		} else {
+			block = append(block, iterEntry.Entry)
+		}
+	}
+
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+
+	ml.allow, ml.block = allow, block
+
+	return nil
+}
+
+func (ml *ManagedLists) Allow() List {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	return ml.allow
+}
+
+func (ml *ManagedLists) Block() List {
+	ml.mu.RLock()
+	defer ml.mu.RUnlock()
+
+	return ml.block
+}
+
+func (le *ListEntry) IndexValues() map[string][]string {
+	return map[string][]string{
+		"kind": {listEntryKind},
+		"list": {le.List},
+	}
+}
+
+func (le *ListEntry) SortValues() map[string]float64 {
+	return map[string]float64{
+		"created_at": float64(le.CreatedAt.UnixNano() / int64(time.Microsecond)),
+	}
+}
@@ -7,8 +7,12 @@ import (
 
 	"github.com/gorilla/mux"
 
+	"github.com/nktsitas/checkout-techlab/audit"
 	"github.com/nktsitas/checkout-techlab/handlers"
 	"github.com/nktsitas/checkout-techlab/logger"
+	"github.com/nktsitas/checkout-techlab/metrics"
+	"github.com/nktsitas/checkout-techlab/ratelimit"
+	"github.com/nktsitas/checkout-techlab/tracing"
 	"github.com/nktsitas/checkout-techlab/auth"
 
 	_ "github.com/nktsitas/checkout-techlab/docs"
@@ -16,11 +20,32 @@ import (
 	httpSwagger "github.com/swaggo/http-swagger"
 )
 
+// auditedOperations are the routes changing state or reserved to admins, by route name
+var auditedOperations = map[string]string{
+	"CreateAuthorization": audit.OperationAuthorize,
+	"Capture": audit.OperationCapture,
+	"Refund": audit.OperationRefund,
+	"Void": audit.OperationVoid,
+	"DisputeNotification": audit.OperationDisputeNotification,
+	"SubmitEvidence": audit.OperationDisputeEvidence,
+	"ListRiskEntries": audit.OperationRiskListView,
+	"AddRiskEntry": audit.OperationRiskListAdd,
+	"RemoveRiskEntry": audit.OperationRiskListRemove,
+	"ExportAudit": audit.OperationAuditExport,
+	"ListReconciliations": audit.OperationReconciliationView,
+	"GetReconciliation": audit.OperationReconciliationView,
+}
+
+// Route is served under every API version, HandlerFunc answering unless Versions has a handler for the version
 type Route struct {
 	Name        string
 	Method      string
+	// Pattern is unversioned, e.g. /authorize for /v1/authorize
 	Pattern     string
 	HandlerFunc http.HandlerFunc
+	// Versions replace HandlerFunc by API version, for changes that would break older clients. A nil handler
+	// leaves the route out of that version.
+	Versions    map[string]http.HandlerFunc
 }
 
 func NewRouter() *mux.Router {
@@ -29,39 +54,131 @@ func NewRouter() *mux.Router {
 	// Create a new mux.Router
 	router := mux.NewRouter().StrictSlash(true)
 
-	router.HandleFunc("/login", auth.Login).Methods("POST")
-	router.HandleFunc("/status/ping", handlers.Ping).Methods("GET")
+	// unauthenticated routes are limited by client IP, health checks and metrics scrapes aren't limited
+	handle(router, "POST", "/login", "Login", func(version string) http.Handler {
+		return ratelimit.Middleware(audit.Middleware(http.HandlerFunc(auth.Login), audit.OperationLogin), "Login")
+	})
+	router.Handle("/status/ping", instrument(ratelimit.Middleware(http.HandlerFunc(handlers.Ping), "Ping"), "Ping")).Methods("GET")
+	router.Handle("/health/live", instrument(http.HandlerFunc(handlers.LiveHandler), "Live")).Methods("GET")
+	router.Handle("/health/ready", instrument(http.HandlerFunc(handlers.ReadyHandler), "Ready")).Methods("GET")
+	router.Handle("/status/acquirers", instrument(ratelimit.Middleware(http.HandlerFunc(handlers.AcquirerStatus), "AcquirerStatus"), "AcquirerStatus")).Methods("GET")
+	router.Handle("/metrics", metrics.Handler()).Methods("GET")
 
 	router.PathPrefix("/swagger").Handler(httpSwagger.WrapHandler)
 
+	// admin routes need the admin role on top of authentication
+	adminRoutes := make(map[string]bool)
+	for _, route := range CreateAdminRoutes() {
+		adminRoutes[route.Name] = true
+		routes = append(routes, route)
+	}
+
+	known := map[string]bool{"Login": true, "Ping": true, "AcquirerStatus": true}
+
 	// Add logging & authentication middleware and register routes
-	for _, route := range routes {
-		var handler http.Handler
-		handler = route.HandlerFunc
-		handler = auth.Authenticate(handler, route.Name)
-		handler = logger.APICallsLogger(handler, route.Name)
+	for _, iterRoute := range routes {
+		route := iterRoute
+		known[route.Name] = true
+
+		handle(router, route.Method, route.Pattern, route.Name, func(version string) http.Handler {
+			handlerFunc := route.handlerFor(version)
+			if handlerFunc == nil {
+				return nil
+			}
+
+			var handler http.Handler
+			handler = handlerFunc
+			if adminRoutes[route.Name] {
+				handler = auth.RequireRole(handler, auth.RoleAdmin)
+			}
+			if operation, ok := auditedOperations[route.Name]; ok {
+				handler = audit.Middleware(handler, operation)
+			}
+			// rate limited requests aren't audited, they change nothing
+			handler = ratelimit.Middleware(handler, route.Name)
+
+			return auth.Authenticate(handler, route.Name)
+		})
+	}
 
-		router.
-			Methods(route.Method).
-			Path(route.Pattern).
-			Name(route.Name).
-			Handler(handler)
+	for _, iterRoute := range ratelimit.Routes() {
+		if !known[iterRoute] {
+			log.WithField("route", iterRoute).Warn("NewRouter - Rate limit set for an unknown route")
+		}
 	}
 
 	return router
 }
 
+// handle registers a route under every API version, and unversioned as a deprecated alias of LegacyVersion.
+// handlerFor returns nil for the versions without the route.
+func handle(router *mux.Router, method string, pattern string, name string, handlerFor func(version string) http.Handler) {
+	for _, iterVersion := range APIVersions {
+		if handler := handlerFor(iterVersion); handler != nil {
+			router.
+				Methods(method).
+				Path("/" + iterVersion + pattern).
+				Name(iterVersion + "." + name).
+				Handler(instrument(versioned(handler, iterVersion), name))
+		}
+	}
+
+	if !LegacyRoutes {
+		return
+	}
+	if handler := handlerFor(LegacyVersion); handler != nil {
+		router.
+			Methods(method).
+			Path(pattern).
+			Name(name).
+			Handler(instrument(deprecated(versioned(handler, LegacyVersion), LegacyVersion), name))
+	}
+}
+
 func CreateRoutes() []Route {
 	var routes []Route
 
-	routes = append(routes, Route{"CreateAuthorization", "POST", "/authorize", handlers.CreateAuthorizationHandler})
-	routes = append(routes, Route{"Void", "POST", "/void", handlers.VoidHandler})
-	routes = append(routes, Route{"Capture", "POST", "/capture", handlers.CaptureHandler})
-	routes = append(routes, Route{"Refund", "POST", "/refund", handlers.RefundHandler})
+	routes = append(routes, Route{"CreateAuthorization", "POST", "/authorize", handlers.CreateAuthorizationHandler, nil})
+	routes = append(routes, Route{"Void", "POST", "/void", handlers.VoidHandler, nil})
+	routes = append(routes, Route{"Capture", "POST", "/capture", handlers.CaptureHandler, nil})
+	routes = append(routes, Route{"Refund", "POST", "/refund", handlers.RefundHandler, nil})
+	routes = append(routes, Route{"ListAuthorizations", "GET", "/authorizations", handlers.ListAuthorizationsHandler, nil})
+	routes = append(routes, Route{"SettlementReport", "GET", "/settlements/report", handlers.SettlementReportHandler, nil})
+	routes = append(routes, Route{"Balances", "GET", "/balances", handlers.BalancesHandler, nil})
+	routes = append(routes, Route{"ListDisputes", "GET", "/disputes", handlers.ListDisputesHandler, nil})
+	routes = append(routes, Route{"GetDispute", "GET", "/disputes/{id}", handlers.GetDisputeHandler, nil})
+	routes = append(routes, Route{"SubmitEvidence", "POST", "/disputes/{id}/evidence", handlers.SubmitEvidenceHandler, nil})
+	routes = append(routes, Route{"ListEvents", "GET", "/events", handlers.ListEventsHandler, nil})
 
 	log.WithFields(log.Fields{
 		"routes": routes,
 	}).Debug("Routes initialized")
 
 	return routes
-}
\ No newline at end of file
+}
+
+// instrument adds the request id, access logging, metrics and tracing of every route
+func instrument(handler http.Handler, name string) http.Handler {
+	handler = logger.AccessLogger(handler, name)
+	handler = metrics.Instrument(handler, name)
+	handler = tracing.Middleware(handler, name)
+
+	return handler
+}
+
+// CreateAdminRoutes are the routes reserved to tokens with the admin role
+func CreateAdminRoutes() []Route {
+	var routes []Route
+
+	routes = append(routes, Route{"ListRiskEntries", "GET", "/admin/risk/lists", handlers.ListRiskEntriesHandler, nil})
+	routes = append(routes, Route{"AddRiskEntry", "POST", "/admin/risk/lists", handlers.AddRiskEntryHandler, nil})
+	routes = append(routes, Route{"RemoveRiskEntry", "DELETE", "/admin/risk/lists/{id}", handlers.RemoveRiskEntryHandler, nil})
+	routes = append(routes, Route{"ExportAudit", "GET", "/admin/audit", handlers.ExportAuditHandler, nil})
+	// reconciliations span every merchant's transactions
+	routes = append(routes, Route{"ListReconciliations", "GET", "/admin/reconciliations", handlers.ListReconciliationsHandler, nil})
+	routes = append(routes, Route{"GetReconciliation", "GET", "/admin/reconciliations/{id}", handlers.GetReconciliationHandler, nil})
+	// acquirers' dispute notifications are simulated by admins, they move money across merchants
+	routes = append(routes, Route{"DisputeNotification", "POST", "/admin/disputes/notifications", handlers.DisputeNotificationHandler, nil})
+
+	return routes
+}
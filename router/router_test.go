@@ -0,0 +1,177 @@
+package router
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/gorilla/mux"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+}
+
+func TestVersions(t *testing.T) {
+	assert := assert.New(t)
+
+	auth.AccessSecret = "supersecret"
+	db.DB = db.InitMemoryDB()
+	// every login comes from the same address
+	ratelimit.Configure(ratelimit.Settings{Default: ratelimit.Limit{PerMinute: 1, Burst: 1}})
+	defer ratelimit.Configure(ratelimit.DefaultSettings())
+	LegacySunset = time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)
+	router := NewRouter()
+
+	login := func(path string, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", path, bytes.NewBufferString(`{"username":"Checkout","password":"Checkout"}`))
+		req.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	tests := []struct{
+		path string
+		accept string
+		expectedCode int
+		expectedContentType string
+		expectedDeprecated bool
+		description string
+	}{
+		{"/v1/login", "", 200, "application/json", false, "v1"},
+		{"/v1/login", "application/vnd.checkout.v1+json", 200, "application/vnd.checkout.v1+json", false, "v1 - Vendor media type"},
+		{"/v1/login", "text/html, */*;q=0.8", 200, "application/json", false, "v1 - Browser"},
+		{"/v1/login", "application/vnd.checkout.v2+json", 406, "text/plain; charset=utf-8", false, "Error - Other version asked"},
+		{"/v1/login", "application/json;q=0", 406, "text/plain; charset=utf-8", false, "Error - JSON refused"},
+		{"/login", "", 200, "application/json", true, "Legacy - Deprecated alias"},
+	}
+
+	for _, iterTest := range tests {
+		w := login(iterTest.path, iterTest.accept)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Equal(iterTest.expectedContentType, w.Header().Get("Content-Type"), iterTest.description)
+		assert.Equal("v1", w.Header().Get("API-Version"), iterTest.description)
+		if iterTest.expectedDeprecated {
+			assert.Equal("@1792368000", w.Header().Get("Deprecation"), iterTest.description)
+			assert.Equal("Fri, 30 Apr 2027 00:00:00 GMT", w.Header().Get("Sunset"), iterTest.description)
+			assert.Equal(`</v1/login>; rel="successor-version"`, w.Header().Get("Link"), iterTest.description)
+		} else {
+			assert.Empty(w.Header().Get("Deprecation"), iterTest.description)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/authorizations", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(400, w.Code, "Legacy - Still authenticated")
+	assert.NotEmpty(w.Header().Get("Deprecation"), "Legacy - Still authenticated")
+
+	LegacyRoutes = false
+	defer func() { LegacyRoutes = true }()
+	router = NewRouter()
+	assert.Equal(404, login("/login", "").Code, "Legacy routes off")
+	assert.Equal(200, login("/v1/login", "").Code, "Legacy routes off - v1 kept")
+}
+
+func TestVersionHandlers(t *testing.T) {
+	assert := assert.New(t)
+
+	APIVersions = []string{"v1", "v2"}
+	defer func() { APIVersions = []string{"v1"} }()
+
+	answer := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(body)) }
+	}
+	routes := []Route{
+		{"Changed", "GET", "/changed", answer("old"), map[string]http.HandlerFunc{"v2": answer("new")}},
+		{"Removed", "GET", "/removed", answer("old"), map[string]http.HandlerFunc{"v2": nil}},
+		{"Added", "GET", "/added", nil, map[string]http.HandlerFunc{"v2": answer("new")}},
+	}
+
+	router := mux.NewRouter()
+	for _, iterRoute := range routes {
+		route := iterRoute
+		handle(router, route.Method, route.Pattern, route.Name, func(version string) http.Handler {
+			if handlerFunc := route.handlerFor(version); handlerFunc != nil {
+				return handlerFunc
+			}
+			return nil
+		})
+	}
+
+	tests := []struct{
+		path string
+		expectedCode int
+		expectedBody string
+		description string
+	}{
+		{"/v1/changed", 200, "old", "Changed - v1 keeps the old handler"},
+		{"/v2/changed", 200, "new", "Changed - v2 handler"},
+		{"/changed", 200, "old", "Changed - Legacy is v1"},
+		{"/v2/removed", 404, "404 page not found\n", "Removed - Not in v2"},
+		{"/v1/added", 404, "404 page not found\n", "Added - Not in v1"},
+		{"/v2/added", 200, "new", "Added - In v2"},
+	}
+
+	for _, iterTest := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", iterTest.path, nil))
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+		assert.Equal(iterTest.expectedBody, w.Body.String(), iterTest.description)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	assert := assert.New(t)
+
+	auth.AccessSecret = "supersecret"
+	db.DB = db.InitMemoryDB()
+	ratelimit.Configure(ratelimit.Settings{Default: ratelimit.Limit{PerMinute: 1, Burst: 1}})
+	defer ratelimit.Configure(ratelimit.DefaultSettings())
+	router := NewRouter()
+
+	merchantToken, err := auth.GenerateToken("Checkout", "")
+	assert.NoError(err)
+	adminToken, err := auth.GenerateToken(auth.AdminUsername, auth.RoleAdmin)
+	assert.NoError(err)
+
+	tests := []struct{
+		method string
+		path string
+		body string
+		token string
+		expectedCode int
+		description string
+	}{
+		{"POST", "/v1/admin/disputes/notifications", `{"type":"won","acquirer":"simulator","dispute_id":"dsp_unknown"}`, merchantToken, 403, "Dispute notification - Merchant"},
+		{"POST", "/v1/admin/disputes/notifications", `{"type":"won","acquirer":"simulator","dispute_id":"dsp_unknown"}`, adminToken, 404, "Dispute notification - Admin"},
+		{"POST", "/v1/disputes/notifications", `{"type":"won","acquirer":"simulator","dispute_id":"dsp_unknown"}`, merchantToken, 405, "Dispute notification - Merchant path gone"},
+		{"GET", "/v1/admin/reconciliations", "", merchantToken, 403, "Reconciliations - Merchant"},
+		{"GET", "/v1/admin/reconciliations", "", adminToken, 200, "Reconciliations - Admin"},
+		{"GET", "/v1/admin/reconciliations/rec_unknown", "", merchantToken, 403, "Reconciliation - Merchant"},
+		{"GET", "/v1/reconciliations", "", merchantToken, 404, "Reconciliations - Merchant path gone"},
+	}
+
+	for _, iterTest := range tests {
+		req := httptest.NewRequest(iterTest.method, iterTest.path, bytes.NewBufferString(iterTest.body))
+		req.Header.Set("Token", iterTest.token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(iterTest.expectedCode, w.Code, iterTest.description)
+	}
+}
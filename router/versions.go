@@ -0,0 +1,132 @@
+package router
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	v1 "github.com/nktsitas/checkout-techlab/api/v1"
+	"github.com/nktsitas/checkout-techlab/logger"
+)
+
+// APIVersions are served under /<version>/, oldest first
+var APIVersions = []string{v1.Version}
+
+// LegacyVersion answers the unversioned paths, kept as deprecated aliases for clients from before versioning
+const LegacyVersion = v1.Version
+
+// LegacyDeprecated is when the unversioned paths were deprecated
+var LegacyDeprecated = time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+
+// LegacySunset is when the unversioned paths go away, set from the configuration at startup
+var LegacySunset = time.Date(2027, 4, 30, 0, 0, 0, 0, time.UTC)
+
+// LegacyRoutes serves the unversioned paths, turn it off once LegacySunset passed
+var LegacyRoutes = true
+
+// MediaType is the vendor media type of version, e.g. application/vnd.checkout.v1+json
+func MediaType(version string) string {
+	return "application/vnd.checkout." + version + "+json"
+}
+
+// handlerFor is the route's handler in version
+func (route Route) handlerFor(version string) http.HandlerFunc {
+	if handler, ok := route.Versions[version]; ok {
+		return handler
+	}
+
+	return route.HandlerFunc
+}
+
+// versioned answers 406 to clients accepting neither JSON nor version's media type. Clients asking for the
+// media type get it as the Content-Type, so they know which version answered.
+func versioned(inner http.Handler, version string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("API-Version", version)
+		w.Header().Add("Vary", "Accept")
+
+		acceptable, vendor := negotiate(r.Header.Get("Accept"), version)
+		if !acceptable {
+			log.WithFields(log.Fields{"accept": r.Header.Get("Accept"), "version": version}).Error("versioned - Not acceptable")
+			http.Error(w, fmt.Sprintf("Not Acceptable - %s answers application/json or %s", r.URL.Path, MediaType(version)), http.StatusNotAcceptable)
+			return
+		}
+
+		if vendor {
+			w = &mediaTypeWriter{ResponseWriter: w, mediaType: MediaType(version)}
+		}
+
+		inner.ServeHTTP(w, r)
+	})
+}
+
+// negotiate tells whether accept lets version answer, and whether the client asked for its vendor media type
+func negotiate(accept string, version string) (bool, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return true, false
+	}
+
+	acceptable, vendor := false, false
+	for _, iterRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(iterRange))
+		if err != nil {
+			continue
+		}
+		if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+			continue
+		}
+
+		switch mediaType {
+		case MediaType(version):
+			acceptable, vendor = true, true
+		case "*/*", "application/*", "application/json":
+			acceptable = true
+		}
+	}
+
+	return acceptable, vendor
+}
+
+// mediaTypeWriter replaces the JSON Content-Type of responses with the vendor media type
+type mediaTypeWriter struct {
+	http.ResponseWriter
+	mediaType string
+	wroteHeader bool
+}
+
+func (mw *mediaTypeWriter) WriteHeader(status int) {
+	if !mw.wroteHeader {
+		mw.wroteHeader = true
+		if mediaType, _, _ := mime.ParseMediaType(mw.Header().Get("Content-Type")); mediaType == "application/json" {
+			mw.Header().Set("Content-Type", mw.mediaType)
+		}
+	}
+
+	mw.ResponseWriter.WriteHeader(status)
+}
+
+func (mw *mediaTypeWriter) Write(body []byte) (int, error) {
+	if !mw.wroteHeader {
+		mw.WriteHeader(http.StatusOK)
+	}
+
+	return mw.ResponseWriter.Write(body)
+}
+
+// deprecated marks the responses of an unversioned path with Deprecation (RFC 9745), Sunset (RFC 8594)
+// and a link to the same path under version
+func deprecated(inner http.Handler, version string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(LegacyDeprecated.Unix(), 10))
+		w.Header().Set("Sunset", LegacySunset.UTC().Format(http.TimeFormat))
+		w.Header().Add("Link", fmt.Sprintf(`</%s%s>; rel="successor-version"`, version, r.URL.Path))
+		logger.Annotate(r.Context(), "deprecated", true)
+
+		inner.ServeHTTP(w, r)
+	})
+}
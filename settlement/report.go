@@ -0,0 +1,89 @@
+package settlement
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+var csvHeader = []string{
+	"record_type",
+	"batch_id",
+	"merchant",
+	"currency",
+	"date",
+	"authorization_id",
+	"type",
+	"amount",
+	"fee",
+	"net",
+	"reference",
+	"acquirer_reference",
+	"created_at",
+}
+
+// WriteCSV writes a report with a "batch" record carrying the totals of each batch,
+// followed by a "transaction" record per settled capture or refund
+func WriteCSV(w io.Writer, report *Report) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(csvHeader); err != nil {
+		return err
+	}
+
+	for _, iterBatch := range report.Batches {
+		err := writer.Write([]string{
+			"batch",
+			iterBatch.Id,
+			iterBatch.Merchant,
+			iterBatch.Currency,
+			iterBatch.Date,
+			"",
+			"",
+			formatAmount(iterBatch.Gross - iterBatch.Refunds),
+			formatAmount(iterBatch.Fees),
+			formatAmount(iterBatch.Net),
+			"",
+			"",
+			iterBatch.CreatedAt.Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, iterEntry := range iterBatch.Entries {
+			amount := iterEntry.Amount
+			if iterEntry.Type == EntryRefund {
+				amount = -amount
+			}
+
+			err := writer.Write([]string{
+				"transaction",
+				iterBatch.Id,
+				iterBatch.Merchant,
+				iterBatch.Currency,
+				iterBatch.Date,
+				iterEntry.AuthorizationId,
+				iterEntry.Type,
+				formatAmount(amount),
+				formatAmount(iterEntry.Fee),
+				formatAmount(amount - iterEntry.Fee),
+				iterEntry.Reference,
+				iterEntry.AcquirerReference,
+				iterEntry.CreatedAt.Format(time.RFC3339),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func formatAmount(amount float64) string {
+	return strconv.FormatFloat(round(amount), 'f', 2, 64)
+}
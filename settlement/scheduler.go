@@ -0,0 +1,55 @@
+package settlement
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCutoff is the end of the settlement day, as an offset from midnight UTC
+const DefaultCutoff = 22 * time.Hour
+
+// ParseCutoff parses an HH:MM time of day in UTC
+func ParseCutoff(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid settlement cutoff %q - must be HH:MM", value)
+	}
+
+	return time.Duration(t.Hour()) * time.Hour + time.Duration(t.Minute()) * time.Minute, nil
+}
+
+// NextCutoff is the first cutoff strictly after now
+func NextCutoff(now time.Time, cutoff time.Duration) time.Time {
+	now = now.UTC()
+	next := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).Add(cutoff)
+
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+
+	return next
+}
+
+// Schedule runs a settlement at every daily cutoff until ctx is done
+func Schedule(ctx context.Context, cutoff time.Duration) {
+	for {
+		next := NextCutoff(time.Now(), cutoff)
+		log.WithField("cutoff", next).Info("Settlement.Schedule - Next settlement scheduled")
+
+		timer := time.NewTimer(time.Until(next))
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if _, err := Run(next); err != nil {
+			log.WithField("err", err).Error("Settlement.Schedule - Settlement failed")
+		}
+	}
+}
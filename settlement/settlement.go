@@ -0,0 +1,325 @@
+package settlement
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/gateway"
+	"github.com/nktsitas/checkout-techlab/ledger"
+)
+
+// Entry types
+const (
+	EntryCapture = "capture"
+	EntryRefund = "refund"
+)
+
+const batchKind = "settlement_batch"
+
+// DateLayout is the layout of settlement dates, the UTC date of the cutoff
+const DateLayout = "2006-01-02"
+
+// Batch groups the captures and refunds of one merchant and settlement currency settled at a cutoff
+type Batch struct {
+	Id string							`json:"id"`
+	Merchant string				`json:"merchant"`
+	Currency string				`json:"currency"`
+	Date string						`json:"date" example:"2020-07-01"`
+	Cutoff time.Time			`json:"cutoff"`
+	CreatedAt time.Time		`json:"created_at"`
+
+	CaptureCount int			`json:"capture_count"`
+	RefundCount int				`json:"refund_count"`
+	Gross float64					`json:"gross"`
+	Refunds float64				`json:"refunds"`
+	Fees float64					`json:"fees"`
+	Net float64						`json:"net"`
+
+	Entries []Entry				`json:"entries"`
+}
+
+// Entry is a settled capture or refund, amounts are in the batch's currency
+type Entry struct {
+	AuthorizationId string		`json:"authorization_id"`
+	Type string								`json:"type" example:"capture"`
+	Amount float64						`json:"amount"`
+	Fee float64								`json:"fee"`
+	// PresentmentAmount is the amount the shopper paid, in the currency they paid in
+	PresentmentAmount float64		`json:"presentment_amount"`
+	PresentmentCurrency string	`json:"presentment_currency" example:"USD"`
+	Reference string					`json:"reference,omitempty"`
+	AcquirerReference string	`json:"acquirer_reference,omitempty"`
+	CreatedAt time.Time				`json:"created_at"`
+}
+
+// Report is every batch of a merchant for a settlement date
+type Report struct {
+	Merchant string		`json:"merchant"`
+	Date string				`json:"date" example:"2020-07-01"`
+	Gross float64			`json:"gross"`
+	Refunds float64		`json:"refunds"`
+	Fees float64			`json:"fees"`
+	Net float64				`json:"net"`
+	Batches []*Batch	`json:"batches"`
+}
+
+var ErrInvalidDate = errors.New("Settlement failure - date must be formatted as YYYY-MM-DD")
+
+// runs never overlap, a capture can only end up in one batch anyway
+var runMu sync.Mutex
+
+// Run settles every succeeded capture and refund made before cutoff that is not settled yet,
+// grouped in one batch per merchant and currency, and stores the batches. A batch the ledger refuses is
+// neither stored nor kept settled, it is left for the next run and Run returns the error with the other batches.
+func Run(cutoff time.Time) ([]*Batch, error) {
+	runMu.Lock()
+	defer runMu.Unlock()
+
+	cutoff = cutoff.UTC()
+	createdAt := time.Now().UTC()
+
+	auths, err := authorizationsBefore(cutoff)
+	if err != nil {
+		log.WithField("err", err).Error("Settlement.Run - Error listing authorizations")
+		return nil, err
+	}
+
+	batches := make(map[string]*Batch)
+	// settled are the authorizations with captures or refunds marked as part of each batch
+	settled := make(map[string][]*gateway.Authorization)
+
+	for _, auth := range auths {
+		// merchant and settlement currency never change once the authorization is created
+		currency := auth.SettlementCurrency()
+		id := batchId(auth.Merchant, currency, createdAt)
+
+		captures, refunds := auth.Settle(cutoff, id)
+		if len(captures) == 0 && len(refunds) == 0 {
+			continue
+		}
+		settled[id] = append(settled[id], auth)
+
+		batch, ok := batches[id]
+		if !ok {
+			batch = &Batch{
+				Id: id,
+				Merchant: auth.Merchant,
+				Currency: currency,
+				Date: cutoff.Format(DateLayout),
+				Cutoff: cutoff,
+				CreatedAt: createdAt,
+			}
+			batches[id] = batch
+		}
+
+		for _, iterCapture := range captures {
+			batch.add(Entry{
+				AuthorizationId: auth.Id,
+				Type: EntryCapture,
+				Amount: iterCapture.SettlementAmount,
+				Fee: iterCapture.Fee,
+				PresentmentAmount: iterCapture.Amount,
+				PresentmentCurrency: strings.ToUpper(auth.Currency),
+				Reference: iterCapture.Reference,
+				AcquirerReference: iterCapture.AcquirerReference,
+				CreatedAt: iterCapture.CreatedAt,
+			})
+		}
+
+		for _, iterRefund := range refunds {
+			batch.add(Entry{
+				AuthorizationId: auth.Id,
+				Type: EntryRefund,
+				Amount: iterRefund.SettlementAmount,
+				Fee: iterRefund.Fee,
+				PresentmentAmount: iterRefund.Amount,
+				PresentmentCurrency: strings.ToUpper(auth.Currency),
+				Reference: iterRefund.Reference,
+				AcquirerReference: iterRefund.AcquirerReference,
+				CreatedAt: iterRefund.CreatedAt,
+			})
+		}
+	}
+
+	var postErr error
+	result := make([]*Batch, 0, len(batches))
+	for _, iterBatch := range batches {
+		iterBatch.total()
+		if err := iterBatch.post(); err != nil {
+			for _, iterAuth := range settled[iterBatch.Id] {
+				iterAuth.Unsettle(iterBatch.Id)
+			}
+			postErr = err
+			continue
+		}
+		db.DB.StoreItem(iterBatch.Id, iterBatch)
+
+		result = append(result, iterBatch)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Merchant != result[j].Merchant {
+			return result[i].Merchant < result[j].Merchant
+		}
+		return result[i].Currency < result[j].Currency
+	})
+
+	log.WithFields(log.Fields{
+		"cutoff": cutoff,
+		"batches": len(result),
+	}).Info("Settlement.Run - Settlement completed")
+
+	return result, postErr
+}
+
+// authorizations created after the cutoff cannot hold anything to settle
+func authorizationsBefore(cutoff time.Time) ([]*gateway.Authorization, error) {
+	filter := gateway.AuthorizationFilter{
+		CreatedTo: &cutoff,
+		Limit: gateway.MaxListLimit,
+	}
+
+	var auths []*gateway.Authorization
+	for {
+		page, cursor, err := gateway.Gateway.ListAuthorizations(filter)
+		if err != nil {
+			return nil, err
+		}
+
+		auths = append(auths, page...)
+		if cursor == "" {
+			return auths, nil
+		}
+		filter.Cursor = cursor
+	}
+}
+
+// GetReport returns the batches settled for a merchant on date (YYYY-MM-DD)
+func GetReport(merchant string, date string) (*Report, error) {
+	if _, err := time.Parse(DateLayout, date); err != nil {
+		return nil, ErrInvalidDate
+	}
+
+	items, _, err := db.DB.QueryItems(db.Query{
+		Equals: map[string]string{
+			"kind": batchKind,
+			"merchant": merchant,
+			"date": date,
+		},
+		SortBy: "created_at",
+	})
+	if err != nil {
+		log.WithField("err", err).Error("Settlement.GetReport - Error querying batches")
+		return nil, err
+	}
+
+	report := &Report{
+		Merchant: merchant,
+		Date: date,
+		Batches: []*Batch{},
+	}
+
+	for _, iterItem := range items {
+		batch, ok := iterItem.(*Batch)
+		if !ok {
+			continue
+		}
+
+		report.Batches = append(report.Batches, batch)
+		report.Gross += batch.Gross
+		report.Refunds += batch.Refunds
+		report.Fees += batch.Fees
+		report.Net += batch.Net
+	}
+
+	report.Gross = round(report.Gross)
+	report.Refunds = round(report.Refunds)
+	report.Fees = round(report.Fees)
+	report.Net = round(report.Net)
+
+	return report, nil
+}
+
+func (batch *Batch) add(entry Entry) {
+	batch.Entries = append(batch.Entries, entry)
+
+	switch entry.Type {
+	case EntryCapture:
+		batch.CaptureCount++
+		batch.Gross += entry.Amount
+	case EntryRefund:
+		batch.RefundCount++
+		batch.Refunds += entry.Amount
+	}
+	batch.Fees += entry.Fee
+}
+
+func (batch *Batch) total() {
+	batch.Gross = round(batch.Gross)
+	batch.Refunds = round(batch.Refunds)
+	batch.Fees = round(batch.Fees)
+	batch.Net = round(batch.Gross - batch.Refunds - batch.Fees)
+
+	sort.SliceStable(batch.Entries, func(i, j int) bool {
+		return batch.Entries[i].CreatedAt.Before(batch.Entries[j].CreatedAt)
+	})
+}
+
+// post moves the batch's net from the merchant's pending to its available balance
+func (batch *Batch) post() error {
+	if batch.Net == 0 {
+		return nil
+	}
+
+	err := ledger.Ledger.Post(&ledger.Entry{
+		Type: ledger.EntrySettlement,
+		Merchant: batch.Merchant,
+		Currency: batch.Currency,
+		Postings: []ledger.Posting{
+			{Account: ledger.MerchantPending(batch.Merchant), Amount: batch.Net},
+			{Account: ledger.MerchantAvailable(batch.Merchant), Amount: -batch.Net},
+		},
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"batch": batch.Id,
+			"err": err,
+		}).Error("Settlement.Run - Error posting settlement to the ledger, batch left unsettled")
+	}
+
+	return err
+}
+
+func (batch *Batch) IndexValues() map[string][]string {
+	return map[string][]string{
+		"kind": {batchKind},
+		"merchant": {batch.Merchant},
+		"currency": {batch.Currency},
+		"date": {batch.Date},
+	}
+}
+
+func (batch *Batch) SortValues() map[string]float64 {
+	return map[string]float64{
+		"created_at": float64(batch.CreatedAt.UnixNano() / int64(time.Microsecond)),
+	}
+}
+
+func batchId(merchant string, currency string, createdAt time.Time) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d", merchant, currency, createdAt.UnixNano())))
+	return fmt.Sprintf("stl_%x", sum[:8])
+}
+
+// amounts are kept in major units, totals are rounded to cents
+func round(amount float64) float64 {
+	return math.Round(amount * 100) / 100
+}
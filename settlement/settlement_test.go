@@ -0,0 +1,282 @@
+package settlement
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/nktsitas/checkout-techlab/auth"
+	"github.com/nktsitas/checkout-techlab/bank"
+	"github.com/nktsitas/checkout-techlab/db"
+	"github.com/nktsitas/checkout-techlab/fees"
+	"github.com/nktsitas/checkout-techlab/fx"
+	"github.com/nktsitas/checkout-techlab/gateway"
+	"github.com/nktsitas/checkout-techlab/ledger"
+	"github.com/nktsitas/checkout-techlab/merchants"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAuthorization = `{"credit_card":{"number":"4000 0000 0000 0123","expiry":"12/22","cvv":"123"},"amount":100,"currency":"%s"}`
+
+func init() {
+	log.SetOutput(ioutil.Discard)
+
+	bank.Scenarios = &bank.ScenarioRegistry{}
+}
+
+func newTestAuthorization(t *testing.T, merchant string, currency string) *gateway.Authorization {
+	ctx := auth.WithMerchant(context.Background(), merchant)
+	body := strings.Replace(testAuthorization, "%s", currency, 1)
+
+	newAuth, err := gateway.Gateway.NewAuthorization(ctx, []byte(body), merchant + currency + time.Now().String())
+	assert.NoError(t, err)
+
+	return newAuth
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	ledger.Ledger = ledger.InitMemoryLedger()
+	gateway.Gateway = new(gateway.GatewayS)
+
+	defer func() {
+		fees.Pricing = &fees.Config{}
+		merchants.Merchants = &merchants.Registry{}
+	}()
+	fees.Pricing = &fees.Config{Plans: []fees.Plan{{Name: "flat", Rate: fees.Rate{Fixed: 0.50}}}}
+	merchants.Merchants = &merchants.Registry{Merchants: []merchants.Merchant{{Id: "Other", PricingPlan: "flat"}}}
+
+	first := newTestAuthorization(t, "Checkout", "EUR")
+	second := newTestAuthorization(t, "Checkout", "EUR")
+	dollars := newTestAuthorization(t, "Checkout", "USD")
+	other := newTestAuthorization(t, "Other", "EUR")
+	uncaptured := newTestAuthorization(t, "Checkout", "EUR")
+
+	_, err := first.Capture(context.Background(), 100.00, "EUR", gateway.Details{Reference: "order-1"})
+	assert.NoError(err)
+	_, err = first.Refund(context.Background(), 30.00, "EUR", gateway.Details{})
+	assert.NoError(err)
+	_, err = second.Capture(context.Background(), 20.50, "EUR", gateway.Details{})
+	assert.NoError(err)
+	_, err = dollars.Capture(context.Background(), 10.00, "USD", gateway.Details{})
+	assert.NoError(err)
+	_, err = other.Capture(context.Background(), 5.00, "EUR", gateway.Details{})
+	assert.NoError(err)
+	assert.NotNil(uncaptured)
+
+	cutoff := time.Now()
+
+	// made after the cutoff, left for the next run
+	_, err = second.Capture(context.Background(), 1.00, "EUR", gateway.Details{})
+	assert.NoError(err)
+
+	batches, err := Run(cutoff)
+	assert.NoError(err)
+	assert.Len(batches, 3, "Run - One batch per merchant and currency")
+
+	euros := batches[0]
+	assert.Equal("Checkout", euros.Merchant)
+	assert.Equal("EUR", euros.Currency)
+	assert.Equal(2, euros.CaptureCount, "Run - Captures before the cutoff")
+	assert.Equal(1, euros.RefundCount, "Run - Refunds before the cutoff")
+	assert.Equal(120.50, euros.Gross, "Run - Gross")
+	assert.Equal(30.00, euros.Refunds, "Run - Refunds")
+	assert.Equal(90.50, euros.Net, "Run - Net")
+	assert.Equal("order-1", euros.Entries[0].Reference, "Run - Entries keep the merchant reference")
+
+	assert.Equal("USD", batches[1].Currency)
+	assert.Equal(10.00, batches[1].Net)
+	assert.Equal("Other", batches[2].Merchant)
+	assert.Equal(0.50, batches[2].Fees, "Run - Fees of the merchant's plan")
+	assert.Equal(0.50, batches[2].Entries[0].Fee, "Run - Entries keep their fee")
+	assert.Equal(4.50, batches[2].Net, "Run - Net of fees")
+
+	assert.Equal([]ledger.MerchantBalance{
+		{Currency: "EUR", Pending: 1.00, Available: 90.50},
+		{Currency: "USD", Pending: 0, Available: 10.00},
+	}, ledger.MerchantBalances("Checkout"), "Run - Net moved from pending to available")
+
+	batches, err = Run(time.Now())
+	assert.NoError(err)
+	assert.Len(batches, 1, "Second run - Only what was not settled yet")
+	assert.Equal(1.00, batches[0].Gross, "Second run - Capture made after the first cutoff")
+
+	report, err := GetReport("Checkout", cutoff.UTC().Format(DateLayout))
+	assert.NoError(err)
+	assert.Len(report.Batches, 3, "Report - Every batch of the merchant for the day")
+	assert.Equal(101.50, report.Net, "Report - Net over all batches")
+
+	_, err = GetReport("Checkout", "yesterday")
+	assert.Equal(ErrInvalidDate, err, "Report - Invalid date")
+}
+
+func TestRunConverted(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	ledger.Ledger = ledger.InitMemoryLedger()
+	gateway.Gateway = new(gateway.GatewayS)
+
+	defer func() {
+		fx.Provider = &fx.StaticRates{}
+		merchants.Merchants = &merchants.Registry{}
+	}()
+	fx.Provider = &fx.StaticRates{Base: "EUR", Rates: map[string]float64{"USD": 1.25}}
+	merchants.Merchants = &merchants.Registry{Merchants: []merchants.Merchant{{Id: "Checkout", SettlementCurrency: "EUR"}}}
+
+	dollars := newTestAuthorization(t, "Checkout", "USD")
+	euros := newTestAuthorization(t, "Checkout", "EUR")
+
+	_, err := dollars.Capture(context.Background(), 50.00, "USD", gateway.Details{})
+	assert.NoError(err)
+	_, err = euros.Capture(context.Background(), 10.00, "EUR", gateway.Details{})
+	assert.NoError(err)
+
+	batches, err := Run(time.Now())
+	assert.NoError(err)
+	assert.Len(batches, 1, "One batch in the settlement currency")
+	assert.Equal("EUR", batches[0].Currency)
+	assert.Equal(50.00, batches[0].Gross, "Converted at the locked rate")
+
+	for _, iterEntry := range batches[0].Entries {
+		if iterEntry.AuthorizationId == dollars.Id {
+			assert.Equal(40.00, iterEntry.Amount)
+			assert.Equal(50.00, iterEntry.PresentmentAmount)
+			assert.Equal("USD", iterEntry.PresentmentCurrency)
+		}
+	}
+
+	assert.Equal([]ledger.MerchantBalance{
+		{Currency: "EUR", Pending: 0, Available: 50.00},
+	}, ledger.MerchantBalances("Checkout"), "Balances in the settlement currency only")
+}
+
+// refusingLedger refuses settlement postings, as a ledger failing mid-run would
+type refusingLedger struct {
+	ledger.LedgerI
+}
+
+func (rl refusingLedger) Post(entry *ledger.Entry) error {
+	if entry.Type == ledger.EntrySettlement {
+		return errors.New("Ledger failure - Unavailable")
+	}
+
+	return rl.LedgerI.Post(entry)
+}
+
+func TestRunPostFailure(t *testing.T) {
+	assert := assert.New(t)
+
+	db.DB = db.InitMemoryDB()
+	ledger.Ledger = ledger.InitMemoryLedger()
+	gateway.Gateway = new(gateway.GatewayS)
+
+	captured := newTestAuthorization(t, "Checkout", "EUR")
+	_, err := captured.Capture(context.Background(), 10.00, "EUR", gateway.Details{})
+	assert.NoError(err)
+
+	memoryLedger := ledger.Ledger
+	ledger.Ledger = refusingLedger{memoryLedger}
+
+	batches, err := Run(time.Now())
+	assert.Equal(errors.New("Ledger failure - Unavailable"), err)
+	assert.Empty(batches, "Failed - Batch not stored")
+
+	captures, _ := captured.Transactions()
+	assert.Empty(captures[0].SettlementBatch, "Failed - Capture left unsettled")
+
+	ledger.Ledger = memoryLedger
+
+	batches, err = Run(time.Now())
+	assert.NoError(err)
+	assert.Len(batches, 1, "Next run - Capture settled")
+	assert.Equal([]ledger.MerchantBalance{
+		{Currency: "EUR", Pending: 0, Available: 10.00},
+	}, ledger.MerchantBalances("Checkout"), "Next run - Net moved to available")
+
+	report, err := GetReport("Checkout", time.Now().UTC().Format(DateLayout))
+	assert.NoError(err)
+	assert.Len(report.Batches, 1, "Report - Only the stored batch")
+}
+
+func TestWriteCSV(t *testing.T) {
+	assert := assert.New(t)
+
+	createdAt := time.Date(2020, 7, 1, 22, 0, 0, 0, time.UTC)
+
+	report := &Report{
+		Merchant: "Checkout",
+		Date: "2020-07-01",
+		Batches: []*Batch{
+			{
+				Id: "stl_1",
+				Merchant: "Checkout",
+				Currency: "EUR",
+				Date: "2020-07-01",
+				CreatedAt: createdAt,
+				Gross: 100,
+				Refunds: 30,
+				Net: 70,
+				Entries: []Entry{
+					{AuthorizationId: "auth_1", Type: EntryCapture, Amount: 100, Reference: "order-1", CreatedAt: createdAt},
+					{AuthorizationId: "auth_1", Type: EntryRefund, Amount: 30, CreatedAt: createdAt},
+				},
+			},
+		},
+	}
+
+	var buffer bytes.Buffer
+	assert.NoError(WriteCSV(&buffer, report))
+
+	expected := "record_type,batch_id,merchant,currency,date,authorization_id,type,amount,fee,net,reference,acquirer_reference,created_at\n" +
+		"batch,stl_1,Checkout,EUR,2020-07-01,,,70.00,0.00,70.00,,,2020-07-01T22:00:00Z\n" +
+		"transaction,stl_1,Checkout,EUR,2020-07-01,auth_1,capture,100.00,0.00,100.00,order-1,,2020-07-01T22:00:00Z\n" +
+		"transaction,stl_1,Checkout,EUR,2020-07-01,auth_1,refund,-30.00,0.00,-30.00,,,2020-07-01T22:00:00Z\n"
+
+	assert.Equal(expected, buffer.String())
+}
+
+func TestNextCutoff(t *testing.T) {
+	assert := assert.New(t)
+
+	cutoff, err := ParseCutoff("22:00")
+	assert.NoError(err)
+
+	tests := []struct{
+		now time.Time
+		expected time.Time
+		description string
+	}{
+		{
+			time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC),
+			time.Date(2020, 7, 1, 22, 0, 0, 0, time.UTC),
+			"Before the cutoff - Same day",
+		},
+		{
+			time.Date(2020, 7, 1, 22, 0, 0, 0, time.UTC),
+			time.Date(2020, 7, 2, 22, 0, 0, 0, time.UTC),
+			"At the cutoff - Next day",
+		},
+		{
+			time.Date(2020, 7, 31, 23, 0, 0, 0, time.UTC),
+			time.Date(2020, 8, 1, 22, 0, 0, 0, time.UTC),
+			"After the cutoff - Next month",
+		},
+	}
+
+	for _, iterTest := range tests {
+		assert.Equal(iterTest.expected, NextCutoff(iterTest.now, cutoff), iterTest.description)
+	}
+
+	_, err = ParseCutoff("10pm")
+	assert.Error(err, "Invalid cutoff")
+}
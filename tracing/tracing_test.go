@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware(t *testing.T) {
+	assert := assert.New(t)
+
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(previous)
+
+	var outgoing http.Header
+	handler := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "gateway.NewAuthorization")
+		End(span, errors.New("Authorization failure - Declined"))
+
+		outgoing = http.Header{}
+		Inject(r.Context(), outgoing)
+
+		w.WriteHeader(http.StatusBadGateway)
+	}), "Authorize")
+
+	req := httptest.NewRequest(http.MethodPost, "/authorize", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	assert.Len(spans, 2)
+
+	child, server := spans[0], spans[1]
+	assert.Equal("Authorize", server.Name())
+	assert.Equal(trace.SpanKindServer, server.SpanKind())
+	assert.Equal("4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String(), "Continues the caller's trace")
+	assert.Equal("00f067aa0ba902b7", server.Parent().SpanID().String(), "Child of the caller's span")
+	assert.Equal(codes.Error, server.Status().Code, "5xx - Error status")
+
+	assert.Equal(server.SpanContext().SpanID(), child.Parent().SpanID(), "Operation span - Child of the route span")
+	assert.Equal(codes.Error, child.Status().Code, "Operation span - Error recorded")
+
+	assert.Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-"+server.SpanContext().SpanID().String()+"-01", outgoing.Get("traceparent"), "Propagated out")
+}
+
+func TestInit(t *testing.T) {
+	assert := assert.New(t)
+
+	shutdown, err := Init(ExporterNone, "test")
+	assert.NoError(err)
+	assert.NoError(shutdown(context.Background()))
+
+	_, err = Init("zipkin", "test")
+	assert.Equal(errors.New(`Invalid tracing exporter "zipkin" - expected none, stdout or otlp`), err)
+}